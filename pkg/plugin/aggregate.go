@@ -0,0 +1,175 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// chatCompletionChunk is a single event in a streamed chat completions response.
+type chatCompletionChunk struct {
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role      string `json:"role"`
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	// Usage is only sent in the final chunk, and only if requested with
+	// `stream_options: {"include_usage": true}`.
+	Usage *openAIUsage `json:"usage"`
+}
+
+// streamedChoice is a choice reconstructed from the deltas of a streamed response.
+type streamedChoice struct {
+	Index        int
+	Role         string
+	Content      string
+	ToolCalls    []streamedToolCall
+	FinishReason string
+}
+
+// streamedToolCall is a tool call reconstructed from a streamed response, in
+// the same shape as in a non-streamed response.
+type streamedToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// choiceBuilder accumulates a streamed choice. Content arrives a token or so at
+// a time, so it is built up rather than concatenated.
+type choiceBuilder struct {
+	choice  streamedChoice
+	content strings.Builder
+}
+
+// toolCallBuilder accumulates a streamed tool call and its arguments.
+type toolCallBuilder struct {
+	call      streamedToolCall
+	arguments strings.Builder
+}
+
+// streamAggregator reconstructs the choices of a streamed chat completions
+// response. Chunks for different choices (when `n > 1`) may be interleaved, so
+// deltas are accumulated by their choice index rather than in arrival order.
+// Likewise, the arguments of each tool call arrive in fragments which are
+// accumulated by the tool call's index within its choice.
+type streamAggregator struct {
+	choices   map[int]*choiceBuilder
+	toolCalls map[int]map[int]*toolCallBuilder
+	usage     *openAIUsage
+	// size is the total size of the chunks added.
+	size int64
+}
+
+func newStreamAggregator() *streamAggregator {
+	return &streamAggregator{
+		choices:   map[int]*choiceBuilder{},
+		toolCalls: map[int]map[int]*toolCallBuilder{},
+	}
+}
+
+// add accumulates a single chunk.
+func (s *streamAggregator) add(data []byte) error {
+	s.size += int64(len(data))
+	var chunk chatCompletionChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return fmt.Errorf("unmarshal chunk: %w", err)
+	}
+	for _, c := range chunk.Choices {
+		b, ok := s.choices[c.Index]
+		if !ok {
+			b = &choiceBuilder{choice: streamedChoice{Index: c.Index}}
+			s.choices[c.Index] = b
+		}
+		choice := &b.choice
+		if c.Delta.Role != "" {
+			choice.Role = c.Delta.Role
+		}
+		b.content.WriteString(c.Delta.Content)
+		for _, tc := range c.Delta.ToolCalls {
+			calls, ok := s.toolCalls[c.Index]
+			if !ok {
+				calls = map[int]*toolCallBuilder{}
+				s.toolCalls[c.Index] = calls
+			}
+			tb, ok := calls[tc.Index]
+			if !ok {
+				tb = &toolCallBuilder{call: streamedToolCall{Index: tc.Index}}
+				calls[tc.Index] = tb
+			}
+			call := &tb.call
+			// The ID, type and name are only sent in a tool call's first delta.
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Type != "" {
+				call.Type = tc.Type
+			}
+			if tc.Function.Name != "" {
+				call.Function.Name = tc.Function.Name
+			}
+			tb.arguments.WriteString(tc.Function.Arguments)
+		}
+		if c.FinishReason != nil {
+			choice.FinishReason = *c.FinishReason
+		}
+	}
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+	return nil
+}
+
+// result returns the reconstructed choices and their tool calls, ordered by index.
+func (s *streamAggregator) result() []streamedChoice {
+	choices := make([]streamedChoice, 0, len(s.choices))
+	for i, b := range s.choices {
+		choice := b.choice
+		choice.Content = b.content.String()
+		for _, tb := range s.toolCalls[i] {
+			call := tb.call
+			call.Function.Arguments = tb.arguments.String()
+			choice.ToolCalls = append(choice.ToolCalls, call)
+		}
+		sort.Slice(choice.ToolCalls, func(i, j int) bool { return choice.ToolCalls[i].Index < choice.ToolCalls[j].Index })
+		choices = append(choices, choice)
+	}
+	sort.Slice(choices, func(i, j int) bool { return choices[i].Index < choices[j].Index })
+	return choices
+}
+
+// completion returns the reconstructed response as the body of a non-streamed
+// chat completions response for model, so it can be handled like one.
+func (s *streamAggregator) completion(model string) ([]byte, error) {
+	choices := s.result()
+	out := make([]map[string]interface{}, 0, len(choices))
+	for _, c := range choices {
+		message := map[string]interface{}{"role": c.Role, "content": c.Content}
+		if len(c.ToolCalls) > 0 {
+			message["tool_calls"] = c.ToolCalls
+		}
+		out = append(out, map[string]interface{}{"index": c.Index, "message": message, "finish_reason": c.FinishReason})
+	}
+	resp := map[string]interface{}{"object": "chat.completion", "model": model, "choices": out}
+	if s.usage != nil {
+		resp["usage"] = s.usage
+	}
+	return json.Marshal(resp)
+}
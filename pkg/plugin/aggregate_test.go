@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStreamAggregatorToolCalls(t *testing.T) {
+	// Two tool calls, with their arguments split across interleaved chunks.
+	chunks := []string{
+		`{"choices": [{"index": 0, "delta": {"role": "assistant", "tool_calls": [{"index": 0, "id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": ""}}]}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "{\"loc"}}]}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 1, "id": "call_2", "type": "function", "function": {"name": "get_time", "arguments": "{\"tz\":"}}]}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "ation\": \"Par"}}]}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 1, "function": {"arguments": " \"CET\"}"}}]}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "is\"}"}}]}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 0, "delta": {}, "finish_reason": "tool_calls"}]}`,
+	}
+	agg := newStreamAggregator()
+	for _, c := range chunks {
+		if err := agg.add([]byte(c)); err != nil {
+			t.Fatalf("aggregate %s: %s", c, err)
+		}
+	}
+
+	choices := agg.result()
+	if len(choices) != 1 {
+		t.Fatalf("expected 1 choice, got %d: %+v", len(choices), choices)
+	}
+	if choices[0].FinishReason != "tool_calls" || choices[0].Role != "assistant" {
+		t.Errorf("unexpected choice %+v", choices[0])
+	}
+	calls := choices[0].ToolCalls
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 tool calls, got %d: %+v", len(calls), calls)
+	}
+	for i, exp := range []struct {
+		id, name string
+		args     map[string]string
+	}{
+		{id: "call_1", name: "get_weather", args: map[string]string{"location": "Paris"}},
+		{id: "call_2", name: "get_time", args: map[string]string{"tz": "CET"}},
+	} {
+		call := calls[i]
+		if call.Index != i || call.ID != exp.id || call.Type != "function" || call.Function.Name != exp.name {
+			t.Errorf("unexpected tool call %d: %+v", i, call)
+		}
+		var args map[string]string
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			t.Fatalf("tool call %d arguments %q are not valid JSON: %s", i, call.Function.Arguments, err)
+		}
+		if !reflect.DeepEqual(args, exp.args) {
+			t.Errorf("tool call %d: expected arguments %v, got %v", i, exp.args, args)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// openAIKeysKey is the secure setting holding a list of API keys for the
+// provider, used instead of openAIKey. It is separate since openAIKey is
+// shared with the vector embedder, which only takes one key.
+const openAIKeysKey = "openAIKeys"
+
+// KeyRotation is how requests are spread between a provider's API keys.
+type KeyRotation string
+
+const (
+	// KeyRotationRoundRobin uses each key in turn. This is the default.
+	KeyRotationRoundRobin KeyRotation = "round-robin"
+	// KeyRotationQuota uses the key with the most requests remaining, by the
+	// rate limit headers of its last response. Keys which haven't been used
+	// yet go first, and ties are broken round-robin.
+	KeyRotationQuota KeyRotation = "quota"
+)
+
+func (r KeyRotation) validate() error {
+	switch r {
+	case "", KeyRotationRoundRobin, KeyRotationQuota:
+		return nil
+	}
+	return fmt.Errorf("unknown key rotation: %s", r)
+}
+
+// splitAPIKeys splits a list of API keys separated by commas or whitespace.
+func splitAPIKeys(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// apiKeyPool rotates requests between a provider's API keys.
+type apiKeyPool struct {
+	keys     []string
+	rotation KeyRotation
+
+	mu   sync.Mutex
+	next int
+	// remaining is the number of requests each key has left, from the rate
+	// limit headers of its last response less the requests made since, or
+	// math.MaxInt64 if unknown.
+	remaining []int64
+}
+
+// newAPIKeyPool returns a pool of keys, or nil if there aren't several.
+func newAPIKeyPool(keys []string, rotation KeyRotation) *apiKeyPool {
+	if len(keys) < 2 {
+		return nil
+	}
+	remaining := make([]int64, len(keys))
+	for i := range remaining {
+		remaining[i] = math.MaxInt64
+	}
+	return &apiKeyPool{keys: keys, rotation: rotation, remaining: remaining}
+}
+
+// loadAPIKeys fills in the API keys of s from secret, which holds one key or
+// a list of them.
+func loadAPIKeys(s *OpenAISettings, secret string) {
+	keys := splitAPIKeys(secret)
+	if len(keys) == 0 {
+		s.apiKey = ""
+		return
+	}
+	s.apiKey = keys[0]
+	s.apiKeys = newAPIKeyPool(keys, s.KeyRotation)
+}
+
+// key returns the key to use for the next request.
+func (p *apiKeyPool) key() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.next
+	if p.rotation == KeyRotationQuota {
+		for j := 1; j < len(p.keys); j++ {
+			k := (p.next + j) % len(p.keys)
+			if p.remaining[k] > p.remaining[i] {
+				i = k
+			}
+		}
+		if p.remaining[i] > 0 && p.remaining[i] != math.MaxInt64 {
+			p.remaining[i]--
+		}
+	}
+	p.next = (i + 1) % len(p.keys)
+	return p.keys[i]
+}
+
+// observe records the requests remaining for key from the headers of a
+// response to a request made with it. Rate limited responses leave none. A
+// nil pool ignores them.
+func (p *apiKeyPool) observe(key string, resp *http.Response) {
+	if p == nil || p.rotation != KeyRotationQuota {
+		return
+	}
+	remaining, err := strconv.ParseInt(resp.Header.Get(rateLimitHeaders["requests"].remaining), 10, 64)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		remaining, err = 0, nil
+	}
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, k := range p.keys {
+		if k == key {
+			p.remaining[i] = remaining
+		}
+	}
+}
+
+// rateLimitObserver is implemented by providers which choose between API keys
+// by the rate limit headers of their responses.
+type rateLimitObserver interface {
+	// ObserveRateLimits records the rate limits of the API key used for a
+	// response's request.
+	ObserveRateLimits(resp *http.Response)
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestAPIKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		keys = append(keys, key)
+		// key-1 is nearly out of requests.
+		remaining := "100"
+		if key == "key-1" {
+			remaining = "1"
+		}
+		w.Header().Set("X-Ratelimit-Remaining-Requests", remaining)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name     string
+		rotation KeyRotation
+
+		expKeys []string
+	}{
+		{
+			name:    "round-robin by default",
+			expKeys: []string{"key-1", "key-2", "key-3", "key-1", "key-2"},
+		},
+		{
+			name:     "quota",
+			rotation: KeyRotationQuota,
+			expKeys:  []string{"key-1", "key-2", "key-3", "key-2", "key-3"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keys = nil
+			settings := Settings{
+				OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL, KeyRotation: tc.rotation},
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData: jsonData,
+				DecryptedSecureJSONData: map[string]string{
+					openAIKey:     "embedder-key",
+					openAIKeysKey: "key-1, key-2\nkey-3",
+				},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			for range tc.expKeys {
+				var r mockCallResourceResponseSender
+				err = app.CallResource(ctx, &backend.CallResourceRequest{
+					PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+					Method:        http.MethodPost,
+					Path:          "/openai/v1/chat/completions",
+					Body:          []byte(`{"model": "gpt-4", "messages": [{"role": "user", "content": "hi"}]}`),
+				}, &r)
+				if err != nil {
+					t.Fatalf("CallResource error: %s", err)
+				}
+				if r.response.Status != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+				}
+			}
+			if !reflect.DeepEqual(keys, tc.expKeys) {
+				t.Errorf("expected keys %v to be used, got %v", tc.expKeys, keys)
+			}
+		})
+	}
+}
+
+func TestInvalidKeyRotation(t *testing.T) {
+	jsonData, _ := json.Marshal(Settings{OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, KeyRotation: "random"}})
+	_, err := NewApp(context.Background(), backend.AppInstanceSettings{JSONData: jsonData})
+	if err == nil {
+		t.Fatal("expected an error for an unknown key rotation")
+	}
+}
@@ -31,7 +31,102 @@ type App struct {
 
 	vectorService vector.Service
 
+	// llmGateway tracks which llm-gateway endpoint is in use when the
+	// Grafana-managed provider is configured.
+	llmGateway *llmGatewayEndpoints
+
+	// provider is the configured OpenAI provider, or nil if none is configured.
+	provider Provider
+
+	// transformers are applied by the proxy around upstream chat completions calls.
+	transformers transformers
+
+	// blocklist rejects prompts matching blocked patterns, if configured.
+	blocklist *promptBlocklist
+
+	// moderation checks prompts with a moderation endpoint, if enabled.
+	moderation *moderator
+
+	// messageLimit limits the number of messages per request, if configured.
+	messageLimit *messageLimit
+
+	// contextLimit rejects requests too long for their model, if enabled.
+	contextLimit *contextLimit
+
+	// budget enforces the daily token budget, if configured.
+	budget *tokenBudget
+
+	// costs estimates the cost of each request, if enabled.
+	costs *costEstimator
+
+	// rateLimiter limits the rate of requests for each model, if configured.
+	rateLimiter *modelRateLimiter
+
+	// latency is the average latency of successful chat completions requests.
+	latency latencyEMA
+
+	// audit records who made each LLM call, if enabled.
+	audit *auditLogger
+
+	// idempotency replays responses to retried requests with an Idempotency-Key.
+	idempotency *idempotencyCache
+
+	// activeRequests holds the in-flight proxied requests and streams, so they
+	// can be cancelled.
+	activeRequests *activeRequests
+
+	// warmer keeps connections to the provider open, if enabled.
+	warmer *warmer
+
+	// limiter limits concurrent requests to the provider, if configured,
+	// letting high priority requests go first.
+	limiter *priorityLimiter
+
+	// streamLimit limits the number of open streams, if configured.
+	streamLimit *streamLimit
+
+	// promptWarnings warns clients about questionable requests, if enabled.
+	promptWarnings *promptWarnings
+
+	// streamCoalescer shares upstream streams between identical streaming
+	// requests, if enabled.
+	streamCoalescer *streamCoalescer
+
+	// bodyTransforms change request bodies, if configured.
+	bodyTransforms bodyTransforms
+
+	// endpoints holds the enabled proxy endpoints, or nil if all are enabled.
+	endpoints *endpointAllowList
+
+	// maintenance answers requests during maintenance windows, if configured.
+	maintenance *maintenance
+
+	// healthHistory holds the results of recent health checks.
+	healthHistory *healthHistory
+
+	// settingsFingerprint is the fingerprint of the settings last seen in a
+	// request, guarded by healthCheckMutex.
+	settingsFingerprint string
+
+	// healthRefreshing records which features' health results are being
+	// refreshed in the background, guarded by healthCheckMutex.
+	healthRefreshing map[string]bool
+
+	// openAIHealthSchedule and vectorHealthSchedule decide when cached health
+	// results expire, if adaptive health checks are enabled. They are guarded
+	// by healthCheckMutex.
+	openAIHealthSchedule *healthSchedule
+	vectorHealthSchedule *healthSchedule
+
+	// healthStore persists successful health results, if enabled.
+	healthStore *healthStore
+
+	// loadedHealth is the persisted result loaded when the instance was
+	// created, until a check replaces it. It is guarded by healthCheckMutex.
+	loadedHealth *persistedHealth
+
 	healthCheckClient healthCheckClient
+	checkReachable    reachabilityChecker
 	healthCheckMutex  sync.Mutex
 	healthOpenAI      *openAIHealthDetails
 	healthVector      *vectorHealthDetails
@@ -53,12 +148,123 @@ func NewApp(ctx context.Context, appSettings backend.AppInstanceSettings) (insta
 		return nil, err
 	}
 
+	if app.settings.OpenAI.Provider == openAIProviderGrafana {
+		app.llmGateway, err = newLLMGatewayEndpoints(app.settings.LLMGateway.endpoints())
+		if err != nil {
+			log.DefaultLogger.Error("Error configuring LLM Gateway endpoints", "err", err)
+			return nil, err
+		}
+	}
+	app.provider = newProvider(*app.settings, app.llmGateway)
+	if len(app.settings.BlockedPatterns) > 0 {
+		app.blocklist, err = newPromptBlocklist(app.settings.BlockedPatterns)
+		if err != nil {
+			log.DefaultLogger.Error("Error compiling blocked patterns", "err", err)
+			return nil, err
+		}
+		app.RegisterRequestTransformer(app.blocklist.requestTransformer)
+	}
+	if app.settings.ForceModel != "" {
+		// Before the vision check, which depends on the model.
+		app.RegisterRequestTransformer(forceModelRequestTransformer(app.settings.ForceModel))
+		app.RegisterResponseTransformer(forceModelResponseTransformer(app.settings.ForceModel))
+	}
+	app.RegisterRequestTransformer(app.visionRequestTransformer)
+	if len(app.settings.DefaultParams) > 0 {
+		app.RegisterRequestTransformer(defaultParamsRequestTransformer(app.settings.DefaultParams))
+	}
+	if len(app.settings.BodyTransforms) > 0 {
+		// After default params, so the rules have the last word, and before
+		// the checks, so they see the changed request.
+		app.bodyTransforms, err = newBodyTransforms(app.settings.BodyTransforms)
+		if err != nil {
+			log.DefaultLogger.Error("Error parsing body transforms", "err", err)
+			return nil, err
+		}
+		app.RegisterRequestTransformer(app.bodyTransforms.requestTransformer)
+	}
+	if app.settings.MaxMessages > 0 {
+		app.messageLimit, err = newMessageLimit(app.settings.MaxMessages, app.settings.MessageOverflow)
+		if err != nil {
+			log.DefaultLogger.Error("Error configuring message limit", "err", err)
+			return nil, err
+		}
+		app.RegisterRequestTransformer(app.messageLimit.requestTransformer)
+	}
+	if app.settings.ContextLimit.Enabled {
+		// After default params and the message limit, which change the
+		// request's length.
+		app.contextLimit = newContextLimit(app.settings.ContextLimit)
+		app.RegisterRequestTransformer(app.contextLimit.requestTransformer)
+	}
+	if app.settings.Moderation.Enabled {
+		// After the message limit, so only the messages sent are moderated.
+		app.moderation, err = newModerator(app.settings.Moderation, app.settings.userAgent())
+		if err != nil {
+			log.DefaultLogger.Error("Error configuring moderation", "err", err)
+			return nil, err
+		}
+		app.RegisterRequestTransformer(app.moderation.requestTransformer)
+	}
+
+	if app.settings.Budget.DailyTokenBudget > 0 {
+		app.budget = newTokenBudget(app.settings.Budget)
+		app.RegisterRequestTransformer(app.budget.requestTransformer(app.settings.Tenant))
+		app.RegisterResponseTransformer(app.budget.responseTransformer(app.settings.Tenant))
+	}
+	if app.settings.CostEstimate.Enabled {
+		app.costs = newCostEstimator(app.settings.CostEstimate)
+		app.RegisterResponseTransformer(app.costs.responseTransformer)
+	}
+	if app.settings.RateLimit.enabled() {
+		// Last, so that requests rejected for other reasons don't count.
+		app.rateLimiter = newModelRateLimiter(app.settings.RateLimit)
+		app.RegisterRequestTransformer(app.rateLimiter.requestTransformer)
+	}
+
+	if app.settings.Audit.Enabled {
+		app.audit, err = newAuditLogger(app.settings.Audit, app.settings.Tenant)
+		if err != nil {
+			log.DefaultLogger.Error("Error creating audit logger", "err", err)
+			return nil, err
+		}
+	}
+
+	if !app.settings.Idempotency.Disabled {
+		app.idempotency = newIdempotencyCache(app.settings.Idempotency)
+	}
+
+	app.healthHistory = newHealthHistory(app.settings.HealthHistorySize)
+	app.openAIHealthSchedule = newHealthSchedule(app.settings.AdaptiveHealthChecks)
+	app.vectorHealthSchedule = newHealthSchedule(app.settings.AdaptiveHealthChecks)
+	app.activeRequests = newActiveRequests()
+	app.limiter = newPriorityLimiter(app.settings.MaxConcurrentRequests)
+	app.streamLimit, err = newStreamLimit(app.settings.MaxConcurrentStreams, app.settings.StreamOverflow)
+	if err != nil {
+		log.DefaultLogger.Error("Error configuring stream limit", "err", err)
+		return nil, err
+	}
+	app.promptWarnings, err = newPromptWarnings(app.settings.PromptWarnings)
+	if err != nil {
+		log.DefaultLogger.Error("Error configuring prompt warnings", "err", err)
+		return nil, err
+	}
+	if app.settings.StreamCoalesce {
+		app.streamCoalescer = newStreamCoalescer(app.audit)
+	}
+	app.endpoints = newEndpointAllowList(app.settings.EnabledEndpoints)
+	app.maintenance, err = newMaintenance(app.settings.MaintenanceWindows)
+	if err != nil {
+		log.DefaultLogger.Error("Error configuring maintenance windows", "err", err)
+		return nil, err
+	}
+
 	// Use a httpadapter (provided by the SDK) for resource calls. This allows us
 	// to use a *http.ServeMux for resource calls, so we can map multiple routes
 	// to CallResource without having to implement extra logic.
 	mux := http.NewServeMux()
 	app.registerRoutes(mux, *app.settings)
-	app.CallResourceHandler = httpadapter.New(mux)
+	app.CallResourceHandler = httpadapter.New(requestIDMiddleware(mux))
 
 	// Getting the service account token that has been shared with the plugin
 	app.saToken = os.Getenv("GF_PLUGIN_APP_CLIENT_SECRET")
@@ -72,9 +278,11 @@ func NewApp(ctx context.Context, appSettings backend.AppInstanceSettings) (insta
 
 	if app.settings.Vector.Enabled {
 		log.DefaultLogger.Debug("Creating vector service")
+		// The embedder shares the provider's key, so needs it migrated too.
+		secrets, _ := migrateSecrets(appSettings.DecryptedSecureJSONData)
 		app.vectorService, err = vector.NewService(
 			app.settings.Vector,
-			appSettings.DecryptedSecureJSONData,
+			secrets,
 		)
 		if err != nil {
 			log.DefaultLogger.Error("Error creating vector service", "err", err)
@@ -82,16 +290,72 @@ func NewApp(ctx context.Context, appSettings backend.AppInstanceSettings) (insta
 		}
 	}
 
-	app.healthCheckClient = &http.Client{}
+	app.settingsFingerprint = app.settings.fingerprint
+	app.healthCheckClient = &http.Client{Transport: app.providerTransport()}
+	app.checkReachable = dialProvider
 	app.healthCheckMutex = sync.Mutex{}
+	app.healthRefreshing = map[string]bool{}
+	app.healthStore = newHealthStore(app.settings.HealthPersistence, app.settings.Tenant)
+	app.loadedHealth = app.healthStore.load()
+
+	if app.settings.Warmup.Enabled && app.provider != nil {
+		app.warmer = startWarmer(app.settings.Warmup, app.warmupProbe)
+	}
 
 	return &app, nil
 }
 
+// CallResource resets any provider-specific cached state if the request was made
+// with different settings to those the instance was created with, before handling
+// the request as usual.
+func (a *App) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	a.checkSettingsFingerprint(req.PluginContext.AppInstanceSettings)
+	return a.CallResourceHandler.CallResource(ctx, req, sender)
+}
+
+// checkSettingsFingerprint compares the fingerprint of the settings a request was
+// made with to that of the settings last seen, resetting provider-specific cached
+// state if they differ. Grafana normally creates a new instance when settings
+// change, but this makes sure a stale health result or replayed response from the
+// old provider is never served if that doesn't happen.
+func (a *App) checkSettingsFingerprint(appSettings *backend.AppInstanceSettings) {
+	if appSettings == nil {
+		return
+	}
+	fingerprint := settingsFingerprint(*appSettings)
+	a.healthCheckMutex.Lock()
+	defer a.healthCheckMutex.Unlock()
+	if fingerprint == a.settingsFingerprint {
+		return
+	}
+	log.DefaultLogger.Info("Settings changed, resetting provider state")
+	a.settingsFingerprint = fingerprint
+	a.resetProviderState()
+}
+
+// resetProviderState clears all cached state specific to the configured provider.
+// The caller must lock a.healthCheckMutex.
+func (a *App) resetProviderState() {
+	a.healthOpenAI = nil
+	a.healthVector = nil
+	a.loadedHealth = nil
+	a.openAIHealthSchedule.reset()
+	a.vectorHealthSchedule.reset()
+	if a.llmGateway != nil {
+		a.llmGateway.reset()
+	}
+	if a.idempotency != nil {
+		a.idempotency.reset()
+	}
+	a.latency.reset()
+}
+
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created.
 func (a *App) Dispose() {
 	if a.vectorService != nil {
 		a.vectorService.Cancel()
 	}
+	a.warmer.stop()
+	a.audit.close()
 }
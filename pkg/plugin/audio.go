@@ -0,0 +1,49 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+)
+
+// errAudioTranscriptionsUnsupported is returned for audio transcriptions
+// requests to providers which don't serve them.
+var errAudioTranscriptionsUnsupported = errors.New("audio transcriptions are not supported by the configured provider")
+
+// isAudioTranscriptionsPath reports whether path is the audio transcriptions
+// endpoint. Its requests are multipart/form-data rather than JSON, so they are
+// forwarded unmodified, skipping the transformers and body translation.
+func isAudioTranscriptionsPath(path string) bool {
+	return strings.HasSuffix(path, "/audio/transcriptions")
+}
+
+// multipartModel returns the value of the `model` field of a multipart/form-data
+// body with the given content type, or an empty string if it has none. Parts
+// are read in order, so the uploaded file is skipped rather than buffered.
+func multipartModel(body []byte, contentType string) (string, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("parse content type: %w", err)
+	}
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("read multipart body: %w", err)
+		}
+		if part.FormName() == "model" {
+			model, err := io.ReadAll(part)
+			if err != nil {
+				return "", fmt.Errorf("read model field: %w", err)
+			}
+			return string(model), nil
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// audioUpload returns a multipart transcriptions request body and its
+// content type.
+func audioUpload(t *testing.T, model string) ([]byte, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", "speech.mp3")
+	if err != nil {
+		t.Fatalf("create form file: %s", err)
+	}
+	_, _ = fw.Write([]byte("\xff\xfb\x90\x00not really an mp3"))
+	if err := mw.WriteField("model", model); err != nil {
+		t.Fatalf("write field: %s", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart writer: %s", err)
+	}
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+func TestAudioTranscriptions(t *testing.T) {
+	ctx := context.Background()
+	body, contentType := audioUpload(t, "whisper-1")
+
+	for _, tc := range []struct {
+		name     string
+		provider openAIProvider
+		mapping  [][]string
+
+		expStatus int
+		expPath   string
+		expAuth   string
+	}{
+		{
+			name:      "openai",
+			provider:  openAIProviderOpenAI,
+			expStatus: http.StatusOK,
+			expPath:   "/v1/audio/transcriptions",
+			expAuth:   "Authorization",
+		},
+		{
+			name:      "azure",
+			provider:  openAIProviderAzure,
+			mapping:   [][]string{{"whisper-1", "whisper"}},
+			expStatus: http.StatusOK,
+			expPath:   "/openai/deployments/whisper/audio/transcriptions",
+			expAuth:   "api-key",
+		},
+		{
+			name:      "cohere",
+			provider:  openAIProviderCohere,
+			expStatus: http.StatusNotImplemented,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got *http.Request
+			var gotBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				gotBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"text": "hello"}`))
+			}))
+			defer server.Close()
+
+			settings := Settings{
+				OpenAI: OpenAISettings{
+					Provider:     tc.provider,
+					URL:          server.URL,
+					AzureMapping: tc.mapping,
+					// Must not be merged into the form.
+					ExtraBodyFields: map[string]interface{}{"user": "grafana"},
+				},
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/audio/transcriptions",
+				Headers:       map[string][]string{"Content-Type": {contentType}},
+				Body:          body,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if tc.expStatus != http.StatusOK {
+				if got != nil {
+					t.Error("expected the provider not to be called")
+				}
+				return
+			}
+			if got.URL.Path != tc.expPath {
+				t.Errorf("expected path %s, got %s", tc.expPath, got.URL.Path)
+			}
+			if got.Header.Get(tc.expAuth) == "" {
+				t.Errorf("expected the %s header to be set", tc.expAuth)
+			}
+			if got.Header.Get("Content-Type") != contentType {
+				t.Errorf("expected content type %s, got %s", contentType, got.Header.Get("Content-Type"))
+			}
+			if !bytes.Equal(gotBody, body) {
+				t.Errorf("expected the form to be forwarded unmodified, got %q", gotBody)
+			}
+			if string(r.response.Body) != `{"text": "hello"}` {
+				t.Errorf("unexpected response body %s", r.response.Body)
+			}
+		})
+	}
+}
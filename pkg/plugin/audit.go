@@ -0,0 +1,304 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// auditBufferSize is the number of audit records which can be queued before
+	// the backpressure policy applies.
+	auditBufferSize  = 1024
+	auditHTTPTimeout = 10 * time.Second
+	// defaultAuditBlockTimeout is how long log waits for queue space with
+	// AuditBackpressureBlockWithTimeout, unless configured otherwise.
+	defaultAuditBlockTimeout = 100 * time.Millisecond
+)
+
+// AuditBackpressure is what happens to audit records when the queue is full
+// because the sink can't keep up.
+type AuditBackpressure string
+
+const (
+	// AuditBackpressureDropNewest drops the record being logged. This is the
+	// default.
+	AuditBackpressureDropNewest AuditBackpressure = "drop_newest"
+	// AuditBackpressureDropOldest drops the oldest queued record to make room.
+	AuditBackpressureDropOldest AuditBackpressure = "drop_oldest"
+	// AuditBackpressureBlockWithTimeout waits for queue space for up to the
+	// block timeout, then drops the record being logged.
+	AuditBackpressureBlockWithTimeout AuditBackpressure = "block_with_timeout"
+)
+
+// auditDroppedRecords counts audit records which were never written because
+// the queue was full or the log closed.
+var auditDroppedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "grafana_llm_app",
+	Subsystem: "audit",
+	Name:      "dropped_records_total",
+	Help:      "Audit records dropped without being written to the sink.",
+}, []string{"reason"})
+
+// AuditSettings configures the audit log of LLM calls.
+type AuditSettings struct {
+	Enabled bool `json:"enabled"`
+	// Sink is where audit records are written, as JSON: either the path of a
+	// file to append lines to, or an http(s) URL to POST each record to.
+	Sink string `json:"sink"`
+	// Backpressure is what happens when the sink falls behind and the queue
+	// fills up. Defaults to AuditBackpressureDropNewest.
+	Backpressure AuditBackpressure `json:"backpressure"`
+	// BlockTimeoutMilliseconds is how long logging may wait for queue space
+	// with AuditBackpressureBlockWithTimeout. Defaults to 100ms.
+	BlockTimeoutMilliseconds int `json:"blockTimeoutMs"`
+}
+
+// auditRecord is a single audited LLM call. It deliberately never includes
+// prompt or completion content.
+type auditRecord struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	User       string      `json:"user"`
+	UserEmail  string      `json:"userEmail,omitempty"`
+	Tenant     string      `json:"tenant,omitempty"`
+	Path       string      `json:"path"`
+	Model      string      `json:"model,omitempty"`
+	StatusCode int         `json:"statusCode,omitempty"`
+	Usage      openAIUsage `json:"usage"`
+	// Coalesced is set for requests which shared another request's upstream
+	// stream, whose usage the provider only charged for once.
+	Coalesced bool `json:"coalesced,omitempty"`
+}
+
+// auditSink writes audit records somewhere durable.
+type auditSink interface {
+	write(auditRecord) error
+	close() error
+}
+
+// auditLogger queues audit records and writes them to a sink in the background,
+// so that auditing never blocks the response path.
+type auditLogger struct {
+	tenant       string
+	sink         auditSink
+	records      chan auditRecord
+	done         chan struct{}
+	backpressure AuditBackpressure
+	blockTimeout time.Duration
+
+	// mu guards closed, so records aren't sent after records is closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAuditLogger(s AuditSettings, tenant string) (*auditLogger, error) {
+	switch s.Backpressure {
+	case "":
+		s.Backpressure = AuditBackpressureDropNewest
+	case AuditBackpressureDropNewest, AuditBackpressureDropOldest, AuditBackpressureBlockWithTimeout:
+	default:
+		return nil, fmt.Errorf("unknown audit backpressure policy: %s", s.Backpressure)
+	}
+	if s.BlockTimeoutMilliseconds < 0 {
+		return nil, fmt.Errorf("audit block timeout must not be negative")
+	}
+	blockTimeout := defaultAuditBlockTimeout
+	if s.BlockTimeoutMilliseconds > 0 {
+		blockTimeout = time.Duration(s.BlockTimeoutMilliseconds) * time.Millisecond
+	}
+
+	var sink auditSink
+	switch {
+	case s.Sink == "":
+		return nil, fmt.Errorf("audit sink is required")
+	case strings.HasPrefix(s.Sink, "http://") || strings.HasPrefix(s.Sink, "https://"):
+		sink = &httpAuditSink{url: s.Sink, client: &http.Client{Timeout: auditHTTPTimeout}}
+	default:
+		f, err := os.OpenFile(s.Sink, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %w", err)
+		}
+		sink = &fileAuditSink{f: f}
+	}
+	return startAuditLogger(sink, tenant, auditBufferSize, s.Backpressure, blockTimeout), nil
+}
+
+func startAuditLogger(sink auditSink, tenant string, bufferSize int, backpressure AuditBackpressure, blockTimeout time.Duration) *auditLogger {
+	l := &auditLogger{
+		tenant:       tenant,
+		sink:         sink,
+		records:      make(chan auditRecord, bufferSize),
+		done:         make(chan struct{}),
+		backpressure: backpressure,
+		blockTimeout: blockTimeout,
+	}
+	go l.run()
+	return l
+}
+
+func (l *auditLogger) run() {
+	defer close(l.done)
+	for r := range l.records {
+		if err := l.sink.write(r); err != nil {
+			log.DefaultLogger.Error("Unable to write audit record", "err", err)
+		}
+	}
+	if err := l.sink.close(); err != nil {
+		log.DefaultLogger.Error("Unable to close audit sink", "err", err)
+	}
+}
+
+// logStream queues a record for a streamed response once it has been read
+// and closed, with the usage reported at its end, if any. Timestamps are
+// when the stream ended.
+func (l *auditLogger) logStream(user *backend.User, r auditRecord, resp *http.Response) {
+	if l == nil {
+		return
+	}
+	watchStreamUsage(resp, func(usage openAIUsage) {
+		r.Usage = usage
+	})
+	resp.Body = &closeHook{ReadCloser: resp.Body, f: func() { l.log(user, r) }}
+}
+
+// closeHook calls f once, when the body is first closed.
+type closeHook struct {
+	io.ReadCloser
+	f    func()
+	once sync.Once
+}
+
+func (c *closeHook) Close() error {
+	err := c.ReadCloser.Close()
+	c.once.Do(c.f)
+	return err
+}
+
+// log queues a record for the given user. If the queue is full the
+// backpressure policy decides which record is dropped, and whether to wait a
+// bounded time for space first; log never blocks for longer than that.
+func (l *auditLogger) log(user *backend.User, r auditRecord) {
+	if l == nil {
+		return
+	}
+	r.Timestamp = time.Now().UTC()
+	r.Tenant = l.tenant
+	if user != nil {
+		r.User = user.Login
+		r.UserEmail = user.Email
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		log.DefaultLogger.Warn("Audit log closed, dropping record", "user", r.User, "path", r.Path)
+		auditDroppedRecords.WithLabelValues("closed").Inc()
+		return
+	}
+	select {
+	case l.records <- r:
+		return
+	default:
+	}
+
+	switch l.backpressure {
+	case AuditBackpressureDropOldest:
+		// Other callers may be competing for the space freed, so keep
+		// evicting until this record fits.
+		for {
+			select {
+			case old := <-l.records:
+				dropAuditRecord(old)
+			default:
+			}
+			select {
+			case l.records <- r:
+				return
+			default:
+			}
+		}
+	case AuditBackpressureBlockWithTimeout:
+		timer := time.NewTimer(l.blockTimeout)
+		defer timer.Stop()
+		select {
+		case l.records <- r:
+			return
+		case <-timer.C:
+		}
+	}
+	dropAuditRecord(r)
+}
+
+// dropAuditRecord records that r was dropped because the queue was full.
+func dropAuditRecord(r auditRecord) {
+	log.DefaultLogger.Error("Audit log queue full, dropping record", "user", r.User, "path", r.Path)
+	auditDroppedRecords.WithLabelValues("queue_full").Inc()
+}
+
+// close flushes any queued records and closes the sink.
+func (l *auditLogger) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.records)
+	}
+	l.mu.Unlock()
+	<-l.done
+}
+
+// fileAuditSink appends records to a file as JSON lines.
+type fileAuditSink struct {
+	f *os.File
+}
+
+func (s *fileAuditSink) write(r auditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+func (s *fileAuditSink) close() error {
+	return s.f.Close()
+}
+
+// httpAuditSink POSTs each record as JSON to a URL.
+type httpAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpAuditSink) write(r auditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("send audit record: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("send audit record: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpAuditSink) close() error {
+	return nil
+}
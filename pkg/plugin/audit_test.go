@@ -0,0 +1,340 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [{"message": {"content": "the answer"}}], "usage": {"prompt_tokens": 5, "completion_tokens": 2, "total_tokens": 7}}`))
+	}))
+	defer upstream.Close()
+
+	var (
+		mu       sync.Mutex
+		received []auditRecord
+	)
+	httpSink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec auditRecord
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, rec)
+		mu.Unlock()
+	}))
+	defer httpSink.Close()
+
+	filePath := filepath.Join(t.TempDir(), "audit.log")
+	for _, tc := range []struct {
+		name string
+		sink string
+		// records returns the records written to the sink.
+		records func(t *testing.T) []auditRecord
+	}{
+		{
+			name: "file",
+			sink: filePath,
+			records: func(t *testing.T) []auditRecord {
+				f, err := os.Open(filePath)
+				if err != nil {
+					t.Fatalf("open audit log: %s", err)
+				}
+				defer f.Close()
+				var records []auditRecord
+				scanner := bufio.NewScanner(f)
+				for scanner.Scan() {
+					if strings.Contains(scanner.Text(), "secret prompt") || strings.Contains(scanner.Text(), "the answer") {
+						t.Errorf("audit log must not contain content: %s", scanner.Text())
+					}
+					var rec auditRecord
+					if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+						t.Fatalf("unmarshal audit record: %s", err)
+					}
+					records = append(records, rec)
+				}
+				return records
+			},
+		},
+		{
+			name: "http",
+			sink: httpSink.URL,
+			records: func(t *testing.T) []auditRecord {
+				mu.Lock()
+				defer mu.Unlock()
+				return received
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := Settings{
+				OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: upstream.URL},
+				Audit:  AuditSettings{Enabled: true, Sink: tc.sink},
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{
+					AppInstanceSettings: &appSettings,
+					User:                &backend.User{Login: "alice", Email: "alice@example.com"},
+				},
+				Method: http.MethodPost,
+				Path:   "/openai/v1/chat/completions",
+				Body:   []byte(`{"model": "gpt-4", "messages": [{"role": "user", "content": "secret prompt"}]}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			// The response must still reach the client intact.
+			if !strings.Contains(string(r.response.Body), "the answer") {
+				t.Errorf("unexpected response body %s", r.response.Body)
+			}
+
+			// Closing flushes queued records.
+			app.Dispose()
+			records := tc.records(t)
+			if len(records) != 1 {
+				t.Fatalf("expected 1 audit record, got %d", len(records))
+			}
+			rec := records[0]
+			if rec.User != "alice" || rec.UserEmail != "alice@example.com" {
+				t.Errorf("unexpected user %q <%s>", rec.User, rec.UserEmail)
+			}
+			if rec.Model != "gpt-4" || rec.StatusCode != http.StatusOK || rec.Path != "/v1/chat/completions" {
+				t.Errorf("unexpected record %+v", rec)
+			}
+			if rec.Usage != (openAIUsage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7}) {
+				t.Errorf("unexpected usage %+v", rec.Usage)
+			}
+			if rec.Timestamp.IsZero() {
+				t.Error("expected timestamp to be set")
+			}
+		})
+	}
+}
+
+// blockingAuditSink blocks writes until unblocked.
+type blockingAuditSink struct {
+	unblock chan struct{}
+	written int
+	paths   []string
+}
+
+func (s *blockingAuditSink) write(r auditRecord) error {
+	<-s.unblock
+	s.written++
+	s.paths = append(s.paths, r.Path)
+	return nil
+}
+
+func (s *blockingAuditSink) close() error { return nil }
+
+func TestAuditLogStream(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"the answer\"}}]}\n\n"))
+		_, _ = w.Write([]byte("data: {\"choices\": [], \"usage\": {\"prompt_tokens\": 5, \"completion_tokens\": 2, \"total_tokens\": 7}}\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	filePath := filepath.Join(t.TempDir(), "audit.log")
+	jsonData, err := json.Marshal(Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: upstream.URL},
+		Audit:  AuditSettings{Enabled: true, Sink: filePath},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	var r streamingCallResourceResponseSender
+	err = app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{
+			AppInstanceSettings: &appSettings,
+			User:                &backend.User{Login: "alice"},
+		},
+		Method: http.MethodPost,
+		Path:   "/openai/v1/chat/completions",
+		Body:   []byte(`{"model": "gpt-4", "messages": [], "stream": true}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.status != http.StatusOK || !strings.Contains(r.body.String(), "the answer") {
+		t.Fatalf("expected the stream to reach the client, got %d: %s", r.status, r.body.String())
+	}
+	if strings.Contains(r.body.String(), `"usage"`) {
+		t.Errorf("expected the usage chunk the client didn't ask for to be dropped, got %s", r.body.String())
+	}
+	options, _ := upstreamBody["stream_options"].(map[string]interface{})
+	if options["include_usage"] != true {
+		t.Errorf("expected usage to be requested from the provider, got %v", upstreamBody)
+	}
+
+	// Closing flushes queued records.
+	app.Dispose()
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("read audit log: %s", err)
+	}
+	var rec auditRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf("unmarshal audit record %s: %s", b, err)
+	}
+	if rec.User != "alice" || rec.Model != "gpt-4" || rec.Usage != (openAIUsage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7}) {
+		t.Errorf("unexpected record %+v", rec)
+	}
+}
+
+func TestAuditLogDoesNotBlock(t *testing.T) {
+	sink := &blockingAuditSink{unblock: make(chan struct{})}
+	l := startAuditLogger(sink, "123", 1, AuditBackpressureDropNewest, 0)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			l.log(nil, auditRecord{Path: "/v1/chat/completions"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("logging blocked on a slow sink")
+	}
+
+	close(sink.unblock)
+	l.close()
+	// One record is being written and one queued; the rest are dropped.
+	if sink.written > 2 {
+		t.Errorf("expected at most 2 records written, got %d", sink.written)
+	}
+	// Logging after close is a no-op rather than a panic.
+	l.log(nil, auditRecord{})
+}
+
+func TestAuditLogBackpressure(t *testing.T) {
+	const logged = 10
+	for _, tc := range []struct {
+		backpressure AuditBackpressure
+		// check checks the paths of the records written.
+		check func(t *testing.T, paths []string)
+	}{
+		{
+			backpressure: AuditBackpressureDropNewest,
+			check: func(t *testing.T, paths []string) {
+				if len(paths) < 2 || paths[0] != "/0" || paths[1] != "/1" {
+					t.Errorf("expected the oldest records to be kept, got %v", paths)
+				}
+			},
+		},
+		{
+			backpressure: AuditBackpressureDropOldest,
+			check: func(t *testing.T, paths []string) {
+				if len(paths) < 2 || paths[len(paths)-2] != "/8" || paths[len(paths)-1] != "/9" {
+					t.Errorf("expected the newest records to be kept, got %v", paths)
+				}
+			},
+		},
+		{
+			backpressure: AuditBackpressureBlockWithTimeout,
+			check:        func(t *testing.T, paths []string) {},
+		},
+	} {
+		t.Run(string(tc.backpressure), func(t *testing.T) {
+			dropped := auditDroppedRecords.WithLabelValues("queue_full")
+			before := testutil.ToFloat64(dropped)
+			sink := &blockingAuditSink{unblock: make(chan struct{})}
+			l := startAuditLogger(sink, "123", 2, tc.backpressure, 10*time.Millisecond)
+
+			// Requests must not be held up by the slow sink, beyond the
+			// block timeout.
+			done := make(chan struct{})
+			go func() {
+				for i := 0; i < logged; i++ {
+					l.log(nil, auditRecord{Path: fmt.Sprintf("/%d", i)})
+				}
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("logging blocked on a slow sink")
+			}
+
+			close(sink.unblock)
+			l.close()
+			// At most one record is being written and two queued.
+			if sink.written > 3 {
+				t.Errorf("expected at most 3 records written, got %d: %v", sink.written, sink.paths)
+			}
+			if got := testutil.ToFloat64(dropped) - before; got != float64(logged-sink.written) {
+				t.Errorf("expected %d dropped records to be counted, got %v", logged-sink.written, got)
+			}
+			tc.check(t, sink.paths)
+		})
+	}
+}
+
+func TestNewAuditLoggerBackpressure(t *testing.T) {
+	sink := filepath.Join(t.TempDir(), "audit.log")
+	if _, err := newAuditLogger(AuditSettings{Sink: sink, Backpressure: "wait_forever"}, ""); err == nil {
+		t.Error("expected an error for an unknown backpressure policy")
+	}
+	if _, err := newAuditLogger(AuditSettings{Sink: sink, BlockTimeoutMilliseconds: -1}, ""); err == nil {
+		t.Error("expected an error for a negative block timeout")
+	}
+	l, err := newAuditLogger(AuditSettings{Sink: sink}, "")
+	if err != nil {
+		t.Fatalf("new audit logger: %s", err)
+	}
+	defer l.close()
+	if l.backpressure != AuditBackpressureDropNewest || l.blockTimeout != defaultAuditBlockTimeout {
+		t.Errorf("unexpected defaults %q, %s", l.backpressure, l.blockTimeout)
+	}
+}
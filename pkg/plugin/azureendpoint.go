@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// AzureEndpointStyle is the shape of the Azure endpoint requests are sent to.
+type AzureEndpointStyle string
+
+const (
+	// AzureEndpointStyleClassic is Azure OpenAI's
+	// https://{resource}.openai.azure.com endpoint, which takes the
+	// deployment in the path: /openai/deployments/{deployment}/chat/completions.
+	// This is the default.
+	AzureEndpointStyleClassic AzureEndpointStyle = "classic"
+	// AzureEndpointStyleFoundry is Azure AI Foundry's model inference
+	// endpoint, https://{resource}.services.ai.azure.com, which takes the
+	// deployment as the model in the body: /models/chat/completions.
+	AzureEndpointStyleFoundry AzureEndpointStyle = "foundry"
+)
+
+const (
+	// defaultAzureFoundryAPIVersion is the API version used with the Foundry
+	// endpoint style if none is configured.
+	defaultAzureFoundryAPIVersion = "2024-05-01-preview"
+	// azureStreamUsageAPIVersion is the first API version accepting
+	// `stream_options`. Versions are dates, so compare as strings.
+	azureStreamUsageAPIVersion = "2024-05-01"
+
+	azureClassicHostSuffix = ".openai.azure.com"
+	azureFoundryHostSuffix = ".services.ai.azure.com"
+)
+
+// validate returns an error if the style is unknown, or if rawURL is an Azure
+// endpoint of the other style. Other hosts, such as proxies in front of
+// Azure, are allowed with either style.
+func (s AzureEndpointStyle) validate(rawURL string) error {
+	var want, other string
+	switch s {
+	case "", AzureEndpointStyleClassic:
+		want, other = azureClassicHostSuffix, azureFoundryHostSuffix
+	case AzureEndpointStyleFoundry:
+		want, other = azureFoundryHostSuffix, azureClassicHostSuffix
+	default:
+		return fmt.Errorf("unknown Azure endpoint style: %s", s)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("parse Azure URL: %w", err)
+	}
+	if strings.HasSuffix(strings.ToLower(u.Hostname()), other) {
+		return fmt.Errorf("Azure URL %s doesn't match the %s endpoint style, which expects https://<resource>%s", rawURL, s.orDefault(), want)
+	}
+	return nil
+}
+
+func (s AzureEndpointStyle) orDefault() AzureEndpointStyle {
+	if s == "" {
+		return AzureEndpointStyleClassic
+	}
+	return s
+}
+
+// loadAzureSettings validates the Azure endpoint style of s and fills in the
+// default API version for it.
+func loadAzureSettings(s *OpenAISettings) error {
+	if err := s.AzureEndpointStyle.validate(s.URL); err != nil {
+		return err
+	}
+	if s.AzureAPIVersion == "" {
+		s.AzureAPIVersion = defaultAzureAPIVersion
+		if s.AzureEndpointStyle == AzureEndpointStyleFoundry {
+			s.AzureAPIVersion = defaultAzureFoundryAPIVersion
+		}
+	}
+	return nil
+}
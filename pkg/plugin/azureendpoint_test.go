@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestAzureEndpointStyle(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		jsonData string
+
+		expErr        bool
+		expAPIVersion string
+	}{
+		{
+			name:          "classic",
+			jsonData:      `{"openAI": {"provider": "azure", "url": "https://example.openai.azure.com"}}`,
+			expAPIVersion: defaultAzureAPIVersion,
+		},
+		{
+			name:          "foundry",
+			jsonData:      `{"openAI": {"provider": "azure", "url": "https://example.services.ai.azure.com", "azureEndpointStyle": "foundry"}}`,
+			expAPIVersion: defaultAzureFoundryAPIVersion,
+		},
+		{
+			name:          "foundry with api version",
+			jsonData:      `{"openAI": {"provider": "azure", "url": "https://example.services.ai.azure.com", "azureEndpointStyle": "foundry", "azureApiVersion": "2024-10-21"}}`,
+			expAPIVersion: "2024-10-21",
+		},
+		{
+			name:          "custom host",
+			jsonData:      `{"openAI": {"provider": "azure", "url": "https://azure-proxy.example.com", "azureEndpointStyle": "foundry"}}`,
+			expAPIVersion: defaultAzureFoundryAPIVersion,
+		},
+		{
+			name:     "foundry url with classic style",
+			jsonData: `{"openAI": {"provider": "azure", "url": "https://example.services.ai.azure.com"}}`,
+			expErr:   true,
+		},
+		{
+			name:     "classic url with foundry style",
+			jsonData: `{"openAI": {"provider": "azure", "url": "https://example.openai.azure.com", "azureEndpointStyle": "foundry"}}`,
+			expErr:   true,
+		},
+		{
+			name:     "unknown style",
+			jsonData: `{"openAI": {"provider": "azure", "url": "https://example.openai.azure.com", "azureEndpointStyle": "serverless"}}`,
+			expErr:   true,
+		},
+		{
+			name:     "load balanced mismatch",
+			jsonData: `{"loadBalance": [{"weight": 1, "openAI": {"provider": "azure", "url": "https://example.openai.azure.com", "azureEndpointStyle": "foundry"}}]}`,
+			expErr:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := loadSettings(backend.AppInstanceSettings{JSONData: []byte(tc.jsonData)})
+			if tc.expErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSettings failed: %s", err)
+			}
+			if settings.OpenAI.AzureAPIVersion != tc.expAPIVersion {
+				t.Errorf("expected API version %s, got %s", tc.expAPIVersion, settings.OpenAI.AzureAPIVersion)
+			}
+		})
+	}
+}
+
+func TestAzureFoundryCapabilities(t *testing.T) {
+	classic := &azureProvider{settings: OpenAISettings{AzureEndpointStyle: AzureEndpointStyleClassic}}
+	foundry := &azureProvider{settings: OpenAISettings{AzureEndpointStyle: AzureEndpointStyleFoundry}}
+	if c := classic.Capabilities(); !c.LegacyCompletions || !c.AudioTranscriptions {
+		t.Errorf("expected the classic endpoint to serve legacy completions and transcriptions, got %+v", c)
+	}
+	if c := foundry.Capabilities(); c.LegacyCompletions || c.AudioTranscriptions {
+		t.Errorf("expected Foundry not to serve legacy completions or transcriptions, got %+v", c)
+	}
+}
+
+func TestAzureFoundryHealthCheckRequest(t *testing.T) {
+	app := &App{settings: &Settings{OpenAI: OpenAISettings{
+		Provider:           openAIProviderAzure,
+		URL:                "https://test.services.ai.azure.com",
+		AzureMapping:       [][]string{{"gpt-4", "my-gpt-4"}},
+		AzureAPIVersion:    defaultAzureFoundryAPIVersion,
+		AzureEndpointStyle: AzureEndpointStyleFoundry,
+	}}}
+	req, err := app.newOpenAIChatCompletionsRequest(context.Background(), map[string]interface{}{"model": "gpt-4"})
+	if err != nil {
+		t.Fatalf("new request: %s", err)
+	}
+	if exp := "/models/chat/completions?api-version=" + defaultAzureFoundryAPIVersion; req.URL.RequestURI() != exp {
+		t.Errorf("expected %s, got %s", exp, req.URL.RequestURI())
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body: %s", err)
+	}
+	if body["model"] != "my-gpt-4" {
+		t.Errorf("expected the deployment as the model, got %v", body["model"])
+	}
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// errPromptBlocked is returned when a prompt matches a blocked pattern.
+var errPromptBlocked = errors.New("prompt blocked by content policy")
+
+// promptBlocklist rejects prompts matching any of a set of patterns.
+type promptBlocklist struct {
+	patterns []*regexp.Regexp
+}
+
+// newPromptBlocklist compiles the given regular expressions. Patterns are
+// case-insensitive unless they clear the flag themselves with `(?-i)`.
+func newPromptBlocklist(patterns []string) (*promptBlocklist, error) {
+	b := &promptBlocklist{}
+	for i, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("compile blocked pattern %d: %w", i, err)
+		}
+		b.patterns = append(b.patterns, re)
+	}
+	return b, nil
+}
+
+// userContent returns the text of all user messages in a chat completions
+// request body, or the prompts of a legacy completions request body, separated
+// by newlines.
+func userContent(body []byte) (string, error) {
+	var requestBody struct {
+		Prompt   json.RawMessage `json:"prompt"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &requestBody); err != nil {
+		return "", fmt.Errorf("unmarshal request body: %w", err)
+	}
+	var texts []string
+	if len(requestBody.Prompt) > 0 {
+		var prompts []string
+		if err := json.Unmarshal(requestBody.Prompt, &prompts); err != nil {
+			var prompt string
+			// Ignore prompts we can't parse; the provider will reject them.
+			_ = json.Unmarshal(requestBody.Prompt, &prompt)
+			prompts = []string{prompt}
+		}
+		texts = append(texts, prompts...)
+	}
+	for _, m := range requestBody.Messages {
+		if m.Role != "user" {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(m.Content, &s); err == nil {
+			texts = append(texts, s)
+			continue
+		}
+		var parts []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		// Ignore content we can't parse; the provider will reject it.
+		_ = json.Unmarshal(m.Content, &parts)
+		for _, part := range parts {
+			if part.Type == "text" {
+				texts = append(texts, part.Text)
+			}
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// check returns an error wrapping errPromptBlocked if the user content of body
+// matches a blocked pattern. A nil blocklist allows everything.
+func (b *promptBlocklist) check(body []byte) error {
+	if b == nil || len(b.patterns) == 0 {
+		return nil
+	}
+	content, err := userContent(body)
+	if err != nil {
+		return err
+	}
+	for i, re := range b.patterns {
+		if re.MatchString(content) {
+			// Identify the pattern by index only, so the response doesn't
+			// reveal what is blocked.
+			return fmt.Errorf("%w (pattern %d)", errPromptBlocked, i)
+		}
+	}
+	return nil
+}
+
+// requestTransformer rejects chat completions requests whose prompts match a
+// blocked pattern with HTTP 451, before they reach the provider.
+func (b *promptBlocklist) requestTransformer(req *http.Request) error {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err := b.check(body); err != nil {
+		if errors.Is(err, errPromptBlocked) {
+			return &TransformError{StatusCode: http.StatusUnavailableForLegalReasons, Err: err}
+		}
+		return &TransformError{StatusCode: http.StatusBadRequest, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BodyTransformOp is what a BodyTransform does at its path.
+type BodyTransformOp string
+
+const (
+	// BodyTransformSet sets the value at the path, adding it and any missing
+	// objects on the way if needed.
+	BodyTransformSet BodyTransformOp = "set"
+	// BodyTransformRemove removes the value at the path, if there is one.
+	BodyTransformRemove BodyTransformOp = "remove"
+)
+
+// BodyTransform is a rule changing the bodies of requests before they are
+// forwarded, such as setting $.temperature to 0 or removing $.logprobs.
+type BodyTransform struct {
+	Op BodyTransformOp `json:"op"`
+	// Path is a JSONPath, such as $.response_format.type or
+	// $.messages[*].name. It supports child names, in dot or bracket
+	// notation, array indexes, negative from the end, and `*` wildcards.
+	Path string `json:"path"`
+	// Value is the JSON value set by set rules.
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPathSegment is a step of a JSONPath: a child name, an array index, or a
+// wildcard matching every child or element.
+type jsonPathSegment struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the subset of JSONPath supported by BodyTransform.
+// The path must select something below the root.
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, errors.New("must start with $")
+	}
+	var segments []jsonPathSegment
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			switch name {
+			case "":
+				return nil, errors.New("empty name")
+			case "*":
+				segments = append(segments, jsonPathSegment{wildcard: true})
+			default:
+				segments = append(segments, jsonPathSegment{name: name})
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.New("unclosed [")
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				segments = append(segments, jsonPathSegment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				segments = append(segments, jsonPathSegment{name: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q", inner)
+				}
+				segments = append(segments, jsonPathSegment{index: index, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest[0])
+		}
+	}
+	if len(segments) == 0 {
+		return nil, errors.New("must select below the root")
+	}
+	return segments, nil
+}
+
+// bodyTransform is a parsed BodyTransform.
+type bodyTransform struct {
+	op    BodyTransformOp
+	path  []jsonPathSegment
+	value json.RawMessage
+}
+
+// bodyTransforms are rules applied in order to request bodies.
+type bodyTransforms []bodyTransform
+
+func newBodyTransforms(rules []BodyTransform) (bodyTransforms, error) {
+	transforms := make(bodyTransforms, 0, len(rules))
+	for i, r := range rules {
+		path, err := parseJSONPath(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("body transform %d: invalid path %q: %w", i, r.Path, err)
+		}
+		switch r.Op {
+		case BodyTransformSet:
+			var v interface{}
+			if err := json.Unmarshal(r.Value, &v); err != nil {
+				return nil, fmt.Errorf("body transform %d: invalid value: %w", i, err)
+			}
+		case BodyTransformRemove:
+		default:
+			return nil, fmt.Errorf("body transform %d: unknown op %q", i, r.Op)
+		}
+		transforms = append(transforms, bodyTransform{op: r.Op, path: path, value: r.Value})
+	}
+	return transforms, nil
+}
+
+// apply applies the rules to body in order. Rules whose path matches nothing
+// do nothing, except that set rules add missing names.
+func (t bodyTransforms) apply(body map[string]interface{}) {
+	for _, r := range t {
+		r.applyTo(body, r.path)
+	}
+}
+
+// newValue returns a fresh copy of the value, so that later transformers
+// can't change the rule by changing the body.
+func (r bodyTransform) newValue() interface{} {
+	var v interface{}
+	// The value was checked when the rule was parsed.
+	_ = json.Unmarshal(r.value, &v)
+	return v
+}
+
+// applyTo applies the rule to the part of node selected by path, returning
+// the changed node.
+func (r bodyTransform) applyTo(node interface{}, path []jsonPathSegment) interface{} {
+	seg, last := path[0], len(path) == 1
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if seg.isIndex {
+			return n
+		}
+		names := []string{seg.name}
+		if seg.wildcard {
+			names = names[:0]
+			for name := range n {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			child, ok := n[name]
+			switch {
+			case last && r.op == BodyTransformSet:
+				n[name] = r.newValue()
+			case last:
+				delete(n, name)
+			case ok:
+				n[name] = r.applyTo(child, path[1:])
+			case r.op == BodyTransformSet && !seg.wildcard && !path[1].isIndex && !path[1].wildcard:
+				// Add missing objects on the way to a name being set.
+				n[name] = r.applyTo(map[string]interface{}{}, path[1:])
+			}
+		}
+		return n
+	case []interface{}:
+		if !seg.isIndex && !seg.wildcard {
+			return n
+		}
+		var indexes []int
+		if seg.wildcard {
+			for i := range n {
+				indexes = append(indexes, i)
+			}
+		} else {
+			i := seg.index
+			if i < 0 {
+				i += len(n)
+			}
+			if i < 0 || i >= len(n) {
+				return n
+			}
+			indexes = []int{i}
+		}
+		if last && r.op == BodyTransformRemove {
+			remove := make(map[int]bool, len(indexes))
+			for _, i := range indexes {
+				remove[i] = true
+			}
+			kept := make([]interface{}, 0, len(n)-len(indexes))
+			for i, v := range n {
+				if !remove[i] {
+					kept = append(kept, v)
+				}
+			}
+			return kept
+		}
+		for _, i := range indexes {
+			if last {
+				n[i] = r.newValue()
+			} else {
+				n[i] = r.applyTo(n[i], path[1:])
+			}
+		}
+		return n
+	}
+	return node
+}
+
+// requestTransformer applies the rules to chat and legacy completions
+// requests.
+func (t bodyTransforms) requestTransformer(req *http.Request) error {
+	return rewriteJSONBody(req, func(body map[string]interface{}) error {
+		t.apply(body)
+		return nil
+	})
+}
@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestBodyTransformsApply(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rules []BodyTransform
+		body  string
+		exp   string
+	}{
+		{
+			name:  "set",
+			rules: []BodyTransform{{Op: BodyTransformSet, Path: "$.temperature", Value: json.RawMessage(`0`)}},
+			body:  `{"model": "gpt-4", "temperature": 1}`,
+			exp:   `{"model": "gpt-4", "temperature": 0}`,
+		},
+		{
+			name:  "set missing",
+			rules: []BodyTransform{{Op: BodyTransformSet, Path: "$.response_format.type", Value: json.RawMessage(`"json_object"`)}},
+			body:  `{"model": "gpt-4"}`,
+			exp:   `{"model": "gpt-4", "response_format": {"type": "json_object"}}`,
+		},
+		{
+			name:  "set wildcard",
+			rules: []BodyTransform{{Op: BodyTransformSet, Path: "$.messages[*].name", Value: json.RawMessage(`"grafana"`)}},
+			body:  `{"messages": [{"role": "system"}, {"role": "user", "name": "bob"}]}`,
+			exp:   `{"messages": [{"role": "system", "name": "grafana"}, {"role": "user", "name": "grafana"}]}`,
+		},
+		{
+			name:  "set index",
+			rules: []BodyTransform{{Op: BodyTransformSet, Path: "$['messages'][-1].content", Value: json.RawMessage(`"hi"`)}},
+			body:  `{"messages": [{"content": "a"}, {"content": "b"}]}`,
+			exp:   `{"messages": [{"content": "a"}, {"content": "hi"}]}`,
+		},
+		{
+			name:  "remove",
+			rules: []BodyTransform{{Op: BodyTransformRemove, Path: "$.logprobs"}},
+			body:  `{"model": "gpt-4", "logprobs": true}`,
+			exp:   `{"model": "gpt-4"}`,
+		},
+		{
+			name:  "remove element",
+			rules: []BodyTransform{{Op: BodyTransformRemove, Path: "$.messages[0]"}},
+			body:  `{"messages": [{"content": "a"}, {"content": "b"}]}`,
+			exp:   `{"messages": [{"content": "b"}]}`,
+		},
+		{
+			name: "no match",
+			rules: []BodyTransform{
+				{Op: BodyTransformRemove, Path: "$.logprobs"},
+				{Op: BodyTransformRemove, Path: "$.messages[5]"},
+				{Op: BodyTransformSet, Path: "$.messages[5].name", Value: json.RawMessage(`"x"`)},
+				{Op: BodyTransformSet, Path: "$.model.name", Value: json.RawMessage(`"x"`)},
+				{Op: BodyTransformSet, Path: "$.tools[0].type", Value: json.RawMessage(`"x"`)},
+			},
+			body: `{"model": "gpt-4", "messages": []}`,
+			exp:  `{"model": "gpt-4", "messages": []}`,
+		},
+		{
+			name: "in order",
+			rules: []BodyTransform{
+				{Op: BodyTransformSet, Path: "$.temperature", Value: json.RawMessage(`0`)},
+				{Op: BodyTransformRemove, Path: "$.temperature"},
+			},
+			body: `{"temperature": 1}`,
+			exp:  `{}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			transforms, err := newBodyTransforms(tc.rules)
+			if err != nil {
+				t.Fatalf("new body transforms: %s", err)
+			}
+			var body, exp map[string]interface{}
+			if err := json.Unmarshal([]byte(tc.body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tc.exp), &exp); err != nil {
+				t.Fatal(err)
+			}
+			transforms.apply(body)
+			if !reflect.DeepEqual(body, exp) {
+				t.Errorf("expected %v, got %v", exp, body)
+			}
+		})
+	}
+}
+
+func TestBodyTransformsInvalid(t *testing.T) {
+	for _, r := range []BodyTransform{
+		{Op: BodyTransformRemove, Path: "temperature"},
+		{Op: BodyTransformRemove, Path: "$"},
+		{Op: BodyTransformRemove, Path: "$..temperature"},
+		{Op: BodyTransformRemove, Path: "$.messages[x]"},
+		{Op: BodyTransformRemove, Path: "$.messages[0"},
+		{Op: BodyTransformSet, Path: "$.temperature"},
+		{Op: "replace", Path: "$.temperature"},
+	} {
+		if _, err := newBodyTransforms([]BodyTransform{r}); err == nil {
+			t.Errorf("expected an error for %+v", r)
+		}
+	}
+}
+
+func TestBodyTransforms(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI:        OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		DefaultParams: map[string]interface{}{"temperature": 0.2},
+		BodyTransforms: []BodyTransform{
+			{Op: BodyTransformSet, Path: "$.temperature", Value: json.RawMessage(`0`)},
+			{Op: BodyTransformRemove, Path: "$.logprobs"},
+		},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	var r mockCallResourceResponseSender
+	err = app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Method:        http.MethodPost,
+		Path:          "/openai/v1/chat/completions",
+		Body:          []byte(`{"model": "gpt-4", "messages": [], "logprobs": true}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.response.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(upstreamBody, &got); err != nil {
+		t.Fatalf("unmarshal upstream body %s: %s", upstreamBody, err)
+	}
+	exp := map[string]interface{}{"model": "gpt-4", "messages": []interface{}{}, "temperature": 0.0}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected upstream body %v, got %v", exp, got)
+	}
+
+	settings.BodyTransforms = []BodyTransform{{Op: "replace", Path: "$.temperature"}}
+	jsonData, err = json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings.JSONData = jsonData
+	if _, err := NewApp(ctx, appSettings); err == nil {
+		t.Error("expected an error for an invalid body transform")
+	}
+}
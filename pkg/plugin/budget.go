@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const budgetRemainingHeader = "X-LLM-Budget-Remaining"
+
+var errBudgetExhausted = errors.New("daily token budget exhausted, try again after midnight UTC")
+
+// BudgetSettings configures a daily token budget enforced per tenant.
+type BudgetSettings struct {
+	// DailyTokenBudget is the maximum number of tokens a tenant may use per day
+	// (UTC). If zero, no budget is enforced.
+	DailyTokenBudget int64 `json:"dailyTokenBudget"`
+}
+
+// tokenBudget tracks the tokens used by each tenant today and rejects requests
+// once the daily budget has been used up. Usage is taken from the `usage`
+// reported in upstream responses. Streamed requests are sent with
+// `stream_options.include_usage`, for providers which accept it, so that
+// their usage is reported in the final chunk and counted as it arrives.
+type tokenBudget struct {
+	limit int64
+
+	mu   sync.Mutex
+	day  time.Time
+	used map[string]int64
+	now  func() time.Time
+}
+
+func newTokenBudget(s BudgetSettings) *tokenBudget {
+	return &tokenBudget{
+		limit: s.DailyTokenBudget,
+		used:  map[string]int64{},
+		now:   time.Now,
+	}
+}
+
+// resetIfNewDay clears usage when the UTC day has changed. The caller must hold b.mu.
+func (b *tokenBudget) resetIfNewDay() {
+	today := b.now().UTC().Truncate(24 * time.Hour)
+	if !today.Equal(b.day) {
+		b.day = today
+		b.used = map[string]int64{}
+	}
+}
+
+// remaining returns the number of tokens the tenant has left today.
+func (b *tokenBudget) remaining(tenant string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resetIfNewDay()
+	return max(b.limit-b.used[tenant], 0)
+}
+
+// record adds tokens to the tenant's usage for today, returning the number remaining.
+func (b *tokenBudget) record(tenant string, tokens int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resetIfNewDay()
+	b.used[tenant] += tokens
+	return max(b.limit-b.used[tenant], 0)
+}
+
+// check returns errBudgetExhausted if the tenant has no tokens left today. A
+// nil budget is never exhausted.
+func (b *tokenBudget) check(tenant string) error {
+	if b != nil && b.remaining(tenant) <= 0 {
+		return errBudgetExhausted
+	}
+	return nil
+}
+
+// requestTransformer rejects requests with a 429 once the tenant's budget is exhausted.
+func (b *tokenBudget) requestTransformer(tenant string) RequestTransformer {
+	return func(req *http.Request) error {
+		if err := b.check(tenant); err != nil {
+			return &TransformError{StatusCode: http.StatusTooManyRequests, Err: err}
+		}
+		return nil
+	}
+}
+
+// responseTransformer records the tokens used by a response and reports the
+// remaining budget in a response header. The usage of streamed responses
+// isn't known until their end, so their header has the budget remaining
+// before them.
+func (b *tokenBudget) responseTransformer(tenant string) ResponseTransformer {
+	return func(resp *http.Response) error {
+		if isEventStream(resp) {
+			resp.Header.Set(budgetRemainingHeader, strconv.FormatInt(b.remaining(tenant), 10))
+			watchStreamUsage(resp, func(usage openAIUsage) {
+				b.record(tenant, usage.TotalTokens)
+			})
+			return nil
+		}
+		usage, ok, err := responseUsage(resp)
+		if err != nil {
+			return err
+		}
+		remaining := b.remaining(tenant)
+		if ok {
+			remaining = b.record(tenant, usage.TotalTokens)
+		} else if resp.StatusCode == http.StatusOK {
+			log.DefaultLogger.FromContext(resp.Request.Context()).Debug("No usage in response, not counting towards budget", "tenant", tenant)
+		}
+		resp.Header.Set(budgetRemainingHeader, strconv.FormatInt(remaining, 10))
+		return nil
+	}
+}
@@ -0,0 +1,269 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestTokenBudgetResetsDaily(t *testing.T) {
+	b := newTokenBudget(BudgetSettings{DailyTokenBudget: 100})
+	now := time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	if got := b.record("1", 150); got != 0 {
+		t.Errorf("expected 0 tokens remaining, got %d", got)
+	}
+	now = now.Add(2 * time.Minute)
+	if got := b.remaining("1"); got != 100 {
+		t.Errorf("expected budget to reset at midnight UTC, got %d remaining", got)
+	}
+}
+
+func TestTokenBudgetProxy(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [], "usage": {"prompt_tokens": 40, "completion_tokens": 20, "total_tokens": 60}}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		Tenant: "123",
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		Budget: BudgetSettings{DailyTokenBudget: 100},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for i, exp := range []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "40"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, ""},
+	} {
+		var r mockCallResourceResponseSender
+		err = app.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+			Method:        http.MethodPost,
+			Path:          "/openai/v1/chat/completions",
+			Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+		}, &r)
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+		if r.response.Status != exp.status {
+			t.Errorf("request %d: expected status %d, got %d", i, exp.status, r.response.Status)
+		}
+		if got := http.Header(r.response.Headers).Get(budgetRemainingHeader); got != exp.remaining {
+			t.Errorf("request %d: expected remaining budget %q, got %q", i, exp.remaining, got)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", calls)
+	}
+}
+
+func TestTokenBudgetIdempotentReplay(t *testing.T) {
+	ctx := context.Background()
+	const response = `{"choices": [], "usage": {"prompt_tokens": 40, "completion_tokens": 20, "total_tokens": 60}}`
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		Tenant: "123",
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		Budget: BudgetSettings{DailyTokenBudget: 1000},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for i, expReplayed := range []string{"", "true"} {
+		var r mockCallResourceResponseSender
+		err = app.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+			Method:        http.MethodPost,
+			Path:          "/openai/v1/chat/completions",
+			Headers:       map[string][]string{idempotencyKeyHeader: {"abc"}},
+			Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+		}, &r)
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+		if r.response.Status != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, r.response.Status)
+		}
+		if got := http.Header(r.response.Headers).Get(idempotentReplayedHeader); got != expReplayed {
+			t.Errorf("request %d: expected %s %q, got %q", i, idempotentReplayedHeader, expReplayed, got)
+		}
+		// Replayed responses keep the usage of the original.
+		if string(r.response.Body) != response {
+			t.Errorf("request %d: expected the original response, got %s", i, r.response.Body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls)
+	}
+	// The replay didn't use any more tokens, so isn't counted.
+	if got := app.budget.remaining(settings.Tenant); got != 940 {
+		t.Errorf("expected 940 tokens remaining, got %d", got)
+	}
+}
+
+// streamingCallResourceResponseSender collects a streamed response, which is
+// sent in several parts.
+type streamingCallResourceResponseSender struct {
+	status int
+	body   bytes.Buffer
+}
+
+func (s *streamingCallResourceResponseSender) Send(response *backend.CallResourceResponse) error {
+	if response.Status != 0 {
+		s.status = response.Status
+	}
+	s.body.Write(response.Body)
+	return nil
+}
+
+func TestTokenBudgetStreamedProxy(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"Hi\"}}]}\n\n"))
+		_, _ = w.Write([]byte("data: {\"choices\": [], \"usage\": {\"prompt_tokens\": 40, \"completion_tokens\": 20, \"total_tokens\": 60}}\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+	app, appSettings := newBudgetTestApp(t, server.URL, 1000)
+
+	for _, tc := range []struct {
+		name     string
+		body     string
+		expUsage bool
+	}{
+		{name: "usage not requested", body: `{"model": "gpt-4o", "messages": [], "stream": true}`},
+		{name: "usage requested", body: `{"model": "gpt-4o", "messages": [], "stream": true, "stream_options": {"include_usage": true}}`, expUsage: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			remaining := app.budget.remaining(app.settings.Tenant)
+			var r streamingCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(tc.body),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", r.status)
+			}
+			options, _ := upstreamBody["stream_options"].(map[string]interface{})
+			if options["include_usage"] != true {
+				t.Errorf("expected usage to be requested from the provider, got %v", upstreamBody)
+			}
+			if got := strings.Contains(r.body.String(), `"usage"`); got != tc.expUsage {
+				t.Errorf("expected the usage chunk to reach the client to be %t, got body %s", tc.expUsage, r.body.String())
+			}
+			if !strings.Contains(r.body.String(), "data: [DONE]") {
+				t.Errorf("expected the rest of the stream, got %s", r.body.String())
+			}
+			if got := app.budget.remaining(app.settings.Tenant); got != remaining-60 {
+				t.Errorf("expected the streamed usage to be counted, got %d remaining of %d", got, remaining)
+			}
+		})
+	}
+}
+
+func TestTokenBudgetExhaustedStream(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	app, appSettings := newBudgetTestApp(t, server.URL, 100)
+	app.budget.record(app.settings.Tenant, 100)
+
+	r := mockStreamPacketSender{messages: []json.RawMessage{}}
+	err := app.RunStream(context.Background(), &backend.RunStreamRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Path:          openAIChatCompletionsPath + "/abcd1234",
+		Data:          []byte(`{"model": "gpt-4o", "messages": []}`),
+	}, backend.NewStreamSender(&r))
+	if err != nil {
+		t.Fatalf("RunStream error: %s", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected no upstream calls, got %d", calls)
+	}
+	if len(r.messages) != 1 {
+		t.Fatalf("expected a single error message, got %s", r.messages)
+	}
+	var got EventError
+	if err := json.Unmarshal(r.messages[0], &got); err != nil || !strings.Contains(got.Error, errBudgetExhausted.Error()) {
+		t.Errorf("expected a budget exhausted error, got %s", r.messages[0])
+	}
+}
+
+func newBudgetTestApp(t *testing.T, url string, budget int64) (*App, backend.AppInstanceSettings) {
+	t.Helper()
+	jsonData, err := json.Marshal(Settings{
+		Tenant: "123",
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: url},
+		Budget: BudgetSettings{DailyTokenBudget: budget},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(context.Background(), appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	return inst.(*App), appSettings
+}
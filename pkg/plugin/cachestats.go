@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/store"
+)
+
+// cacheStatsResponse is the response to /cache/stats, describing the vector
+// search cache. The stats are all zero if the cache is disabled.
+type cacheStatsResponse struct {
+	Enabled bool `json:"enabled"`
+	store.CacheStats
+}
+
+func (a *App) handleCacheStats(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	var resp cacheStatsResponse
+	if a.vectorService != nil {
+		resp.CacheStats, resp.Enabled = a.vectorService.CacheStats()
+	}
+	if resp.Collections == nil {
+		resp.Collections = map[string]store.CollectionCacheStats{}
+	}
+	bodyJSON, err := json.Marshal(resp)
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	//nolint:errcheck // Just do our best to write.
+	w.Write(bodyJSON)
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/store"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCacheStats(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name          string
+		vectorService bool
+		expBody       cacheStatsResponse
+	}{
+		{
+			name:    "vector disabled",
+			expBody: cacheStatsResponse{CacheStats: store.CacheStats{Collections: map[string]store.CollectionCacheStats{}}},
+		},
+		{
+			name:          "cache enabled",
+			vectorService: true,
+			expBody: cacheStatsResponse{
+				Enabled: true,
+				CacheStats: store.CacheStats{
+					Hits:     3,
+					Misses:   1,
+					HitRatio: 0.75,
+					Entries:  1,
+					Collections: map[string]store.CollectionCacheStats{
+						"grafana": {Hits: 3, Misses: 1, HitRatio: 0.75},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := NewApp(ctx, backend.AppInstanceSettings{})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			if tc.vectorService {
+				app.vectorService = &mockVectorService{}
+			}
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				Method: http.MethodGet,
+				Path:   "/cache/stats",
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			var got cacheStatsResponse
+			if err := json.Unmarshal(r.response.Body, &got); err != nil {
+				t.Fatalf("unmarshal response: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.expBody) {
+				t.Errorf("expected %+v, got %+v", tc.expBody, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+var (
+	errRequestNotFound = errors.New("no such request in progress")
+	errDuplicateID     = errors.New("a request with this ID is already in progress")
+)
+
+type activeRequest struct {
+	// user is the login of the user who made the request. Only they may cancel it.
+	user   string
+	cancel context.CancelFunc
+}
+
+// activeRequests tracks in-flight requests by ID so they can be cancelled.
+type activeRequests struct {
+	mu       sync.Mutex
+	requests map[string]activeRequest
+}
+
+func newActiveRequests() *activeRequests {
+	return &activeRequests{requests: map[string]activeRequest{}}
+}
+
+func userLogin(u *backend.User) string {
+	if u == nil {
+		return ""
+	}
+	return u.Login
+}
+
+// start registers a request made by user, returning a context which is
+// cancelled if the request is, and a function to call once it completes.
+func (r *activeRequests) start(ctx context.Context, id, user string) (context.Context, func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.requests[id]; ok {
+		cancel()
+		return nil, nil, errDuplicateID
+	}
+	r.requests[id] = activeRequest{user: user, cancel: cancel}
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.requests, id)
+		r.mu.Unlock()
+		cancel()
+	}, nil
+}
+
+// cancel cancels the request with the given ID, if user made it. Requests by
+// other users are reported as not found, so IDs can't be probed.
+func (r *activeRequests) cancel(id, user string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.requests[id]
+	if !ok || req.user != user {
+		return errRequestNotFound
+	}
+	req.cancel()
+	delete(r.requests, id)
+	return nil
+}
+
+// middleware makes each request cancellable by its ID, assigned by
+// requestIDMiddleware, until it completes.
+func (r *activeRequests) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		user := userLogin(httpadapter.UserFromContext(req.Context()))
+		ctx, done, err := r.start(req.Context(), id, user)
+		if err != nil {
+			handleError(w, req, err, http.StatusConflict)
+			return
+		}
+		defer done()
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+type cancelRequest struct {
+	RequestID string `json:"requestId"`
+}
+
+// handleCancel cancels an in-flight proxied request or stream made by the
+// same user, aborting the upstream call.
+func (a *App) handleCancel(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	body := cancelRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		handleError(w, req, fmt.Errorf("decode request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	if body.RequestID == "" {
+		handleError(w, req, errors.New("`requestId` field is required"), http.StatusBadRequest)
+		return
+	}
+	if err := a.activeRequests.cancel(body.RequestID, userLogin(httpadapter.UserFromContext(req.Context()))); err != nil {
+		handleError(w, req, err, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
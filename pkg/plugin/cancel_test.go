@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCancelRequest(t *testing.T) {
+	ctx := context.Background()
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Read the body, so the server notices when the connection is closed.
+		_, _ = io.ReadAll(r.Body)
+		started <- struct{}{}
+		// Never respond; only cancelling the request ends it.
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	app, appSettings := newTransformTestApp(t, server.URL)
+	alice := &backend.User{Login: "alice"}
+	cancel := func(user *backend.User, id string) int {
+		t.Helper()
+		var r mockCallResourceResponseSender
+		err := app.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings, User: user},
+			Method:        http.MethodPost,
+			Path:          "/cancel",
+			Body:          []byte(`{"requestId": "` + id + `"}`),
+		}, &r)
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+		return r.response.Status
+	}
+
+	var r mockCallResourceResponseSender
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- app.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings, User: alice},
+			Method:        http.MethodPost,
+			Path:          "/openai/v1/chat/completions",
+			Headers:       map[string][]string{http.CanonicalHeaderKey(requestIDHeader): {"req-1"}},
+			Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+		}, &r)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the provider")
+	}
+
+	if status := cancel(&backend.User{Login: "bob"}, "req-1"); status != http.StatusNotFound {
+		t.Errorf("expected another user's cancel to get 404, got %d", status)
+	}
+	if status := cancel(alice, "unknown"); status != http.StatusNotFound {
+		t.Errorf("expected cancelling an unknown request to get 404, got %d", status)
+	}
+	if status := cancel(alice, "req-1"); status != http.StatusNoContent {
+		t.Fatalf("expected cancel to get 204, got %d", status)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request wasn't cancelled")
+	}
+	if got := http.Header(r.response.Headers).Get(requestIDHeader); got != "req-1" {
+		t.Errorf("expected %s header to be req-1, got %q", requestIDHeader, got)
+	}
+	// Once finished, the request can no longer be cancelled.
+	if status := cancel(alice, "req-1"); status != http.StatusNotFound {
+		t.Errorf("expected cancelling a finished request to get 404, got %d", status)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The provider's own request ID is replaced by ours.
+		w.Header().Set(requestIDHeader, "upstream")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	app, appSettings := newTransformTestApp(t, server.URL)
+	var r mockCallResourceResponseSender
+	err := app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Method:        http.MethodPost,
+		Path:          "/openai/v1/chat/completions",
+		Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	ids := http.Header(r.response.Headers).Values(requestIDHeader)
+	if len(ids) != 1 || len(ids[0]) != 36 {
+		t.Errorf("expected a single generated request ID, got %q", ids)
+	}
+}
+
+func TestCancelStream(t *testing.T) {
+	ctx := context.Background()
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	app, appSettings := newTransformTestApp(t, server.URL)
+	user := &backend.User{Login: "alice"}
+	errCh := make(chan error, 1)
+	go func() {
+		s := mockStreamPacketSender{messages: []json.RawMessage{}}
+		errCh <- app.RunStream(ctx, &backend.RunStreamRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings, User: user},
+			Path:          openAIChatCompletionsPath + "/stream-1",
+			Data:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+		}, backend.NewStreamSender(&s))
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream never reached the provider")
+	}
+
+	if err := app.activeRequests.cancel("stream-1", "alice"); err != nil {
+		t.Fatalf("cancel: %s", err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("RunStream error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream wasn't cancelled")
+	}
+}
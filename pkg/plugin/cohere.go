@@ -0,0 +1,333 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	defaultCohereURL = "https://api.cohere.com"
+	cohereChatPath   = "/v2/chat"
+	cohereModelsPath = "/v1/models"
+)
+
+var cohereModels = []string{"command-r-plus"}
+
+// cohereProvider talks to Cohere's chat API. Requests and responses are
+// translated between OpenAI's chat completions format and Cohere's, so
+// callers can use it exactly like an OpenAI provider.
+type cohereProvider struct {
+	settings OpenAISettings
+}
+
+func (p *cohereProvider) url() string {
+	if p.settings.URL == "" {
+		return defaultCohereURL
+	}
+	return p.settings.URL
+}
+
+func (p *cohereProvider) RewriteRequest(req *http.Request) error {
+	var providerPath string
+	switch {
+	case isChatCompletionsPath(req.URL.Path):
+		providerPath = cohereChatPath
+	case isModelsPath(req.URL.Path):
+		providerPath = cohereModelsPath
+	default:
+		return fmt.Errorf("unsupported path for Cohere: %s", req.URL.Path)
+	}
+	if err := modifyURL(p.url(), req); err != nil {
+		return err
+	}
+	req.URL.Path = providerPath
+	return nil
+}
+
+// cohereMessage is a message in a Cohere chat request.
+type cohereMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// TranslateBody converts an OpenAI chat completions request into a Cohere chat
+// request. System messages are combined into a single leading system message,
+// which takes the place of the older API's preamble.
+func (p *cohereProvider) TranslateBody(body []byte) ([]byte, error) {
+	var requestBody map[string]json.RawMessage
+	if err := json.Unmarshal(body, &requestBody); err != nil {
+		return nil, fmt.Errorf("unmarshal request body: %w", err)
+	}
+	var n int
+	if raw, ok := requestBody["n"]; ok {
+		if err := json.Unmarshal(raw, &n); err == nil && n > 1 {
+			return nil, fmt.Errorf("cohere does not support n > 1")
+		}
+	}
+	var messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(requestBody["messages"], &messages); err != nil {
+		return nil, fmt.Errorf("unmarshal messages: %w", err)
+	}
+
+	var system []string
+	translated := []cohereMessage{}
+	for _, m := range messages {
+		content, err := messageText(m.Content)
+		if err != nil {
+			return nil, err
+		}
+		switch m.Role {
+		case "system":
+			system = append(system, content)
+		case "user", "assistant":
+			translated = append(translated, cohereMessage{Role: m.Role, Content: content})
+		default:
+			return nil, fmt.Errorf("unsupported message role for Cohere: %s", m.Role)
+		}
+	}
+	if len(system) > 0 {
+		translated = append([]cohereMessage{{Role: "system", Content: strings.Join(system, "\n\n")}}, translated...)
+	}
+
+	cohereBody := map[string]interface{}{"messages": translated}
+	// Fields with the same meaning in both APIs, keyed by their OpenAI name.
+	for openAIName, cohereName := range map[string]string{
+		"model":             "model",
+		"stream":            "stream",
+		"temperature":       "temperature",
+		"max_tokens":        "max_tokens",
+		"top_p":             "p",
+		"stop":              "stop_sequences",
+		"seed":              "seed",
+		"frequency_penalty": "frequency_penalty",
+		"presence_penalty":  "presence_penalty",
+	} {
+		if v, ok := requestBody[openAIName]; ok {
+			cohereBody[cohereName] = v
+		}
+	}
+	newBody, err := json.Marshal(cohereBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return newBody, nil
+}
+
+// messageText returns the text of an OpenAI message's content, which is
+// either a string or a list of parts of which only text is supported.
+func messageText(content json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(content, &s); err == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", fmt.Errorf("unmarshal message content: %w", err)
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("unsupported content type for Cohere: %s", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func (p *cohereProvider) AuthHeader() (string, string) {
+	return "Authorization", "Bearer " + p.settings.key()
+}
+
+func (p *cohereProvider) ObserveRateLimits(resp *http.Response) {
+	p.settings.apiKeys.observe(strings.TrimPrefix(resp.Request.Header.Get("Authorization"), "Bearer "), resp)
+}
+
+func (p *cohereProvider) HealthModels() []string {
+	return cohereModels
+}
+
+func (p *cohereProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{StopFormat: StopFormatArray, ModelsList: true}
+}
+
+func (p *cohereProvider) SupportsVision(model string) bool {
+	return false
+}
+
+// cohereUsage is the token usage reported by Cohere.
+type cohereUsage struct {
+	BilledUnits struct {
+		InputTokens  int64 `json:"input_tokens"`
+		OutputTokens int64 `json:"output_tokens"`
+	} `json:"billed_units"`
+	Tokens struct {
+		InputTokens  int64 `json:"input_tokens"`
+		OutputTokens int64 `json:"output_tokens"`
+	} `json:"tokens"`
+}
+
+func (u *cohereUsage) openAI() *openAIUsage {
+	if u == nil {
+		return nil
+	}
+	prompt, completion := u.Tokens.InputTokens, u.Tokens.OutputTokens
+	if prompt == 0 && completion == 0 {
+		prompt, completion = u.BilledUnits.InputTokens, u.BilledUnits.OutputTokens
+	}
+	return &openAIUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+// cohereFinishReason maps a Cohere finish reason to OpenAI's equivalent.
+func cohereFinishReason(reason string) string {
+	switch reason {
+	case "COMPLETE", "STOP_SEQUENCE":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	case "TOOL_CALL":
+		return "tool_calls"
+	}
+	return strings.ToLower(reason)
+}
+
+// TranslateResponse converts a Cohere chat response into an OpenAI chat
+// completions response.
+func (p *cohereProvider) TranslateResponse(body []byte) ([]byte, error) {
+	var resp struct {
+		ID           string `json:"id"`
+		FinishReason string `json:"finish_reason"`
+		Message      struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+		Usage *cohereUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("unmarshal response body: %w", err)
+	}
+	var content strings.Builder
+	for _, c := range resp.Message.Content {
+		if c.Type == "text" {
+			content.WriteString(c.Text)
+		}
+	}
+	openAIResp := map[string]interface{}{
+		"id":     resp.ID,
+		"object": "chat.completion",
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": content.String()},
+			"finish_reason": cohereFinishReason(resp.FinishReason),
+		}},
+	}
+	if usage := resp.Usage.openAI(); usage != nil {
+		openAIResp["usage"] = usage
+	}
+	return json.Marshal(openAIResp)
+}
+
+// cohereStreamEvent is a single event in a streamed Cohere chat response. Only
+// the fields needed to rebuild OpenAI's chunks are included.
+type cohereStreamEvent struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Delta struct {
+		Message struct {
+			Content struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+		FinishReason string       `json:"finish_reason"`
+		Usage        *cohereUsage `json:"usage"`
+	} `json:"delta"`
+}
+
+// TranslateStream converts a streamed Cohere chat response into OpenAI's
+// server-sent events format, ending with the usual `[DONE]` event.
+func (p *cohereProvider) TranslateStream(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(translateCohereStream(body, pw))
+	}()
+	return &translatedStream{PipeReader: pr, body: body}
+}
+
+// translatedStream is the translated side of a stream. Closing it also closes
+// the original body, so the translating goroutine isn't left blocked reading it.
+type translatedStream struct {
+	*io.PipeReader
+	body io.Closer
+}
+
+func (s *translatedStream) Close() error {
+	s.PipeReader.Close()
+	return s.body.Close()
+}
+
+func translateCohereStream(r io.Reader, w io.Writer) error {
+	var id string
+	writeChunk := func(chunk map[string]interface{}) error {
+		chunk["id"] = id
+		chunk["object"] = "chat.completion.chunk"
+		b, err := json.Marshal(chunk)
+		if err != nil {
+			return fmt.Errorf("marshal chunk: %w", err)
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", b)
+		return err
+	}
+	choice := func(delta map[string]string, finishReason interface{}) []map[string]interface{} {
+		return []map[string]interface{}{{"index": 0, "delta": delta, "finish_reason": finishReason}}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			// Cohere also sends `event:` lines, but the type is repeated in the data.
+			continue
+		}
+		var event cohereStreamEvent
+		if err := json.Unmarshal(bytes.TrimSpace(data), &event); err != nil {
+			log.DefaultLogger.Warn("Unable to parse Cohere stream event", "err", err)
+			continue
+		}
+		var err error
+		switch event.Type {
+		case "message-start":
+			id = event.ID
+			err = writeChunk(map[string]interface{}{"choices": choice(map[string]string{"role": "assistant", "content": ""}, nil)})
+		case "content-delta":
+			err = writeChunk(map[string]interface{}{"choices": choice(map[string]string{"content": event.Delta.Message.Content.Text}, nil)})
+		case "message-end":
+			chunk := map[string]interface{}{"choices": choice(map[string]string{}, cohereFinishReason(event.Delta.FinishReason))}
+			if usage := event.Delta.Usage.openAI(); usage != nil {
+				chunk["usage"] = usage
+			}
+			if err = writeChunk(chunk); err == nil {
+				_, err = io.WriteString(w, "data: [DONE]\n\n")
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newCohereTestApp returns an App using the Cohere provider at serverURL.
+func newCohereTestApp(t *testing.T, serverURL string) (*App, backend.AppInstanceSettings) {
+	t.Helper()
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderCohere, URL: serverURL},
+		Budget: BudgetSettings{DailyTokenBudget: 100},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(context.Background(), appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	return inst.(*App), appSettings
+}
+
+func TestCohereProxy(t *testing.T) {
+	ctx := context.Background()
+	var reqBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != cohereChatPath {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		reqBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"id": "abc",
+			"finish_reason": "MAX_TOKENS",
+			"message": {"role": "assistant", "content": [{"type": "text", "text": "Hello"}, {"type": "text", "text": " there"}]},
+			"usage": {"billed_units": {"input_tokens": 3, "output_tokens": 2}, "tokens": {"input_tokens": 10, "output_tokens": 2}}
+		}`))
+	}))
+	defer server.Close()
+	app, appSettings := newCohereTestApp(t, server.URL)
+
+	var r mockCallResourceResponseSender
+	err := app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Method:        http.MethodPost,
+		Path:          "/openai/v1/chat/completions",
+		Body:          []byte(`{"model": "command-r-plus", "messages": [{"role": "system", "content": "Be brief."}, {"role": "user", "content": "Hi"}], "stop": "\n"}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.response.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+	}
+
+	// Cohere only accepts a list of stop sequences.
+	expReq := `{"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hi"}],"model":"command-r-plus","stop_sequences":["\n"]}`
+	if string(reqBody) != expReq {
+		t.Errorf("expected request body %s, got %s", expReq, reqBody)
+	}
+
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage openAIUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(r.response.Body, &resp); err != nil {
+		t.Fatalf("unmarshal response %s: %s", r.response.Body, err)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "Hello there" || resp.Choices[0].Message.Role != "assistant" || resp.Choices[0].FinishReason != "length" {
+		t.Errorf("unexpected response %s", r.response.Body)
+	}
+	if resp.Usage != (openAIUsage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12}) {
+		t.Errorf("unexpected usage %+v", resp.Usage)
+	}
+	// Usage from the response counts against the budget, although the
+	// provider's path isn't a completions path.
+	if remaining := app.budget.remaining(app.settings.Tenant); remaining != 88 {
+		t.Errorf("expected 88 tokens remaining, got %d", remaining)
+	}
+	if got := http.Header(r.response.Headers).Get(budgetRemainingHeader); got != "88" {
+		t.Errorf("expected the remaining budget header to be 88, got %q", got)
+	}
+}
+
+func TestCohereStream(t *testing.T) {
+	ctx := context.Background()
+	events := []string{
+		`{"id": "abc", "type": "message-start", "delta": {"message": {"role": "assistant"}}}`,
+		`{"type": "content-start", "index": 0, "delta": {"message": {"content": {"type": "text", "text": ""}}}}`,
+		`{"type": "content-delta", "index": 0, "delta": {"message": {"content": {"text": "Hello"}}}}`,
+		`{"type": "content-delta", "index": 0, "delta": {"message": {"content": {"text": " there"}}}}`,
+		`{"type": "content-end", "index": 0}`,
+		`{"type": "message-end", "delta": {"finish_reason": "COMPLETE", "usage": {"tokens": {"input_tokens": 10, "output_tokens": 2}}}}`,
+	}
+	var reqBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&reqBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, e := range events {
+			var typ struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal([]byte(e), &typ)
+			_, _ = w.Write([]byte("event: " + typ.Type + "\ndata: " + e + "\n\n"))
+			w.(http.Flusher).Flush()
+		}
+		// Hold the connection open until the client is done, so closing it
+		// doesn't trigger the stream's error handler.
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	app, appSettings := newCohereTestApp(t, server.URL)
+
+	r := mockStreamPacketSender{messages: []json.RawMessage{}}
+	err := app.RunStream(ctx, &backend.RunStreamRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Path:          openAIChatCompletionsPath + "/abcd1234",
+		Data:          []byte(`{"model": "command-r-plus", "messages": [{"role": "user", "content": "Hi"}]}`),
+	}, backend.NewStreamSender(&r))
+	if err != nil {
+		t.Fatalf("RunStream error: %s", err)
+	}
+	if reqBody["stream"] != true {
+		t.Errorf("expected stream to be requested, got %v", reqBody)
+	}
+
+	// message-start, two content deltas, message-end and the final done message.
+	if len(r.messages) != 5 {
+		t.Fatalf("expected 5 messages, got %d: %s", len(r.messages), r.messages)
+	}
+	agg := newStreamAggregator()
+	for _, m := range r.messages[:4] {
+		if err := agg.add(m); err != nil {
+			t.Fatalf("aggregate %s: %s", m, err)
+		}
+	}
+	got := agg.result()
+	exp := streamedChoice{Index: 0, Role: "assistant", Content: "Hello there", FinishReason: "stop"}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], exp) {
+		t.Errorf("expected choices %+v, got %+v", exp, got)
+	}
+	if agg.usage == nil || agg.usage.TotalTokens != 12 {
+		t.Errorf("unexpected usage %+v", agg.usage)
+	}
+	// Usage from the stream counts against the budget.
+	if remaining := app.budget.remaining(app.settings.Tenant); remaining != 88 {
+		t.Errorf("expected 88 tokens remaining, got %d", remaining)
+	}
+}
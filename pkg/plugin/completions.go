@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// legacyCompletionsOnlyFields are the fields of a legacy completions request
+// with no chat completions equivalent.
+var legacyCompletionsOnlyFields = []string{"prompt", "suffix", "echo", "best_of"}
+
+// isCompletionsPath reports whether path is a chat or legacy completions
+// endpoint, whose request and response bodies the proxy understands.
+func isCompletionsPath(path string) bool {
+	return strings.HasSuffix(path, "/completions")
+}
+
+// isLegacyCompletionsPath reports whether path is the legacy (`prompt` based)
+// completions endpoint.
+func isLegacyCompletionsPath(path string) bool {
+	return isCompletionsPath(path) && !isChatCompletionsPath(path)
+}
+
+// completionsPrompt returns the prompt of a legacy completions request. Only a
+// single prompt can be translated into a chat request.
+func completionsPrompt(v interface{}) (string, error) {
+	switch p := v.(type) {
+	case string:
+		return p, nil
+	case []interface{}:
+		if len(p) == 1 {
+			if s, ok := p[0].(string); ok {
+				return s, nil
+			}
+		}
+	case nil:
+		return "", errors.New("`prompt` field is required")
+	}
+	return "", errors.New("`prompt` must be a single string to be sent as a chat completions request")
+}
+
+// translateCompletionsRequest rewrites a legacy completions request into a chat
+// completions request with the prompt as a single user message, for providers
+// which no longer support the legacy endpoint.
+func translateCompletionsRequest(req *http.Request) error {
+	err := rewriteJSONBody(req, func(body map[string]interface{}) error {
+		prompt, err := completionsPrompt(body["prompt"])
+		if err != nil {
+			return &TransformError{StatusCode: http.StatusBadRequest, Err: err}
+		}
+		for _, field := range legacyCompletionsOnlyFields {
+			delete(body, field)
+		}
+		// The legacy `logprobs` is the number of most likely tokens to return
+		// log probabilities for; chat has a flag plus `top_logprobs`.
+		if n, ok := body["logprobs"].(float64); ok {
+			body["logprobs"] = true
+			body["top_logprobs"] = n
+		} else {
+			delete(body, "logprobs")
+		}
+		body["messages"] = []interface{}{
+			map[string]interface{}{"role": "user", "content": prompt},
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	req.URL.Path = strings.TrimSuffix(req.URL.Path, "/completions") + "/chat/completions"
+	req.URL.RawPath = ""
+	return nil
+}
+
+// toTextCompletion converts a chat completion, or a streamed chunk of one, into
+// the legacy text completion format in place. contentKey is the field of each
+// choice holding the generated message: `message`, or `delta` for chunks.
+func toTextCompletion(completion map[string]interface{}, contentKey string) {
+	completion["object"] = "text_completion"
+	choices, _ := completion["choices"].([]interface{})
+	for i, c := range choices {
+		choice, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		text := ""
+		if message, ok := choice[contentKey].(map[string]interface{}); ok {
+			text, _ = message["content"].(string)
+		}
+		choices[i] = map[string]interface{}{
+			"index":         choice["index"],
+			"text":          text,
+			"logprobs":      legacyLogprobs(choice["logprobs"]),
+			"finish_reason": choice["finish_reason"],
+		}
+	}
+}
+
+// translateCompletionsResponse converts a successful chat completions response
+// back into the legacy completions format the client asked for.
+func translateCompletionsResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	switch contentType := resp.Header.Get("Content-Type"); {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		body := resp.Body
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(translateCompletionsStream(body, pw))
+		}()
+		resp.Body = &translatedStream{PipeReader: pr, body: body}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+	case strings.HasPrefix(contentType, "application/json"):
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read response body: %w", err)
+		}
+		var completion map[string]interface{}
+		if err := json.Unmarshal(body, &completion); err != nil {
+			return fmt.Errorf("unmarshal chat completions response: %w", err)
+		}
+		toTextCompletion(completion, "message")
+		body, err = json.Marshal(completion)
+		if err != nil {
+			return fmt.Errorf("marshal completions response: %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
+
+// translateCompletionsStream converts streamed chat completion chunks into
+// legacy completion chunks. Everything other than the chunks, including the
+// final `[DONE]` event, is passed through unchanged.
+func translateCompletionsStream(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			var chunk map[string]interface{}
+			if err := json.Unmarshal(bytes.TrimSpace(data), &chunk); err == nil {
+				toTextCompletion(chunk, "delta")
+				b, err := json.Marshal(chunk)
+				if err != nil {
+					return fmt.Errorf("marshal chunk: %w", err)
+				}
+				line = append([]byte("data: "), b...)
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
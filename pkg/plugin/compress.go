@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minCompressBytes is the size below which responses of known length aren't
+// compressed, since gzip saves little or nothing on them.
+const minCompressBytes = 1024
+
+// acceptsGzip reports whether an Accept-Encoding header value allows gzip,
+// either by name or with a wildcard, and not with a quality of zero.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(name) == "q" {
+				q, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// gzipResponse compresses a response body for a client which accepts gzip.
+// Streamed responses are left alone, so that each event still reaches the
+// client as soon as it arrives, as are responses which are already encoded
+// and those too small to be worth compressing.
+func gzipResponse(resp *http.Response) {
+	if resp.Header.Get("Content-Encoding") != "" ||
+		strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") ||
+		(resp.ContentLength >= 0 && resp.ContentLength < minCompressBytes) {
+		return
+	}
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, body)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	resp.Body = &gzipBody{PipeReader: pr, body: body}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Add("Vary", "Accept-Encoding")
+}
+
+// gzipBody is a compressed response body. Closing it closes the original
+// body too, so that compression stops if the client goes away.
+type gzipBody struct {
+	*io.PipeReader
+	body io.ReadCloser
+}
+
+func (g *gzipBody) Close() error {
+	g.PipeReader.Close()
+	return g.body.Close()
+}
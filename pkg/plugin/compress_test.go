@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, exp := range map[string]bool{
+		"":                       false,
+		"gzip":                   true,
+		"deflate, gzip;q=0.8":    true,
+		"GZIP":                   true,
+		"*":                      true,
+		"br, deflate":            false,
+		"gzip;q=0":               false,
+		"gzip; q=0.0, identity":  false,
+		"identity, gzip ; q=1.0": true,
+	} {
+		if got := acceptsGzip(header); got != exp {
+			t.Errorf("acceptsGzip(%q): expected %t, got %t", header, exp, got)
+		}
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	completion := `{"choices": [{"message": {"content": "` + strings.Repeat("the answer ", 500) + `"}}]}`
+	stream := "data: {\"choices\": [{\"delta\": {\"content\": \"" + strings.Repeat("Hi ", 500) + "\"}}]}\n\ndata: [DONE]\n\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.RawQuery, "stream") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(stream))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(completion))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name           string
+		compress       bool
+		acceptEncoding string
+		stream         bool
+
+		expGzip bool
+	}{
+		{name: "gzip accepted", compress: true, acceptEncoding: "gzip, deflate", expGzip: true},
+		{name: "gzip not accepted", compress: true, acceptEncoding: "deflate"},
+		{name: "disabled", acceptEncoding: "gzip"},
+		{name: "stream", compress: true, acceptEncoding: "gzip", stream: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &directOpenAIProvider{settings: OpenAISettings{URL: server.URL}}
+			proxy := newProviderProxy(provider, providerProxyOptions{compress: tc.compress})
+			path := "/openai/v1/chat/completions"
+			exp := completion
+			if tc.stream {
+				path += "?stream"
+				exp = stream
+			}
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model": "gpt-4o"}`))
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+
+			var body io.Reader = w.Body
+			if encoding := w.Header().Get("Content-Encoding"); tc.expGzip {
+				if encoding != "gzip" {
+					t.Fatalf("expected Content-Encoding gzip, got %q", encoding)
+				}
+				if w.Body.Len() >= len(completion) {
+					t.Errorf("expected the response to be compressed, got %d bytes from %d", w.Body.Len(), len(completion))
+				}
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("new gzip reader: %s", err)
+				}
+				body = gz
+			} else if encoding != "" {
+				t.Fatalf("expected no Content-Encoding, got %q", encoding)
+			}
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("read body: %s", err)
+			}
+			if string(b) != exp {
+				t.Errorf("unexpected body %q", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+)
+
+// errContextLengthExceeded is returned when a request is too long for the
+// context window of its model.
+var errContextLengthExceeded = errors.New("context length exceeded")
+
+// defaultContextWindows are the context windows, in tokens, of well-known
+// models. Dated versions of a model, such as gpt-4o-2024-08-06, share its
+// window.
+var defaultContextWindows = map[string]int{
+	"gpt-3.5-turbo":        16385,
+	"gpt-4":                8192,
+	"gpt-4-32k":            32768,
+	"gpt-4-0125-preview":   128000,
+	"gpt-4-1106-preview":   128000,
+	"gpt-4-vision-preview": 128000,
+	"gpt-4-turbo":          128000,
+	"gpt-4o":               128000,
+	"gpt-4o-mini":          128000,
+}
+
+const (
+	// charsPerToken is the average number of characters in a token of
+	// English text, used to estimate the tokens in a prompt.
+	charsPerToken = 4
+	// tokensPerMessage is the overhead of each message in a chat, for its
+	// role and separators.
+	tokensPerMessage = 4
+	// tokensPerReply is the overhead of priming the model's reply.
+	tokensPerReply = 3
+)
+
+// ContextLimitSettings configures rejecting chat completions requests which
+// won't fit in their model's context window before they are sent to the
+// provider, rather than waiting for the provider to reject them.
+type ContextLimitSettings struct {
+	Enabled bool `json:"enabled"`
+	// ContextWindows gives models' context windows in tokens, adding to or
+	// replacing the built-in ones. A window of zero exempts a model from the
+	// check. Requests for models without a window are not checked.
+	ContextWindows map[string]int `json:"contextWindows"`
+}
+
+// contextLimit checks requests against their model's context window.
+type contextLimit struct {
+	windows map[string]int
+}
+
+func newContextLimit(s ContextLimitSettings) *contextLimit {
+	windows := make(map[string]int, len(defaultContextWindows)+len(s.ContextWindows))
+	for model, window := range defaultContextWindows {
+		windows[model] = window
+	}
+	for model, window := range s.ContextWindows {
+		windows[model] = window
+	}
+	return &contextLimit{windows: windows}
+}
+
+// window returns the context window of model, or zero if it is unknown.
+func (l *contextLimit) window(model string) int {
+	window, _ := lookupModel(l.windows, model)
+	return window
+}
+
+// estimatePromptTokens estimates the number of tokens in the messages of a
+// chat completions request body from the length of their text. Images and
+// other non-text content are not counted.
+func estimatePromptTokens(body map[string]interface{}) int {
+	messages, _ := body["messages"].([]interface{})
+	tokens := tokensPerReply
+	for _, message := range messages {
+		m, _ := message.(map[string]interface{})
+		chars := 0
+		switch content := m["content"].(type) {
+		case string:
+			chars += utf8.RuneCountInString(content)
+		case []interface{}:
+			for _, part := range content {
+				p, _ := part.(map[string]interface{})
+				if text, ok := p["text"].(string); ok {
+					chars += utf8.RuneCountInString(text)
+				}
+			}
+		}
+		toolCalls, _ := m["tool_calls"].([]interface{})
+		for _, call := range toolCalls {
+			c, _ := call.(map[string]interface{})
+			function, _ := c["function"].(map[string]interface{})
+			name, _ := function["name"].(string)
+			arguments, _ := function["arguments"].(string)
+			chars += utf8.RuneCountInString(name) + utf8.RuneCountInString(arguments)
+		}
+		tokens += tokensPerMessage + (chars+charsPerToken-1)/charsPerToken
+	}
+	return tokens
+}
+
+// maxTokens returns the maximum number of tokens a request body allows the
+// reply, or zero if it doesn't limit them.
+func maxTokens(body map[string]interface{}) int {
+	for _, key := range []string{"max_completion_tokens", "max_tokens"} {
+		if n, ok := body[key].(float64); ok && n > 0 {
+			return int(n)
+		}
+	}
+	return 0
+}
+
+// apply returns an error wrapping errContextLengthExceeded if the estimated
+// prompt tokens plus max_tokens of a chat completions request body are more
+// than its model's context window. A nil contextLimit allows everything.
+func (l *contextLimit) apply(body map[string]interface{}) error {
+	if l == nil {
+		return nil
+	}
+	model, _ := body["model"].(string)
+	window := l.window(model)
+	if window <= 0 {
+		return nil
+	}
+	prompt, reply := estimatePromptTokens(body), maxTokens(body)
+	if prompt+reply > window {
+		return fmt.Errorf("%w: an estimated %d prompt tokens plus %d max_tokens is more than the %d token context window of %s", errContextLengthExceeded, prompt, reply, window, model)
+	}
+	return nil
+}
+
+// requestTransformer rejects chat completions requests too long for their
+// model's context window with HTTP 400.
+func (l *contextLimit) requestTransformer(req *http.Request) error {
+	return rewriteJSONBody(req, func(body map[string]interface{}) error {
+		if err := l.apply(body); err != nil {
+			return &TransformError{StatusCode: http.StatusBadRequest, Err: err}
+		}
+		return nil
+	})
+}
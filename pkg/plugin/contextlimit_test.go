@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestContextLimit(t *testing.T) {
+	ctx := context.Background()
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		ContextLimit: ContextLimitSettings{
+			Enabled:        true,
+			ContextWindows: map[string]int{"small-model": 100, "gpt-4": 0},
+		},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	// 200 characters, about 50 tokens.
+	prompt := strings.Repeat("abcd", 50)
+	for _, tc := range []struct {
+		name      string
+		model     string
+		maxTokens int
+
+		expStatus int
+	}{
+		{name: "fits", model: "small-model", maxTokens: 20, expStatus: http.StatusOK},
+		{name: "max_tokens exceeds the window", model: "small-model", maxTokens: 60, expStatus: http.StatusBadRequest},
+		{name: "dated version", model: "small-model-2024-01-01", maxTokens: 60, expStatus: http.StatusBadRequest},
+		{name: "exempt model", model: "gpt-4", maxTokens: 60, expStatus: http.StatusOK},
+		{name: "unknown model", model: "other-model", maxTokens: 60, expStatus: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			body, err := json.Marshal(map[string]interface{}{
+				"model":      tc.model,
+				"max_tokens": tc.maxTokens,
+				"messages":   []map[string]interface{}{{"role": "user", "content": prompt}},
+			})
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          body,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if rejected := tc.expStatus != http.StatusOK; rejected {
+				if called {
+					t.Errorf("expected rejected request not to reach the provider")
+				}
+				if !strings.Contains(string(r.response.Body), "context length exceeded") {
+					t.Errorf("expected a context length error, got %s", r.response.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestContextLimitWindow(t *testing.T) {
+	l := newContextLimit(ContextLimitSettings{ContextWindows: map[string]int{"gpt-4o": 64000}})
+	for model, exp := range map[string]int{
+		"gpt-4":                  8192,
+		"gpt-4-0613":             8192,
+		"gpt-4-turbo-2024-04-09": 128000,
+		"gpt-4o":                 64000,
+		"gpt-4o-2024-08-06":      64000,
+		"gpt-4o-mini-2024-07-18": 128000,
+		"gpt-4omni":              0,
+		"llama3":                 0,
+	} {
+		if got := l.window(model); got != exp {
+			t.Errorf("expected the window of %s to be %d, got %d", model, exp, got)
+		}
+	}
+}
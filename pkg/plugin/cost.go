@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// estimatedCostHeader is set on responses to proxied completions requests to
+// their estimated cost in US dollars, from the usage they report and the
+// price of the model, when cost estimates are enabled.
+const estimatedCostHeader = "X-LLM-Estimated-Cost"
+
+// ModelPrice is the price of a model's tokens, in US dollars per million
+// tokens.
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// defaultModelPrices are the list prices of well-known models. Dated versions
+// of a model, such as gpt-4o-2024-08-06, share its price unless listed.
+var defaultModelPrices = map[string]ModelPrice{
+	"gpt-3.5-turbo":     {Prompt: 0.5, Completion: 1.5},
+	"gpt-4":             {Prompt: 30, Completion: 60},
+	"gpt-4-32k":         {Prompt: 60, Completion: 120},
+	"gpt-4-turbo":       {Prompt: 10, Completion: 30},
+	"gpt-4o":            {Prompt: 2.5, Completion: 10},
+	"gpt-4o-2024-05-13": {Prompt: 5, Completion: 15},
+	"gpt-4o-mini":       {Prompt: 0.15, Completion: 0.6},
+	"command-r":         {Prompt: 0.15, Completion: 0.6},
+	"command-r-plus":    {Prompt: 2.5, Completion: 10},
+}
+
+// CostEstimateSettings configures the X-LLM-Estimated-Cost response header.
+type CostEstimateSettings struct {
+	Enabled bool `json:"enabled"`
+	// Prices gives models' prices, adding to or replacing the built-in ones.
+	// Responses from models without a price don't get the header.
+	Prices map[string]ModelPrice `json:"prices"`
+}
+
+// costEstimator estimates the cost of requests from their usage.
+type costEstimator struct {
+	prices map[string]ModelPrice
+}
+
+func newCostEstimator(s CostEstimateSettings) *costEstimator {
+	prices := make(map[string]ModelPrice, len(defaultModelPrices)+len(s.Prices))
+	for model, price := range defaultModelPrices {
+		prices[model] = price
+	}
+	for model, price := range s.Prices {
+		prices[model] = price
+	}
+	return &costEstimator{prices: prices}
+}
+
+// estimate returns the cost in US dollars of usage by model, and whether the
+// model's price is known.
+func (c *costEstimator) estimate(model string, usage openAIUsage) (float64, bool) {
+	price, ok := lookupModel(c.prices, model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6, true
+}
+
+// responseTransformer sets the estimated cost header on responses which
+// report their usage, which excludes streamed responses since their headers
+// are sent before the usage is known.
+func (c *costEstimator) responseTransformer(resp *http.Response) error {
+	usage, ok, err := responseUsage(resp)
+	if err != nil || !ok {
+		return err
+	}
+	info, _ := resp.Request.Context().Value(proxyRequestInfoKey{}).(proxyRequestInfo)
+	if cost, ok := c.estimate(info.model, usage); ok {
+		resp.Header.Set(estimatedCostHeader, strconv.FormatFloat(cost, 'f', 6, 64))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestEstimatedCostHeader(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [], "usage": {"prompt_tokens": 1000, "completion_tokens": 500, "total_tokens": 1500}}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		CostEstimate: CostEstimateSettings{
+			Enabled: true,
+			Prices:  map[string]ModelPrice{"llama3": {Prompt: 1, Completion: 2}},
+		},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for _, tc := range []struct {
+		model string
+
+		expCost string
+	}{
+		// 1000 * $0.15 / 1M + 500 * $0.60 / 1M.
+		{model: "gpt-4o-mini", expCost: "0.000450"},
+		// A dated version, at gpt-4o's price: 1000 * $2.50 / 1M + 500 * $10 / 1M.
+		{model: "gpt-4o-2024-08-06", expCost: "0.007500"},
+		// A configured price: 1000 * $1 / 1M + 500 * $2 / 1M.
+		{model: "llama3", expCost: "0.002000"},
+		{model: "unknown-model"},
+	} {
+		t.Run(tc.model, func(t *testing.T) {
+			var r mockCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(`{"model": "` + tc.model + `", "messages": [{"role": "user", "content": "hi"}]}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			if got := http.Header(r.response.Headers).Get(estimatedCostHeader); got != tc.expCost {
+				t.Errorf("expected estimated cost %q, got %q", tc.expCost, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// redactedSecret replaces secret values in the effective settings.
+const redactedSecret = "***"
+
+// effectiveSettingsResponse is the response of the /settings/effective
+// endpoint.
+type effectiveSettingsResponse struct {
+	// Settings are the settings the plugin loaded, after defaults were
+	// applied, with secret values redacted.
+	Settings Settings `json:"settings"`
+	// Secrets reports which secrets were set, without their values.
+	Secrets map[string]bool `json:"secrets"`
+}
+
+// effectiveSettings returns the loaded settings with secrets redacted. API
+// keys aren't serialized at all, so only values which are, such as the
+// grafana.com API key, need replacing.
+func (a *App) effectiveSettings() effectiveSettingsResponse {
+	s := *a.settings
+	if s.GrafanaComAPIKey != "" {
+		s.GrafanaComAPIKey = redactedSecret
+	}
+	secrets := make(map[string]bool, len(s.secretNames))
+	for _, name := range s.secretNames {
+		secrets[name] = true
+	}
+	return effectiveSettingsResponse{Settings: s, Secrets: secrets}
+}
+
+// handleEffectiveSettings returns the settings the plugin actually loaded, to
+// help diagnose provisioning problems. Only admins may see them.
+func (a *App) handleEffectiveSettings(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	user := httpadapter.UserFromContext(req.Context())
+	if user == nil || user.Role != "Admin" {
+		handleError(w, req, errors.New("only admins can view the effective settings"), http.StatusForbidden)
+		return
+	}
+	bodyJSON, err := json.Marshal(a.effectiveSettings())
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	//nolint:errcheck // Just do our best to write.
+	w.Write(bodyJSON)
+}
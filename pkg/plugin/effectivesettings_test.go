@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestEffectiveSettings(t *testing.T) {
+	ctx := context.Background()
+	appSettings := backend.AppInstanceSettings{
+		JSONData: []byte(`{"openAI": {"provider": "azure", "url": "https://example.openai.azure.com"}}`),
+		DecryptedSecureJSONData: map[string]string{
+			openAIKey:                "abcd1234",
+			encodedTenantAndTokenKey: base64.StdEncoding.EncodeToString([]byte("123:gcom-token")),
+			"qdrantApiKey":           "",
+		},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for _, tc := range []struct {
+		name string
+		user *backend.User
+
+		expStatus int
+	}{
+		{name: "admin", user: &backend.User{Login: "admin", Role: "Admin"}, expStatus: http.StatusOK},
+		{name: "editor", user: &backend.User{Login: "editor", Role: "Editor"}, expStatus: http.StatusForbidden},
+		{name: "anonymous", expStatus: http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r mockCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings, User: tc.user},
+				Method:        http.MethodGet,
+				Path:          "/settings/effective",
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			for _, secret := range []string{"abcd1234", "gcom-token"} {
+				if strings.Contains(string(r.response.Body), secret) {
+					t.Errorf("expected secrets to be redacted, got %s", r.response.Body)
+				}
+			}
+			if tc.expStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Settings map[string]interface{} `json:"settings"`
+				Secrets  map[string]bool        `json:"secrets"`
+			}
+			if err := json.Unmarshal(r.response.Body, &resp); err != nil {
+				t.Fatalf("unmarshal response %s: %s", r.response.Body, err)
+			}
+			openAI, _ := resp.Settings["openAI"].(map[string]interface{})
+			// Defaults are applied.
+			if openAI["provider"] != "azure" || openAI["azureApiVersion"] != defaultAzureAPIVersion {
+				t.Errorf("unexpected OpenAI settings %v", openAI)
+			}
+			if resp.Settings["Tenant"] != "123" || resp.Settings["GrafanaComAPIKey"] != redactedSecret {
+				t.Errorf("expected the tenant and a redacted grafana.com API key, got %v, %v", resp.Settings["Tenant"], resp.Settings["GrafanaComAPIKey"])
+			}
+			expSecrets := map[string]bool{openAIKey: true, encodedTenantAndTokenKey: true}
+			if len(resp.Secrets) != len(expSecrets) || !resp.Secrets[openAIKey] || !resp.Secrets[encodedTenantAndTokenKey] {
+				t.Errorf("expected secrets %v, got %v", expSecrets, resp.Secrets)
+			}
+		})
+	}
+}
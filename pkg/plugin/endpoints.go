@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// errEndpointDisabled is returned for requests to proxy endpoints which
+// aren't in the EnabledEndpoints setting.
+var errEndpointDisabled = errors.New("endpoint disabled")
+
+// endpointAllowList holds the enabled proxy endpoints, as paths relative to
+// /openai such as /v1/chat/completions.
+type endpointAllowList struct {
+	paths    map[string]bool
+	prefixes []string
+}
+
+// proxyEndpoint returns path relative to /openai, with a leading slash and
+// without a trailing one.
+func proxyEndpoint(path string) string {
+	return strings.TrimSuffix(proxyPrefix(path), "/")
+}
+
+// proxyPrefix returns path relative to /openai, with a leading slash,
+// keeping any trailing one.
+func proxyPrefix(path string) string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "/"), "openai")
+	return "/" + strings.TrimPrefix(path, "/")
+}
+
+// newEndpointAllowList returns a list allowing only endpoints, or nil to
+// allow all endpoints if it is empty. A path ending in `*` allows all paths
+// starting with the rest of it.
+func newEndpointAllowList(endpoints []string) *endpointAllowList {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	l := &endpointAllowList{paths: map[string]bool{}}
+	for _, e := range endpoints {
+		if prefix, ok := strings.CutSuffix(e, "*"); ok {
+			l.prefixes = append(l.prefixes, proxyPrefix(prefix))
+		} else {
+			l.paths[proxyEndpoint(e)] = true
+		}
+	}
+	return l
+}
+
+// check returns an error wrapping errEndpointDisabled unless the endpoint
+// at path is enabled. A nil list enables all endpoints.
+func (l *endpointAllowList) check(path string) error {
+	if l == nil {
+		return nil
+	}
+	endpoint := proxyEndpoint(path)
+	if l.paths[endpoint] {
+		return nil
+	}
+	for _, prefix := range l.prefixes {
+		if strings.HasPrefix(endpoint, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not enabled for this instance", errEndpointDisabled, endpoint)
+}
+
+// middleware rejects requests to disabled endpoints with HTTP 403. A nil list
+// returns next unchanged.
+func (l *endpointAllowList) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := l.check(req.URL.Path); err != nil {
+			writeProxyError(w, req, err, http.StatusForbidden, "endpoint_disabled")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
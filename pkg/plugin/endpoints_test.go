@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestEnabledEndpoints(t *testing.T) {
+	ctx := context.Background()
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name      string
+		endpoints []string
+		path      string
+
+		expStatus int
+	}{
+		{
+			name:      "all enabled by default",
+			path:      "/openai/v1/embeddings",
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "chat enabled",
+			endpoints: []string{"/v1/chat/completions"},
+			path:      "/openai/v1/chat/completions",
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "embeddings disabled",
+			endpoints: []string{"/v1/chat/completions"},
+			path:      "/openai/v1/embeddings",
+			expStatus: http.StatusForbidden,
+		},
+		{
+			name:      "prefix enabled",
+			endpoints: []string{"/v1/chat/*"},
+			path:      "/openai/v1/chat/completions",
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "outside prefix disabled",
+			endpoints: []string{"/v1/chat/*"},
+			path:      "/openai/v1/embeddings",
+			expStatus: http.StatusForbidden,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			settings := Settings{
+				OpenAI:           OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+				EnabledEndpoints: tc.endpoints,
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          tc.path,
+				Body:          []byte(`{"model": "gpt-4o", "input": "hi", "messages": [{"role": "user", "content": "hi"}]}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if tc.expStatus == http.StatusForbidden {
+				if called {
+					t.Error("expected the provider not to be called")
+				}
+				if !strings.Contains(string(r.response.Body), "/v1/embeddings is not enabled") {
+					t.Errorf("expected the disabled endpoint in the body, got %s", r.response.Body)
+				}
+			} else if !called {
+				t.Error("expected the provider to be called")
+			}
+		})
+	}
+}
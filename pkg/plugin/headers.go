@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// alwaysForwardedHeaders are copied from the incoming request to the provider
+// regardless of the ForwardHeaders setting, since requests don't work without them.
+var alwaysForwardedHeaders = []string{"Accept", "Content-Type", idempotencyKeyHeader}
+
+// hopByHopHeaders only apply to a single connection and are never forwarded,
+// even if allow-listed. See RFC 9110, section 7.6.1.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// defaultStripHeaders are never sent to the provider, whatever the
+// ForwardHeaders and StripHeaders settings, since they may carry Grafana
+// credentials or user details. The provider's own Authorization header is set
+// after they're stripped.
+var defaultStripHeaders = []string{"Cookie", "Authorization", "X-Grafana-*"}
+
+// headerStripList is a set of headers which are never sent to the provider.
+// A name ending in `*` strips all headers starting with the rest of it.
+type headerStripList struct {
+	names    map[string]bool
+	prefixes []string
+}
+
+func newHeaderStripList(strip []string) headerStripList {
+	l := headerStripList{names: map[string]bool{}}
+	for _, h := range append(defaultStripHeaders, strip...) {
+		if prefix, ok := strings.CutSuffix(h, "*"); ok {
+			l.prefixes = append(l.prefixes, http.CanonicalHeaderKey(prefix))
+		} else {
+			l.names[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+	return l
+}
+
+// matches reports whether the header named key must be stripped.
+func (l headerStripList) matches(key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	if l.names[key] {
+		return true
+	}
+	for _, prefix := range l.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// strip removes all headers in the list.
+func (l headerStripList) strip(h http.Header) {
+	for k := range h {
+		if l.matches(k) {
+			delete(h, k)
+		}
+	}
+}
+
+// headerAllowList is the set of canonical header names forwarded to the provider.
+type headerAllowList map[string]bool
+
+func newHeaderAllowList(forward []string, strip headerStripList) headerAllowList {
+	l := headerAllowList{}
+	for _, h := range alwaysForwardedHeaders {
+		l[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, h := range forward {
+		l[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, h := range hopByHopHeaders {
+		if l[h] {
+			log.DefaultLogger.Warn("Ignoring hop-by-hop header in forwardHeaders", "header", h)
+			delete(l, h)
+		}
+	}
+	for h := range l {
+		if strip.matches(h) {
+			log.DefaultLogger.Warn("Ignoring stripped header in forwardHeaders", "header", h)
+			delete(l, h)
+		}
+	}
+	return l
+}
+
+// filter removes all headers which aren't allow-listed.
+func (l headerAllowList) filter(h http.Header) {
+	for k := range h {
+		if !l[http.CanonicalHeaderKey(k)] {
+			delete(h, k)
+		}
+	}
+}
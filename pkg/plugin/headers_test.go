@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestForwardHeaders(t *testing.T) {
+	ctx := context.Background()
+	var upstream http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI:         OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		ForwardHeaders: []string{"x-request-id", "X-Trace-Id", "Upgrade"},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	var r mockCallResourceResponseSender
+	err = app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Method:        http.MethodPost,
+		Path:          "/openai/v1/chat/completions",
+		Headers: map[string][]string{
+			"Content-Type":   {"application/json"},
+			"X-Request-Id":   {"req-1"},
+			"X-Trace-Id":     {"trace-1"},
+			"X-Grafana-User": {"admin"},
+			"Cookie":         {"grafana_session=secret"},
+			"Authorization":  {"Bearer grafana-token"},
+			"Upgrade":        {"websocket"},
+		},
+		Body: []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.response.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+	}
+
+	for _, tc := range []struct {
+		header string
+		exp    string
+	}{
+		{header: "Content-Type", exp: "application/json"},
+		{header: "X-Request-Id", exp: "req-1"},
+		{header: "X-Trace-Id", exp: "trace-1"},
+		// Dropped, since they aren't allow-listed.
+		{header: "X-Grafana-User", exp: ""},
+		{header: "Cookie", exp: ""},
+		// Hop-by-hop headers are never forwarded.
+		{header: "Upgrade", exp: ""},
+		// Replaced by the provider's credentials.
+		{header: "Authorization", exp: "Bearer abcd1234"},
+	} {
+		if got := upstream.Get(tc.header); got != tc.exp {
+			t.Errorf("expected upstream header %s to be %q, got %q", tc.header, tc.exp, got)
+		}
+	}
+}
+
+func TestStripHeaders(t *testing.T) {
+	ctx := context.Background()
+	var upstream http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		// Stripped headers win over forwarded ones.
+		ForwardHeaders: []string{"X-Request-Id", "Cookie", "X-Grafana-Org-Id", "X-Internal-Token"},
+		StripHeaders:   []string{"x-internal-*"},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+	// Headers added by transformers are stripped too.
+	app.RegisterRequestTransformer(func(req *http.Request) error {
+		req.Header.Set("X-Grafana-Id", "token")
+		return nil
+	})
+
+	var r mockCallResourceResponseSender
+	err = app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Method:        http.MethodPost,
+		Path:          "/openai/v1/chat/completions",
+		Headers: map[string][]string{
+			"Content-Type":     {"application/json"},
+			"X-Request-Id":     {"req-1"},
+			"Cookie":           {"grafana_session=secret"},
+			"X-Grafana-Org-Id": {"1"},
+			"X-Internal-Token": {"secret"},
+		},
+		Body: []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.response.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+	}
+
+	for _, tc := range []struct {
+		header string
+		exp    string
+	}{
+		{header: "X-Request-Id", exp: "req-1"},
+		{header: "Cookie", exp: ""},
+		{header: "X-Grafana-Org-Id", exp: ""},
+		{header: "X-Grafana-Id", exp: ""},
+		{header: "X-Internal-Token", exp: ""},
+		{header: "Authorization", exp: "Bearer abcd1234"},
+	} {
+		if got := upstream.Get(tc.header); got != tc.exp {
+			t.Errorf("expected upstream header %s to be %q, got %q", tc.header, tc.exp, got)
+		}
+	}
+}
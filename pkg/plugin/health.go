@@ -2,10 +2,17 @@ package plugin
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/build"
@@ -13,13 +20,31 @@ import (
 
 var openAIModels = []string{"gpt-3.5-turbo", "gpt-4"}
 
+// errOpenAIAuthFailed is returned by testOpenAIModel when the provider rejects
+// our credentials, so that callers can distinguish a bad key from other failures.
+var errOpenAIAuthFailed = errors.New("authentication failed - check the API key")
+
+// reachabilityTimeout bounds how long we wait to connect to the provider's host
+// before giving up on the health check.
+const reachabilityTimeout = 5 * time.Second
+
+// healthRefreshTimeout bounds how long a background health check refresh, in
+// stale-while-revalidate mode, may take.
+const healthRefreshTimeout = time.Minute
+
 type healthCheckClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+// reachabilityChecker checks whether the provider at the given URL can be reached.
+type reachabilityChecker func(ctx context.Context, rawURL string) error
+
 type openAIModelHealth struct {
 	OK    bool   `json:"ok"`
 	Error string `json:"error,omitempty"`
+	// Unknown is true if the model wasn't checked because the health check
+	// was cancelled, so OK being false doesn't mean it isn't working.
+	Unknown bool `json:"unknown,omitempty"`
 }
 
 type openAIHealthDetails struct {
@@ -27,18 +52,166 @@ type openAIHealthDetails struct {
 	OK         bool                         `json:"ok"`
 	Error      string                       `json:"error,omitempty"`
 	Models     map[string]openAIModelHealth `json:"models"`
+	// AuthFailed is true if the provider rejected the configured credentials
+	// (HTTP 401 or 403) for every model checked.
+	AuthFailed bool `json:"authFailed,omitempty"`
+	// ActiveEndpoint is the llm-gateway endpoint currently in use, when the
+	// Grafana-managed provider is configured.
+	ActiveEndpoint string `json:"activeEndpoint,omitempty"`
+	// Reachable is true if we could connect to the provider's host. If false,
+	// the models weren't checked.
+	Reachable bool `json:"reachable"`
+	// AvgLatencyMs is a moving average of the latency of successful chat
+	// completions requests, from both health checks and proxied requests.
+	AvgLatencyMs float64 `json:"avgLatencyMs,omitempty"`
+	// Unknown is true if the health check was cancelled before any model
+	// could be checked successfully, and none were found not to work.
+	Unknown bool `json:"unknown,omitempty"`
+	// CheckIntervalSeconds is how long results are currently reused for,
+	// with adaptive health checks.
+	CheckIntervalSeconds float64 `json:"checkIntervalSeconds,omitempty"`
+	// Providers is the health of each load balanced provider, by name, with
+	// CheckAllProviders.
+	Providers map[string]openAIHealthDetails `json:"providers,omitempty"`
+}
+
+// incomplete reports whether any part of the check was cancelled.
+func (d openAIHealthDetails) incomplete() bool {
+	if d.Unknown {
+		return true
+	}
+	for _, m := range d.Models {
+		if m.Unknown {
+			return true
+		}
+	}
+	for _, p := range d.Providers {
+		if p.incomplete() {
+			return true
+		}
+	}
+	return false
+}
+
+type vectorCollectionHealth struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
 }
 
 type vectorHealthDetails struct {
 	Enabled bool   `json:"enabled"`
 	OK      bool   `json:"ok"`
 	Error   string `json:"error,omitempty"`
+	// Unknown is true if the health check was cancelled before it finished.
+	Unknown bool `json:"unknown,omitempty"`
+	// Collections is the health of each of the configured HealthCollections,
+	// if the vector services themselves are working.
+	Collections map[string]vectorCollectionHealth `json:"collections,omitempty"`
+	// CheckIntervalSeconds is how long results are currently reused for,
+	// with adaptive health checks.
+	CheckIntervalSeconds float64 `json:"checkIntervalSeconds,omitempty"`
+	// DeepCheckLatencyMs is how long the deep check's search took, including
+	// embedding the query, if it's enabled and succeeded.
+	DeepCheckLatencyMs float64 `json:"deepCheckLatencyMs,omitempty"`
 }
 
+// healthStatus is the overall health of the plugin's features.
+type healthStatus string
+
+const (
+	// healthStatusHealthy means every configured feature is working.
+	healthStatusHealthy healthStatus = "healthy"
+	// healthStatusDegraded means some, but not all, configured features are working.
+	healthStatusDegraded healthStatus = "degraded"
+	// healthStatusUnhealthy means no features are configured or none are working.
+	healthStatusUnhealthy healthStatus = "unhealthy"
+	// healthStatusUnknown means no configured features were found not to be
+	// working, but some couldn't be checked because the check was cancelled.
+	healthStatusUnknown healthStatus = "unknown"
+)
+
+// errHealthCheckCancelled is reported for checks which didn't finish because
+// the health check's context was cancelled, typically by a client timeout.
+var errHealthCheckCancelled = errors.New("health check cancelled before this was checked")
+
 type healthCheckDetails struct {
 	OpenAI  openAIHealthDetails `json:"openAI"`
 	Vector  vectorHealthDetails `json:"vector"`
 	Version string              `json:"version"`
+	// Status summarizes the health of the features above, so that clients
+	// don't each need to work it out.
+	Status healthStatus `json:"status"`
+	// Summary is a human-readable description of Status.
+	Summary string `json:"summary"`
+	// Stale is true if these are the details of an earlier check, persisted
+	// by a previous instance, which a new check is running to replace.
+	Stale bool `json:"stale,omitempty"`
+	// CheckedAt is when the check was made, if Stale.
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+}
+
+// summarizeHealth returns the overall status of the configured features and a
+// description of it. Features which aren't configured are ignored, and those
+// which couldn't be checked aren't counted as failing.
+func summarizeHealth(openAI openAIHealthDetails, vector vectorHealthDetails) (healthStatus, string) {
+	type feature struct {
+		name    string
+		ok      bool
+		unknown bool
+		error   string
+	}
+	var features []feature
+	if openAI.Configured {
+		features = append(features, feature{name: "LLM provider", ok: openAI.OK, unknown: openAI.Unknown, error: openAI.Error})
+	}
+	if vector.Enabled {
+		features = append(features, feature{name: "vector search", ok: vector.OK, unknown: vector.Unknown, error: vector.Error})
+	}
+	if len(features) == 0 {
+		return healthStatusUnhealthy, "No features are configured"
+	}
+	var failing, unknown []string
+	for _, f := range features {
+		if f.ok {
+			continue
+		}
+		if f.unknown {
+			unknown = append(unknown, f.name)
+			continue
+		}
+		if f.error != "" {
+			failing = append(failing, fmt.Sprintf("%s (%s)", f.name, f.error))
+		} else {
+			failing = append(failing, f.name)
+		}
+	}
+	summary := "Not working: " + strings.Join(failing, ", ")
+	if len(unknown) > 0 {
+		notChecked := "Not checked before the health check was cancelled: " + strings.Join(unknown, ", ")
+		if len(failing) == 0 {
+			return healthStatusUnknown, notChecked
+		}
+		summary += ". " + notChecked
+	}
+	switch len(failing) {
+	case 0:
+		return healthStatusHealthy, "All configured features are working"
+	case len(features):
+		return healthStatusUnhealthy, summary
+	default:
+		return healthStatusDegraded, summary
+	}
+}
+
+// defaultHealthCheckPrompt is the prompt sent to each model by health checks,
+// unless HealthCheckPrompt is set.
+const defaultHealthCheckPrompt = "Hello"
+
+func (s Settings) healthCheckPrompt() string {
+	if s.HealthCheckPrompt == "" {
+		return defaultHealthCheckPrompt
+	}
+	return s.HealthCheckPrompt
 }
 
 func getVersion() string {
@@ -49,26 +222,94 @@ func getVersion() string {
 	return buildInfo.Version
 }
 
+// dialProvider checks that we can open a TCP connection to the host in rawURL,
+// completing a TLS handshake for https URLs. This lets us distinguish network
+// problems from authentication or model problems.
+func dialProvider(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("parse URL: %w", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	ctx, cancel := context.WithTimeout(ctx, reachabilityTimeout)
+	defer cancel()
+	var conn net.Conn
+	if u.Scheme == "http" {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&tls.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// providerURL returns the base URL of the configured OpenAI provider.
+func (a *App) providerURL() string {
+	if a.settings.OpenAI.Provider == openAIProviderGrafana {
+		if a.llmGateway != nil {
+			return a.llmGateway.activeURL()
+		}
+		return a.settings.LLMGateway.URL
+	}
+	return a.settings.OpenAI.URL
+}
+
+// healthModels returns the models to test in the health check.
+func (a *App) healthModels() []string {
+	if a.provider == nil {
+		return openAIModels
+	}
+	return a.provider.HealthModels()
+}
+
+// testOpenAIModel sends a chat completions request for a single token to
+// model, checking that the provider accepts it.
 func (a *App) testOpenAIModel(ctx context.Context, model string) error {
 	body := map[string]interface{}{
 		"model": model,
 		"messages": []map[string]interface{}{
 			{
 				"role":    "user",
-				"content": "Hello",
+				"content": a.settings.healthCheckPrompt(),
 			},
 		},
+		// A single token is enough to know the model works, and costs least.
 		"max_tokens": 1,
 	}
 	req, err := a.newOpenAIChatCompletionsRequest(ctx, body)
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
+	start := time.Now()
 	resp, err := a.healthCheckClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("make request: %w", err)
 	}
 	defer resp.Body.Close()
+	if err := checkHealthResponse(resp); err != nil {
+		return err
+	}
+	a.latency.observe(time.Since(start))
+	return nil
+}
+
+// checkHealthResponse returns an error unless resp, from a health check
+// request, was successful. It wraps errOpenAIAuthFailed if our credentials
+// were rejected.
+func checkHealthResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w (status code %d)", errOpenAIAuthFailed, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		respBody, err := io.ReadAll(resp.Body)
 		if err != nil {
@@ -79,53 +320,277 @@ func (a *App) testOpenAIModel(ctx context.Context, model string) error {
 	return nil
 }
 
-// openAIHealth checks the health of the OpenAI configuration and caches the
-// result if successful. The caller must lock a.healthCheckMutex.
+// healthCacheable reports whether health results may be served from or stored
+// in the cache. Results are cached per instance, and the SDK's instance manager
+// creates a separate instance for each tenant (org) and whenever its settings
+// change, so the cache only ever holds results for this instance's settings.
+// If a request nonetheless arrives with different settings, such as another
+// tenant's, nothing is cached until requests match this instance's settings
+// again. The caller must lock a.healthCheckMutex.
+func (a *App) healthCacheable() bool {
+	return a.settingsFingerprint == a.settings.fingerprint
+}
+
+// revalidateHealth refreshes a cached health result in the background, for
+// stale-while-revalidate mode. check is run without the lock held, so other
+// health checks aren't blocked, and returns a function which stores its result
+// and is called with the lock held. Only one refresh of each feature runs at a
+// time. The caller must lock a.healthCheckMutex.
+func (a *App) revalidateHealth(feature string, check func(ctx context.Context) func()) {
+	if a.healthRefreshing[feature] {
+		return
+	}
+	a.healthRefreshing[feature] = true
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), healthRefreshTimeout)
+		defer cancel()
+		store := check(ctx)
+		a.healthCheckMutex.Lock()
+		defer a.healthCheckMutex.Unlock()
+		store()
+		a.healthRefreshing[feature] = false
+	}()
+}
+
+// reuseHealth reports whether a cached health result tracked by schedule may
+// be returned, and if so whether it should be refreshed in the background.
+// Results expire by schedule with adaptive health checks. In
+// stale-while-revalidate mode they are always returned, and refreshed each
+// time unless the schedule says they're still current. The caller must lock
+// a.healthCheckMutex.
+func (a *App) reuseHealth(schedule *healthSchedule) (reuse, refresh bool) {
+	expired := schedule.expired(time.Now())
+	if a.settings.HealthStaleWhileRevalidate {
+		return true, schedule == nil || expired
+	}
+	return !expired, false
+}
+
+// openAIHealth returns the health of the OpenAI configuration, from the cache
+// if possible. In stale-while-revalidate mode a cached result is refreshed in
+// the background when it is returned, per reuseHealth. The caller must lock
+// a.healthCheckMutex.
 func (a *App) openAIHealth(ctx context.Context, req *backend.CheckHealthRequest) (openAIHealthDetails, error) {
-	if a.healthOpenAI != nil {
-		return *a.healthOpenAI, nil
+	if a.healthOpenAI != nil && a.healthCacheable() {
+		if reuse, refresh := a.reuseHealth(a.openAIHealthSchedule); reuse {
+			if refresh {
+				a.revalidateHealth("openAI", func(ctx context.Context) func() {
+					d := a.checkOpenAIHealth(ctx)
+					return func() { a.cacheOpenAIHealth(d) }
+				})
+			}
+			d := *a.healthOpenAI
+			if a.llmGateway != nil {
+				// The active endpoint may have changed since the result was cached.
+				d.ActiveEndpoint = a.llmGateway.activeURL()
+			}
+			// The latency is updated by proxied requests too, so always report the latest.
+			d.AvgLatencyMs, _ = a.latency.milliseconds()
+			return d, nil
+		}
 	}
 
+	d := a.checkOpenAIHealth(ctx)
+	a.cacheOpenAIHealth(d)
+	return d, nil
+}
+
+// cacheOpenAIHealth caches d if it may be returned by later checks: if it was
+// successful, or always in stale-while-revalidate mode, since the cached result
+// is then refreshed whenever it's used. Incomplete results are never cached.
+// Complete results update the schedule, whether or not they're cached. The
+// caller must lock a.healthCheckMutex.
+func (a *App) cacheOpenAIHealth(d openAIHealthDetails) {
+	if d.incomplete() || !a.healthCacheable() {
+		return
+	}
+	a.openAIHealthSchedule.record(d.OK, time.Now())
+	if d.OK || a.settings.HealthStaleWhileRevalidate {
+		a.healthOpenAI = &d
+	} else {
+		// Don't fall back to an older, successful result.
+		a.healthOpenAI = nil
+	}
+}
+
+// checkOpenAIHealth checks the health of the OpenAI configuration and, with
+// CheckAllProviders, of each load balanced provider at the same time. It only
+// uses state which is safe to access without a.healthCheckMutex.
+func (a *App) checkOpenAIHealth(ctx context.Context) openAIHealthDetails {
+	if !a.settings.CheckAllProviders || len(a.settings.LoadBalance) == 0 {
+		return a.checkProviderHealth(ctx)
+	}
+	providers := make(chan map[string]openAIHealthDetails, 1)
+	go func() { providers <- a.checkLoadBalancedHealth(ctx) }()
+	d := a.checkProviderHealth(ctx)
+	d.Providers = <-providers
+	return d
+}
+
+// checkLoadBalancedHealth checks each load balanced provider concurrently,
+// exactly as the configured provider is checked, returning their health by
+// name. If several providers share a name the first is reported, matching
+// routing by model prefix.
+func (a *App) checkLoadBalancedHealth(ctx context.Context) map[string]openAIHealthDetails {
+	results := make([]openAIHealthDetails, len(a.settings.LoadBalance))
+	var wg sync.WaitGroup
+	for i, p := range a.settings.LoadBalance {
+		s := *a.settings
+		s.OpenAI = p.OpenAI
+		checker := &App{
+			settings:          &s,
+			provider:          newProvider(s, nil),
+			healthCheckClient: a.healthCheckClient,
+			checkReachable:    a.checkReachable,
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = checker.checkProviderHealth(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	health := make(map[string]openAIHealthDetails, len(results))
+	for i, p := range a.settings.LoadBalance {
+		if _, ok := health[p.Name]; !ok {
+			health[p.Name] = results[i]
+		}
+	}
+	return health
+}
+
+// providerURLs returns the URLs the provider can be reached at. Requests to
+// the LLM Gateway fail over between its endpoints, starting with the active
+// one.
+func (a *App) providerURLs() []string {
+	if a.settings.OpenAI.Provider == openAIProviderGrafana && a.llmGateway != nil {
+		return a.llmGateway.activeFirst()
+	}
+	return []string{a.providerURL()}
+}
+
+// reachProvider checks that the provider can be reached at any of its URLs,
+// returning the error for the first if none can.
+func (a *App) reachProvider(ctx context.Context) error {
+	var firstErr error
+	for _, u := range a.providerURLs() {
+		err := a.checkReachable(ctx, u)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return firstErr
+}
+
+// checkProviderHealth checks the health of the configured provider. If ctx is
+// cancelled part way through, models which weren't checked are marked unknown
+// rather than failed, and the results gathered so far are returned.
+func (a *App) checkProviderHealth(ctx context.Context) openAIHealthDetails {
 	d := openAIHealthDetails{
 		OK:         true,
 		Configured: a.settings.OpenAI.apiKey != "" || a.settings.OpenAI.Provider == openAIProviderGrafana,
 		Models:     map[string]openAIModelHealth{},
 	}
 
-	for _, model := range openAIModels {
+	if d.Configured && a.settings.OpenAI.Provider == openAIProviderAzure {
+		if err := a.settings.OpenAI.validateAzureAPIVersion(); err != nil {
+			d.OK = false
+			d.Error = err.Error()
+			for _, model := range a.healthModels() {
+				d.Models[model] = openAIModelHealth{OK: false, Error: "invalid configuration"}
+			}
+			return d
+		}
+	}
+
+	// Check we can reach the provider at all before probing individual models.
+	if d.Configured {
+		if err := a.reachProvider(ctx); err != nil {
+			d.OK = false
+			if ctx.Err() != nil {
+				d.Unknown = true
+				d.Error = errHealthCheckCancelled.Error()
+				for _, model := range a.healthModels() {
+					d.Models[model] = openAIModelHealth{Error: errHealthCheckCancelled.Error(), Unknown: true}
+				}
+				return d
+			}
+			d.Error = fmt.Sprintf("Unable to reach the provider at %s, check network access: %s", strings.Join(a.providerURLs(), ", "), err)
+			for _, model := range a.healthModels() {
+				d.Models[model] = openAIModelHealth{OK: false, Error: "provider unreachable"}
+			}
+			return d
+		}
+		d.Reachable = true
+	}
+
+	authFailures := 0
+	models := a.healthModels()
+	probe := a.testOpenAIModel
+	if d.Configured && a.probesModelsList() {
+		// One free request checks reachability and authentication for all
+		// the models, though not that each of them works.
+		err := ctx.Err()
+		if err == nil {
+			err = a.testOpenAIModelsList(ctx)
+		}
+		probe = func(context.Context, string) error { return err }
+	}
+	for _, model := range models {
 		health := openAIModelHealth{OK: false, Error: "OpenAI not configured"}
 		if d.Configured {
 			health.OK = true
 			health.Error = ""
-			err := a.testOpenAIModel(ctx, model)
-			if err != nil {
+			err := ctx.Err()
+			if err == nil {
+				err = probe(ctx, model)
+			}
+			if err != nil && ctx.Err() != nil {
+				// The failure is ours, not the model's.
+				health = openAIModelHealth{Error: errHealthCheckCancelled.Error(), Unknown: true}
+			} else if err != nil {
 				health.OK = false
 				health.Error = err.Error()
+				if errors.Is(err, errOpenAIAuthFailed) {
+					authFailures++
+				}
 			}
 		}
 		d.Models[model] = health
 	}
-	anyOK := false
+	d.AuthFailed = d.Configured && authFailures == len(models)
+	anyOK, anyUnknown := false, false
 	for _, v := range d.Models {
-		if v.OK {
-			anyOK = true
-			break
-		}
+		anyOK = anyOK || v.OK
+		anyUnknown = anyUnknown || v.Unknown
 	}
 	if !anyOK {
 		d.OK = false
 		d.Error = "No models are working"
+		if d.AuthFailed {
+			d.Error = errOpenAIAuthFailed.Error()
+		} else if anyUnknown {
+			// One of the models which weren't checked may have worked.
+			d.Unknown = true
+			d.Error = errHealthCheckCancelled.Error()
+		}
 	}
 
-	// Only cache result if openAI is ok to use.
-	if d.OK {
-		a.healthOpenAI = &d
+	if a.llmGateway != nil {
+		d.ActiveEndpoint = a.llmGateway.activeURL()
 	}
-	return d, nil
+	d.AvgLatencyMs, _ = a.latency.milliseconds()
+	return d
 }
 
-// testVectorService checks the health of VectorAPI and caches the result if
-// successful. The caller must lock a.healthCheckMutex.
+// testVectorService checks the health of VectorAPI.
 func (a *App) testVectorService(ctx context.Context) error {
 	if a.vectorService == nil {
 		return fmt.Errorf("vector service not configured")
@@ -137,11 +602,44 @@ func (a *App) testVectorService(ctx context.Context) error {
 	return nil
 }
 
+// vectorHealth returns the health of the vector services, from the cache if
+// possible, like openAIHealth. The caller must lock a.healthCheckMutex.
 func (a *App) vectorHealth(ctx context.Context) vectorHealthDetails {
-	if a.healthVector != nil {
-		return *a.healthVector
+	if a.healthVector != nil && a.healthCacheable() {
+		if reuse, refresh := a.reuseHealth(a.vectorHealthSchedule); reuse {
+			if refresh {
+				a.revalidateHealth("vector", func(ctx context.Context) func() {
+					d := a.checkVectorHealth(ctx)
+					return func() { a.cacheVectorHealth(d) }
+				})
+			}
+			return *a.healthVector
+		}
 	}
 
+	d := a.checkVectorHealth(ctx)
+	a.cacheVectorHealth(d)
+	return d
+}
+
+// cacheVectorHealth caches d if it may be returned by later checks, like
+// cacheOpenAIHealth. The caller must lock a.healthCheckMutex.
+func (a *App) cacheVectorHealth(d vectorHealthDetails) {
+	if d.Unknown || !a.healthCacheable() {
+		return
+	}
+	a.vectorHealthSchedule.record(d.OK, time.Now())
+	if d.OK || a.settings.HealthStaleWhileRevalidate {
+		a.healthVector = &d
+	} else {
+		// Don't fall back to an older, successful result.
+		a.healthVector = nil
+	}
+}
+
+// checkVectorHealth checks the health of the vector services. It only uses
+// state which is safe to access without a.healthCheckMutex.
+func (a *App) checkVectorHealth(ctx context.Context) vectorHealthDetails {
 	d := vectorHealthDetails{
 		Enabled: a.settings.Vector.Enabled,
 		OK:      true,
@@ -151,24 +649,92 @@ func (a *App) vectorHealth(ctx context.Context) vectorHealthDetails {
 		return d
 	}
 	err := a.testVectorService(ctx)
+	if err == nil {
+		err = a.checkVectorCollections(ctx, &d)
+	}
+	if err == nil {
+		err = a.deepCheckVector(ctx, &d)
+	}
 	if err != nil {
 		d.OK = false
 		d.Error = err.Error()
+		if ctx.Err() != nil {
+			d.Unknown = true
+			d.Error = errHealthCheckCancelled.Error()
+		}
 	}
+	return d
+}
 
-	// Only cache if the health check succeeded.
-	if d.OK {
-		a.healthVector = &d
+// deepCheckVector searches for the deep check's query, if enabled, checking
+// that the expected document is the top result and recording the latency of
+// the search in d.
+func (a *App) deepCheckVector(ctx context.Context, d *vectorHealthDetails) error {
+	s := a.settings.Vector.DeepCheck
+	if !s.Enabled {
+		return nil
 	}
-	return d
+	if s.Collection == "" || s.Query == "" || s.ExpectedID == "" {
+		return errors.New("deep check requires a collection, query and expected ID")
+	}
+	start := time.Now()
+	results, err := a.vectorService.Search(ctx, s.Collection, s.Query, 1, nil)
+	if err != nil {
+		return fmt.Errorf("deep check search failed: %w", err)
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("deep check search of %s returned no results", s.Collection)
+	}
+	if results[0].ID != s.ExpectedID {
+		return fmt.Errorf("deep check search of %s returned %q as the top result, expected %q", s.Collection, results[0].ID, s.ExpectedID)
+	}
+	d.DeepCheckLatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	return nil
+}
+
+// checkVectorCollections checks that each of the configured health check
+// collections exists, recording the result of each in d and returning an
+// error naming those which don't.
+func (a *App) checkVectorCollections(ctx context.Context, d *vectorHealthDetails) error {
+	collections := a.settings.Vector.HealthCollections
+	if len(collections) == 0 {
+		return nil
+	}
+	d.Collections = make(map[string]vectorCollectionHealth, len(collections))
+	var failing []string
+	for _, collection := range collections {
+		health := vectorCollectionHealth{OK: true}
+		exists, err := a.vectorService.CollectionExists(ctx, collection)
+		switch {
+		case err != nil:
+			health = vectorCollectionHealth{Error: err.Error()}
+		case !exists:
+			health = vectorCollectionHealth{Error: "collection not found"}
+		}
+		if !health.OK {
+			failing = append(failing, collection)
+		}
+		d.Collections[collection] = health
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("collections not working: %s", strings.Join(failing, ", "))
+	}
+	return nil
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // It returns whether each feature is working based on the plugin settings.
 func (a *App) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	a.checkSettingsFingerprint(req.PluginContext.AppInstanceSettings)
+
 	a.healthCheckMutex.Lock()
 	defer a.healthCheckMutex.Unlock()
 
+	if details, ok := a.persistedHealthDetails(); ok {
+		details.Version = getVersion()
+		return healthCheckResult(details), nil
+	}
+
 	openAI, err := a.openAIHealth(ctx, req)
 	if err != nil {
 		openAI.OK = false
@@ -176,7 +742,7 @@ func (a *App) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest)
 	}
 
 	vector := a.vectorHealth(ctx)
-	if vector.Error == "" {
+	if vector.Error == "" && a.healthCacheable() {
 		a.healthVector = &vector
 	}
 
@@ -185,15 +751,30 @@ func (a *App) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest)
 		Vector:  vector,
 		Version: getVersion(),
 	}
+	if openAI.Configured {
+		details.OpenAI.CheckIntervalSeconds = a.openAIHealthSchedule.currentInterval().Seconds()
+	}
+	if vector.Enabled {
+		details.Vector.CheckIntervalSeconds = a.vectorHealthSchedule.currentInterval().Seconds()
+	}
+	details.Status, details.Summary = summarizeHealth(openAI, vector)
+	a.healthHistory.add(newHealthSnapshot(time.Now(), details))
+	if details.Status == healthStatusHealthy && a.healthCacheable() {
+		a.healthStore.save(persistedHealth{CheckedAt: time.Now(), Details: details})
+	}
+	return healthCheckResult(details), nil
+}
+
+func healthCheckResult(details healthCheckDetails) *backend.CheckHealthResult {
 	body, err := json.Marshal(details)
 	if err != nil {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
 			Message: "failed to marshal details",
-		}, nil
+		}
 	}
 	return &backend.CheckHealthResult{
 		Status:      backend.HealthStatusOk,
 		JSONDetails: body,
-	}, nil
+	}
 }
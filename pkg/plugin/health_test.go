@@ -2,13 +2,20 @@ package plugin
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-llm-app/pkg/plugin/vector"
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/embed"
 	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/store"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -27,21 +34,79 @@ func (m *mockVectorService) Search(ctx context.Context, collection string, query
 	return []store.SearchResult{{Payload: map[string]any{"a": "b"}, Score: 1.0}}, nil
 }
 
+// SearchByVector searches a collection of 3 dimensional vectors.
+func (m *mockVectorService) SearchByVector(ctx context.Context, collection string, v []float32, topK uint64, filter map[string]interface{}) ([]store.SearchResult, error) {
+	if len(v) != 3 {
+		return nil, fmt.Errorf("%w: collection %s has dimension 3, got %d", vector.ErrDimensionMismatch, collection, len(v))
+	}
+	return []store.SearchResult{{Payload: map[string]any{"a": "b"}, Score: 1.0}}, nil
+}
+
+// HybridSearch is a hybrid search of a collection of 3 dimensional vectors,
+// scoring results by alpha.
+func (m *mockVectorService) HybridSearch(ctx context.Context, collection string, query string, v []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]store.SearchResult, bool, error) {
+	if len(v) > 0 && len(v) != 3 {
+		return nil, false, fmt.Errorf("%w: collection %s has dimension 3, got %d", vector.ErrDimensionMismatch, collection, len(v))
+	}
+	return []store.SearchResult{{Payload: map[string]any{"a": "b"}, Score: alpha}}, true, nil
+}
+
+func (m *mockVectorService) MultiSearch(ctx context.Context, collections []string, query string, topK uint64, filter map[string]interface{}) (store.MultiSearchResult, error) {
+	results := store.MultiSearchResult{}
+	for _, c := range collections {
+		results.Results = append(results.Results, store.SearchResult{Payload: map[string]any{"a": "b"}, Score: 1.0, Collection: c})
+	}
+	return results, nil
+}
+
+func (m *mockVectorService) Embed(ctx context.Context, model string, text string) ([]float32, error) {
+	return []float32{0.1, 0.2, 0.3}, nil
+}
+
+func (m *mockVectorService) EmbedBatches(ctx context.Context, model string, batches [][]string) []embed.BatchResult {
+	return nil
+}
+
+func (m *mockVectorService) CollectionExists(ctx context.Context, collection string) (bool, error) {
+	return true, nil
+}
+
+func (m *mockVectorService) CacheStats() (store.CacheStats, bool) {
+	return store.CacheStats{
+		Hits:     3,
+		Misses:   1,
+		HitRatio: 0.75,
+		Entries:  1,
+		Collections: map[string]store.CollectionCacheStats{
+			"grafana": {Hits: 3, Misses: 1, HitRatio: 0.75},
+		},
+	}, true
+}
+
 func (m *mockVectorService) Health(ctx context.Context) error {
 	return nil
 }
 
 func (m *mockVectorService) Cancel() {}
 
+type unhealthyVectorService struct {
+	mockVectorService
+}
+
+func (m *unhealthyVectorService) Health(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
 // TestCheckHealth tests CheckHealth calls, using backend.CheckHealthRequest and backend.CheckHealthResponse.
 func TestCheckHealth(t *testing.T) {
 
 	// Set up and run test cases
 	for _, tc := range []struct {
-		name     string
-		settings backend.AppInstanceSettings
-		hcClient healthCheckClient
-		vService vector.Service
+		name      string
+		settings  backend.AppInstanceSettings
+		hcClient  healthCheckClient
+		reachable reachabilityChecker
+		vService  vector.Service
 
 		expDetails healthCheckDetails
 	}{
@@ -62,6 +127,8 @@ func TestCheckHealth(t *testing.T) {
 				},
 				Vector:  vectorHealthDetails{},
 				Version: "unknown",
+				Status:  healthStatusUnhealthy,
+				Summary: "No features are configured",
 			},
 		},
 		{
@@ -87,6 +154,7 @@ func TestCheckHealth(t *testing.T) {
 			expDetails: healthCheckDetails{
 				OpenAI: openAIHealthDetails{
 					Configured: true,
+					Reachable:  true,
 					OK:         true,
 					Models: map[string]openAIModelHealth{
 						"gpt-3.5-turbo": {OK: true, Error: ""},
@@ -95,6 +163,105 @@ func TestCheckHealth(t *testing.T) {
 				},
 				Vector:  vectorHealthDetails{},
 				Version: "unknown",
+				Status:  healthStatusHealthy,
+				Summary: "All configured features are working",
+			},
+		},
+		{
+			name: "openai invalid key",
+			settings: backend.AppInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{openAIKey: "expired"},
+				JSONData: json.RawMessage(`{
+					"openai": {
+						"provider": "openai"
+					}
+				}`),
+			},
+			hcClient: &mockHealthCheckClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					body := io.NopCloser(strings.NewReader(`{"error": "invalid api key"}`))
+					return &http.Response{StatusCode: http.StatusUnauthorized, Body: body}, nil
+				},
+			},
+			expDetails: healthCheckDetails{
+				OpenAI: openAIHealthDetails{
+					Configured: true,
+					Reachable:  true,
+					OK:         false,
+					Error:      "authentication failed - check the API key",
+					AuthFailed: true,
+					Models: map[string]openAIModelHealth{
+						"gpt-3.5-turbo": {OK: false, Error: "authentication failed - check the API key (status code 401)"},
+						"gpt-4":         {OK: false, Error: "authentication failed - check the API key (status code 401)"},
+					},
+				},
+				Vector:  vectorHealthDetails{},
+				Version: "unknown",
+				Status:  healthStatusUnhealthy,
+				Summary: "Not working: LLM provider (authentication failed - check the API key)",
+			},
+		},
+		{
+			name: "azure invalid api version",
+			settings: backend.AppInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+				JSONData: json.RawMessage(`{
+					"openai": {
+						"provider": "azure",
+						"url": "https://example.openai.azure.com",
+						"azureApiVersion": "latest"
+					}
+				}`),
+			},
+			expDetails: healthCheckDetails{
+				OpenAI: openAIHealthDetails{
+					Configured: true,
+					OK:         false,
+					Error:      `invalid Azure API version "latest", expected a version such as 2024-02-01`,
+					Models: map[string]openAIModelHealth{
+						"gpt-3.5-turbo": {OK: false, Error: "invalid configuration"},
+					},
+				},
+				Vector:  vectorHealthDetails{},
+				Version: "unknown",
+				Status:  healthStatusUnhealthy,
+				Summary: `Not working: LLM provider (invalid Azure API version "latest", expected a version such as 2024-02-01)`,
+			},
+		},
+		{
+			name: "openai unreachable",
+			settings: backend.AppInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+				JSONData: json.RawMessage(`{
+					"openai": {
+						"provider": "openai",
+						"url": "https://openai.example.com"
+					}
+				}`),
+			},
+			hcClient: &mockHealthCheckClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					t.Fatal("models should not be checked if the provider is unreachable")
+					return nil, nil
+				},
+			},
+			reachable: func(ctx context.Context, rawURL string) error {
+				return errors.New("dial tcp: lookup openai.example.com: no such host")
+			},
+			expDetails: healthCheckDetails{
+				OpenAI: openAIHealthDetails{
+					Configured: true,
+					OK:         false,
+					Error:      "Unable to reach the provider at https://openai.example.com, check network access: dial tcp: lookup openai.example.com: no such host",
+					Models: map[string]openAIModelHealth{
+						"gpt-3.5-turbo": {OK: false, Error: "provider unreachable"},
+						"gpt-4":         {OK: false, Error: "provider unreachable"},
+					},
+				},
+				Vector:  vectorHealthDetails{},
+				Version: "unknown",
+				Status:  healthStatusUnhealthy,
+				Summary: "Not working: LLM provider (Unable to reach the provider at https://openai.example.com, check network access: dial tcp: lookup openai.example.com: no such host)",
 			},
 		},
 		{
@@ -130,6 +297,8 @@ func TestCheckHealth(t *testing.T) {
 					OK:      true,
 				},
 				Version: "unknown",
+				Status:  healthStatusHealthy,
+				Summary: "All configured features are working",
 			},
 		},
 		{
@@ -165,6 +334,7 @@ func TestCheckHealth(t *testing.T) {
 			expDetails: healthCheckDetails{
 				OpenAI: openAIHealthDetails{
 					Configured: true,
+					Reachable:  true,
 					OK:         true,
 					Error:      "",
 					Models: map[string]openAIModelHealth{
@@ -177,6 +347,49 @@ func TestCheckHealth(t *testing.T) {
 					OK:      true,
 				},
 				Version: "unknown",
+				Status:  healthStatusHealthy,
+				Summary: "All configured features are working",
+			},
+		},
+		{
+			name: "vector failing with openai",
+			settings: backend.AppInstanceSettings{
+				JSONData: json.RawMessage(`{
+					"vector": {
+						"enabled": true,
+						"embed": {
+							"type": "openai"
+						},
+						"store": {
+							"type": "qdrant",
+							"qdrant": {
+								"address": "localhost:6334"
+							}
+						}
+					}
+				}`),
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			},
+			vService: &unhealthyVectorService{},
+			hcClient: &mockHealthCheckClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+				},
+			},
+			expDetails: healthCheckDetails{
+				OpenAI: openAIHealthDetails{
+					Configured: true,
+					Reachable:  true,
+					OK:         true,
+				},
+				Vector: vectorHealthDetails{
+					Enabled: true,
+					OK:      false,
+					Error:   "vector service health check failed: connection refused",
+				},
+				Version: "unknown",
+				Status:  healthStatusDegraded,
+				Summary: "Not working: vector search (vector service health check failed: connection refused)",
 			},
 		},
 	} {
@@ -195,6 +408,10 @@ func TestCheckHealth(t *testing.T) {
 				t.Fatal("inst must be of type *App")
 			}
 			app.healthCheckClient = tc.hcClient
+			app.checkReachable = tc.reachable
+			if app.checkReachable == nil {
+				app.checkReachable = func(context.Context, string) error { return nil }
+			}
 			app.vectorService = tc.vService
 			// Request by calling CheckHealth.
 			resp, err := app.CheckHealth(ctx, &backend.CheckHealthRequest{
@@ -214,7 +431,9 @@ func TestCheckHealth(t *testing.T) {
 			}
 			if details.OpenAI.OK != tc.expDetails.OpenAI.OK ||
 				details.OpenAI.Configured != tc.expDetails.OpenAI.Configured ||
-				details.OpenAI.Error != tc.expDetails.OpenAI.Error {
+				details.OpenAI.Error != tc.expDetails.OpenAI.Error ||
+				details.OpenAI.AuthFailed != tc.expDetails.OpenAI.AuthFailed ||
+				details.OpenAI.Reachable != tc.expDetails.OpenAI.Reachable {
 				t.Errorf("OpenAI details should be %+v, got %+v", tc.expDetails.OpenAI, details.OpenAI)
 			}
 			for k, v := range tc.expDetails.OpenAI.Models {
@@ -222,9 +441,551 @@ func TestCheckHealth(t *testing.T) {
 					t.Errorf("OpenAI model %s should be %+v, got %+v", k, v, details.OpenAI.Models[k])
 				}
 			}
-			if details.Vector != tc.expDetails.Vector {
+			if !reflect.DeepEqual(details.Vector, tc.expDetails.Vector) {
 				t.Errorf("vector details should be %v, got %v", tc.expDetails.Vector, details.Vector)
 			}
+			if details.Status != tc.expDetails.Status || details.Summary != tc.expDetails.Summary {
+				t.Errorf("status should be %s (%q), got %s (%q)", tc.expDetails.Status, tc.expDetails.Summary, details.Status, details.Summary)
+			}
+		})
+	}
+}
+
+func TestCheckHealthResetsCacheOnSettingsChange(t *testing.T) {
+	ctx := context.Background()
+	settings := backend.AppInstanceSettings{
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, settings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+	calls := 0
+	app.healthCheckClient = &mockHealthCheckClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	app.checkReachable = func(context.Context, string) error { return nil }
+
+	changed := backend.AppInstanceSettings{
+		DecryptedSecureJSONData: map[string]string{openAIKey: "efgh5678"},
+	}
+	for i, s := range []backend.AppInstanceSettings{settings, settings, changed} {
+		if _, err := app.CheckHealth(ctx, &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &s},
+		}); err != nil {
+			t.Fatalf("CheckHealth %d error: %s", i, err)
+		}
+	}
+	// The first result is cached for the second check, but not the third.
+	if exp := 2 * len(openAIModels); calls != exp {
+		t.Errorf("expected %d model checks, got %d", exp, calls)
+	}
+}
+
+func TestCheckHealthStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	jsonData, err := json.Marshal(Settings{
+		OpenAI:                     OpenAISettings{Provider: openAIProviderOpenAI},
+		HealthStaleWhileRevalidate: true,
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	settings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, settings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+	app.checkReachable = func(context.Context, string) error { return nil }
+
+	var (
+		mu      sync.Mutex
+		calls   int
+		status  = http.StatusUnauthorized
+		release = make(chan struct{})
+	)
+	app.healthCheckClient = &mockHealthCheckClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			calls++
+			s := status
+			mu.Unlock()
+			if s == http.StatusOK {
+				<-release
+			}
+			return &http.Response{StatusCode: s, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	check := func() openAIHealthDetails {
+		t.Helper()
+		done := make(chan *backend.CheckHealthResult, 1)
+		go func() {
+			result, _ := app.CheckHealth(ctx, &backend.CheckHealthRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &settings},
+			})
+			done <- result
+		}()
+		select {
+		case result := <-done:
+			var details healthCheckDetails
+			if err := json.Unmarshal(result.JSONDetails, &details); err != nil {
+				t.Fatalf("unmarshal details: %s", err)
+			}
+			return details.OpenAI
+		case <-time.After(5 * time.Second):
+			t.Fatal("health check blocked on the provider")
+			return openAIHealthDetails{}
+		}
+	}
+
+	// The first check has nothing cached, so waits for the result, which is
+	// cached even though it failed.
+	if d := check(); d.OK {
+		t.Fatalf("expected the first check to fail, got %+v", d)
+	}
+
+	// The provider recovers, but responds slowly. Checks return the stale
+	// result immediately, while a single refresh runs in the background.
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if d := check(); d.OK {
+			t.Fatalf("expected check %d to return the stale result, got %+v", i, d)
+		}
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		app.healthCheckMutex.Lock()
+		refreshing := app.healthRefreshing["openAI"]
+		app.healthCheckMutex.Unlock()
+		if !refreshing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refresh never finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	if exp := 2 * len(openAIModels); calls != exp {
+		t.Errorf("expected %d model checks from one check and one refresh, got %d", exp, calls)
+	}
+	mu.Unlock()
+
+	if d := check(); !d.OK {
+		t.Errorf("expected the refreshed result to be OK, got %+v", d)
+	}
+}
+
+func TestCheckHealthPerTenant(t *testing.T) {
+	ctx := context.Background()
+	tenantSettings := func(tenant, key string) backend.AppInstanceSettings {
+		return backend.AppInstanceSettings{
+			DecryptedSecureJSONData: map[string]string{
+				openAIKey:                key,
+				encodedTenantAndTokenKey: base64.StdEncoding.EncodeToString([]byte(tenant + ":token")),
+			},
+		}
+	}
+	// The upstream only accepts tenant 1's key.
+	client := &mockHealthCheckClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			status := http.StatusOK
+			if req.Header.Get("Authorization") != "Bearer key-1" {
+				status = http.StatusUnauthorized
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	settings1, settings2 := tenantSettings("1", "key-1"), tenantSettings("2", "key-2")
+	apps := map[string]*App{}
+	for tenant, s := range map[string]backend.AppInstanceSettings{"1": settings1, "2": settings2} {
+		inst, err := NewApp(ctx, s)
+		if err != nil {
+			t.Fatalf("new app: %s", err)
+		}
+		app := inst.(*App)
+		app.healthCheckClient = client
+		app.checkReachable = func(context.Context, string) error { return nil }
+		apps[tenant] = app
+	}
+
+	check := func(app *App, s backend.AppInstanceSettings) openAIHealthDetails {
+		t.Helper()
+		result, err := app.CheckHealth(ctx, &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &s},
+		})
+		if err != nil {
+			t.Fatalf("CheckHealth error: %s", err)
+		}
+		var details healthCheckDetails
+		if err := json.Unmarshal(result.JSONDetails, &details); err != nil {
+			t.Fatalf("unmarshal details: %s", err)
+		}
+		return details.OpenAI
+	}
+
+	// Check each tenant twice so the second result may come from the cache.
+	for i := 0; i < 2; i++ {
+		if d := check(apps["1"], settings1); !d.OK {
+			t.Errorf("tenant 1 check %d: expected OK, got %+v", i, d)
+		}
+		if d := check(apps["2"], settings2); d.OK || !d.AuthFailed {
+			t.Errorf("tenant 2 check %d: expected auth failure, got %+v", i, d)
+		}
+	}
+
+	// An instance never caches results for another tenant's settings.
+	check(apps["1"], settings2)
+	if apps["1"].healthOpenAI != nil {
+		t.Errorf("expected tenant 1 instance not to cache results for tenant 2's settings")
+	}
+}
+
+func TestCheckHealthPrompt(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name   string
+		prompt string
+
+		expPrompt string
+	}{
+		{name: "default", expPrompt: "Hello"},
+		{name: "configured", prompt: "Reply with OK.", expPrompt: "Reply with OK."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, err := json.Marshal(Settings{
+				OpenAI:            OpenAISettings{Provider: openAIProviderOpenAI},
+				HealthCheckPrompt: tc.prompt,
+			})
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			inst, err := NewApp(ctx, backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			var mu sync.Mutex
+			var bodies []map[string]interface{}
+			app.healthCheckClient = &mockHealthCheckClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					var body map[string]interface{}
+					_ = json.NewDecoder(req.Body).Decode(&body)
+					mu.Lock()
+					bodies = append(bodies, body)
+					mu.Unlock()
+					// The probe asks for a single token, so the completion is cut short.
+					resp := `{"object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "Hi"}, "finish_reason": "length"}], "usage": {"completion_tokens": 1}}`
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(resp))}, nil
+				},
+			}
+			app.checkReachable = func(context.Context, string) error { return nil }
+
+			details, err := app.openAIHealth(ctx, &backend.CheckHealthRequest{})
+			if err != nil {
+				t.Fatalf("openAIHealth error: %s", err)
+			}
+			if !details.OK {
+				t.Fatalf("expected a 1-token response to pass the health check, got %+v", details)
+			}
+			if len(bodies) == 0 {
+				t.Fatal("expected the models to be probed")
+			}
+			for _, body := range bodies {
+				messages, _ := json.Marshal(body["messages"])
+				if exp := fmt.Sprintf(`[{"content":%q,"role":"user"}]`, tc.expPrompt); string(messages) != exp {
+					t.Errorf("expected messages %s, got %s", exp, messages)
+				}
+				if body["max_tokens"] != float64(1) {
+					t.Errorf("expected max_tokens 1, got %v", body["max_tokens"])
+				}
+			}
+		})
+	}
+}
+
+// cancelledVectorService fails health checks with the context's error, as a
+// real service would if the check was cancelled.
+type cancelledVectorService struct {
+	mockVectorService
+}
+
+func (m *cancelledVectorService) Health(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCheckHealthCancelled(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// cancelAfter is the number of model checks which succeed before
+		// the context is cancelled, or -1 to cancel before the provider
+		// is found to be reachable.
+		cancelAfter int
+
+		expOpenAIOK      bool
+		expOpenAIUnknown bool
+		expStatus        healthStatus
+	}{
+		{name: "while checking reachability", cancelAfter: -1, expOpenAIUnknown: true, expStatus: healthStatusUnknown},
+		{name: "before any models are checked", cancelAfter: 0, expOpenAIUnknown: true, expStatus: healthStatusUnknown},
+		{name: "after a model is checked", cancelAfter: 1, expOpenAIOK: true, expStatus: healthStatusUnknown},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := backend.AppInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(context.Background(), settings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			app.settings.Vector.Enabled = true
+			app.vectorService = &cancelledVectorService{}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			app.checkReachable = func(ctx context.Context, _ string) error {
+				if tc.cancelAfter < 0 {
+					cancel()
+					return ctx.Err()
+				}
+				return nil
+			}
+			checked := 0
+			app.healthCheckClient = &mockHealthCheckClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					checked++
+					if checked > tc.cancelAfter {
+						cancel()
+						return nil, req.Context().Err()
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+				},
+			}
+
+			resp, err := app.CheckHealth(ctx, &backend.CheckHealthRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &settings},
+			})
+			if err != nil {
+				t.Fatalf("CheckHealth error: %s", err)
+			}
+			var details healthCheckDetails
+			if err := json.Unmarshal(resp.JSONDetails, &details); err != nil {
+				t.Fatalf("unmarshal details: %s", err)
+			}
+			if details.OpenAI.OK != tc.expOpenAIOK || details.OpenAI.Unknown != tc.expOpenAIUnknown {
+				t.Errorf("expected OpenAI OK %t and unknown %t, got %+v", tc.expOpenAIOK, tc.expOpenAIUnknown, details.OpenAI)
+			}
+			for model, health := range details.OpenAI.Models {
+				if !health.OK && !health.Unknown {
+					t.Errorf("expected model %s to be OK or unknown, got %+v", model, health)
+				}
+			}
+			if !details.Vector.Unknown || details.Vector.Error != errHealthCheckCancelled.Error() {
+				t.Errorf("expected vector health to be unknown, got %+v", details.Vector)
+			}
+			if details.Status != tc.expStatus {
+				t.Errorf("expected status %s, got %s (%s)", tc.expStatus, details.Status, details.Summary)
+			}
+			if app.healthOpenAI != nil || app.healthVector != nil {
+				t.Errorf("expected incomplete results not to be cached")
+			}
+		})
+	}
+}
+
+// collectionsVectorService has the collections in exists, and fails to check
+// those in broken.
+type collectionsVectorService struct {
+	mockVectorService
+	exists, broken map[string]bool
+}
+
+func (m *collectionsVectorService) CollectionExists(ctx context.Context, collection string) (bool, error) {
+	if m.broken[collection] {
+		return false, errors.New("permission denied")
+	}
+	return m.exists[collection], nil
+}
+
+func TestCheckHealthVectorCollections(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		collections []string
+
+		expDetails vectorHealthDetails
+	}{
+		{
+			name:       "none configured",
+			expDetails: vectorHealthDetails{Enabled: true, OK: true},
+		},
+		{
+			name:        "all working",
+			collections: []string{"dashboards", "queries"},
+			expDetails: vectorHealthDetails{
+				Enabled: true,
+				OK:      true,
+				Collections: map[string]vectorCollectionHealth{
+					"dashboards": {OK: true},
+					"queries":    {OK: true},
+				},
+			},
+		},
+		{
+			name:        "some not working",
+			collections: []string{"dashboards", "missing", "forbidden"},
+			expDetails: vectorHealthDetails{
+				Enabled: true,
+				Error:   "collections not working: missing, forbidden",
+				Collections: map[string]vectorCollectionHealth{
+					"dashboards": {OK: true},
+					"missing":    {Error: "collection not found"},
+					"forbidden":  {Error: "permission denied"},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := &App{settings: &Settings{Vector: vector.VectorSettings{Enabled: true, HealthCollections: tc.collections}}}
+			app.vectorService = &collectionsVectorService{
+				exists: map[string]bool{"dashboards": true, "queries": true},
+				broken: map[string]bool{"forbidden": true},
+			}
+			d := app.checkVectorHealth(context.Background())
+			if !reflect.DeepEqual(d, tc.expDetails) {
+				t.Errorf("expected vector health %+v, got %+v", tc.expDetails, d)
+			}
+		})
+	}
+}
+
+// searchVectorService returns the results for each query in results.
+type searchVectorService struct {
+	mockVectorService
+	results map[string][]store.SearchResult
+}
+
+func (m *searchVectorService) Search(ctx context.Context, collection string, query string, topK uint64, filter map[string]interface{}) ([]store.SearchResult, error) {
+	if collection != "health" {
+		return nil, fmt.Errorf("collection %s not found in store", collection)
+	}
+	return m.results[query], nil
+}
+
+func TestCheckHealthVectorDeepCheck(t *testing.T) {
+	service := &searchVectorService{results: map[string][]store.SearchResult{
+		"known":   {{ID: "doc-1", Score: 0.99}, {ID: "doc-2", Score: 0.5}},
+		"unknown": {{ID: "doc-2", Score: 0.6}, {ID: "doc-1", Score: 0.5}},
+	}}
+	for _, tc := range []struct {
+		name  string
+		check vector.DeepCheckSettings
+
+		expErr string
+	}{
+		{name: "disabled"},
+		{name: "top result", check: vector.DeepCheckSettings{Enabled: true, Collection: "health", Query: "known", ExpectedID: "doc-1"}},
+		{name: "wrong top result", check: vector.DeepCheckSettings{Enabled: true, Collection: "health", Query: "unknown", ExpectedID: "doc-1"}, expErr: `deep check search of health returned "doc-2" as the top result, expected "doc-1"`},
+		{name: "no results", check: vector.DeepCheckSettings{Enabled: true, Collection: "health", Query: "nothing", ExpectedID: "doc-1"}, expErr: "deep check search of health returned no results"},
+		{name: "search failure", check: vector.DeepCheckSettings{Enabled: true, Collection: "missing", Query: "known", ExpectedID: "doc-1"}, expErr: "deep check search failed: collection missing not found in store"},
+		{name: "incomplete settings", check: vector.DeepCheckSettings{Enabled: true, Collection: "health"}, expErr: "deep check requires a collection, query and expected ID"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := &App{settings: &Settings{Vector: vector.VectorSettings{Enabled: true, DeepCheck: tc.check}}}
+			app.vectorService = service
+			d := app.checkVectorHealth(context.Background())
+			if d.OK != (tc.expErr == "") || d.Error != tc.expErr {
+				t.Errorf("expected error %q, got %+v", tc.expErr, d)
+			}
+			if ran := d.DeepCheckLatencyMs > 0; ran != (tc.check.Enabled && tc.expErr == "") {
+				t.Errorf("expected the latency to be reported only for a successful deep check, got %gms", d.DeepCheckLatencyMs)
+			}
+		})
+	}
+}
+
+func TestCheckHealthAllProviders(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name              string
+		checkAllProviders bool
+
+		expProviders map[string]bool
+	}{
+		{name: "default", checkAllProviders: false},
+		{name: "all providers", checkAllProviders: true, expProviders: map[string]bool{"primary": true, "fallback": false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			appSettings := backend.AppInstanceSettings{
+				JSONData: []byte(fmt.Sprintf(`{
+					"openAI": {"provider": "openai", "url": "https://active.example.com"},
+					"checkAllProviders": %t,
+					"loadBalance": [
+						{"name": "primary", "weight": 1, "openAI": {"provider": "openai", "url": "https://primary.example.com"}},
+						{"name": "fallback", "weight": 1, "apiKeySecret": "fallbackKey", "openAI": {"provider": "openai", "url": "https://fallback.example.com"}}
+					]
+				}`, tc.checkAllProviders)),
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234", "fallbackKey": "revoked"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			var mu sync.Mutex
+			hosts := map[string]bool{}
+			app.healthCheckClient = &mockHealthCheckClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					mu.Lock()
+					hosts[req.URL.Host] = true
+					mu.Unlock()
+					if req.Header.Get("Authorization") == "Bearer revoked" {
+						return &http.Response{StatusCode: http.StatusUnauthorized, Body: io.NopCloser(strings.NewReader(""))}, nil
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+				},
+			}
+			app.checkReachable = func(context.Context, string) error { return nil }
+
+			details, err := app.openAIHealth(ctx, &backend.CheckHealthRequest{})
+			if err != nil {
+				t.Fatalf("openAIHealth error: %s", err)
+			}
+			// The configured provider is always checked, and its health
+			// reported as before.
+			if !details.OK || !hosts["active.example.com"] {
+				t.Errorf("expected the configured provider to be checked and working, got %+v", details)
+			}
+			if len(details.Providers) != len(tc.expProviders) {
+				t.Fatalf("expected %d providers to be reported, got %+v", len(tc.expProviders), details.Providers)
+			}
+			for name, ok := range tc.expProviders {
+				d := details.Providers[name]
+				if d.OK != ok || len(d.Models) == 0 {
+					t.Errorf("expected provider %s to have OK %t, got %+v", name, ok, d)
+				}
+			}
+			if fallback, ok := details.Providers["fallback"]; ok && !fallback.AuthFailed {
+				t.Errorf("expected the fallback provider's key to be reported as rejected, got %+v", fallback)
+			}
+			if !tc.checkAllProviders && (hosts["primary.example.com"] || hosts["fallback.example.com"]) {
+				t.Errorf("expected load balanced providers not to be checked by default, got %v", hosts)
+			}
 		})
 	}
 }
@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// HealthPersistenceSettings configures keeping the last successful health
+// check result on disk, so that a new instance can report it straight away.
+type HealthPersistenceSettings struct {
+	Enabled bool `json:"enabled"`
+	// Path is the directory results are kept in. Defaults to a directory in
+	// the system's temporary directory.
+	Path string `json:"path"`
+}
+
+// defaultHealthPersistencePath is where health results are kept if no path is
+// set.
+func defaultHealthPersistencePath() string {
+	return filepath.Join(os.TempDir(), "grafana-llm-app", "health")
+}
+
+// persistedHealth is a health check result kept on disk.
+type persistedHealth struct {
+	CheckedAt time.Time          `json:"checkedAt"`
+	Details   healthCheckDetails `json:"details"`
+}
+
+// healthStore keeps the last successful health check result of a tenant in a
+// file. Errors reading or writing it are logged and otherwise ignored, since
+// it is only used to answer the first health check sooner.
+type healthStore struct {
+	path string
+}
+
+// newHealthStore returns a store for the tenant's results, or nil if
+// persistence is disabled.
+func newHealthStore(s HealthPersistenceSettings, tenant string) *healthStore {
+	if !s.Enabled {
+		return nil
+	}
+	dir := s.Path
+	if dir == "" {
+		dir = defaultHealthPersistencePath()
+	}
+	if tenant == "" {
+		tenant = "default"
+	}
+	return &healthStore{path: filepath.Join(dir, filepath.Base(tenant)+".json")}
+}
+
+// load returns the stored result, if there is one. A nil store has none.
+func (s *healthStore) load() *persistedHealth {
+	if s == nil {
+		return nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.DefaultLogger.Warn("Failed to read persisted health result", "err", err)
+		}
+		return nil
+	}
+	var p persistedHealth
+	if err := json.Unmarshal(b, &p); err != nil {
+		log.DefaultLogger.Warn("Ignoring corrupt persisted health result", "path", s.path, "err", err)
+		return nil
+	}
+	return &p
+}
+
+// save stores a result, replacing any stored before. A nil store does
+// nothing.
+func (s *healthStore) save(p persistedHealth) {
+	if s == nil {
+		return
+	}
+	if err := s.write(p); err != nil {
+		log.DefaultLogger.Warn("Failed to persist health result", "err", err)
+	}
+}
+
+func (s *healthStore) write(p persistedHealth) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal result: %w", err)
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	// Write to a temporary file and rename it into place, so that a new
+	// instance never reads a partly written result.
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// persistedHealthDetails returns the result loaded from disk when the
+// instance was created, marked as stale, if no check has replaced it yet. A
+// check of every feature is started in the background to replace it. The
+// caller must lock a.healthCheckMutex.
+func (a *App) persistedHealthDetails() (healthCheckDetails, bool) {
+	p := a.loadedHealth
+	if p == nil || !a.healthCacheable() {
+		return healthCheckDetails{}, false
+	}
+	a.revalidateHealth("persisted", func(ctx context.Context) func() {
+		openAI := a.checkOpenAIHealth(ctx)
+		vector := a.checkVectorHealth(ctx)
+		return func() {
+			a.cacheOpenAIHealth(openAI)
+			a.cacheVectorHealth(vector)
+			a.loadedHealth = nil
+		}
+	})
+	d := p.Details
+	d.Stale = true
+	d.CheckedAt = &p.CheckedAt
+	return d, true
+}
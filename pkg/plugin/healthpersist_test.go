@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func newHealthPersistenceApp(t *testing.T, dir string, status int, release <-chan struct{}) (*App, backend.AppInstanceSettings) {
+	t.Helper()
+	jsonData, err := json.Marshal(Settings{
+		OpenAI:            OpenAISettings{Provider: openAIProviderOpenAI},
+		HealthPersistence: HealthPersistenceSettings{Enabled: true, Path: dir},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	settings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(context.Background(), settings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+	app.healthCheckClient = &mockHealthCheckClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			if release != nil {
+				<-release
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	app.checkReachable = func(context.Context, string) error { return nil }
+	return app, settings
+}
+
+func checkHealthDetails(t *testing.T, app *App, settings backend.AppInstanceSettings) healthCheckDetails {
+	t.Helper()
+	result, err := app.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &settings},
+	})
+	if err != nil {
+		t.Fatalf("CheckHealth error: %s", err)
+	}
+	var details healthCheckDetails
+	if err := json.Unmarshal(result.JSONDetails, &details); err != nil {
+		t.Fatalf("unmarshal details: %s", err)
+	}
+	return details
+}
+
+func TestHealthPersistenceLoadOnStartup(t *testing.T) {
+	dir := t.TempDir()
+	first, settings := newHealthPersistenceApp(t, dir, http.StatusOK, nil)
+	if d := checkHealthDetails(t, first, settings); d.Status != healthStatusHealthy || d.Stale {
+		t.Fatalf("expected a fresh, healthy result, got %+v", d)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "default.json")); err != nil {
+		t.Fatalf("expected the result to be persisted: %s", err)
+	}
+
+	// A new instance reports the persisted result at once, while its first
+	// check is still running.
+	release := make(chan struct{})
+	second, settings := newHealthPersistenceApp(t, dir, http.StatusOK, release)
+	d := checkHealthDetails(t, second, settings)
+	if !d.Stale || d.CheckedAt == nil || d.Status != healthStatusHealthy || !d.OpenAI.OK {
+		t.Fatalf("expected the stale, healthy persisted result, got %+v", d)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		second.healthCheckMutex.Lock()
+		refreshed := second.loadedHealth == nil
+		second.healthCheckMutex.Unlock()
+		if refreshed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the background check")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if d := checkHealthDetails(t, second, settings); d.Stale || d.CheckedAt != nil || d.Status != healthStatusHealthy {
+		t.Errorf("expected the fresh result after the background check, got %+v", d)
+	}
+}
+
+func TestHealthPersistenceOnlySuccessful(t *testing.T) {
+	dir := t.TempDir()
+	app, settings := newHealthPersistenceApp(t, dir, http.StatusInternalServerError, nil)
+	if d := checkHealthDetails(t, app, settings); d.Status != healthStatusUnhealthy {
+		t.Fatalf("expected an unhealthy result, got %+v", d)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "default.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no result to be persisted, got %v", err)
+	}
+
+	// A new instance without a persisted result checks straight away.
+	second, settings := newHealthPersistenceApp(t, dir, http.StatusInternalServerError, nil)
+	if d := checkHealthDetails(t, second, settings); d.Stale {
+		t.Errorf("expected a fresh result, got %+v", d)
+	}
+}
+
+func TestHealthStoreDisabled(t *testing.T) {
+	if s := newHealthStore(HealthPersistenceSettings{Path: t.TempDir()}, ""); s != nil {
+		t.Errorf("expected no store when disabled, got %+v", s)
+	}
+	var s *healthStore
+	s.save(persistedHealth{})
+	if p := s.load(); p != nil {
+		t.Errorf("expected a nil store to have no result, got %+v", p)
+	}
+}
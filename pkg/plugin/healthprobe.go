@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// HealthCheckMethod is how health checks probe a provider.
+type HealthCheckMethod string
+
+const (
+	// HealthCheckMethodChat sends each health check model a chat completions
+	// request for a single token. This is the default, and checks that each
+	// model works, but providers bill for it.
+	HealthCheckMethodChat HealthCheckMethod = "chat"
+	// HealthCheckMethodModels lists the provider's models instead, which
+	// providers don't bill for. It checks that the provider is reachable and
+	// accepts our credentials, but not that each model works. Providers
+	// without a models endpoint are probed with chat completions.
+	HealthCheckMethodModels HealthCheckMethod = "models"
+)
+
+func (m HealthCheckMethod) validate() error {
+	switch m {
+	case "", HealthCheckMethodChat, HealthCheckMethodModels:
+		return nil
+	}
+	return fmt.Errorf("unknown health check method: %s", m)
+}
+
+// probesModelsList reports whether health checks should list the provider's
+// models rather than send chat completions requests.
+func (a *App) probesModelsList() bool {
+	if a.settings.OpenAI.HealthCheckMethod != HealthCheckMethodModels || a.provider == nil {
+		return false
+	}
+	if !a.provider.Capabilities().ModelsList {
+		log.DefaultLogger.Debug("Provider has no models endpoint, falling back to chat health checks", "provider", a.settings.OpenAI.Provider)
+		return false
+	}
+	return true
+}
+
+// testOpenAIModelsList lists the provider's models, checking that it is
+// reachable and accepts our credentials.
+func (a *App) testOpenAIModelsList(ctx context.Context) error {
+	req, err := a.newOpenAIModelsRequest(ctx)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := a.healthCheckClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("make request: %w", err)
+	}
+	defer resp.Body.Close()
+	return checkHealthResponse(resp)
+}
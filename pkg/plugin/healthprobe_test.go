@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCheckHealthMethod(t *testing.T) {
+	ctx := context.Background()
+	azureMapping := [][]string{{"gpt-3.5-turbo", "gpt-35"}, {"gpt-4", "gpt-4"}}
+	for _, tc := range []struct {
+		name   string
+		openAI OpenAISettings
+		status int
+
+		expRequests []string
+		expOK       bool
+		expAuth     bool
+	}{
+		{
+			name:        "chat by default",
+			openAI:      OpenAISettings{Provider: openAIProviderOpenAI, URL: "https://api.openai.com"},
+			status:      http.StatusOK,
+			expRequests: []string{"POST /v1/chat/completions", "POST /v1/chat/completions"},
+			expOK:       true,
+		},
+		{
+			name:        "models",
+			openAI:      OpenAISettings{Provider: openAIProviderOpenAI, URL: "https://api.openai.com", HealthCheckMethod: HealthCheckMethodModels},
+			status:      http.StatusOK,
+			expRequests: []string{"GET /v1/models"},
+			expOK:       true,
+		},
+		{
+			name:        "models auth failed",
+			openAI:      OpenAISettings{Provider: openAIProviderOpenAI, URL: "https://api.openai.com", HealthCheckMethod: HealthCheckMethodModels},
+			status:      http.StatusUnauthorized,
+			expRequests: []string{"GET /v1/models"},
+			expAuth:     true,
+		},
+		{
+			name:        "models failed",
+			openAI:      OpenAISettings{Provider: openAIProviderOpenAI, URL: "https://api.openai.com", HealthCheckMethod: HealthCheckMethodModels},
+			status:      http.StatusInternalServerError,
+			expRequests: []string{"GET /v1/models"},
+		},
+		{
+			name: "azure models",
+			openAI: OpenAISettings{
+				Provider: openAIProviderAzure, URL: "https://test.openai.azure.com", AzureMapping: azureMapping,
+				HealthCheckMethod: HealthCheckMethodModels,
+			},
+			status:      http.StatusOK,
+			expRequests: []string{"GET /openai/models?api-version=" + defaultAzureAPIVersion},
+			expOK:       true,
+		},
+		{
+			// Foundry has no models endpoint, so the chat probe is used.
+			name: "fallback",
+			openAI: OpenAISettings{
+				Provider: openAIProviderAzure, URL: "https://test.services.ai.azure.com", AzureMapping: azureMapping,
+				AzureEndpointStyle: AzureEndpointStyleFoundry, HealthCheckMethod: HealthCheckMethodModels,
+			},
+			status: http.StatusOK,
+			expRequests: []string{
+				"POST /models/chat/completions?api-version=" + defaultAzureFoundryAPIVersion,
+				"POST /models/chat/completions?api-version=" + defaultAzureFoundryAPIVersion,
+			},
+			expOK: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, err := json.Marshal(Settings{OpenAI: tc.openAI})
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			inst, err := NewApp(ctx, backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			var mu sync.Mutex
+			requests := map[string]int{}
+			app.healthCheckClient = &mockHealthCheckClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					mu.Lock()
+					requests[req.Method+" "+req.URL.RequestURI()]++
+					mu.Unlock()
+					return &http.Response{StatusCode: tc.status, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+				},
+			}
+			app.checkReachable = func(context.Context, string) error { return nil }
+
+			details, err := app.openAIHealth(ctx, &backend.CheckHealthRequest{})
+			if err != nil {
+				t.Fatalf("openAIHealth error: %s", err)
+			}
+			exp := map[string]int{}
+			for _, r := range tc.expRequests {
+				exp[r]++
+			}
+			if len(requests) != len(exp) {
+				t.Errorf("expected requests %v, got %v", exp, requests)
+			}
+			for r, n := range exp {
+				if requests[r] != n {
+					t.Errorf("expected requests %v, got %v", exp, requests)
+				}
+			}
+			if details.OK != tc.expOK || details.AuthFailed != tc.expAuth {
+				t.Errorf("expected OK %t and auth failed %t, got %+v", tc.expOK, tc.expAuth, details)
+			}
+			if len(details.Models) != 2 {
+				t.Errorf("expected both models to be reported, got %+v", details.Models)
+			}
+			for model, health := range details.Models {
+				if health.OK != tc.expOK {
+					t.Errorf("expected model %s OK %t, got %+v", model, tc.expOK, health)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthCheckMethodInvalid(t *testing.T) {
+	jsonData, err := json.Marshal(Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, HealthCheckMethod: "ping"},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	_, err = NewApp(context.Background(), backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	})
+	if err == nil {
+		t.Error("expected an error for an unknown health check method")
+	}
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import "time"
+
+const (
+	defaultHealthMinInterval = 10 * time.Second
+	defaultHealthMaxInterval = 10 * time.Minute
+)
+
+// AdaptiveHealthCheckSettings configures how long health check results are
+// reused for. The interval halves after each failed check, down to the
+// minimum, and doubles after each successful one, up to the maximum, so a
+// stable provider is checked rarely and a flaky one often.
+type AdaptiveHealthCheckSettings struct {
+	Enabled bool `json:"enabled"`
+	// MinIntervalSeconds is the shortest time a result is reused for, and the
+	// starting interval. Defaults to 10 seconds.
+	MinIntervalSeconds int `json:"minIntervalSeconds"`
+	// MaxIntervalSeconds is the longest time a result is reused for. Defaults
+	// to 10 minutes.
+	MaxIntervalSeconds int `json:"maxIntervalSeconds"`
+}
+
+// healthSchedule tracks when the health of a feature was last checked and how
+// long its result may be reused for. A nil schedule never expires results.
+type healthSchedule struct {
+	min, max  time.Duration
+	interval  time.Duration
+	checkedAt time.Time
+}
+
+// newHealthSchedule returns a schedule for s, or nil if it isn't enabled.
+func newHealthSchedule(s AdaptiveHealthCheckSettings) *healthSchedule {
+	if !s.Enabled {
+		return nil
+	}
+	h := &healthSchedule{
+		min: time.Duration(s.MinIntervalSeconds) * time.Second,
+		max: time.Duration(s.MaxIntervalSeconds) * time.Second,
+	}
+	if h.min <= 0 {
+		h.min = defaultHealthMinInterval
+	}
+	if h.max <= 0 {
+		h.max = defaultHealthMaxInterval
+	}
+	h.max = max(h.max, h.min)
+	h.interval = h.min
+	return h
+}
+
+// record adjusts the interval after a check completed at now.
+func (h *healthSchedule) record(ok bool, now time.Time) {
+	if h == nil {
+		return
+	}
+	if ok {
+		h.interval = min(h.interval*2, h.max)
+	} else {
+		h.interval = max(h.interval/2, h.min)
+	}
+	h.checkedAt = now
+}
+
+// expired reports whether the last result is too old to reuse at now.
+func (h *healthSchedule) expired(now time.Time) bool {
+	return h != nil && now.Sub(h.checkedAt) >= h.interval
+}
+
+// currentInterval returns how long results are currently reused for, or zero
+// if there's no schedule.
+func (h *healthSchedule) currentInterval() time.Duration {
+	if h == nil {
+		return 0
+	}
+	return h.interval
+}
+
+// reset forgets the history of checks, for when the settings change.
+func (h *healthSchedule) reset() {
+	if h == nil {
+		return
+	}
+	h.interval = h.min
+	h.checkedAt = time.Time{}
+}
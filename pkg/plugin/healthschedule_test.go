@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHealthSchedule(t *testing.T) {
+	if s := newHealthSchedule(AdaptiveHealthCheckSettings{}); s != nil || s.expired(time.Now()) {
+		t.Fatalf("expected no schedule, which never expires results, when disabled")
+	}
+
+	s := newHealthSchedule(AdaptiveHealthCheckSettings{Enabled: true, MinIntervalSeconds: 10, MaxIntervalSeconds: 30})
+	now := time.Now()
+	for i, tc := range []struct {
+		ok  bool
+		exp time.Duration
+	}{
+		{ok: true, exp: 20 * time.Second},
+		{ok: true, exp: 30 * time.Second},
+		{ok: true, exp: 30 * time.Second},
+		{ok: false, exp: 15 * time.Second},
+		{ok: false, exp: 10 * time.Second},
+		{ok: false, exp: 10 * time.Second},
+		{ok: true, exp: 20 * time.Second},
+	} {
+		s.record(tc.ok, now)
+		if got := s.currentInterval(); got != tc.exp {
+			t.Errorf("check %d: expected interval %s, got %s", i, tc.exp, got)
+		}
+	}
+	if s.expired(now.Add(19 * time.Second)) {
+		t.Errorf("expected the result to be current within the interval")
+	}
+	if !s.expired(now.Add(20 * time.Second)) {
+		t.Errorf("expected the result to expire after the interval")
+	}
+
+	s.reset()
+	if s.currentInterval() != 10*time.Second || !s.expired(now) {
+		t.Errorf("expected reset to return to the minimum interval and expire the result")
+	}
+}
+
+func TestCheckHealthAdaptive(t *testing.T) {
+	ctx := context.Background()
+	jsonData, err := json.Marshal(Settings{
+		OpenAI:               OpenAISettings{Provider: openAIProviderOpenAI},
+		AdaptiveHealthChecks: AdaptiveHealthCheckSettings{Enabled: true, MinIntervalSeconds: 10, MaxIntervalSeconds: 40},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	settings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, settings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+	calls, status := 0, http.StatusOK
+	app.healthCheckClient = &mockHealthCheckClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	app.checkReachable = func(context.Context, string) error { return nil }
+
+	check := func() openAIHealthDetails {
+		t.Helper()
+		resp, err := app.CheckHealth(ctx, &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &settings},
+		})
+		if err != nil {
+			t.Fatalf("CheckHealth error: %s", err)
+		}
+		var details healthCheckDetails
+		if err := json.Unmarshal(resp.JSONDetails, &details); err != nil {
+			t.Fatalf("unmarshal details: %s", err)
+		}
+		return details.OpenAI
+	}
+	// age makes the last result older.
+	age := func(d time.Duration) {
+		app.openAIHealthSchedule.checkedAt = app.openAIHealthSchedule.checkedAt.Add(-d)
+	}
+
+	for i, tc := range []struct {
+		name   string
+		before func()
+
+		expChecked  bool
+		expOK       bool
+		expInterval float64
+	}{
+		{name: "first check", expChecked: true, expOK: true, expInterval: 20},
+		{name: "within the interval", expOK: true, expInterval: 20},
+		{name: "after the interval", before: func() { age(20 * time.Second) }, expChecked: true, expOK: true, expInterval: 40},
+		{name: "at the maximum", before: func() { age(30 * time.Second) }, expOK: true, expInterval: 40},
+		{name: "failure", before: func() { age(40 * time.Second); status = http.StatusInternalServerError }, expChecked: true, expInterval: 20},
+		{name: "failures aren't cached", expChecked: true, expInterval: 10},
+		{name: "recovery", before: func() { status = http.StatusOK }, expChecked: true, expOK: true, expInterval: 20},
+	} {
+		if tc.before != nil {
+			tc.before()
+		}
+		before := calls
+		d := check()
+		if checked := calls > before; checked != tc.expChecked {
+			t.Errorf("%d (%s): expected the models to be checked to be %t, got %t", i, tc.name, tc.expChecked, checked)
+		}
+		if d.OK != tc.expOK || d.CheckIntervalSeconds != tc.expInterval {
+			t.Errorf("%d (%s): expected OK %t with interval %gs, got %t with %gs", i, tc.name, tc.expOK, tc.expInterval, d.OK, d.CheckIntervalSeconds)
+		}
+	}
+}
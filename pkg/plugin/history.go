@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultHealthHistorySize is the number of health check snapshots kept when
+// no size is configured.
+const defaultHealthHistorySize = 100
+
+// healthSnapshotProvider is the health of a single feature at the time of a check.
+type healthSnapshotProvider struct {
+	OK bool `json:"ok"`
+	// LatencyMs is the average latency of successful requests to the
+	// provider, if known.
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+}
+
+// healthSnapshot is the result of a single health check.
+type healthSnapshot struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Status    healthStatus           `json:"status"`
+	OpenAI    healthSnapshotProvider `json:"openAI"`
+	Vector    healthSnapshotProvider `json:"vector"`
+}
+
+// newHealthSnapshot summarizes the details of a health check made at t.
+func newHealthSnapshot(t time.Time, details healthCheckDetails) healthSnapshot {
+	return healthSnapshot{
+		Timestamp: t,
+		Status:    details.Status,
+		OpenAI:    healthSnapshotProvider{OK: details.OpenAI.OK, LatencyMs: details.OpenAI.AvgLatencyMs},
+		Vector:    healthSnapshotProvider{OK: details.Vector.OK},
+	}
+}
+
+// healthHistory is a ring buffer of the most recent health check snapshots.
+type healthHistory struct {
+	mu      sync.Mutex
+	entries []healthSnapshot
+	// next is the index the next snapshot is written to.
+	next int
+	full bool
+}
+
+func newHealthHistory(size int) *healthHistory {
+	if size <= 0 {
+		size = defaultHealthHistorySize
+	}
+	return &healthHistory{entries: make([]healthSnapshot, size)}
+}
+
+// add records a snapshot, replacing the oldest if the buffer is full.
+func (h *healthHistory) add(s healthSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries[h.next] = s
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// snapshots returns the recorded snapshots, oldest first.
+func (h *healthHistory) snapshots() []healthSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]healthSnapshot{}, h.entries[:h.next]...)
+	}
+	return append(append([]healthSnapshot{}, h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+type healthHistoryResponse struct {
+	Entries []healthSnapshot `json:"entries"`
+}
+
+// handleHealthHistory returns the recent health check snapshots, oldest first,
+// so that provider reliability can be charted over time.
+func (a *App) handleHealthHistory(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	bodyJSON, err := json.Marshal(healthHistoryResponse{Entries: a.healthHistory.snapshots()})
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	//nolint:errcheck // Just do our best to write.
+	w.Write(bodyJSON)
+}
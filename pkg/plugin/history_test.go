@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHealthHistoryRingBuffer(t *testing.T) {
+	h := newHealthHistory(3)
+	start := time.Unix(0, 0)
+	for i := 0; i < 5; i++ {
+		h.add(healthSnapshot{Timestamp: start.Add(time.Duration(i) * time.Second)})
+		exp := min(i+1, 3)
+		got := h.snapshots()
+		if len(got) != exp {
+			t.Fatalf("after %d adds: expected %d snapshots, got %d", i+1, exp, len(got))
+		}
+		// Snapshots are oldest first, ending with the one just added.
+		for j, s := range got {
+			if want := start.Add(time.Duration(i-exp+1+j) * time.Second); !s.Timestamp.Equal(want) {
+				t.Errorf("after %d adds: expected snapshot %d at %s, got %s", i+1, j, want, s.Timestamp)
+			}
+		}
+	}
+}
+
+func TestHealthHistoryEndpoint(t *testing.T) {
+	ctx := context.Background()
+	jsonData, err := json.Marshal(Settings{HealthHistorySize: 2})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	settings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, settings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+	app.checkReachable = func(context.Context, string) error { return nil }
+	healthy := true
+	app.healthCheckClient = &mockHealthCheckClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			status := http.StatusOK
+			if !healthy {
+				status = http.StatusInternalServerError
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+
+	// Three checks, the first of which should be dropped from the history.
+	for _, ok := range []bool{true, false, false} {
+		healthy = ok
+		// Failures aren't cached, but successes are, so clear the cache.
+		app.healthOpenAI = nil
+		if _, err := app.CheckHealth(ctx, &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &settings},
+		}); err != nil {
+			t.Fatalf("CheckHealth error: %s", err)
+		}
+	}
+
+	var r mockCallResourceResponseSender
+	err = app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &settings},
+		Method:        http.MethodGet,
+		Path:          "/health/history",
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.response.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+	}
+	var resp healthHistoryResponse
+	if err := json.Unmarshal(r.response.Body, &resp); err != nil {
+		t.Fatalf("unmarshal response %s: %s", r.response.Body, err)
+	}
+	if len(resp.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(resp.Entries))
+	}
+	for i, e := range resp.Entries {
+		if e.OpenAI.OK || e.Status != healthStatusUnhealthy || e.Timestamp.IsZero() {
+			t.Errorf("expected entry %d to be a failed check, got %+v", i, e)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	defaultIdempotencyWindow  = 10 * time.Minute
+	maxIdempotentResponseSize = 10 * 1024 * 1024
+	// defaultIdempotencyMaxBytes limits the total size of the responses
+	// kept, unless configured otherwise.
+	defaultIdempotencyMaxBytes = 64 * 1024 * 1024
+)
+
+// IdempotencySettings configures how requests carrying an Idempotency-Key
+// header are deduplicated.
+type IdempotencySettings struct {
+	// Disabled turns off deduplication; the header is still passed upstream.
+	Disabled bool `json:"disabled"`
+	// WindowSeconds is how long a response is kept for replay. Defaults to 10 minutes.
+	WindowSeconds int `json:"windowSeconds"`
+	// MaxBytes limits the total size of the responses kept. Once it is
+	// reached the oldest are forgotten. Defaults to 64 MiB.
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// idempotentResponse is a response recorded for an idempotency key. done is
+// closed once the response is complete; until then, retries wait for it.
+type idempotentResponse struct {
+	bodyHash [sha256.Size]byte
+	done     chan struct{}
+	expires  time.Time
+
+	// Set before done is closed. If ok is false the response wasn't cached (e.g.
+	// an upstream error) and waiting retries are sent upstream themselves.
+	ok     bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyCache remembers the first response to each request carrying an
+// Idempotency-Key header, and replays it for retries with the same key rather
+// than calling the provider again.
+type idempotencyCache struct {
+	window   time.Duration
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+	// size is the total size of the bodies of completed entries.
+	size int64
+	now  func() time.Time
+}
+
+func newIdempotencyCache(s IdempotencySettings) *idempotencyCache {
+	window := time.Duration(s.WindowSeconds) * time.Second
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+	maxBytes := s.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultIdempotencyMaxBytes
+	}
+	return &idempotencyCache{
+		window:   window,
+		maxBytes: maxBytes,
+		entries:  map[string]*idempotentResponse{},
+		now:      time.Now,
+	}
+}
+
+// evictExpired removes expired entries. The caller must hold c.mu.
+func (c *idempotencyCache) evictExpired() {
+	now := c.now()
+	for k, e := range c.entries {
+		select {
+		case <-e.done:
+			if now.After(e.expires) {
+				c.remove(k, e)
+			}
+		default:
+			// Still in flight.
+		}
+	}
+}
+
+// remove forgets a completed entry. The caller must hold c.mu.
+func (c *idempotencyCache) remove(key string, e *idempotentResponse) {
+	delete(c.entries, key)
+	c.size -= int64(len(e.body))
+}
+
+// evictOldest removes the completed entries which expire first until n more
+// bytes fit within the limit. The caller must hold c.mu.
+func (c *idempotencyCache) evictOldest(n int64) {
+	for c.size+n > c.maxBytes {
+		var oldestKey string
+		var oldest *idempotentResponse
+		for k, e := range c.entries {
+			select {
+			case <-e.done:
+				if oldest == nil || e.expires.Before(oldest.expires) {
+					oldestKey, oldest = k, e
+				}
+			default:
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		c.remove(oldestKey, oldest)
+	}
+}
+
+// claim returns the entry for key, and whether the caller created it and is
+// therefore responsible for completing it.
+func (c *idempotencyCache) claim(key string, bodyHash [sha256.Size]byte) (*idempotentResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictExpired()
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+	e := &idempotentResponse{bodyHash: bodyHash, done: make(chan struct{})}
+	c.entries[key] = e
+	return e, true
+}
+
+// complete records the outcome of a claimed request and wakes any waiting
+// retries. Successful responses are kept for later retries if they fit
+// within the size limit, evicting older ones if need be; retries already
+// waiting receive them either way.
+func (c *idempotencyCache) complete(key string, e *idempotentResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(e.done)
+	if c.entries[key] != e {
+		// Forgotten by reset while in flight.
+		return
+	}
+	size := int64(len(e.body))
+	if !e.ok || size > c.maxBytes {
+		delete(c.entries, key)
+		return
+	}
+	e.expires = c.now().Add(c.window)
+	c.evictOldest(size)
+	c.size += size
+}
+
+// reset forgets all recorded responses. Requests already waiting on an
+// in-flight response still receive it.
+func (c *idempotencyCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*idempotentResponse{}
+	c.size = 0
+}
+
+// middleware wraps a proxy handler so that requests with an Idempotency-Key
+// header are deduplicated. A nil cache returns next unchanged.
+func (c *idempotencyCache) middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			handleError(w, req, fmt.Errorf("read request body: %w", err), http.StatusBadRequest)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		bodyHash := sha256.Sum256(body)
+		key = req.Method + " " + req.URL.Path + " " + key
+
+		for {
+			e, owner := c.claim(key, bodyHash)
+			if owner {
+				c.record(w, req, next, key, e)
+				return
+			}
+			if e.bodyHash != bodyHash {
+				handleError(w, req, fmt.Errorf("%s has already been used for a different request", idempotencyKeyHeader), http.StatusUnprocessableEntity)
+				return
+			}
+			select {
+			case <-e.done:
+			case <-req.Context().Done():
+				handleError(w, req, req.Context().Err(), http.StatusGatewayTimeout)
+				return
+			}
+			if e.ok {
+				replay(w, e)
+				return
+			}
+			// The original request failed, so try again ourselves.
+		}
+	})
+}
+
+// record serves the request, recording the response for later replay.
+func (c *idempotencyCache) record(w http.ResponseWriter, req *http.Request, next http.Handler, key string, e *idempotentResponse) {
+	rec := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	finished := false
+	defer func() {
+		// Only cache complete, successful responses; errors should be
+		// retryable, as should responses cut short by the client going away,
+		// the upstream dropping or a panic.
+		e.ok = finished && req.Context().Err() == nil && !rec.overflow && rec.status < http.StatusBadRequest && rec.complete(w.Header())
+		if e.ok {
+			e.status = rec.status
+			e.header = w.Header().Clone()
+			e.body = rec.body.Bytes()
+		}
+		c.complete(key, e)
+	}()
+	next.ServeHTTP(rec, req)
+	finished = true
+}
+
+func replay(w http.ResponseWriter, e *idempotentResponse) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(e.status)
+	if _, err := w.Write(e.body); err != nil {
+		log.DefaultLogger.Error("Unable to write replayed response", "err", err)
+	}
+}
+
+// recordingResponseWriter passes a response through to the client while
+// keeping a copy of it.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if !w.overflow {
+		if w.body.Len()+len(b) > maxIdempotentResponseSize {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// complete reports whether the recorded body is a whole response. Streamed
+// responses are only whole once they have sent the final `[DONE]` event;
+// without it they were cut short.
+func (w *recordingResponseWriter) complete(header http.Header) bool {
+	if !strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return true
+	}
+	return bytes.Contains(w.body.Bytes(), []byte("data: [DONE]"))
+}
+
+// Flush implements http.Flusher so streamed responses are still flushed.
+func (w *recordingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
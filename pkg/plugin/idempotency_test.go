@@ -0,0 +1,207 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	type call struct {
+		key  string
+		body string
+
+		expStatus   int
+		expReplayed bool
+	}
+	for _, tc := range []struct {
+		name           string
+		upstreamStatus int
+		// advance is how far to move the clock between calls.
+		advance time.Duration
+		calls   []call
+
+		expUpstreamCalls int
+	}{
+		{
+			name:           "retry is replayed",
+			upstreamStatus: http.StatusOK,
+			calls: []call{
+				{key: "a", body: `{"model":"gpt-4"}`, expStatus: http.StatusOK},
+				{key: "a", body: `{"model":"gpt-4"}`, expStatus: http.StatusOK, expReplayed: true},
+			},
+			expUpstreamCalls: 1,
+		},
+		{
+			name:           "different keys are not deduplicated",
+			upstreamStatus: http.StatusOK,
+			calls: []call{
+				{key: "a", body: `{"model":"gpt-4"}`, expStatus: http.StatusOK},
+				{key: "b", body: `{"model":"gpt-4"}`, expStatus: http.StatusOK},
+			},
+			expUpstreamCalls: 2,
+		},
+		{
+			name:           "no key",
+			upstreamStatus: http.StatusOK,
+			calls: []call{
+				{body: `{"model":"gpt-4"}`, expStatus: http.StatusOK},
+				{body: `{"model":"gpt-4"}`, expStatus: http.StatusOK},
+			},
+			expUpstreamCalls: 2,
+		},
+		{
+			name:           "key reused with a different body",
+			upstreamStatus: http.StatusOK,
+			calls: []call{
+				{key: "a", body: `{"model":"gpt-4"}`, expStatus: http.StatusOK},
+				{key: "a", body: `{"model":"gpt-3.5-turbo"}`, expStatus: http.StatusUnprocessableEntity},
+			},
+			expUpstreamCalls: 1,
+		},
+		{
+			name:           "errors are not cached",
+			upstreamStatus: http.StatusInternalServerError,
+			calls: []call{
+				{key: "a", body: `{"model":"gpt-4"}`, expStatus: http.StatusInternalServerError},
+				{key: "a", body: `{"model":"gpt-4"}`, expStatus: http.StatusInternalServerError},
+			},
+			expUpstreamCalls: 2,
+		},
+		{
+			name:           "expired responses are evicted",
+			upstreamStatus: http.StatusOK,
+			advance:        defaultIdempotencyWindow + time.Second,
+			calls: []call{
+				{key: "a", body: `{"model":"gpt-4"}`, expStatus: http.StatusOK},
+				{key: "a", body: `{"model":"gpt-4"}`, expStatus: http.StatusOK},
+			},
+			expUpstreamCalls: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			upstreamCalls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamCalls++
+				w.WriteHeader(tc.upstreamStatus)
+				_, _ = w.Write([]byte(`{"choices": []}`))
+			}))
+			defer server.Close()
+
+			app, appSettings := newTransformTestApp(t, server.URL)
+			now := time.Now()
+			app.idempotency.now = func() time.Time { return now }
+
+			for i, c := range tc.calls {
+				headers := map[string][]string{}
+				if c.key != "" {
+					headers[idempotencyKeyHeader] = []string{c.key}
+				}
+				var r mockCallResourceResponseSender
+				err := app.CallResource(ctx, &backend.CallResourceRequest{
+					PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+					Method:        http.MethodPost,
+					Path:          "/openai/v1/chat/completions",
+					Headers:       headers,
+					Body:          []byte(c.body),
+				}, &r)
+				if err != nil {
+					t.Fatalf("CallResource error: %s", err)
+				}
+				if r.response.Status != c.expStatus {
+					t.Errorf("call %d: expected status %d, got %d", i, c.expStatus, r.response.Status)
+				}
+				replayed := len(r.response.Headers[idempotentReplayedHeader]) > 0
+				if replayed != c.expReplayed {
+					t.Errorf("call %d: expected replayed %t, got %t", i, c.expReplayed, replayed)
+				}
+				now = now.Add(tc.advance)
+			}
+			if upstreamCalls != tc.expUpstreamCalls {
+				t.Errorf("expected %d upstream calls, got %d", tc.expUpstreamCalls, upstreamCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyIncompleteResponses(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		// cancel cancels the request before it finishes.
+		cancel bool
+
+		expReplayed bool
+	}{
+		{name: "complete stream", body: "data: {\"choices\": []}\n\ndata: [DONE]\n\n", expReplayed: true},
+		{name: "stream cut short", body: "data: {\"choices\": []}\n\n"},
+		{name: "client went away", body: "data: {\"choices\": []}\n\ndata: [DONE]\n\n", cancel: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			c := newIdempotencyCache(IdempotencySettings{})
+			handler := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			for i := 0; i < 2; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				if tc.cancel && i == 0 {
+					cancel()
+				}
+				req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(`{"stream": true}`)).WithContext(ctx)
+				req.Header.Set(idempotencyKeyHeader, "a")
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				cancel()
+				if i == 1 {
+					if replayed := w.Header().Get(idempotentReplayedHeader) != ""; replayed != tc.expReplayed {
+						t.Errorf("expected the retry to be replayed to be %t", tc.expReplayed)
+					}
+				}
+			}
+			if exp := map[bool]int{true: 1, false: 2}[tc.expReplayed]; calls != exp {
+				t.Errorf("expected %d upstream calls, got %d", exp, calls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyMaxBytes(t *testing.T) {
+	c := newIdempotencyCache(IdempotencySettings{MaxBytes: 25})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	handler := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	serve := func(key string) bool {
+		req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Header().Get(idempotentReplayedHeader) != ""
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		serve(key)
+		now = now.Add(time.Second)
+	}
+	if c.size > c.maxBytes {
+		t.Errorf("expected at most %d bytes kept, got %d", c.maxBytes, c.size)
+	}
+	// The oldest response was forgotten to make room for the newest.
+	for _, key := range []string{"b", "c"} {
+		if !serve(key) {
+			t.Errorf("expected %s to be replayed", key)
+		}
+	}
+	if serve("a") {
+		t.Error("expected the oldest response to have been forgotten")
+	}
+}
@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStreamKeepAliveInterval is the time between keep-alive comments if
+// no interval is configured.
+const defaultStreamKeepAliveInterval = 15 * time.Second
+
+// keepAliveComment is an SSE comment line, which clients ignore.
+const keepAliveComment = ": keep-alive\n\n"
+
+// StreamKeepAliveSettings configures keep-alive comments in streamed
+// responses, for load balancers which drop connections that are idle while
+// a model takes a long time to produce its first token.
+type StreamKeepAliveSettings struct {
+	Enabled bool `json:"enabled"`
+	// IntervalSeconds is the time between keep-alive comments. Defaults to 15
+	// seconds.
+	IntervalSeconds int `json:"intervalSeconds"`
+}
+
+// interval returns the time between keep-alive comments, or zero if they're
+// disabled.
+func (s StreamKeepAliveSettings) interval() time.Duration {
+	if !s.Enabled {
+		return 0
+	}
+	if s.IntervalSeconds <= 0 {
+		return defaultStreamKeepAliveInterval
+	}
+	return time.Duration(s.IntervalSeconds) * time.Second
+}
+
+// keepAliveStream sends a keep-alive comment every interval in a successful
+// streamed response until the provider sends its first data. The comments
+// start once the provider's response headers have arrived.
+func keepAliveStream(resp *http.Response, interval time.Duration) {
+	if interval <= 0 || resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	body := resp.Body
+	pr, pw := io.Pipe()
+	k := &keepAliveBody{PipeReader: pr, body: body, done: make(chan struct{})}
+	go k.copy(pw)
+	go k.tick(pw, interval)
+	resp.Body = k
+}
+
+// keepAliveBody is a response body which interleaves keep-alive comments with
+// the provider's body until the first data arrives.
+type keepAliveBody struct {
+	*io.PipeReader
+	body io.ReadCloser
+
+	// mu serializes writes, so that a comment never lands in the middle of
+	// an event. started is set once data has been written.
+	mu      sync.Mutex
+	started bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// copy copies the provider's body to pw.
+func (k *keepAliveBody) copy(pw *io.PipeWriter) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := k.body.Read(buf)
+		if n > 0 {
+			k.mu.Lock()
+			k.started = true
+			_, werr := pw.Write(buf[:n])
+			k.mu.Unlock()
+			if werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			pw.CloseWithError(err)
+			k.stop()
+			return
+		}
+	}
+}
+
+// tick writes a keep-alive comment to pw every interval, until data is
+// written or the body is closed.
+func (k *keepAliveBody) tick(pw *io.PipeWriter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+			k.mu.Lock()
+			started := k.started
+			if !started {
+				_, _ = io.WriteString(pw, keepAliveComment)
+			}
+			k.mu.Unlock()
+			if started {
+				return
+			}
+		}
+	}
+}
+
+func (k *keepAliveBody) stop() {
+	k.closeOnce.Do(func() { close(k.done) })
+}
+
+func (k *keepAliveBody) Close() error {
+	k.stop()
+	k.PipeReader.Close()
+	return k.body.Close()
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamKeepAlive(t *testing.T) {
+	const (
+		chunk1 = "data: {\"choices\": [{\"delta\": {\"content\": \"Hi\"}}]}\n\n"
+		chunk2 = "data: [DONE]\n\n"
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// The model takes a while to produce its first token, then a while
+		// longer for the rest.
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte(chunk1))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte(chunk2))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name      string
+		keepAlive time.Duration
+
+		expComments bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", keepAlive: 10 * time.Millisecond, expComments: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &directOpenAIProvider{settings: OpenAISettings{URL: server.URL}}
+			proxy := newProviderProxy(provider, providerProxyOptions{keepAlive: tc.keepAlive})
+			req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "stream": true}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+
+			body := w.Body.String()
+			data := strings.Index(body, "data:")
+			if data < 0 {
+				t.Fatalf("expected the stream to be passed through, got %q", body)
+			}
+			comments := body[:data]
+			if tc.expComments && (comments == "" || strings.ReplaceAll(comments, keepAliveComment, "") != "") {
+				t.Errorf("expected keep-alive comments before the first data, got %q", comments)
+			}
+			if !tc.expComments && comments != "" {
+				t.Errorf("expected no keep-alive comments, got %q", comments)
+			}
+			if rest := body[data:]; rest != chunk1+chunk2 {
+				t.Errorf("expected no keep-alive comments once data arrived, got %q", rest)
+			}
+		})
+	}
+}
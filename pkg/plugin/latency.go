@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyEMAAlpha is the weight given to each new sample in the latency average.
+const latencyEMAAlpha = 0.2
+
+// latencyEMA tracks an exponential moving average of successful chat
+// completions latencies, giving a cheap live indicator of provider performance.
+type latencyEMA struct {
+	mu      sync.Mutex
+	avg     float64
+	samples int
+}
+
+// observe adds a latency sample to the average.
+func (e *latencyEMA) observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.samples == 0 {
+		e.avg = ms
+	} else {
+		e.avg = latencyEMAAlpha*ms + (1-latencyEMAAlpha)*e.avg
+	}
+	e.samples++
+}
+
+// milliseconds returns the average latency in milliseconds, and whether there
+// have been any samples.
+func (e *latencyEMA) milliseconds() (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.avg, e.samples > 0
+}
+
+// reset discards all samples.
+func (e *latencyEMA) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.avg = 0
+	e.samples = 0
+}
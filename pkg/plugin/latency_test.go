@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestLatencyEMA(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		samples []time.Duration
+
+		expOK  bool
+		expAvg float64
+	}{
+		{
+			name:  "no samples",
+			expOK: false,
+		},
+		{
+			name:    "first sample is the average",
+			samples: []time.Duration{100 * time.Millisecond},
+			expOK:   true,
+			expAvg:  100,
+		},
+		{
+			name:    "later samples are weighted",
+			samples: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+			expOK:   true,
+			expAvg:  120,
+		},
+		{
+			name:    "sub-millisecond samples",
+			samples: []time.Duration{500 * time.Microsecond},
+			expOK:   true,
+			expAvg:  0.5,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var e latencyEMA
+			for _, s := range tc.samples {
+				e.observe(s)
+			}
+			avg, ok := e.milliseconds()
+			if ok != tc.expOK {
+				t.Fatalf("expected ok %t, got %t", tc.expOK, ok)
+			}
+			if math.Abs(avg-tc.expAvg) > 1e-9 {
+				t.Errorf("expected average %v, got %v", tc.expAvg, avg)
+			}
+		})
+	}
+}
+
+func TestProxyUpdatesLatency(t *testing.T) {
+	ctx := context.Background()
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+	app, appSettings := newTransformTestApp(t, server.URL)
+
+	call := func() {
+		var r mockCallResourceResponseSender
+		err := app.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+			Method:        http.MethodPost,
+			Path:          "/openai/v1/chat/completions",
+			Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+		}, &r)
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+	}
+
+	// Failed requests aren't counted.
+	status.Store(http.StatusInternalServerError)
+	call()
+	if _, ok := app.latency.milliseconds(); ok {
+		t.Fatal("expected failed request not to update latency")
+	}
+
+	status.Store(http.StatusOK)
+	call()
+	avg, ok := app.latency.milliseconds()
+	if !ok || avg < 5 {
+		t.Errorf("expected average latency of at least 5ms, got %v (ok %t)", avg, ok)
+	}
+}
@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// llmGatewayReprobeInterval is how often we retry the primary llm-gateway
+// endpoint after failing over to a secondary one.
+const llmGatewayReprobeInterval = time.Minute
+
+// llmGatewayEndpoints tracks the configured llm-gateway endpoints and which one
+// is currently in use. Requests are sent to the active endpoint; if that isn't
+// the primary, the primary is re-probed periodically so we fail back once it
+// recovers.
+type llmGatewayEndpoints struct {
+	urls []*url.URL
+
+	mu        sync.Mutex
+	active    int
+	lastProbe time.Time
+	now       func() time.Time
+}
+
+func newLLMGatewayEndpoints(urls []string) (*llmGatewayEndpoints, error) {
+	e := &llmGatewayEndpoints{now: time.Now}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse LLM Gateway URL %q: %w", raw, err)
+		}
+		e.urls = append(e.urls, u)
+	}
+	if len(e.urls) == 0 {
+		return nil, fmt.Errorf("no LLM Gateway URLs configured")
+	}
+	return e, nil
+}
+
+// candidates returns the indexes of the endpoints to try for a request, in order.
+func (e *llmGatewayEndpoints) candidates() []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	order := make([]int, 0, len(e.urls))
+	probing := e.active != 0 && e.now().Sub(e.lastProbe) >= llmGatewayReprobeInterval
+	if probing {
+		e.lastProbe = e.now()
+		order = append(order, 0)
+	}
+	order = append(order, e.active)
+	for i := range e.urls {
+		if i != e.active && !(probing && i == 0) {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// markGood records that the endpoint at index i served a request successfully,
+// making it the active endpoint.
+func (e *llmGatewayEndpoints) markGood(i int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.active == i {
+		return
+	}
+	log.DefaultLogger.Info("Switching active LLM Gateway endpoint", "from", e.urls[e.active].String(), "to", e.urls[i].String())
+	e.active = i
+	e.lastProbe = e.now()
+}
+
+// reset makes the primary endpoint active again.
+func (e *llmGatewayEndpoints) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.active = 0
+	e.lastProbe = time.Time{}
+}
+
+// activeURL returns the URL of the endpoint currently in use.
+func (e *llmGatewayEndpoints) activeURL() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.urls[e.active].String()
+}
+
+// activeFirst returns the URLs of the endpoints, starting with the one
+// currently in use.
+func (e *llmGatewayEndpoints) activeFirst() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	urls := make([]string, 0, len(e.urls))
+	for i := range e.urls {
+		urls = append(urls, e.urls[(e.active+i)%len(e.urls)].String())
+	}
+	return urls
+}
+
+// relativePath returns the path of u relative to the endpoint it points at,
+// so it can be sent to another endpoint with a different path prefix. If u
+// doesn't point at any endpoint, its whole path is returned.
+func (e *llmGatewayEndpoints) relativePath(u *url.URL) string {
+	rel := u.Path
+	matched := -1
+	for _, ep := range e.urls {
+		prefix := strings.TrimSuffix(ep.Path, "/")
+		if ep.Scheme != u.Scheme || ep.Host != u.Host || len(prefix) <= matched {
+			continue
+		}
+		if u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/") {
+			rel = strings.TrimPrefix(u.Path, prefix)
+			matched = len(prefix)
+		}
+	}
+	return rel
+}
+
+// urlFor returns u pointed at the endpoint at index i, keeping the path
+// relative to the endpoint and the query.
+func (e *llmGatewayEndpoints) urlFor(i int, u *url.URL) *url.URL {
+	ep := e.urls[i]
+	out := *u
+	out.Scheme = ep.Scheme
+	out.Host = ep.Host
+	out.User = ep.User
+	out.Path = strings.TrimSuffix(ep.Path, "/") + e.relativePath(u)
+	out.RawPath = ""
+	return &out
+}
+
+// providerTransport returns the transport for requests to the configured
+// provider: one which fails over between the llm-gateway endpoints, so
+// requests go to whichever regional endpoint is currently healthy, or nil
+// for the default transport.
+func (a *App) providerTransport() http.RoundTripper {
+	if a.llmGateway == nil {
+		return nil
+	}
+	return &llmGatewayFailoverTransport{
+		endpoints: a.llmGateway,
+		base:      http.DefaultTransport,
+	}
+}
+
+// llmGatewayFailoverTransport is a http.RoundTripper which sends requests to
+// each llm-gateway endpoint in turn, moving on to the next one if a request
+// fails to connect or returns a 5xx status code.
+type llmGatewayFailoverTransport struct {
+	endpoints *llmGatewayEndpoints
+	base      http.RoundTripper
+}
+
+func (t *llmGatewayFailoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Buffer the body so it can be replayed against each endpoint.
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read request body: %w", err)
+		}
+	}
+
+	candidates := t.endpoints.candidates()
+	for n, i := range candidates {
+		u := t.endpoints.urls[i]
+		outReq := req.Clone(req.Context())
+		outReq.URL = t.endpoints.urlFor(i, req.URL)
+		outReq.Host = ""
+		if body != nil {
+			outReq.Body = io.NopCloser(bytes.NewReader(body))
+			outReq.ContentLength = int64(len(body))
+		}
+
+		resp, err := t.base.RoundTrip(outReq)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			t.endpoints.markGood(i)
+			return resp, nil
+		}
+		// Don't fail over if the caller has gone away, or if there's nowhere left to go.
+		if req.Context().Err() != nil || n == len(candidates)-1 {
+			return resp, err
+		}
+		if err != nil {
+			log.DefaultLogger.FromContext(req.Context()).Warn("LLM Gateway endpoint unreachable, failing over", "url", u.String(), "err", err)
+		} else {
+			log.DefaultLogger.FromContext(req.Context()).Warn("LLM Gateway endpoint returned server error, failing over", "url", u.String(), "status", resp.Status)
+			resp.Body.Close()
+		}
+	}
+	// Unreachable: candidates always contains at least the active endpoint.
+	return nil, fmt.Errorf("no LLM Gateway endpoints available")
+}
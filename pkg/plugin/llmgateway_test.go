@@ -0,0 +1,285 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestLLMGatewayEndpointsCandidates(t *testing.T) {
+	e, err := newLLMGatewayEndpoints([]string{"http://primary", "http://secondary", "http://tertiary"})
+	if err != nil {
+		t.Fatalf("new endpoints: %s", err)
+	}
+	now := time.Now()
+	e.now = func() time.Time { return now }
+
+	assertOrder := func(exp ...int) {
+		t.Helper()
+		got := e.candidates()
+		if len(got) != len(exp) {
+			t.Fatalf("expected candidates %v, got %v", exp, got)
+		}
+		for i := range exp {
+			if got[i] != exp[i] {
+				t.Fatalf("expected candidates %v, got %v", exp, got)
+			}
+		}
+	}
+
+	assertOrder(0, 1, 2)
+
+	// After failing over, the secondary is tried first...
+	e.markGood(1)
+	assertOrder(1, 0, 2)
+
+	// ...until the re-probe interval elapses, when the primary is tried first once.
+	now = now.Add(llmGatewayReprobeInterval)
+	assertOrder(0, 1, 2)
+	assertOrder(1, 0, 2)
+}
+
+func TestLLMGatewayFailover(t *testing.T) {
+	ctx := context.Background()
+	primaryCalls, secondaryCalls := 0, 0
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryCalls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer secondary.Close()
+
+	settings := Settings{
+		Tenant:           "123",
+		GrafanaComAPIKey: "abcd1234",
+		OpenAI:           OpenAISettings{Provider: openAIProviderGrafana},
+		LLMGateway: LLMGatewaySettings{
+			URLs: []string{primary.URL, secondary.URL},
+		},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{JSONData: jsonData}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for i := 0; i < 2; i++ {
+		var r mockCallResourceResponseSender
+		err = app.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+			Method:        http.MethodPost,
+			Path:          "/openai/v1/chat/completions",
+			Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+		}, &r)
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+		if r.response.Status != http.StatusOK {
+			t.Fatalf("response status should be %d, got %d", http.StatusOK, r.response.Status)
+		}
+	}
+
+	// The second request should have gone straight to the secondary.
+	if primaryCalls != 1 {
+		t.Errorf("expected primary to be called once, got %d", primaryCalls)
+	}
+	if secondaryCalls != 2 {
+		t.Errorf("expected secondary to be called twice, got %d", secondaryCalls)
+	}
+	if got := app.llmGateway.activeURL(); got != secondary.URL {
+		t.Errorf("expected active endpoint %s, got %s", secondary.URL, got)
+	}
+}
+
+func TestLLMGatewayFailoverPathPrefix(t *testing.T) {
+	ctx := context.Background()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	var secondaryPaths []string
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryPaths = append(secondaryPaths, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer secondary.Close()
+
+	settings := Settings{
+		Tenant:           "123",
+		GrafanaComAPIKey: "abcd1234",
+		OpenAI:           OpenAISettings{Provider: openAIProviderGrafana},
+		LLMGateway: LLMGatewaySettings{
+			URLs: []string{primary.URL + "/eu", secondary.URL + "/us/"},
+		},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{JSONData: jsonData}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	var r mockCallResourceResponseSender
+	err = app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Method:        http.MethodPost,
+		Path:          "/openai/v1/chat/completions",
+		Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.response.Status != http.StatusOK {
+		t.Fatalf("response status should be %d, got %d", http.StatusOK, r.response.Status)
+	}
+
+	// Health checks fail over too. Make the primary active again, so the
+	// check has to.
+	app.llmGateway.reset()
+	if err := app.testOpenAIModelsList(ctx); err != nil {
+		t.Fatalf("health check: %s", err)
+	}
+
+	exp := []string{"/us/openai/v1/chat/completions", "/us/openai/v1/models"}
+	if len(secondaryPaths) != len(exp) || secondaryPaths[0] != exp[0] || secondaryPaths[1] != exp[1] {
+		t.Errorf("expected the secondary to be sent %v, got %v", exp, secondaryPaths)
+	}
+}
+
+func TestLLMGatewayEndpointsURLFor(t *testing.T) {
+	e, err := newLLMGatewayEndpoints([]string{"https://gateway.example.com/eu", "https://gateway.example.com/eu/v2", "https://us.example.com"})
+	if err != nil {
+		t.Fatalf("new endpoints: %s", err)
+	}
+	for _, tc := range []struct {
+		url string
+		to  int
+		exp string
+	}{
+		{url: "https://gateway.example.com/eu/openai/v1/models?a=b", to: 2, exp: "https://us.example.com/openai/v1/models?a=b"},
+		{url: "https://gateway.example.com/eu/v2/openai/v1/models", to: 0, exp: "https://gateway.example.com/eu/openai/v1/models"},
+		{url: "https://us.example.com/openai/v1/models", to: 1, exp: "https://gateway.example.com/eu/v2/openai/v1/models"},
+		{url: "https://elsewhere.example.com/openai/v1/models", to: 0, exp: "https://gateway.example.com/eu/openai/v1/models"},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatalf("parse url: %s", err)
+		}
+		if got := e.urlFor(tc.to, u).String(); got != tc.exp {
+			t.Errorf("urlFor(%d, %s) = %s, expected %s", tc.to, tc.url, got, tc.exp)
+		}
+	}
+}
+
+func TestLLMGatewayFailoverStream(t *testing.T) {
+	ctx := context.Background()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"Hello\"}}]}\n\ndata: [DONE]\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer secondary.Close()
+
+	jsonData, err := json.Marshal(Settings{
+		Tenant:           "123",
+		GrafanaComAPIKey: "abcd1234",
+		OpenAI:           OpenAISettings{Provider: openAIProviderGrafana},
+		LLMGateway:       LLMGatewaySettings{URLs: []string{primary.URL, secondary.URL}},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{JSONData: jsonData}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	r := mockStreamPacketSender{messages: []json.RawMessage{}}
+	err = app.RunStream(ctx, &backend.RunStreamRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Path:          openAIChatCompletionsPath + "/abcd1234",
+		Data:          []byte(`{"model": "gpt-4o", "messages": []}`),
+	}, backend.NewStreamSender(&r))
+	if err != nil {
+		t.Fatalf("RunStream error: %s", err)
+	}
+	if len(r.messages) != 2 {
+		t.Fatalf("expected a chunk and the done message, got %s", r.messages)
+	}
+	if got := app.llmGateway.activeURL(); got != secondary.URL {
+		t.Errorf("expected the stream to fail over to %s, got %s", secondary.URL, got)
+	}
+}
+
+func TestLLMGatewayFailoverHealth(t *testing.T) {
+	ctx := context.Background()
+	// Nothing listens at the primary, so dialing it fails.
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [], "choices": [{"message": {"content": "ok"}}]}`))
+	}))
+	defer secondary.Close()
+
+	jsonData, err := json.Marshal(Settings{
+		Tenant:           "123",
+		GrafanaComAPIKey: "abcd1234",
+		OpenAI:           OpenAISettings{Provider: openAIProviderGrafana},
+		LLMGateway:       LLMGatewaySettings{URLs: []string{primary.URL, secondary.URL}},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{JSONData: jsonData}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	resp, err := app.CheckHealth(ctx, &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+	})
+	if err != nil {
+		t.Fatalf("CheckHealth error: %s", err)
+	}
+	var details healthCheckDetails
+	if err := json.Unmarshal(resp.JSONDetails, &details); err != nil {
+		t.Fatalf("non-JSON response details (%s): %s", resp.JSONDetails, err)
+	}
+	if !details.OpenAI.Reachable || !details.OpenAI.OK {
+		t.Errorf("expected the provider to be reachable and healthy through the secondary, got %+v", details.OpenAI)
+	}
+	for model, health := range details.OpenAI.Models {
+		if !health.OK {
+			t.Errorf("expected model %s to be healthy, got %+v", model, health)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+)
+
+// loadBalancedProviderHeader is set on responses to load balanced requests to
+// the name of the provider which handled them.
+const loadBalancedProviderHeader = "X-LLM-Provider"
+
+// WeightedProvider is a provider to which a share of proxied requests is sent.
+type WeightedProvider struct {
+	// Name identifies the provider in the X-LLM-Provider response header.
+	// Defaults to the provider type, such as `openai`.
+	Name string `json:"name"`
+	// Weight is the provider's share of requests, relative to the others.
+	Weight int `json:"weight"`
+	// OpenAI configures the provider, like the top-level openAI settings.
+	OpenAI OpenAISettings `json:"openAI"`
+	// APIKeySecret is the name of the secure setting holding the provider's
+	// API key, or a list of keys separated by commas. Defaults to openAIKey.
+	APIKeySecret string `json:"apiKeySecret"`
+}
+
+// loadWeightedProviders validates the load balanced providers, filling in
+// their defaults and API keys from secrets.
+func loadWeightedProviders(providers []WeightedProvider, secrets map[string]string) error {
+	total := 0
+	for i := range providers {
+		p := &providers[i]
+		if err := loadProviderSettings(&p.OpenAI, p.APIKeySecret, secrets); err != nil {
+			return fmt.Errorf("load balanced provider %d: %w", i, err)
+		}
+		if p.Weight < 0 {
+			return fmt.Errorf("load balanced provider %d: negative weight %d", i, p.Weight)
+		}
+		total += p.Weight
+		if p.Name == "" {
+			p.Name = string(p.OpenAI.Provider)
+		}
+	}
+	if len(providers) > 0 && total == 0 {
+		return fmt.Errorf("load balanced providers must have a positive total weight")
+	}
+	return nil
+}
+
+// loadProviderSettings validates the settings of a provider configured apart
+// from the top-level one, filling in their defaults and API keys from the
+// keySecret secret, or openAIKey if it's empty. Only providers with their own
+// API keys are supported.
+func loadProviderSettings(s *OpenAISettings, keySecret string, secrets map[string]string) error {
+	switch s.Provider {
+	case openAIProviderOpenAI:
+		if s.URL == "" {
+			s.URL = "https://api.openai.com"
+		}
+	case openAIProviderAzure:
+		if err := loadAzureSettings(s); err != nil {
+			return err
+		}
+	case openAIProviderCohere:
+		if s.URL == "" {
+			s.URL = defaultCohereURL
+		}
+	default:
+		return fmt.Errorf("unsupported provider %q", s.Provider)
+	}
+	if err := s.KeyRotation.validate(); err != nil {
+		return err
+	}
+	if err := s.HealthCheckMethod.validate(); err != nil {
+		return err
+	}
+	if err := s.StructuredOutputsFallback.validate(); err != nil {
+		return err
+	}
+	if keySecret == "" {
+		keySecret = openAIKey
+	}
+	loadAPIKeys(s, secrets[keySecret])
+	return nil
+}
+
+// weightedHandler is a handler for a share of requests.
+type weightedHandler struct {
+	name    string
+	weight  int
+	handler http.Handler
+}
+
+// loadBalancer sends each request to one of its handlers, chosen at random
+// in proportion to their weights.
+type loadBalancer struct {
+	handlers []weightedHandler
+	total    int
+	// intn returns a random number in [0, n).
+	intn func(n int) int
+}
+
+func newLoadBalancer(handlers []weightedHandler) *loadBalancer {
+	l := &loadBalancer{handlers: handlers, intn: rand.Intn}
+	for _, h := range handlers {
+		l.total += h.weight
+	}
+	return l
+}
+
+// pick chooses the handler for a request.
+func (l *loadBalancer) pick() weightedHandler {
+	n := l.intn(l.total)
+	for _, h := range l.handlers {
+		if n < h.weight {
+			return h
+		}
+		n -= h.weight
+	}
+	// Unreachable, since n < total.
+	return l.handlers[len(l.handlers)-1]
+}
+
+func (l *loadBalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h := l.pick()
+	w.Header().Set(loadBalancedProviderHeader, h.name)
+	h.handler.ServeHTTP(w, req)
+}
@@ -0,0 +1,197 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestLoadBalancerDistribution(t *testing.T) {
+	counts := map[string]int{}
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { counts[name]++ })
+	}
+	l := newLoadBalancer([]weightedHandler{
+		{name: "openai", weight: 70, handler: handler("openai")},
+		{name: "unused", weight: 0, handler: handler("unused")},
+		{name: "azure", weight: 30, handler: handler("azure")},
+	})
+	l.intn = rand.New(rand.NewSource(1)).Intn
+
+	const n = 10000
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil))
+		if name := w.Header().Get(loadBalancedProviderHeader); name == "" {
+			t.Fatalf("expected the %s header to be set", loadBalancedProviderHeader)
+		}
+	}
+	for name, exp := range map[string]float64{"openai": 0.7, "azure": 0.3, "unused": 0} {
+		if got := float64(counts[name]) / n; math.Abs(got-exp) > 0.02 {
+			t.Errorf("expected %s to get %.0f%% of requests, got %.1f%%", name, exp*100, got*100)
+		}
+	}
+}
+
+func TestLoadBalanceRouting(t *testing.T) {
+	ctx := context.Background()
+	var openAIPath, azurePath, azureKey string
+	openAIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		openAIPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer openAIServer.Close()
+	azureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		azurePath = r.URL.Path
+		azureKey = r.Header.Get("api-key")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer azureServer.Close()
+
+	appSettings := backend.AppInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{
+			"openAI": {"provider": "openai"},
+			"loadBalance": [
+				{"weight": 1, "openAI": {"provider": "openai", "url": %q}},
+				{"name": "azure-eu", "weight": 1, "apiKeySecret": "azureKey", "openAI": {"provider": "azure", "url": %q, "azureModelMapping": [["gpt-4o", "gpt-4o-eu"]]}}
+			]
+		}`, openAIServer.URL, azureServer.URL)),
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234", "azureKey": "efgh5678"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	seen := map[string]bool{}
+	for i := 0; i < 50 && len(seen) < 2; i++ {
+		var r mockCallResourceResponseSender
+		err = app.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+			Method:        http.MethodPost,
+			Path:          "/openai/v1/chat/completions",
+			Headers:       map[string][]string{"Content-Type": {"application/json"}},
+			Body:          []byte(`{"model": "gpt-4o", "messages": []}`),
+		}, &r)
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+		if r.response.Status != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+		}
+		name := http.Header(r.response.Headers).Get(loadBalancedProviderHeader)
+		if name == "" {
+			t.Fatalf("expected the %s header to be set, got %v", loadBalancedProviderHeader, r.response.Headers)
+		}
+		seen[name] = true
+	}
+	if !seen["openai"] || !seen["azure-eu"] {
+		t.Fatalf("expected requests to be sent to both providers, got %v", seen)
+	}
+	if openAIPath != "/v1/chat/completions" {
+		t.Errorf("expected the OpenAI request path to be /v1/chat/completions, got %s", openAIPath)
+	}
+	if azurePath != "/openai/deployments/gpt-4o-eu/chat/completions" || azureKey != "efgh5678" {
+		t.Errorf("expected the Azure request to use the mapped deployment and its own key, got %s with key %q", azurePath, azureKey)
+	}
+}
+
+func TestLoadWeightedProvidersErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		providers []WeightedProvider
+	}{
+		{name: "unsupported provider", providers: []WeightedProvider{{Weight: 1, OpenAI: OpenAISettings{Provider: openAIProviderGrafana}}}},
+		{name: "negative weight", providers: []WeightedProvider{{Weight: -1, OpenAI: OpenAISettings{Provider: openAIProviderOpenAI}}}},
+		{name: "no weight", providers: []WeightedProvider{{OpenAI: OpenAISettings{Provider: openAIProviderOpenAI}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := loadWeightedProviders(tc.providers, nil); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestModelPrefixRouting(t *testing.T) {
+	ctx := context.Background()
+	received := map[string]string{}
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Model string `json:"model"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			received[name] = body.Model
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+	openAIServer := newServer("openai")
+	defer openAIServer.Close()
+	cohereServer := newServer("cohere")
+	defer cohereServer.Close()
+
+	appSettings := backend.AppInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{
+			"openAI": {"provider": "openai"},
+			"loadBalance": [
+				{"weight": 1, "openAI": {"provider": "openai", "url": %q}},
+				{"weight": 0, "openAI": {"provider": "cohere", "url": %q}}
+			]
+		}`, openAIServer.URL, cohereServer.URL)),
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for _, tc := range []struct {
+		name        string
+		model       string
+		expProvider string
+		expModel    string
+	}{
+		{name: "openai prefix", model: "openai/gpt-4", expProvider: "openai", expModel: "gpt-4"},
+		// Providers with no weight are still reachable by prefix.
+		{name: "cohere prefix", model: "cohere/command-r", expProvider: "cohere", expModel: "command-r"},
+		{name: "no prefix", model: "gpt-4", expProvider: "openai", expModel: "gpt-4"},
+		{name: "unknown prefix", model: "meta-llama/llama-3", expProvider: "openai", expModel: "meta-llama/llama-3"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clear(received)
+			var r mockCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Headers:       map[string][]string{"Content-Type": {"application/json"}},
+				Body:          []byte(fmt.Sprintf(`{"model": %q, "messages": []}`, tc.model)),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			if got := http.Header(r.response.Headers).Get(loadBalancedProviderHeader); got != tc.expProvider {
+				t.Errorf("expected the request to be handled by %s, got %s", tc.expProvider, got)
+			}
+			if len(received) != 1 || received[tc.expProvider] != tc.expModel {
+				t.Errorf("expected %s to receive model %s, got %v", tc.expProvider, tc.expModel, received)
+			}
+			if got := http.Header(r.response.Headers).Get(resolvedModelHeader); got != tc.expModel {
+				t.Errorf("expected the resolved model to be %s, got %s", tc.expModel, got)
+			}
+		})
+	}
+}
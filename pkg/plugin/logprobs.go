@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// logprobsFields are the request fields asking for token log probabilities.
+var logprobsFields = []string{"logprobs", "top_logprobs"}
+
+// stripLogprobs removes the log probability fields from a request body, for
+// providers which reject them. The body is only re-encoded if it had any.
+func stripLogprobs(body []byte) ([]byte, error) {
+	var requestBody map[string]json.RawMessage
+	if err := json.Unmarshal(body, &requestBody); err != nil {
+		return nil, fmt.Errorf("unmarshal request body: %w", err)
+	}
+	stripped := false
+	for _, field := range logprobsFields {
+		if _, ok := requestBody[field]; ok {
+			delete(requestBody, field)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return body, nil
+	}
+	return json.Marshal(requestBody)
+}
+
+// legacyLogprobs converts the `logprobs` of a chat completions choice into the
+// legacy completions format. The legacy `text_offset` field is left out, since
+// it can't be worked out for streamed chunks in isolation.
+func legacyLogprobs(logprobs interface{}) interface{} {
+	chat, ok := logprobs.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	content, _ := chat["content"].([]interface{})
+	tokens := make([]interface{}, 0, len(content))
+	tokenLogprobs := make([]interface{}, 0, len(content))
+	topLogprobs := make([]interface{}, 0, len(content))
+	for _, c := range content {
+		entry, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tokens = append(tokens, entry["token"])
+		tokenLogprobs = append(tokenLogprobs, entry["logprob"])
+		top := map[string]interface{}{}
+		alternatives, _ := entry["top_logprobs"].([]interface{})
+		for _, a := range alternatives {
+			if alt, ok := a.(map[string]interface{}); ok {
+				if token, ok := alt["token"].(string); ok {
+					top[token] = alt["logprob"]
+				}
+			}
+		}
+		topLogprobs = append(topLogprobs, top)
+	}
+	return map[string]interface{}{
+		"tokens":         tokens,
+		"token_logprobs": tokenLogprobs,
+		"top_logprobs":   topLogprobs,
+	}
+}
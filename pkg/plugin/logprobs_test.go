@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const logprobsResponse = `{"object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "Hi"}, "finish_reason": "stop", "logprobs": {"content": [{"token": "Hi", "logprob": -0.1, "top_logprobs": [{"token": "Hi", "logprob": -0.1}, {"token": "Hello", "logprob": -2.5}]}]}}]}`
+
+func TestLogprobsGating(t *testing.T) {
+	var upstreamBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody = nil
+		_ = json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(logprobsResponse))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name    string
+		disable bool
+	}{
+		{name: "capable provider"},
+		{name: "incapable provider", disable: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &directOpenAIProvider{settings: OpenAISettings{URL: server.URL, DisableLogprobs: tc.disable}}
+			proxy := newProviderProxy(provider, providerProxyOptions{})
+			req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "messages": [], "logprobs": true, "top_logprobs": 2}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+			for _, field := range logprobsFields {
+				if _, ok := upstreamBody[field]; ok == tc.disable {
+					t.Errorf("expected %s to be sent to the provider to be %t, got %v", field, !tc.disable, upstreamBody)
+				}
+			}
+			if w.Body.String() != logprobsResponse {
+				t.Errorf("expected the response to be passed through unchanged, got %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestLogprobsStreamRequest(t *testing.T) {
+	app := &App{settings: &Settings{OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: "http://localhost", DisableLogprobs: true}}}
+	app.provider = &directOpenAIProvider{settings: app.settings.OpenAI}
+	req, err := app.newOpenAIChatCompletionsRequest(context.Background(), map[string]interface{}{"model": "gpt-4o", "logprobs": true, "top_logprobs": 2})
+	if err != nil {
+		t.Fatalf("new request: %s", err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != `{"model":"gpt-4o"}` {
+		t.Errorf("expected logprobs fields to be stripped, got %s", body)
+	}
+}
+
+func TestLogprobsLegacyCompletions(t *testing.T) {
+	var upstreamBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(logprobsResponse))
+	}))
+	defer server.Close()
+
+	provider := &directOpenAIProvider{settings: OpenAISettings{URL: server.URL}}
+	proxy := newProviderProxy(provider, providerProxyOptions{translateCompletions: true})
+	req := httptest.NewRequest(http.MethodPost, "/openai/v1/completions", strings.NewReader(`{"model": "gpt-4o", "prompt": "Say hi", "logprobs": 2}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	if upstreamBody["logprobs"] != true || upstreamBody["top_logprobs"] != float64(2) {
+		t.Errorf("expected logprobs to be requested from the chat API, got %v", upstreamBody)
+	}
+	var resp struct {
+		Choices []struct {
+			Logprobs json.RawMessage `json:"logprobs"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal response: %s", err)
+	}
+	exp := `{"token_logprobs":[-0.1],"tokens":["Hi"],"top_logprobs":[{"Hello":-2.5,"Hi":-0.1}]}`
+	if len(resp.Choices) != 1 || string(resp.Choices[0].Logprobs) != exp {
+		t.Errorf("expected logprobs %s, got %s", exp, w.Body)
+	}
+}
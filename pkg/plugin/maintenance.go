@@ -0,0 +1,222 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaintenanceMessage is returned during maintenance windows without a
+// message of their own.
+const defaultMaintenanceMessage = "The LLM provider is down for scheduled maintenance. Please try again later."
+
+// MaintenanceWindow is a period during which requests to a provider are
+// answered with HTTP 503 and a maintenance message, without being sent. A
+// window either happens once, from one date and time to another, or recurs
+// between two times of day.
+type MaintenanceWindow struct {
+	// Provider limits the window to one provider: the provider type, such as
+	// `openai`, or the name of a load balanced provider. Defaults to all
+	// providers.
+	Provider string `json:"provider"`
+	// Start and End bound the window. For one-off windows they are dates and
+	// times such as `2024-06-01T02:00`, or RFC 3339 times with their own
+	// offset. For recurring windows they are times of day such as `02:00`;
+	// a window ending before it starts ends the next day.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Days limits a recurring window to the days of the week it starts on,
+	// such as `sat` or `sunday`. Defaults to every day.
+	Days []string `json:"days"`
+	// TimeZone is the IANA time zone of Start and End, such as
+	// `Europe/London`. Defaults to UTC.
+	TimeZone string `json:"timeZone"`
+	// Message is returned to clients during the window. Defaults to
+	// defaultMaintenanceMessage.
+	Message string `json:"message"`
+}
+
+// maintenanceWindow is a validated MaintenanceWindow.
+type maintenanceWindow struct {
+	provider string
+	message  string
+
+	// start and end bound one-off windows.
+	start, end time.Time
+
+	// recurring windows start at startOfDay after midnight on days, in loc,
+	// and last for duration.
+	recurring  bool
+	loc        *time.Location
+	startOfDay time.Duration
+	duration   time.Duration
+	days       map[time.Weekday]bool
+}
+
+// parseTimeOfDay parses a time of day such as 02:00 into the time after
+// midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseWeekday parses a day of the week, such as sat or Saturday.
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(s)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if len(s) >= 3 && strings.HasPrefix(name, s) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", s)
+}
+
+// parseMaintenanceTime parses a one-off window's start or end.
+func parseMaintenanceTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04", s, loc)
+}
+
+func newMaintenanceWindow(w MaintenanceWindow) (*maintenanceWindow, error) {
+	loc := time.UTC
+	if w.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(w.TimeZone); err != nil {
+			return nil, fmt.Errorf("time zone: %w", err)
+		}
+	}
+	mw := &maintenanceWindow{provider: w.Provider, message: w.Message, loc: loc}
+	if mw.message == "" {
+		mw.message = defaultMaintenanceMessage
+	}
+	if startOfDay, err := parseTimeOfDay(w.Start); err == nil {
+		endOfDay, err := parseTimeOfDay(w.End)
+		if err != nil {
+			return nil, fmt.Errorf("end %q is not a time of day like the start", w.End)
+		}
+		mw.recurring, mw.startOfDay, mw.duration = true, startOfDay, endOfDay-startOfDay
+		if mw.duration <= 0 {
+			mw.duration += 24 * time.Hour
+		}
+		if len(w.Days) > 0 {
+			mw.days = map[time.Weekday]bool{}
+			for _, d := range w.Days {
+				day, err := parseWeekday(d)
+				if err != nil {
+					return nil, err
+				}
+				mw.days[day] = true
+			}
+		}
+		return mw, nil
+	}
+	if len(w.Days) > 0 {
+		return nil, errors.New("days are only allowed for recurring windows, with times of day")
+	}
+	var err error
+	if mw.start, err = parseMaintenanceTime(w.Start, loc); err != nil {
+		return nil, fmt.Errorf("start %q is neither a date and time nor a time of day", w.Start)
+	}
+	if mw.end, err = parseMaintenanceTime(w.End, loc); err != nil {
+		return nil, fmt.Errorf("end %q is not a date and time like the start", w.End)
+	}
+	if !mw.end.After(mw.start) {
+		return nil, errors.New("end is not after start")
+	}
+	return mw, nil
+}
+
+// until returns the end of the window if now is in it.
+func (w *maintenanceWindow) until(now time.Time) (time.Time, bool) {
+	if !w.recurring {
+		return w.end, !now.Before(w.start) && now.Before(w.end)
+	}
+	now = now.In(w.loc)
+	// The window may have started today or, if it is long or runs past
+	// midnight, on one of the days before.
+	for daysAgo := 0; daysAgo <= int(w.duration/(24*time.Hour))+1; daysAgo++ {
+		// Use wall clock times, which differ from the time since midnight
+		// on days the clocks change.
+		start := time.Date(now.Year(), now.Month(), now.Day()-daysAgo, 0, int(w.startOfDay/time.Minute), 0, 0, w.loc)
+		if w.days != nil && !w.days[start.Weekday()] {
+			continue
+		}
+		end := time.Date(start.Year(), start.Month(), start.Day(), 0, int((w.startOfDay+w.duration)/time.Minute), 0, 0, w.loc)
+		if !now.Before(start) && now.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// maintenance holds the configured maintenance windows.
+type maintenance struct {
+	windows []*maintenanceWindow
+	now     func() time.Time
+}
+
+// newMaintenance validates the windows, returning nil if there are none.
+func newMaintenance(windows []MaintenanceWindow) (*maintenance, error) {
+	if len(windows) == 0 {
+		return nil, nil
+	}
+	m := &maintenance{now: time.Now}
+	for i, w := range windows {
+		mw, err := newMaintenanceWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %d: %w", i, err)
+		}
+		m.windows = append(m.windows, mw)
+	}
+	return m, nil
+}
+
+// active returns the message of a window for one of providers which is in
+// progress, and how long until it ends. The empty provider matches windows
+// for all providers. A nil maintenance is never active.
+func (m *maintenance) active(providers ...string) (string, time.Duration, bool) {
+	if m == nil {
+		return "", 0, false
+	}
+	now := m.now()
+	for _, w := range m.windows {
+		for _, p := range providers {
+			if w.provider != p {
+				continue
+			}
+			if end, ok := w.until(now); ok {
+				return w.message, end.Sub(now), true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// middleware wraps a proxy handler for provider so that, during its
+// maintenance windows, requests are answered with HTTP 503, the window's
+// message and a Retry-After header of when the window ends. A nil
+// maintenance returns next unchanged.
+func (m *maintenance) middleware(provider string, next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		message, remaining, ok := m.active(provider)
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+		writeProxyError(w, req, errors.New(message), http.StatusServiceUnavailable, "maintenance")
+	})
+}
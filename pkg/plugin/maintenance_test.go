@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	ctx := context.Background()
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	for _, tc := range []struct {
+		name   string
+		window MaintenanceWindow
+
+		expStatus  int
+		expMessage string
+	}{
+		{
+			name: "active window",
+			window: MaintenanceWindow{
+				Start:   now.Add(-time.Hour).Format(time.RFC3339),
+				End:     now.Add(time.Hour).Format(time.RFC3339),
+				Message: "Upgrading the GPUs, back soon.",
+			},
+			expStatus:  http.StatusServiceUnavailable,
+			expMessage: "Upgrading the GPUs, back soon.",
+		},
+		{
+			name: "active window for the provider",
+			window: MaintenanceWindow{
+				Provider: "openai",
+				Start:    now.Add(-time.Hour).Format(time.RFC3339),
+				End:      now.Add(time.Hour).Format(time.RFC3339),
+			},
+			expStatus:  http.StatusServiceUnavailable,
+			expMessage: defaultMaintenanceMessage,
+		},
+		{
+			name: "active window for another provider",
+			window: MaintenanceWindow{
+				Provider: "azure",
+				Start:    now.Add(-time.Hour).Format(time.RFC3339),
+				End:      now.Add(time.Hour).Format(time.RFC3339),
+			},
+			expStatus: http.StatusOK,
+		},
+		{
+			name: "past window",
+			window: MaintenanceWindow{
+				Start: now.Add(-2 * time.Hour).Format(time.RFC3339),
+				End:   now.Add(-time.Hour).Format(time.RFC3339),
+			},
+			expStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			settings := Settings{
+				OpenAI:             OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+				MaintenanceWindows: []MaintenanceWindow{tc.window},
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(`{"model": "gpt-4", "messages": [{"role": "user", "content": "hi"}]}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if tc.expStatus == http.StatusOK {
+				if !called {
+					t.Error("expected the request to reach the provider")
+				}
+				return
+			}
+			if called {
+				t.Error("expected the request not to reach the provider")
+			}
+			if !strings.Contains(string(r.response.Body), tc.expMessage) {
+				t.Errorf("expected the message %q, got %s", tc.expMessage, r.response.Body)
+			}
+			retryAfter, err := strconv.Atoi(strings.Join(r.response.Headers["Retry-After"], ""))
+			if err != nil || retryAfter <= 3500 || retryAfter > 3600 {
+				t.Errorf("expected Retry-After to be about an hour, got %v", r.response.Headers["Retry-After"])
+			}
+		})
+	}
+}
+
+func TestRecurringMaintenanceWindow(t *testing.T) {
+	// Saturday nights from 23:00 to 01:00, New York time.
+	w, err := newMaintenanceWindow(MaintenanceWindow{
+		Start:    "23:00",
+		End:      "01:00",
+		Days:     []string{"sat"},
+		TimeZone: "America/New_York",
+	})
+	if err != nil {
+		t.Fatalf("new maintenance window: %s", err)
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+	for _, tc := range []struct {
+		now time.Time
+
+		expActive bool
+		expEnd    time.Time
+	}{
+		{now: time.Date(2024, 6, 1, 22, 59, 0, 0, ny)},
+		{now: time.Date(2024, 6, 1, 23, 30, 0, 0, ny), expActive: true, expEnd: time.Date(2024, 6, 2, 1, 0, 0, 0, ny)},
+		// After midnight, on Sunday.
+		{now: time.Date(2024, 6, 2, 0, 30, 0, 0, ny), expActive: true, expEnd: time.Date(2024, 6, 2, 1, 0, 0, 0, ny)},
+		{now: time.Date(2024, 6, 2, 1, 0, 0, 0, ny)},
+		// The same time of day on Friday.
+		{now: time.Date(2024, 5, 31, 23, 30, 0, 0, ny)},
+		// The same moment in UTC.
+		{now: time.Date(2024, 6, 2, 3, 30, 0, 0, time.UTC), expActive: true, expEnd: time.Date(2024, 6, 2, 1, 0, 0, 0, ny)},
+	} {
+		end, active := w.until(tc.now)
+		if active != tc.expActive || !end.Equal(tc.expEnd) {
+			t.Errorf("at %s: expected active %t until %s, got %t until %s", tc.now, tc.expActive, tc.expEnd, active, end)
+		}
+	}
+}
+
+func TestInvalidMaintenanceWindows(t *testing.T) {
+	for _, w := range []MaintenanceWindow{
+		{Start: "02:00", End: "2024-06-01T04:00"},
+		{Start: "2024-06-01T04:00", End: "2024-06-01T02:00"},
+		{Start: "2024-06-01T02:00", End: "2024-06-01T04:00", Days: []string{"sat"}},
+		{Start: "02:00", End: "04:00", Days: []string{"someday"}},
+		{Start: "02:00", End: "04:00", TimeZone: "Mars/Olympus_Mons"},
+	} {
+		if _, err := newMaintenance([]MaintenanceWindow{w}); err == nil {
+			t.Errorf("expected an error for %+v", w)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// errTooManyMessages is returned when a request has more messages than allowed.
+var errTooManyMessages = errors.New("too many messages")
+
+// MessageOverflow is what happens to requests with more than the maximum
+// number of messages.
+type MessageOverflow string
+
+const (
+	// MessageOverflowReject rejects the request. This is the default.
+	MessageOverflowReject MessageOverflow = "reject"
+	// MessageOverflowTruncate drops the oldest messages, keeping any leading
+	// system messages.
+	MessageOverflowTruncate MessageOverflow = "truncate"
+)
+
+// messageLimit limits the number of messages in chat completions requests.
+type messageLimit struct {
+	max      int
+	overflow MessageOverflow
+}
+
+func newMessageLimit(max int, overflow MessageOverflow) (*messageLimit, error) {
+	switch overflow {
+	case "":
+		overflow = MessageOverflowReject
+	case MessageOverflowReject, MessageOverflowTruncate:
+	default:
+		return nil, fmt.Errorf("unknown message overflow mode: %s", overflow)
+	}
+	return &messageLimit{max: max, overflow: overflow}, nil
+}
+
+// apply rejects or truncates the messages of a chat completions request body
+// if there are too many. A nil messageLimit allows any number.
+func (l *messageLimit) apply(body map[string]interface{}) error {
+	if l == nil {
+		return nil
+	}
+	messages, ok := body["messages"].([]interface{})
+	if !ok || len(messages) <= l.max {
+		return nil
+	}
+	if l.overflow == MessageOverflowReject {
+		return fmt.Errorf("%w: %d messages, the maximum is %d", errTooManyMessages, len(messages), l.max)
+	}
+	body["messages"] = truncateMessages(messages, l.max)
+	return nil
+}
+
+// truncateMessages returns the leading system messages followed by the most
+// recent other messages, up to max in total. The most recent message is always
+// kept, even if that means exceeding max. Tool results left without the
+// assistant message which requested them are dropped too, since providers
+// reject them.
+func truncateMessages(messages []interface{}, max int) []interface{} {
+	system := 0
+	for system < len(messages) && messageRole(messages[system]) == "system" {
+		system++
+	}
+	keep := max - system
+	if keep < 1 {
+		keep = 1
+	}
+	rest := messages[system:]
+	if len(rest) > keep {
+		rest = rest[len(rest)-keep:]
+	}
+	for len(rest) > 1 && messageRole(rest[0]) == "tool" {
+		rest = rest[1:]
+	}
+	return append(append([]interface{}{}, messages[:system]...), rest...)
+}
+
+func messageRole(message interface{}) string {
+	m, _ := message.(map[string]interface{})
+	role, _ := m["role"].(string)
+	return role
+}
+
+// requestTransformer applies the limit to chat completions requests, rejecting
+// those with too many messages with HTTP 400 unless truncating.
+func (l *messageLimit) requestTransformer(req *http.Request) error {
+	return rewriteJSONBody(req, func(body map[string]interface{}) error {
+		if err := l.apply(body); err != nil {
+			return &TransformError{StatusCode: http.StatusBadRequest, Err: err}
+		}
+		return nil
+	})
+}
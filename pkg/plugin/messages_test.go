@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestMaxMessages(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	msg := func(role, content string) map[string]interface{} {
+		return map[string]interface{}{"role": role, "content": content}
+	}
+	history := []map[string]interface{}{
+		msg("system", "be brief"),
+		msg("user", "1"),
+		msg("assistant", "2"),
+		msg("user", "3"),
+		msg("assistant", "4"),
+		msg("user", "5"),
+	}
+	withTool := []map[string]interface{}{
+		msg("system", "be brief"),
+		msg("user", "1"),
+		{"role": "assistant", "tool_calls": []interface{}{map[string]interface{}{"id": "call_1"}}},
+		{"role": "tool", "tool_call_id": "call_1", "content": "2"},
+		msg("user", "3"),
+	}
+
+	for _, tc := range []struct {
+		name     string
+		overflow MessageOverflow
+		messages []map[string]interface{}
+		status   int
+		exp      []map[string]interface{}
+	}{
+		{
+			name:     "under limit",
+			overflow: MessageOverflowReject,
+			messages: history[:3],
+			status:   http.StatusOK,
+			exp:      history[:3],
+		},
+		{
+			name:     "reject",
+			overflow: MessageOverflowReject,
+			messages: history,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "reject by default",
+			messages: history,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "truncate keeps system message",
+			overflow: MessageOverflowTruncate,
+			messages: history,
+			status:   http.StatusOK,
+			exp:      []map[string]interface{}{history[0], history[4], history[5]},
+		},
+		{
+			name:     "truncate drops orphaned tool results",
+			overflow: MessageOverflowTruncate,
+			messages: withTool,
+			status:   http.StatusOK,
+			exp:      []map[string]interface{}{withTool[0], withTool[4]},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstreamBody = nil
+			settings := Settings{
+				OpenAI:          OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+				MaxMessages:     3,
+				MessageOverflow: tc.overflow,
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			body, err := json.Marshal(map[string]interface{}{"model": "gpt-4", "messages": tc.messages})
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          body,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, r.response.Status, r.response.Body)
+			}
+			if tc.status != http.StatusOK {
+				if upstreamBody != nil {
+					t.Errorf("expected rejected request not to reach the provider")
+				}
+				return
+			}
+			var got struct {
+				Messages []map[string]interface{} `json:"messages"`
+			}
+			if err := json.Unmarshal(upstreamBody, &got); err != nil {
+				t.Fatalf("unmarshal upstream body %s: %s", upstreamBody, err)
+			}
+			// Round trip the expected messages so their types match.
+			var exp []map[string]interface{}
+			expJSON, _ := json.Marshal(tc.exp)
+			_ = json.Unmarshal(expJSON, &exp)
+			if !reflect.DeepEqual(got.Messages, exp) {
+				t.Errorf("expected messages %v, got %v", exp, got.Messages)
+			}
+		})
+	}
+}
+
+func TestInvalidMessageOverflow(t *testing.T) {
+	jsonData, _ := json.Marshal(Settings{MaxMessages: 3, MessageOverflow: "drop"})
+	_, err := NewApp(context.Background(), backend.AppInstanceSettings{JSONData: jsonData})
+	if err == nil {
+		t.Fatal("expected an error for an unknown message overflow mode")
+	}
+}
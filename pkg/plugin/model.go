@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"net/http"
+	"strings"
+)
+
+// forcedModelHeader is set on responses to requests whose model was replaced
+// by the ForceModel setting, so clients can tell which model actually answered.
+const forcedModelHeader = "X-LLM-Forced-Model"
+
+// resolvedModelHeader is set on responses to proxied completions requests to
+// the model sent to the provider, after any forcing or routing, since the
+// model in the response body may be the provider's canonical name instead.
+const resolvedModelHeader = "X-LLM-Resolved-Model"
+
+// forceModelRequestTransformer returns a RequestTransformer which replaces the
+// model of every chat completions request with model, whatever the client asked for.
+func forceModelRequestTransformer(model string) RequestTransformer {
+	return func(req *http.Request) error {
+		return rewriteJSONBody(req, func(body map[string]interface{}) error {
+			body["model"] = model
+			return nil
+		})
+	}
+}
+
+// forceModelResponseTransformer returns a ResponseTransformer which notes the
+// forced model in the response headers.
+func forceModelResponseTransformer(model string) ResponseTransformer {
+	return func(resp *http.Response) error {
+		resp.Header.Set(forcedModelHeader, model)
+		return nil
+	}
+}
+
+// lookupModel returns the value for model in a per-model table. A model
+// without its own entry uses that of the longest model it is a version of, so
+// gpt-4o-2024-08-06 uses gpt-4o's.
+func lookupModel[T any](table map[string]T, model string) (T, bool) {
+	if v, ok := table[model]; ok {
+		return v, true
+	}
+	var v T
+	found, longest := false, 0
+	for m, mv := range table {
+		if len(m) > longest && strings.HasPrefix(model, m+"-") {
+			v, found, longest = mv, true, len(m)
+		}
+	}
+	return v, found
+}
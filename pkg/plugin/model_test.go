@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestForceModel(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu             sync.Mutex
+		upstreamModels []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model  string `json:"model"`
+			Stream bool   `json:"stream"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		upstreamModels = append(upstreamModels, body.Model)
+		mu.Unlock()
+		if body.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: [DONE]\n\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI:     OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		ForceModel: "gpt-4o-mini",
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	var r mockCallResourceResponseSender
+	err = app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Method:        http.MethodPost,
+		Path:          "/openai/v1/chat/completions",
+		Body:          []byte(`{"model": "gpt-4", "messages": []}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.response.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+	}
+	if got := http.Header(r.response.Headers).Get(forcedModelHeader); got != "gpt-4o-mini" {
+		t.Errorf("expected %s header to be gpt-4o-mini, got %q", forcedModelHeader, got)
+	}
+	if got := http.Header(r.response.Headers).Get(resolvedModelHeader); got != "gpt-4o-mini" {
+		t.Errorf("expected %s header to be gpt-4o-mini, got %q", resolvedModelHeader, got)
+	}
+
+	s := mockStreamPacketSender{messages: []json.RawMessage{}}
+	err = app.RunStream(ctx, &backend.RunStreamRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Path:          openAIChatCompletionsPath + "/abcd1234",
+		Data:          []byte(`{"messages": []}`),
+	}, backend.NewStreamSender(&s))
+	if err != nil {
+		t.Fatalf("RunStream error: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(upstreamModels) != 2 {
+		t.Fatalf("expected 2 upstream requests, got %d", len(upstreamModels))
+	}
+	for i, m := range upstreamModels {
+		if m != "gpt-4o-mini" {
+			t.Errorf("expected upstream request %d to use gpt-4o-mini, got %q", i, m)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limit is a request rate limit.
+type Limit struct {
+	// RequestsPerMinute is the number of requests allowed per minute, or zero
+	// for no limit. Up to a minute's worth may be made in a burst.
+	RequestsPerMinute int `json:"requestsPerMinute"`
+}
+
+// RateLimitSettings limits the rate of chat completions requests made through
+// the plugin, before they reach the provider.
+type RateLimitSettings struct {
+	// Limit applies to all requests for models without a PerModel rule,
+	// together. Since each tenant has its own plugin instance, this is a per
+	// tenant limit.
+	Limit
+	// PerModel gives models their own limits, replacing the default one. A
+	// rule with no limit exempts a model from rate limiting entirely.
+	PerModel map[string]Limit `json:"perModel"`
+}
+
+// enabled reports whether any limit is configured.
+func (s RateLimitSettings) enabled() bool {
+	if s.RequestsPerMinute > 0 {
+		return true
+	}
+	for _, l := range s.PerModel {
+		if l.RequestsPerMinute > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenBucket allows bursts of up to capacity requests, refilling at rate
+// requests per second.
+type tokenBucket struct {
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+// take takes a token from the bucket if there is one, returning how long
+// until there will be if not.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// modelRateLimiter enforces RateLimitSettings, with a token bucket for each
+// model with its own limit and one shared by all other models.
+type modelRateLimiter struct {
+	settings RateLimitSettings
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newModelRateLimiter(s RateLimitSettings) *modelRateLimiter {
+	return &modelRateLimiter{
+		settings: s,
+		buckets:  map[string]*tokenBucket{},
+		now:      time.Now,
+	}
+}
+
+// check takes a request for model from its bucket, returning an error if the
+// limit has been reached. A nil limiter allows everything.
+func (l *modelRateLimiter) check(model string) error {
+	if l == nil {
+		return nil
+	}
+	limit, key := l.settings.Limit, ""
+	if modelLimit, ok := l.settings.PerModel[model]; ok {
+		limit, key = modelLimit, "model:"+model
+	}
+	if limit.RequestsPerMinute <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		capacity := float64(limit.RequestsPerMinute)
+		b = &tokenBucket{capacity: capacity, rate: capacity / 60, tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	if ok, wait := b.take(now); !ok {
+		if key == "" {
+			return fmt.Errorf("rate limit of %d requests per minute reached, try again in %s", limit.RequestsPerMinute, wait.Round(time.Second))
+		}
+		return fmt.Errorf("rate limit of %d requests per minute for model %s reached, try again in %s", limit.RequestsPerMinute, model, wait.Round(time.Second))
+	}
+	return nil
+}
+
+// requestTransformer rejects requests over their model's rate limit with a 429.
+func (l *modelRateLimiter) requestTransformer(req *http.Request) error {
+	return rewriteJSONBody(req, func(body map[string]interface{}) error {
+		model, _ := body["model"].(string)
+		if err := l.check(model); err != nil {
+			return &TransformError{StatusCode: http.StatusTooManyRequests, Err: err}
+		}
+		return nil
+	})
+}
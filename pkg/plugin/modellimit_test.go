@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestModelRateLimits(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		RateLimit: RateLimitSettings{
+			Limit: Limit{RequestsPerMinute: 2},
+			PerModel: map[string]Limit{
+				"gpt-4":       {RequestsPerMinute: 1},
+				"gpt-4o-mini": {},
+			},
+		},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	call := func(model string, expStatus int) {
+		t.Helper()
+		var r mockCallResourceResponseSender
+		err := app.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+			Method:        http.MethodPost,
+			Path:          "/openai/v1/chat/completions",
+			Body:          []byte(fmt.Sprintf(`{"model": %q, "messages": []}`, model)),
+		}, &r)
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+		if r.response.Status != expStatus {
+			t.Fatalf("%s: expected status %d, got %d: %s", model, expStatus, r.response.Status, r.response.Body)
+		}
+	}
+
+	// gpt-4 has its own, tighter limit.
+	call("gpt-4", http.StatusOK)
+	call("gpt-4", http.StatusTooManyRequests)
+	// gpt-4o-mini is unlimited.
+	for i := 0; i < 5; i++ {
+		call("gpt-4o-mini", http.StatusOK)
+	}
+	// Other models share the default limit, which gpt-4 didn't use.
+	call("gpt-3.5-turbo", http.StatusOK)
+	call("gpt-4-turbo", http.StatusOK)
+	call("gpt-3.5-turbo", http.StatusTooManyRequests)
+}
+
+func TestModelRateLimiterRefill(t *testing.T) {
+	l := newModelRateLimiter(RateLimitSettings{PerModel: map[string]Limit{"gpt-4": {RequestsPerMinute: 2}}})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if err := l.check("gpt-4"); err != nil {
+			t.Fatalf("request %d: %s", i, err)
+		}
+	}
+	if err := l.check("gpt-4"); err == nil {
+		t.Fatal("expected the third request to be limited")
+	}
+	// A request's worth of capacity comes back every 30 seconds.
+	now = now.Add(30 * time.Second)
+	if err := l.check("gpt-4"); err != nil {
+		t.Fatalf("expected a request to be allowed after 30s: %s", err)
+	}
+	if err := l.check("gpt-4"); err == nil {
+		t.Fatal("expected the next request to be limited")
+	}
+	// Models without a rule aren't limited when there's no default limit.
+	if err := l.check("gpt-4o"); err != nil {
+		t.Errorf("expected gpt-4o to be unlimited: %s", err)
+	}
+}
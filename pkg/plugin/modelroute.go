@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// modelRouter sends requests whose model is prefixed with the name of a load
+// balanced provider, such as `azure/gpt-4`, to that provider with the prefix
+// removed. Other requests, including those for models which merely contain a
+// slash, go to the fallback.
+type modelRouter struct {
+	providers map[string]http.Handler
+	fallback  http.Handler
+}
+
+// newModelRouter routes to the given providers by name. If several providers
+// share a name the first is used.
+func newModelRouter(handlers []weightedHandler, fallback http.Handler) *modelRouter {
+	r := &modelRouter{providers: make(map[string]http.Handler, len(handlers)), fallback: fallback}
+	for _, h := range handlers {
+		if _, ok := r.providers[h.name]; !ok {
+			r.providers[h.name] = h.handler
+		}
+	}
+	return r
+}
+
+// route returns the name and handler of the provider named by the model
+// prefix in body, along with the body rewritten without the prefix. ok is
+// false if the request should go to the fallback.
+func (r *modelRouter) route(body []byte) (name string, handler http.Handler, newBody []byte, ok bool) {
+	var requestBody map[string]interface{}
+	if err := json.Unmarshal(body, &requestBody); err != nil {
+		return "", nil, nil, false
+	}
+	model, _ := requestBody["model"].(string)
+	name, model, found := strings.Cut(model, "/")
+	if !found {
+		return "", nil, nil, false
+	}
+	handler, ok = r.providers[name]
+	if !ok {
+		return "", nil, nil, false
+	}
+	requestBody["model"] = model
+	captureOrderedFields(body).restore(requestBody)
+	newBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", nil, nil, false
+	}
+	return name, handler, newBody, true
+}
+
+func (r *modelRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Audio transcriptions bodies are forms, so their model can't be
+	// rewritten; they are always load balanced.
+	if req.Body == nil || req.Body == http.NoBody || isAudioTranscriptionsPath(req.URL.Path) {
+		r.fallback.ServeHTTP(w, req)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeProxyError(w, req, fmt.Errorf("read request body: %w", err), http.StatusBadRequest, "")
+		return
+	}
+	name, handler, newBody, ok := r.route(body)
+	if !ok {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		r.fallback.ServeHTTP(w, req)
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(newBody))
+	req.ContentLength = int64(len(newBody))
+	w.Header().Set(loadBalancedProviderHeader, name)
+	handler.ServeHTTP(w, req)
+}
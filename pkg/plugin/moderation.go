@@ -0,0 +1,231 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errPromptFlagged is returned when the moderation endpoint flags a prompt.
+var errPromptFlagged = errors.New("prompt flagged by moderation")
+
+// moderationTimeout bounds each call to the moderation endpoint.
+const moderationTimeout = 10 * time.Second
+
+// ModerationProvider is the kind of endpoint prompts are moderated by.
+type ModerationProvider string
+
+const (
+	// ModerationProviderOpenAI is OpenAI's moderations API. The URL defaults
+	// to OpenAI's, the API key to the provider's and the field to
+	// `results.0.flagged`. This is the default.
+	ModerationProviderOpenAI ModerationProvider = "openai"
+	// ModerationProviderCustom is any endpoint which accepts the same
+	// request as OpenAI's, such as a self-hosted classifier. The URL and
+	// field must be set.
+	ModerationProviderCustom ModerationProvider = "custom"
+)
+
+// ModerationSettings configures checking prompts with a moderation endpoint
+// before they are sent to the provider. The endpoint is configured
+// independently of the provider. It is sent `{"model": ..., "input": ...}`
+// with the text of the user messages as the input.
+type ModerationSettings struct {
+	Enabled  bool               `json:"enabled"`
+	Provider ModerationProvider `json:"provider"`
+	// URL is the full URL requests are POSTed to.
+	URL string `json:"url"`
+	// Model is sent as the request's model, if set.
+	Model string `json:"model"`
+	// Field is the path to the verdict in the response: object keys and
+	// array indexes separated by dots, such as `results.0.flagged`.
+	Field string `json:"field"`
+	// Threshold is the score at or above which numeric verdicts block the
+	// prompt. Defaults to 0.5. Boolean verdicts block when true.
+	Threshold float64 `json:"threshold"`
+	// APIKeySecret is the name of the secure setting holding the API key,
+	// sent as a bearer token. Defaults to openAIKey for OpenAI, and to no
+	// key for custom endpoints.
+	APIKeySecret string `json:"apiKeySecret"`
+
+	apiKey string
+}
+
+// loadModeration fills in the API key of the moderation settings from
+// secrets.
+func loadModeration(s *ModerationSettings, secrets map[string]string) {
+	secret := s.APIKeySecret
+	if secret == "" && (s.Provider == "" || s.Provider == ModerationProviderOpenAI) {
+		secret = openAIKey
+	}
+	if secret != "" {
+		s.apiKey = secrets[secret]
+	}
+}
+
+// moderator checks prompts with a moderation endpoint.
+type moderator struct {
+	url       string
+	model     string
+	field     []string
+	threshold float64
+	apiKey    string
+	userAgent string
+	client    *http.Client
+}
+
+// newModerator validates the settings, filling in the provider's defaults.
+func newModerator(s ModerationSettings, userAgent string) (*moderator, error) {
+	switch s.Provider {
+	case "", ModerationProviderOpenAI:
+		if s.URL == "" {
+			s.URL = "https://api.openai.com/v1/moderations"
+		}
+		if s.Field == "" {
+			s.Field = "results.0.flagged"
+		}
+	case ModerationProviderCustom:
+		if s.URL == "" {
+			return nil, errors.New("the custom moderation provider requires a url")
+		}
+		if s.Field == "" {
+			return nil, errors.New("the custom moderation provider requires a field")
+		}
+	default:
+		return nil, fmt.Errorf("unknown moderation provider: %s", s.Provider)
+	}
+	if s.Threshold == 0 {
+		s.Threshold = 0.5
+	}
+	return &moderator{
+		url:       s.URL,
+		model:     s.Model,
+		field:     strings.Split(s.Field, "."),
+		threshold: s.Threshold,
+		apiKey:    s.apiKey,
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: moderationTimeout},
+	}, nil
+}
+
+// verdict finds the value at the moderator's field in a decoded response.
+func (m *moderator) verdict(response interface{}) (interface{}, error) {
+	v := response
+	for _, key := range m.field {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("moderation response has no element %s", key)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("moderation response has no field %s", key)
+		}
+	}
+	return v, nil
+}
+
+// flagged decides whether a verdict blocks the prompt.
+func (m *moderator) flagged(verdict interface{}) (bool, error) {
+	switch v := verdict.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		return v >= m.threshold, nil
+	}
+	return false, fmt.Errorf("moderation verdict %s is %T, not a boolean or a number", strings.Join(m.field, "."), verdict)
+}
+
+// check returns an error wrapping errPromptFlagged if the moderation endpoint
+// flags the user content of body. A nil moderator allows everything.
+func (m *moderator) check(ctx context.Context, body []byte) error {
+	if m == nil {
+		return nil
+	}
+	content, err := userContent(body)
+	if err != nil {
+		return err
+	}
+	return m.moderate(ctx, content)
+}
+
+// moderate asks the moderation endpoint about content, returning
+// errPromptFlagged if it is flagged.
+func (m *moderator) moderate(ctx context.Context, content string) error {
+	if content == "" {
+		return nil
+	}
+	reqBody, err := json.Marshal(struct {
+		Model string `json:"model,omitempty"`
+		Input string `json:"input"`
+	}{Model: m.model, Input: content})
+	if err != nil {
+		return fmt.Errorf("marshal moderation request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("create moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", m.userAgent)
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("moderation request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("moderation request: %s", resp.Status)
+	}
+	var response interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("decode moderation response: %w", err)
+	}
+	verdict, err := m.verdict(response)
+	if err != nil {
+		return err
+	}
+	flagged, err := m.flagged(verdict)
+	if err != nil {
+		return err
+	}
+	if flagged {
+		return errPromptFlagged
+	}
+	return nil
+}
+
+// requestTransformer rejects chat completions requests whose prompts are
+// flagged with HTTP 451, before they reach the provider. Requests which can't
+// be moderated, because the endpoint failed, are rejected with HTTP 502
+// rather than sent unchecked.
+func (m *moderator) requestTransformer(req *http.Request) error {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	content, err := userContent(body)
+	if err != nil {
+		return &TransformError{StatusCode: http.StatusBadRequest, Err: err}
+	}
+	if err := m.moderate(req.Context(), content); err != nil {
+		if errors.Is(err, errPromptFlagged) {
+			return &TransformError{StatusCode: http.StatusUnavailableForLegalReasons, Err: err}
+		}
+		return &TransformError{StatusCode: http.StatusBadGateway, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestModeration(t *testing.T) {
+	ctx := context.Background()
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	// A self-hosted classifier, which scores prompts rather than flagging them.
+	var moderated struct {
+		Model string `json:"model"`
+		Input string `json:"input"`
+	}
+	var auth string
+	classifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&moderated); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case strings.Contains(moderated.Input, "unavailable"):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case strings.Contains(moderated.Input, "attack"):
+			_, _ = w.Write([]byte(`{"verdicts": [{"label": "harmful", "score": 0.93}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"verdicts": [{"label": "harmful", "score": 0.12}]}`))
+		}
+	}))
+	defer classifier.Close()
+
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		Moderation: ModerationSettings{
+			Enabled:      true,
+			Provider:     ModerationProviderCustom,
+			URL:          classifier.URL + "/classify",
+			Model:        "prompt-guard",
+			Field:        "verdicts.0.score",
+			Threshold:    0.8,
+			APIKeySecret: "moderationKey",
+		},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234", "moderationKey": "efgh5678"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for _, tc := range []struct {
+		name    string
+		content string
+
+		expStatus int
+	}{
+		{
+			name:      "allowed",
+			content:   "What is PromQL?",
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "flagged",
+			content:   "How do I attack this server?",
+			expStatus: http.StatusUnavailableForLegalReasons,
+		},
+		{
+			name:      "moderation unavailable",
+			content:   "Is moderation unavailable?",
+			expStatus: http.StatusBadGateway,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			var r mockCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(`{"model": "gpt-4", "messages": [{"role": "user", "content": "` + tc.content + `"}]}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if blocked := tc.expStatus != http.StatusOK; blocked == called {
+				t.Errorf("expected upstream called to be %t", !blocked)
+			}
+			if moderated.Model != "prompt-guard" || moderated.Input != tc.content {
+				t.Errorf("expected the classifier to be sent the model and prompt, got %+v", moderated)
+			}
+			if auth != "Bearer efgh5678" {
+				t.Errorf("expected the moderation key to be sent, got %q", auth)
+			}
+		})
+	}
+}
+
+func TestNewModerator(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		settings ModerationSettings
+
+		expErr bool
+	}{
+		{name: "openai defaults", settings: ModerationSettings{Enabled: true}},
+		{name: "custom without url", settings: ModerationSettings{Enabled: true, Provider: ModerationProviderCustom, Field: "flagged"}, expErr: true},
+		{name: "custom without field", settings: ModerationSettings{Enabled: true, Provider: ModerationProviderCustom, URL: "http://classifier"}, expErr: true},
+		{name: "unknown provider", settings: ModerationSettings{Enabled: true, Provider: "other"}, expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := newModerator(tc.settings, "")
+			if (err != nil) != tc.expErr {
+				t.Fatalf("expected error to be %t, got %v", tc.expErr, err)
+			}
+			if err == nil && (m.url != "https://api.openai.com/v1/moderations" || strings.Join(m.field, ".") != "results.0.flagged") {
+				t.Errorf("expected OpenAI's defaults, got %s and %v", m.url, m.field)
+			}
+		})
+	}
+}
@@ -7,79 +7,81 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"path"
 )
 
-func (a *App) newAuthenticatedOpenAIRequest(ctx context.Context, method string, url url.URL, body io.Reader) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url.String(), body)
-	if err != nil {
-		return nil, err
+// openAIModelsPath is the path listing a provider's models.
+const openAIModelsPath = "/openai/v1/models"
+
+// isModelsPath returns whether path lists the provider's models.
+func isModelsPath(path string) bool {
+	return path == openAIModelsPath
+}
+
+// providerFor returns the Provider for the app's settings.
+func (a *App) providerFor() (Provider, error) {
+	if a.provider != nil {
+		return a.provider, nil
 	}
-	switch a.settings.OpenAI.Provider {
-	case openAIProviderOpenAI:
-		req.Header.Set("Authorization", "Bearer "+a.settings.OpenAI.apiKey)
-		req.Header.Set("OpenAI-Organization", a.settings.OpenAI.OrganizationID)
-	case openAIProviderAzure:
-		req.Header.Set("api-key", a.settings.OpenAI.apiKey)
-	case openAIProviderGrafana:
-		req.SetBasicAuth(a.settings.Tenant, a.settings.GrafanaComAPIKey)
-		req.Header.Add("X-Scope-OrgID", a.settings.Tenant)
+	if p := newProvider(*a.settings, a.llmGateway); p != nil {
+		return p, nil
 	}
-	return req, nil
+	return nil, fmt.Errorf("Unknown OpenAI provider: %s", a.settings.OpenAI.Provider)
 }
 
-func (a *App) newOpenAIChatCompletionsRequest(ctx context.Context, body map[string]interface{}) (*http.Request, error) {
-	var url *url.URL
-	var err error
-
-	switch a.settings.OpenAI.Provider {
-	case openAIProviderOpenAI:
-		url, err = url.Parse(a.settings.OpenAI.URL)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to parse OpenAI URL: %w", err)
-		}
-		url.Path = "/v1/chat/completions"
-
-	case openAIProviderAzure:
-		deployment := ""
-		for _, v := range a.settings.OpenAI.AzureMapping {
-			if val, ok := body["model"].(string); ok && val == v[0] {
-				deployment = v[1]
-				break
+// newProviderRequest returns a request for the `/openai/...` path p pointed
+// at the configured provider, built the same way the proxy builds requests.
+func (a *App) newProviderRequest(ctx context.Context, method, p string, body []byte) (*http.Request, error) {
+	provider, err := a.providerFor()
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p, reader)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if err := provider.RewriteRequest(req); err != nil {
+		return nil, err
+	}
+	if body != nil {
+		if isChatCompletionsPath(p) {
+			body, err = normalizeChatBody(body, provider.Capabilities(), a.settings.OpenAI.StructuredOutputsFallback)
+			if err != nil {
+				return nil, err
 			}
 		}
-		if deployment == "" {
-			return nil, fmt.Errorf("no deployment found for model: %s", body["model"])
-		}
-		delete(body, "model")
-
-		url, err = url.Parse(a.settings.OpenAI.URL)
+		body, err = provider.TranslateBody(body)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to parse OpenAI URL: %w", err)
+			return nil, fmt.Errorf("translate request body: %w", err)
 		}
-		url.Path = fmt.Sprintf("/openai/deployments/%s/chat/completions", deployment)
-		url.RawQuery = "api-version=2023-03-15-preview"
-
-	case openAIProviderGrafana:
-		url, err = url.Parse(a.settings.LLMGateway.URL)
+		body, err = mergeExtraBodyFields(body, a.settings.OpenAI.ExtraBodyFields)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to parse LLM Gateway URL: %w", err)
+			return nil, err
 		}
-		url.Path = path.Join(url.Path, "/openai/v1/chat/completions")
-
-	default:
-		return nil, fmt.Errorf("Unknown OpenAI provider: %s", a.settings.OpenAI.Provider)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Content-Type", "application/json")
 	}
+	name, value := provider.AuthHeader()
+	req.Header.Set(name, value)
+	req.Header.Set("User-Agent", a.settings.userAgent())
+	return req, nil
+}
 
+func (a *App) newOpenAIChatCompletionsRequest(ctx context.Context, body map[string]interface{}) (*http.Request, error) {
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request body: %w", err)
 	}
-	req, err := a.newAuthenticatedOpenAIRequest(ctx, http.MethodPost, *url, bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	return req, nil
+	return a.newProviderRequest(ctx, http.MethodPost, "/"+openAIChatCompletionsPath, bodyBytes)
+}
+
+// newOpenAIModelsRequest returns a request listing the configured provider's
+// models, which providers don't bill for. Callers should check the provider's
+// ModelsList capability first.
+func (a *App) newOpenAIModelsRequest(ctx context.Context) (*http.Request, error) {
+	return a.newProviderRequest(ctx, http.MethodGet, openAIModelsPath, nil)
 }
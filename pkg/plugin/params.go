@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// applyDefaultParams sets each of defaults in body, unless body already has a
+// value for it.
+func applyDefaultParams(body map[string]interface{}, defaults map[string]interface{}) {
+	for k, v := range defaults {
+		if _, ok := body[k]; !ok {
+			body[k] = v
+		}
+	}
+}
+
+// defaultParamsRequestTransformer returns a RequestTransformer which applies
+// defaults to chat completions requests. Values given by the client, even
+// null, always take precedence.
+func defaultParamsRequestTransformer(defaults map[string]interface{}) RequestTransformer {
+	return func(req *http.Request) error {
+		return rewriteJSONBody(req, func(body map[string]interface{}) error {
+			applyDefaultParams(body, defaults)
+			return nil
+		})
+	}
+}
+
+// mergeExtraBodyFields adds extra to a JSON object request body, leaving any
+// fields already present untouched. Bodies which aren't JSON objects are
+// returned unchanged.
+func mergeExtraBodyFields(body []byte, extra map[string]interface{}) ([]byte, error) {
+	if len(extra) == 0 {
+		return body, nil
+	}
+	var requestBody map[string]interface{}
+	if err := json.Unmarshal(body, &requestBody); err != nil {
+		return body, nil
+	}
+	ordered := captureOrderedFields(body)
+	applyDefaultParams(requestBody, extra)
+	ordered.restore(requestBody)
+	newBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return newBody, nil
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestDefaultParams(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI:        OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		DefaultParams: map[string]interface{}{"temperature": 0.2, "max_tokens": 100},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for _, tc := range []struct {
+		name string
+		body string
+		exp  map[string]interface{}
+	}{
+		{
+			name: "defaults applied",
+			body: `{"model": "gpt-4", "messages": []}`,
+			exp:  map[string]interface{}{"model": "gpt-4", "messages": []interface{}{}, "temperature": 0.2, "max_tokens": 100.0},
+		},
+		{
+			name: "client values kept",
+			body: `{"model": "gpt-4", "messages": [], "temperature": 1, "max_tokens": null}`,
+			exp:  map[string]interface{}{"model": "gpt-4", "messages": []interface{}{}, "temperature": 1.0, "max_tokens": nil},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r mockCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(tc.body),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(upstreamBody, &got); err != nil {
+				t.Fatalf("unmarshal upstream body %s: %s", upstreamBody, err)
+			}
+			if !reflect.DeepEqual(got, tc.exp) {
+				t.Errorf("expected upstream body %v, got %v", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestExtraBodyFields(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name     string
+		provider openAIProvider
+		body     string
+		exp      map[string]interface{}
+	}{
+		{
+			name:     "merged",
+			provider: openAIProviderOpenAI,
+			body:     `{"model": "gpt-4", "messages": [], "temperature": 0.5}`,
+			exp: map[string]interface{}{
+				"model": "gpt-4", "messages": []interface{}{}, "temperature": 0.5,
+				"weights": map[string]interface{}{"cost": 0.3, "quality": 0.7},
+			},
+		},
+		{
+			name:     "client values kept",
+			provider: openAIProviderOpenAI,
+			body:     `{"model": "gpt-4", "messages": [], "weights": {"cost": 1}}`,
+			exp: map[string]interface{}{
+				"model": "gpt-4", "messages": []interface{}{}, "temperature": 0.5,
+				"weights": map[string]interface{}{"cost": 1.0},
+			},
+		},
+		{
+			// Fields are added after translation, so provider-specific fields
+			// aren't dropped by it.
+			name:     "after translation",
+			provider: openAIProviderCohere,
+			body:     `{"model": "command-r-plus", "messages": []}`,
+			exp: map[string]interface{}{
+				"model": "command-r-plus", "messages": []interface{}{}, "temperature": 0.5,
+				"weights": map[string]interface{}{"cost": 0.3, "quality": 0.7},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := Settings{
+				OpenAI: OpenAISettings{
+					Provider: tc.provider,
+					URL:      server.URL,
+					ExtraBodyFields: map[string]interface{}{
+						// A standard parameter, which clients can still override.
+						"temperature": 0.5,
+						"weights":     map[string]interface{}{"cost": 0.3, "quality": 0.7},
+					},
+				},
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(tc.body),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(upstreamBody, &got); err != nil {
+				t.Fatalf("unmarshal upstream body %s: %s", upstreamBody, err)
+			}
+			if !reflect.DeepEqual(got, tc.exp) {
+				t.Errorf("expected upstream body %v, got %v", tc.exp, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// priorityHeader sets the priority of a proxied request, either `high` (the
+// default) or `low`. Background work such as indexing should use `low`, so
+// that it doesn't hold up interactive requests when the concurrency limit is
+// reached.
+const priorityHeader = "X-LLM-Priority"
+
+type priority int
+
+const (
+	priorityHigh priority = iota
+	priorityLow
+)
+
+// parsePriority parses the value of a priorityHeader.
+func parsePriority(v string) (priority, error) {
+	switch v {
+	case "", "high":
+		return priorityHigh, nil
+	case "low":
+		return priorityLow, nil
+	}
+	return 0, fmt.Errorf("invalid %s header %q, must be high or low", priorityHeader, v)
+}
+
+// priorityLimiter limits the number of concurrent requests to the provider.
+// Requests over the limit wait in a queue for their priority; whenever a slot
+// is freed it goes to the longest waiting high priority request, and only to
+// a low priority request if no high priority ones are waiting.
+type priorityLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiting [2][]chan struct{}
+}
+
+// newPriorityLimiter returns a limiter allowing limit concurrent requests, or
+// nil for no limit.
+func newPriorityLimiter(limit int) *priorityLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &priorityLimiter{limit: limit}
+}
+
+// acquire waits for a slot for a request of priority p, returning an error if
+// ctx is done first. Low priority requests don't take a free slot while high
+// priority ones are waiting. A nil limiter never waits.
+func (l *priorityLimiter) acquire(ctx context.Context, p priority) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.active < l.limit && len(l.waiting[priorityHigh]) == 0 && (p == priorityHigh || len(l.waiting[priorityLow]) == 0) {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiting[p] = append(l.waiting[p], ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-ready:
+			// We were given a slot just as we gave up, so pass it on.
+			l.active--
+			l.grant()
+		default:
+			for i, ch := range l.waiting[p] {
+				if ch == ready {
+					l.waiting[p] = append(l.waiting[p][:i], l.waiting[p][i+1:]...)
+					break
+				}
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees the slot taken by a successful acquire.
+func (l *priorityLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.grant()
+}
+
+// grant hands free slots to waiting requests, high priority first. The caller
+// must hold l.mu.
+func (l *priorityLimiter) grant() {
+	for l.active < l.limit {
+		p := priorityHigh
+		if len(l.waiting[p]) == 0 {
+			p = priorityLow
+		}
+		if len(l.waiting[p]) == 0 {
+			return
+		}
+		close(l.waiting[p][0])
+		l.waiting[p] = l.waiting[p][1:]
+		l.active++
+	}
+}
+
+// middleware wraps a proxy handler so that requests wait for a slot, by the
+// priority in their priorityHeader, before being sent. A nil limiter returns
+// next unchanged.
+func (l *priorityLimiter) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, err := parsePriority(req.Header.Get(priorityHeader))
+		if err != nil {
+			writeProxyError(w, req, err, http.StatusBadRequest, "")
+			return
+		}
+		if err := l.acquire(req.Context(), p); err != nil {
+			writeProxyError(w, req, fmt.Errorf("waiting for a request slot: %w", err), http.StatusServiceUnavailable, "")
+			return
+		}
+		defer l.release()
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiting waits until n requests of priority p are queued on l.
+func waitForWaiting(t *testing.T, l *priorityLimiter, p priority, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		got := len(l.waiting[p])
+		l.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiting requests, got %d", n, got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPriorityLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := newPriorityLimiter(1)
+	if err := l.acquire(ctx, priorityLow); err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	run := func(name string, p priority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.acquire(ctx, p); err != nil {
+				t.Errorf("acquire %s: %s", name, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			l.release()
+		}()
+	}
+	// Queue the low priority requests before the high priority ones.
+	run("low-1", priorityLow)
+	waitForWaiting(t, l, priorityLow, 1)
+	run("low-2", priorityLow)
+	waitForWaiting(t, l, priorityLow, 2)
+	run("high-1", priorityHigh)
+	waitForWaiting(t, l, priorityHigh, 1)
+	run("high-2", priorityHigh)
+	waitForWaiting(t, l, priorityHigh, 2)
+
+	l.release()
+	wg.Wait()
+	exp := []string{"high-1", "high-2", "low-1", "low-2"}
+	if !reflect.DeepEqual(order, exp) {
+		t.Errorf("expected requests to proceed in order %v, got %v", exp, order)
+	}
+}
+
+func TestPriorityLimiterCancel(t *testing.T) {
+	l := newPriorityLimiter(1)
+	if err := l.acquire(context.Background(), priorityHigh); err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx, priorityHigh); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+	waitForWaiting(t, l, priorityHigh, 0)
+
+	// The slot is still usable once released.
+	l.release()
+	if err := l.acquire(context.Background(), priorityLow); err != nil {
+		t.Fatalf("acquire after release: %s", err)
+	}
+}
+
+func TestPriorityLimiterMiddleware(t *testing.T) {
+	l := newPriorityLimiter(1)
+	handler := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.active != 1 {
+			t.Errorf("expected the request to hold a slot, got %d active", l.active)
+		}
+	}))
+	for _, tc := range []struct {
+		priority  string
+		expStatus int
+	}{
+		{priority: "", expStatus: http.StatusOK},
+		{priority: "low", expStatus: http.StatusOK},
+		{priority: "urgent", expStatus: http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil)
+		req.Header.Set(priorityHeader, tc.priority)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.expStatus {
+			t.Errorf("priority %q: expected status %d, got %d: %s", tc.priority, tc.expStatus, w.Code, w.Body)
+		}
+		if l.active != 0 {
+			t.Errorf("priority %q: expected the slot to be released, got %d active", tc.priority, l.active)
+		}
+	}
+}
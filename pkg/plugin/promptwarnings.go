@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// promptWarningsHeader is set on responses to proxied completions requests
+// which triggered prompt warnings, to a JSON array of the warnings.
+const promptWarningsHeader = "X-LLM-Warnings"
+
+// PromptCheck is a check made by a prompt warning rule.
+type PromptCheck string
+
+const (
+	// PromptCheckNoSystemMessage warns about chat completions requests
+	// without a system message.
+	PromptCheckNoSystemMessage PromptCheck = "no-system-message"
+	// PromptCheckTemperatureAbove warns about requests with a temperature
+	// above the rule's threshold.
+	PromptCheckTemperatureAbove PromptCheck = "temperature-above"
+	// PromptCheckMaxTokensAbove warns about requests allowing more than the
+	// rule's threshold of completion tokens.
+	PromptCheckMaxTokensAbove PromptCheck = "max-tokens-above"
+)
+
+// PromptWarningSettings configures warnings about completions requests which
+// are allowed, but probably not what the client wanted. Warnings never block
+// requests.
+type PromptWarningSettings struct {
+	Enabled bool `json:"enabled"`
+	// Rules are the checks made. Defaults to warning about requests without
+	// a system message and those with a temperature above 1.5.
+	Rules []PromptWarningRule `json:"rules"`
+}
+
+// PromptWarningRule is a check made on each completions request.
+type PromptWarningRule struct {
+	Check PromptCheck `json:"check"`
+	// Threshold is the limit for checks which compare a value against one.
+	Threshold float64 `json:"threshold"`
+	// Message replaces the check's default message, if set.
+	Message string `json:"message"`
+}
+
+var defaultPromptWarningRules = []PromptWarningRule{
+	{Check: PromptCheckNoSystemMessage},
+	{Check: PromptCheckTemperatureAbove, Threshold: 1.5},
+}
+
+// promptWarning is a warning about a request, as sent to clients.
+type promptWarning struct {
+	Code    PromptCheck `json:"code"`
+	Message string      `json:"message"`
+}
+
+// promptWarnings checks completions requests against its rules.
+type promptWarnings struct {
+	rules []PromptWarningRule
+}
+
+// newPromptWarnings returns the configured checks, or nil if they're
+// disabled.
+func newPromptWarnings(s PromptWarningSettings) (*promptWarnings, error) {
+	if !s.Enabled {
+		return nil, nil
+	}
+	rules := s.Rules
+	if len(rules) == 0 {
+		rules = defaultPromptWarningRules
+	}
+	for i, r := range rules {
+		switch r.Check {
+		case PromptCheckNoSystemMessage, PromptCheckTemperatureAbove, PromptCheckMaxTokensAbove:
+		default:
+			return nil, fmt.Errorf("prompt warning rule %d: unknown check %q", i, r.Check)
+		}
+	}
+	return &promptWarnings{rules: rules}, nil
+}
+
+// check returns the warnings for a completions request body.
+func (p *promptWarnings) check(body map[string]interface{}) []promptWarning {
+	var warnings []promptWarning
+	for _, r := range p.rules {
+		var message string
+		switch r.Check {
+		case PromptCheckNoSystemMessage:
+			// Legacy completions requests have a prompt instead of messages.
+			messages, ok := body["messages"].([]interface{})
+			if ok && !hasSystemMessage(messages) {
+				message = "the request has no system message"
+			}
+		case PromptCheckTemperatureAbove:
+			if t, ok := body["temperature"].(float64); ok && t > r.Threshold {
+				message = fmt.Sprintf("temperature %g is above %g and may produce incoherent output", t, r.Threshold)
+			}
+		case PromptCheckMaxTokensAbove:
+			for _, field := range []string{"max_tokens", "max_completion_tokens"} {
+				if n, ok := body[field].(float64); ok && n > r.Threshold {
+					message = fmt.Sprintf("%s %g is above %g", field, n, r.Threshold)
+					break
+				}
+			}
+		}
+		if message == "" {
+			continue
+		}
+		if r.Message != "" {
+			message = r.Message
+		}
+		warnings = append(warnings, promptWarning{Code: r.Check, Message: message})
+	}
+	return warnings
+}
+
+func hasSystemMessage(messages []interface{}) bool {
+	for _, m := range messages {
+		if m, ok := m.(map[string]interface{}); ok && (m["role"] == "system" || m["role"] == "developer") {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware wraps a proxy handler so that responses to completions requests
+// carry any warnings about them in the X-LLM-Warnings header. Requests are
+// checked as the client sent them, before any settings change them. A nil
+// promptWarnings returns next unchanged.
+func (p *promptWarnings) middleware(next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isCompletionsPath(req.URL.Path) || req.Body == nil || req.Body == http.NoBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeProxyError(w, req, fmt.Errorf("read request body: %w", err), http.StatusBadRequest, "")
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		var requestBody map[string]interface{}
+		// Ignore errors; the provider will reject malformed requests.
+		_ = json.Unmarshal(body, &requestBody)
+		if warnings := p.check(requestBody); len(warnings) > 0 {
+			header, err := json.Marshal(warnings)
+			if err != nil {
+				log.DefaultLogger.Warn("Unable to marshal prompt warnings", "err", err)
+			} else {
+				w.Header().Set(promptWarningsHeader, string(header))
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestPromptWarnings(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name     string
+		settings PromptWarningSettings
+		body     string
+
+		exp []promptWarning
+	}{
+		{
+			name:     "high temperature",
+			settings: PromptWarningSettings{Enabled: true},
+			body:     `{"model": "gpt-4", "messages": [{"role": "system", "content": "Be brief."}, {"role": "user", "content": "Hi"}], "temperature": 1.8}`,
+			exp:      []promptWarning{{Code: PromptCheckTemperatureAbove, Message: "temperature 1.8 is above 1.5 and may produce incoherent output"}},
+		},
+		{
+			name:     "no system message",
+			settings: PromptWarningSettings{Enabled: true},
+			body:     `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hi"}], "temperature": 2}`,
+			exp: []promptWarning{
+				{Code: PromptCheckNoSystemMessage, Message: "the request has no system message"},
+				{Code: PromptCheckTemperatureAbove, Message: "temperature 2 is above 1.5 and may produce incoherent output"},
+			},
+		},
+		{
+			name:     "no warnings",
+			settings: PromptWarningSettings{Enabled: true},
+			body:     `{"model": "gpt-4", "messages": [{"role": "system", "content": "Be brief."}], "temperature": 0.5}`,
+		},
+		{
+			name: "configured rules",
+			settings: PromptWarningSettings{Enabled: true, Rules: []PromptWarningRule{
+				{Check: PromptCheckTemperatureAbove, Threshold: 1, Message: "Keep temperatures at 1 or below."},
+				{Check: PromptCheckMaxTokensAbove, Threshold: 1000},
+			}},
+			body: `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hi"}], "temperature": 1.2, "max_tokens": 4000}`,
+			exp: []promptWarning{
+				{Code: PromptCheckTemperatureAbove, Message: "Keep temperatures at 1 or below."},
+				{Code: PromptCheckMaxTokensAbove, Message: "max_tokens 4000 is above 1000"},
+			},
+		},
+		{
+			name:     "disabled",
+			settings: PromptWarningSettings{},
+			body:     `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hi"}], "temperature": 2}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, err := json.Marshal(Settings{
+				OpenAI:         OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+				PromptWarnings: tc.settings,
+			})
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(tc.body),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			header := r.response.Headers[http.CanonicalHeaderKey(promptWarningsHeader)]
+			if tc.exp == nil {
+				if len(header) > 0 {
+					t.Errorf("expected no warnings, got %v", header)
+				}
+				return
+			}
+			if len(header) != 1 {
+				t.Fatalf("expected the %s header, got %v", promptWarningsHeader, r.response.Headers)
+			}
+			var got []promptWarning
+			if err := json.Unmarshal([]byte(header[0]), &got); err != nil {
+				t.Fatalf("unmarshal warnings %s: %s", header[0], err)
+			}
+			if !reflect.DeepEqual(got, tc.exp) {
+				t.Errorf("expected warnings %+v, got %+v", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestPromptWarningsInvalidRule(t *testing.T) {
+	_, err := newPromptWarnings(PromptWarningSettings{Enabled: true, Rules: []PromptWarningRule{{Check: "too-polite"}}})
+	if err == nil {
+		t.Error("expected an error for an unknown check")
+	}
+}
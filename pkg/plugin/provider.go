@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Provider encapsulates everything the proxy needs to know about an
+// OpenAI-compatible provider. Adding a provider is a matter of implementing
+// this interface and returning it from newProvider.
+type Provider interface {
+	// RewriteRequest points an incoming `/openai/...` request at the provider,
+	// updating its URL and setting any provider-specific headers. The request
+	// body may be read but must be left unconsumed.
+	RewriteRequest(req *http.Request) error
+	// TranslateBody converts an OpenAI request body into the provider's format.
+	TranslateBody(body []byte) ([]byte, error)
+	// AuthHeader returns the name and value of the header used to authenticate
+	// requests to the provider.
+	AuthHeader() (string, string)
+	// HealthModels returns the models to test when checking the provider's health.
+	HealthModels() []string
+	// SupportsVision returns true if the provider accepts image inputs for model.
+	SupportsVision(model string) bool
+	// Capabilities describes the request shapes the provider accepts.
+	Capabilities() ProviderCapabilities
+}
+
+// newProvider returns the Provider implementation for the configured provider,
+// or nil if no (known) provider is configured.
+func newProvider(settings Settings, endpoints *llmGatewayEndpoints) Provider {
+	switch settings.OpenAI.Provider {
+	case openAIProviderOpenAI:
+		return &directOpenAIProvider{settings: settings.OpenAI}
+	case openAIProviderAzure:
+		return &azureProvider{settings: settings.OpenAI}
+	case openAIProviderGrafana:
+		return &grafanaProvider{settings: settings, endpoints: endpoints}
+	case openAIProviderCohere:
+		return &cohereProvider{settings: settings.OpenAI}
+	}
+	return nil
+}
+
+// responseTranslator is implemented by providers whose responses aren't in
+// OpenAI's format.
+type responseTranslator interface {
+	// TranslateResponse converts a response body into OpenAI's format.
+	TranslateResponse(body []byte) ([]byte, error)
+	// TranslateStream converts a streamed response body into OpenAI's
+	// server-sent events format.
+	TranslateStream(body io.ReadCloser) io.ReadCloser
+}
+
+// translateResponse converts a successful response from p into OpenAI's
+// format, if p's responses need translating. Lists of models are passed
+// through as the provider sent them.
+func translateResponse(p Provider, resp *http.Response) error {
+	t, ok := p.(responseTranslator)
+	if !ok || isModelsPath(requestedPath(resp)) || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	switch contentType := resp.Header.Get("Content-Type"); {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		resp.Body = t.TranslateStream(resp.Body)
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+	case strings.HasPrefix(contentType, "application/json"):
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read response body: %w", err)
+		}
+		body, err = t.TranslateResponse(body)
+		if err != nil {
+			return fmt.Errorf("translate response: %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
+
+// responseTranslatingTransport translates responses from a provider into
+// OpenAI's format, for clients which don't go through the proxy.
+type responseTranslatingTransport struct {
+	provider Provider
+	base     http.RoundTripper
+}
+
+func (t *responseTranslatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := translateResponse(t.provider, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// directOpenAIProvider talks directly to the OpenAI API.
+type directOpenAIProvider struct {
+	settings OpenAISettings
+}
+
+func (p *directOpenAIProvider) RewriteRequest(req *http.Request) error {
+	if err := modifyURL(p.settings.urlFor(req.URL.Path), req); err != nil {
+		return err
+	}
+	req.URL.Path = strings.TrimPrefix(req.URL.Path, "/openai")
+	req.Header.Set("OpenAI-Organization", p.settings.OrganizationID)
+	return nil
+}
+
+func (p *directOpenAIProvider) TranslateBody(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+func (p *directOpenAIProvider) AuthHeader() (string, string) {
+	return "Authorization", "Bearer " + p.settings.key()
+}
+
+func (p *directOpenAIProvider) ObserveRateLimits(resp *http.Response) {
+	p.settings.apiKeys.observe(strings.TrimPrefix(resp.Request.Header.Get("Authorization"), "Bearer "), resp)
+}
+
+func (p *directOpenAIProvider) HealthModels() []string {
+	return openAIModels
+}
+
+func (p *directOpenAIProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{StopFormat: StopFormatAny, LegacyCompletions: true, Logprobs: !p.settings.DisableLogprobs, AudioTranscriptions: true, ModelsList: true, StructuredOutputs: true, StreamUsage: true}
+}
+
+func (p *directOpenAIProvider) SupportsVision(model string) bool {
+	return isVisionModel(model)
+}
+
+// azureProvider talks to Azure OpenAI, which requires the model to be mapped
+// to a deployment in the URL rather than given in the body.
+type azureProvider struct {
+	settings OpenAISettings
+}
+
+// deployment returns the deployment configured for model, or an empty string.
+func (p *azureProvider) deployment(model string) string {
+	// Models are mapped to deployments in settings.AzureMapping.
+	for _, v := range p.settings.AzureMapping {
+		if model == v[0] {
+			return v[1]
+		}
+	}
+	return ""
+}
+
+// foundry reports whether requests go to an Azure AI Foundry endpoint.
+func (p *azureProvider) foundry() bool {
+	return p.settings.AzureEndpointStyle == AzureEndpointStyleFoundry
+}
+
+func (p *azureProvider) RewriteRequest(req *http.Request) error {
+	if err := modifyURL(p.settings.urlFor(req.URL.Path), req); err != nil {
+		return fmt.Errorf("modify url: %w", err)
+	}
+	q := req.URL.Query()
+	q.Set("api-version", p.settings.AzureAPIVersion)
+	req.URL.RawQuery = q.Encode()
+
+	// Models aren't listed per deployment.
+	if isModelsPath(req.URL.Path) {
+		req.URL.Path = "/openai/models"
+		return nil
+	}
+
+	// Foundry takes the deployment as the model in the body, which
+	// TranslateBody maps.
+	if p.foundry() {
+		req.URL.Path = "/models/" + strings.TrimPrefix(req.URL.Path, "/openai/v1/")
+		return nil
+	}
+
+	// Read the body so we can determine the deployment to use
+	// by mapping the model in the request to a deployment in settings.
+	// Azure OpenAI API requires this deployment name in the URL.
+	bodyBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	var requestBody struct {
+		Model string `json:"model"`
+	}
+	if isAudioTranscriptionsPath(req.URL.Path) {
+		// Audio is uploaded as a form, with the model as one of its fields.
+		requestBody.Model, err = multipartModel(bodyBytes, req.Header.Get("Content-Type"))
+		if err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(bodyBytes, &requestBody); err != nil {
+		return fmt.Errorf("unmarshal request body: %w", err)
+	}
+	deployment := p.deployment(requestBody.Model)
+	if deployment == "" {
+		return fmt.Errorf("no deployment found for model: %s", requestBody.Model)
+	}
+
+	req.URL.Path = fmt.Sprintf("/openai/deployments/%s/%s", deployment, strings.TrimPrefix(req.URL.Path, "/openai/v1/"))
+	return nil
+}
+
+// TranslateBody removes the model, since Azure takes it from the deployment
+// in the path. With the Foundry endpoint style the model is replaced by its
+// deployment instead, or left alone if it has none, since Foundry
+// deployments are often named after their model.
+func (p *azureProvider) TranslateBody(body []byte) ([]byte, error) {
+	// Keep the other fields raw, so that values such as tokenized embeddings
+	// input pass through exactly.
+	var requestBody map[string]json.RawMessage
+	if err := json.Unmarshal(body, &requestBody); err != nil {
+		return nil, fmt.Errorf("unmarshal request body: %w", err)
+	}
+	if p.foundry() {
+		var model string
+		_ = json.Unmarshal(requestBody["model"], &model)
+		if deployment := p.deployment(model); deployment != "" {
+			requestBody["model"], _ = json.Marshal(deployment)
+		}
+	} else {
+		delete(requestBody, "model")
+	}
+	newBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return newBody, nil
+}
+
+func (p *azureProvider) AuthHeader() (string, string) {
+	return "api-key", p.settings.key()
+}
+
+func (p *azureProvider) ObserveRateLimits(resp *http.Response) {
+	p.settings.apiKeys.observe(resp.Request.Header.Get("api-key"), resp)
+}
+
+func (p *azureProvider) HealthModels() []string {
+	return openAIModels
+}
+
+// Capabilities reflects that Foundry's model inference API serves neither
+// legacy completions, audio transcriptions, nor a list of models.
+func (p *azureProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{StopFormat: StopFormatAny, LegacyCompletions: !p.foundry(), Logprobs: !p.settings.DisableLogprobs, AudioTranscriptions: !p.foundry(), ModelsList: !p.foundry(), StructuredOutputs: true, StreamUsage: p.settings.AzureAPIVersion >= azureStreamUsageAPIVersion}
+}
+
+// SupportsVision requires the model to be both vision-capable and mapped to a
+// deployment, unless it goes to Foundry; requests for unmapped models are
+// rejected later regardless.
+func (p *azureProvider) SupportsVision(model string) bool {
+	return isVisionModel(model) && (p.foundry() || p.deployment(model) != "")
+}
+
+// grafanaProvider proxies requests via the Grafana-managed llm-gateway.
+type grafanaProvider struct {
+	settings  Settings
+	endpoints *llmGatewayEndpoints
+}
+
+func (p *grafanaProvider) RewriteRequest(req *http.Request) error {
+	gatewayURL := p.settings.LLMGateway.URL
+	if p.endpoints != nil {
+		gatewayURL = p.endpoints.activeURL()
+	}
+	u, err := url.Parse(gatewayURL)
+	if err != nil {
+		return fmt.Errorf("parse LLM Gateway URL: %w", err)
+	}
+	// Keep any path prefix the gateway is served under.
+	req.URL.Scheme = u.Scheme
+	req.URL.Host = u.Host
+	req.URL.Path = path.Join(u.Path, req.URL.Path)
+	req.Header.Set("X-Scope-OrgID", p.settings.Tenant)
+	return nil
+}
+
+func (p *grafanaProvider) TranslateBody(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+func (p *grafanaProvider) AuthHeader() (string, string) {
+	auth := p.settings.Tenant + ":" + p.settings.GrafanaComAPIKey
+	return "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+}
+
+func (p *grafanaProvider) HealthModels() []string {
+	return openAIModels
+}
+
+func (p *grafanaProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{StopFormat: StopFormatAny, LegacyCompletions: true, Logprobs: !p.settings.OpenAI.DisableLogprobs, AudioTranscriptions: true, ModelsList: true, StructuredOutputs: true, StreamUsage: true}
+}
+
+func (p *grafanaProvider) SupportsVision(model string) bool {
+	return isVisionModel(model)
+}
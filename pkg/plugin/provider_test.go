@@ -0,0 +1,436 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/embed"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestProviders(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		settings Settings
+
+		path string
+		body string
+
+		expNil       bool
+		expRewriteOK bool
+		expURL       string
+		expHeaders   http.Header
+		expBody      string
+		expAuthName  string
+		expAuthValue string
+	}{
+		{
+			name: "openai",
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					URL:            "https://api.openai.com",
+					OrganizationID: "myOrg",
+					Provider:       openAIProviderOpenAI,
+					apiKey:         "abcd1234",
+				},
+			},
+			path: "/openai/v1/chat/completions",
+			body: `{"model":"gpt-3.5-turbo","messages":[]}`,
+
+			expRewriteOK: true,
+			expURL:       "https://api.openai.com/v1/chat/completions",
+			expHeaders:   http.Header{"Openai-Organization": {"myOrg"}},
+			expBody:      `{"model":"gpt-3.5-turbo","messages":[]}`,
+			expAuthName:  "Authorization",
+			expAuthValue: "Bearer abcd1234",
+		},
+		{
+			name: "azure",
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					URL:             "https://example.openai.azure.com",
+					Provider:        openAIProviderAzure,
+					AzureMapping:    [][]string{{"gpt-3.5-turbo", "gpt-35-turbo"}},
+					AzureAPIVersion: "2024-02-01",
+					apiKey:          "abcd1234",
+				},
+			},
+			path: "/openai/v1/chat/completions",
+			body: `{"model":"gpt-3.5-turbo","messages":[]}`,
+
+			expRewriteOK: true,
+			expURL:       "https://example.openai.azure.com/openai/deployments/gpt-35-turbo/chat/completions?api-version=2024-02-01",
+			expHeaders:   http.Header{},
+			expBody:      `{"messages":[]}`,
+			expAuthName:  "api-key",
+			expAuthValue: "abcd1234",
+		},
+		{
+			name: "openai tokenized embeddings",
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					URL:      "https://api.openai.com",
+					Provider: openAIProviderOpenAI,
+					apiKey:   "abcd1234",
+				},
+			},
+			path: "/openai/v1/embeddings",
+			body: `{"model":"text-embedding-3-small","input":[[1,2,3],[4,5]]}`,
+
+			expRewriteOK: true,
+			expURL:       "https://api.openai.com/v1/embeddings",
+			expHeaders:   http.Header{},
+			expBody:      `{"model":"text-embedding-3-small","input":[[1,2,3],[4,5]]}`,
+			expAuthName:  "Authorization",
+			expAuthValue: "Bearer abcd1234",
+		},
+		{
+			name: "azure tokenized embeddings",
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					URL:             "https://example.openai.azure.com",
+					Provider:        openAIProviderAzure,
+					AzureMapping:    [][]string{{"text-embedding-3-small", "embeddings"}},
+					AzureAPIVersion: "2024-02-01",
+					apiKey:          "abcd1234",
+				},
+			},
+			path: "/openai/v1/embeddings",
+			body: `{"model":"text-embedding-3-small","input":[[1,2,3],[4,5]],"dimensions":256}`,
+
+			expRewriteOK: true,
+			expURL:       "https://example.openai.azure.com/openai/deployments/embeddings/embeddings?api-version=2024-02-01",
+			expHeaders:   http.Header{},
+			expBody:      `{"dimensions":256,"input":[[1,2,3],[4,5]]}`,
+			expAuthName:  "api-key",
+			expAuthValue: "abcd1234",
+		},
+		{
+			name: "azure unmapped model",
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					URL:          "https://example.openai.azure.com",
+					Provider:     openAIProviderAzure,
+					AzureMapping: [][]string{{"gpt-3.5-turbo", "gpt-35-turbo"}},
+					apiKey:       "abcd1234",
+				},
+			},
+			path: "/openai/v1/chat/completions",
+			body: `{"model":"gpt-4","messages":[]}`,
+
+			expRewriteOK: false,
+		},
+		{
+			name: "azure foundry",
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					URL:                "https://example.services.ai.azure.com",
+					Provider:           openAIProviderAzure,
+					AzureMapping:       [][]string{{"gpt-3.5-turbo", "gpt-35-turbo"}},
+					AzureAPIVersion:    "2024-05-01-preview",
+					AzureEndpointStyle: AzureEndpointStyleFoundry,
+					apiKey:             "abcd1234",
+				},
+			},
+			path: "/openai/v1/chat/completions",
+			body: `{"model":"gpt-3.5-turbo","messages":[]}`,
+
+			expRewriteOK: true,
+			expURL:       "https://example.services.ai.azure.com/models/chat/completions?api-version=2024-05-01-preview",
+			expHeaders:   http.Header{},
+			expBody:      `{"messages":[],"model":"gpt-35-turbo"}`,
+			expAuthName:  "api-key",
+			expAuthValue: "abcd1234",
+		},
+		{
+			name: "azure foundry unmapped model",
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					URL:                "https://example.services.ai.azure.com",
+					Provider:           openAIProviderAzure,
+					AzureAPIVersion:    "2024-05-01-preview",
+					AzureEndpointStyle: AzureEndpointStyleFoundry,
+					apiKey:             "abcd1234",
+				},
+			},
+			path: "/openai/v1/embeddings",
+			body: `{"model":"text-embedding-3-small","input":"hi"}`,
+
+			expRewriteOK: true,
+			expURL:       "https://example.services.ai.azure.com/models/embeddings?api-version=2024-05-01-preview",
+			expHeaders:   http.Header{},
+			expBody:      `{"input":"hi","model":"text-embedding-3-small"}`,
+			expAuthName:  "api-key",
+			expAuthValue: "abcd1234",
+		},
+		{
+			name: "grafana",
+			settings: Settings{
+				Tenant:           "123",
+				GrafanaComAPIKey: "abcd1234",
+				OpenAI:           OpenAISettings{Provider: openAIProviderGrafana},
+				LLMGateway:       LLMGatewaySettings{URL: "https://llm-gateway.example.com"},
+			},
+			path: "/openai/v1/chat/completions",
+			body: `{"model":"gpt-3.5-turbo","messages":[]}`,
+
+			expRewriteOK: true,
+			expURL:       "https://llm-gateway.example.com/openai/v1/chat/completions",
+			expHeaders:   http.Header{"X-Scope-Orgid": {"123"}},
+			expBody:      `{"model":"gpt-3.5-turbo","messages":[]}`,
+			expAuthName:  "Authorization",
+			expAuthValue: "Basic MTIzOmFiY2QxMjM0",
+		},
+		{
+			name: "cohere",
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					Provider: openAIProviderCohere,
+					apiKey:   "abcd1234",
+				},
+			},
+			path: "/openai/v1/chat/completions",
+			body: `{"model":"command-r-plus","messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hi"},{"role":"system","content":"Be kind."}],"top_p":0.5,"stop":["\n"]}`,
+
+			expRewriteOK: true,
+			expURL:       "https://api.cohere.com/v2/chat",
+			expHeaders:   http.Header{},
+			expBody:      `{"messages":[{"role":"system","content":"Be brief.\n\nBe kind."},{"role":"user","content":"Hi"}],"model":"command-r-plus","p":0.5,"stop_sequences":["\n"]}`,
+			expAuthName:  "Authorization",
+			expAuthValue: "Bearer abcd1234",
+		},
+		{
+			name: "cohere unsupported path",
+			settings: Settings{
+				OpenAI: OpenAISettings{Provider: openAIProviderCohere},
+			},
+			path: "/openai/v1/embeddings",
+
+			expRewriteOK: false,
+		},
+		{
+			name:     "disabled",
+			settings: Settings{},
+			expNil:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProvider(tc.settings, nil)
+			if tc.expNil {
+				if p != nil {
+					t.Fatalf("expected nil provider, got %T", p)
+				}
+				return
+			}
+			if p == nil {
+				t.Fatal("expected provider, got nil")
+			}
+
+			if len(p.HealthModels()) == 0 {
+				t.Error("expected health models, got none")
+			}
+
+			req := httptest.NewRequest(http.MethodPost, tc.path, bytes.NewReader([]byte(tc.body)))
+			err := p.RewriteRequest(req)
+			if !tc.expRewriteOK {
+				if err == nil {
+					t.Fatal("expected rewrite error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rewrite request: %s", err)
+			}
+			if req.URL.String() != tc.expURL {
+				t.Errorf("expected URL %s, got %s", tc.expURL, req.URL.String())
+			}
+			for k, v := range tc.expHeaders {
+				if req.Header.Get(k) != v[0] {
+					t.Errorf("expected header %s: %s, got %s", k, v[0], req.Header.Get(k))
+				}
+			}
+
+			// The body must still be readable after rewriting.
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("read body: %s", err)
+			}
+			translated, err := p.TranslateBody(body)
+			if err != nil {
+				t.Fatalf("translate body: %s", err)
+			}
+			if string(translated) != tc.expBody {
+				t.Errorf("expected body %s, got %s", tc.expBody, translated)
+			}
+
+			name, value := p.AuthHeader()
+			if name != tc.expAuthName || value != tc.expAuthValue {
+				t.Errorf("expected auth header %s: %s, got %s: %s", tc.expAuthName, tc.expAuthValue, name, value)
+			}
+		})
+	}
+}
+
+func TestProviderRequests(t *testing.T) {
+	azureMapping := [][]string{{"gpt-4", "gpt-4-deployment"}}
+	for _, tc := range []struct {
+		name     string
+		settings Settings
+
+		expChat   string
+		expModels string
+		expAuth   string
+	}{
+		{
+			name:      "openai",
+			settings:  Settings{OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: "https://api.openai.com", apiKey: "abcd1234"}},
+			expChat:   "https://api.openai.com/v1/chat/completions",
+			expModels: "https://api.openai.com/v1/models",
+			expAuth:   "Bearer abcd1234",
+		},
+		{
+			name:      "azure",
+			settings:  Settings{OpenAI: OpenAISettings{Provider: openAIProviderAzure, URL: "https://test.openai.azure.com", AzureMapping: azureMapping, AzureAPIVersion: "2024-06-01", apiKey: "abcd1234"}},
+			expChat:   "https://test.openai.azure.com/openai/deployments/gpt-4-deployment/chat/completions?api-version=2024-06-01",
+			expModels: "https://test.openai.azure.com/openai/models?api-version=2024-06-01",
+		},
+		{
+			name:      "cohere",
+			settings:  Settings{OpenAI: OpenAISettings{Provider: openAIProviderCohere, apiKey: "abcd1234"}},
+			expChat:   defaultCohereURL + cohereChatPath,
+			expModels: defaultCohereURL + cohereModelsPath,
+			expAuth:   "Bearer abcd1234",
+		},
+		{
+			name: "grafana with a path prefix",
+			settings: Settings{
+				OpenAI: OpenAISettings{Provider: openAIProviderGrafana}, Tenant: "123", GrafanaComAPIKey: "abcd1234",
+				LLMGateway: LLMGatewaySettings{URL: "https://gateway.example.com/llm"},
+			},
+			expChat:   "https://gateway.example.com/llm/openai/v1/chat/completions",
+			expModels: "https://gateway.example.com/llm/openai/v1/models",
+			expAuth:   "Basic MTIzOmFiY2QxMjM0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := &App{settings: &tc.settings}
+			chat, err := app.newOpenAIChatCompletionsRequest(context.Background(), map[string]interface{}{"model": "gpt-4", "messages": []interface{}{}})
+			if err != nil {
+				t.Fatalf("new chat completions request: %s", err)
+			}
+			if chat.URL.String() != tc.expChat {
+				t.Errorf("expected chat completions URL %s, got %s", tc.expChat, chat.URL)
+			}
+			models, err := app.newOpenAIModelsRequest(context.Background())
+			if err != nil {
+				t.Fatalf("new models request: %s", err)
+			}
+			if models.URL.String() != tc.expModels {
+				t.Errorf("expected models URL %s, got %s", tc.expModels, models.URL)
+			}
+			if tc.expAuth != "" && models.Header.Get("Authorization") != tc.expAuth {
+				t.Errorf("expected authorization %q, got %q", tc.expAuth, models.Header.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestEmbeddingsURL(t *testing.T) {
+	var chatPaths, embeddingsPaths []string
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chatPaths = append(chatPaths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer chat.Close()
+	embeddings := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		embeddingsPaths = append(embeddingsPaths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"index": 0, "embedding": [0.1, 0.2]}]}`))
+	}))
+	defer embeddings.Close()
+
+	settings, err := loadSettings(backend.AppInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{
+			"openAI": {"provider": "openai", "url": %q},
+			"vector": {"enabled": true, "embed": {"type": "openai", "embeddingsUrl": %q}}
+		}`, chat.URL, embeddings.URL)),
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	})
+	if err != nil {
+		t.Fatalf("load settings: %s", err)
+	}
+
+	proxy := newProviderProxy(newProvider(*settings, nil), providerProxyOptions{})
+	for _, path := range []string{"/openai/v1/chat/completions", "/openai/v1/embeddings"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model": "gpt-4o"}`))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", path, w.Code, w.Body)
+		}
+	}
+
+	embedder, err := embed.NewEmbedder(settings.Vector.Embed, map[string]string{openAIKey: "abcd1234"})
+	if err != nil {
+		t.Fatalf("new embedder: %s", err)
+	}
+	if _, err := embedder.Embed(context.Background(), "text-embedding-3-small", "hello"); err != nil {
+		t.Fatalf("embed: %s", err)
+	}
+
+	if len(chatPaths) != 1 || chatPaths[0] != "/v1/chat/completions" {
+		t.Errorf("expected only the chat completions request to reach the chat host, got %v", chatPaths)
+	}
+	if len(embeddingsPaths) != 2 || embeddingsPaths[0] != "/v1/embeddings" || embeddingsPaths[1] != "/v1/embeddings" {
+		t.Errorf("expected both embeddings requests to reach the embeddings host, got %v", embeddingsPaths)
+	}
+}
+
+func TestOpenAIKeySecret(t *testing.T) {
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"index": 0, "embedding": [0.1, 0.2]}]}`))
+	}))
+	defer server.Close()
+
+	// The documented name of the secret, as used in provisioning files.
+	secrets := map[string]string{"openAIKey": "abcd1234"}
+	settings, err := loadSettings(backend.AppInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{
+			"openAI": {"provider": "openai", "url": %q},
+			"vector": {"enabled": true, "embed": {"type": "openai"}}
+		}`, server.URL)),
+		DecryptedSecureJSONData: secrets,
+	})
+	if err != nil {
+		t.Fatalf("load settings: %s", err)
+	}
+
+	proxy := newProviderProxy(newProvider(*settings, nil), providerProxyOptions{})
+	req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o"}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	embedder, err := embed.NewEmbedder(settings.Vector.Embed, secrets)
+	if err != nil {
+		t.Fatalf("new embedder: %s", err)
+	}
+	if _, err := embedder.Embed(context.Background(), "text-embedding-3-small", "hello"); err != nil {
+		t.Fatalf("embed: %s", err)
+	}
+
+	if len(auth) != 2 || auth[0] != "Bearer abcd1234" || auth[1] != "Bearer abcd1234" {
+		t.Errorf("expected the proxy and the embedder to authenticate with the key, got %v", auth)
+	}
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// proxyErrorResponse is the OpenAI error envelope. The proxy uses it for its
+// own errors too, so that clients see the same error shape whether an error
+// comes from the plugin or from the provider.
+type proxyErrorResponse struct {
+	Error proxyErrorDetail `json:"error"`
+}
+
+type proxyErrorDetail struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+// proxyErrorType returns the OpenAI error type for a status code.
+func proxyErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status < http.StatusInternalServerError:
+		return "invalid_request_error"
+	}
+	return "server_error"
+}
+
+// writeProxyError writes err as an OpenAI-style JSON error response, using the
+// status code of a *TransformError if there is one and defaultStatus otherwise.
+func writeProxyError(w http.ResponseWriter, req *http.Request, err error, defaultStatus int, code string) {
+	status := defaultStatus
+	var te *TransformError
+	if errors.As(err, &te) && te.StatusCode != 0 {
+		status = te.StatusCode
+	}
+	log.DefaultLogger.FromContext(req.Context()).Error("Proxy error", "status", status, "err", err)
+	body, _ := json.Marshal(proxyErrorResponse{Error: proxyErrorDetail{
+		Message: err.Error(),
+		Type:    proxyErrorType(status),
+		Code:    code,
+	}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	//nolint:errcheck // Just do our best to write.
+	w.Write(body)
+}
+
+// proxyErrorHandler is used as a ReverseProxy's ErrorHandler so that failed
+// upstream requests (and errors from response transformers) are returned as
+// JSON, with a status saying whether the provider was unreachable or too slow.
+func proxyErrorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		writeProxyError(w, req, err, http.StatusGatewayTimeout, "provider_timeout")
+	case errors.As(err, new(*net.OpError)):
+		writeProxyError(w, req, err, http.StatusBadGateway, "provider_unreachable")
+	default:
+		writeProxyError(w, req, err, http.StatusBadGateway, "")
+	}
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyErrors(t *testing.T) {
+	// A server which has been shut down, so that connections are refused.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	block := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(block)
+
+	for _, tc := range []struct {
+		name    string
+		url     string
+		body    string
+		timeout time.Duration
+
+		expStatus int
+		expType   string
+		expCode   string
+	}{
+		{
+			name:      "connection refused",
+			url:       closed.URL,
+			body:      `{"model": "gpt-4o", "messages": []}`,
+			expStatus: http.StatusBadGateway,
+			expType:   "server_error",
+			expCode:   "provider_unreachable",
+		},
+		{
+			name:      "timeout",
+			url:       slow.URL,
+			body:      `{"model": "gpt-4o", "messages": []}`,
+			timeout:   50 * time.Millisecond,
+			expStatus: http.StatusGatewayTimeout,
+			expType:   "server_error",
+			expCode:   "provider_timeout",
+		},
+		{
+			name:      "invalid request",
+			url:       slow.URL,
+			body:      `not json`,
+			expStatus: http.StatusBadRequest,
+			expType:   "invalid_request_error",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &directOpenAIProvider{settings: OpenAISettings{URL: tc.url}}
+			transformers := &transformers{request: []RequestTransformer{func(req *http.Request) error {
+				return rewriteJSONBody(req, func(map[string]interface{}) error { return nil })
+			}}}
+			proxy := newProviderProxy(provider, providerProxyOptions{transformers: transformers})
+			req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(tc.body))
+			if tc.timeout > 0 {
+				ctx, cancel := context.WithTimeout(req.Context(), tc.timeout)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected a JSON response, got Content-Type %q", ct)
+			}
+			var got proxyErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("expected an error envelope, got %s", w.Body)
+			}
+			if got.Error.Message == "" || got.Error.Type != tc.expType || got.Error.Code != tc.expCode {
+				t.Errorf("expected error of type %q with code %q, got %+v", tc.expType, tc.expCode, got.Error)
+			}
+		})
+	}
+}
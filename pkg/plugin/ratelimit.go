@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Rate limit headers sent by OpenAI and Azure OpenAI, by the resource they
+// limit. The proxy passes them through to the client unchanged; they are also
+// recorded as gauges so they can be monitored.
+var rateLimitHeaders = map[string]struct{ limit, remaining string }{
+	"requests": {limit: "X-Ratelimit-Limit-Requests", remaining: "X-Ratelimit-Remaining-Requests"},
+	"tokens":   {limit: "X-Ratelimit-Limit-Tokens", remaining: "X-Ratelimit-Remaining-Tokens"},
+}
+
+// Rate limit metrics are registered with the default registerer, which is what
+// the plugin SDK serves at /metrics. They hold the values from the most recent
+// provider response which included them.
+var (
+	rateLimitLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana_llm_app",
+		Subsystem: "provider",
+		Name:      "ratelimit_limit",
+		Help:      "Rate limit reported by the LLM provider.",
+	}, []string{"resource"})
+	rateLimitRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana_llm_app",
+		Subsystem: "provider",
+		Name:      "ratelimit_remaining",
+		Help:      "Remaining rate limit reported by the LLM provider.",
+	}, []string{"resource"})
+)
+
+// recordRateLimits updates the rate limit metrics from a provider response's
+// headers. Missing or malformed values are ignored.
+func recordRateLimits(h http.Header) {
+	for resource, headers := range rateLimitHeaders {
+		if v, err := strconv.ParseFloat(h.Get(headers.limit), 64); err == nil {
+			rateLimitLimit.WithLabelValues(resource).Set(v)
+		}
+		if v, err := strconv.ParseFloat(h.Get(headers.remaining), 64); err == nil {
+			rateLimitRemaining.WithLabelValues(resource).Set(v)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	ctx := context.Background()
+	upstreamHeaders := map[string]string{
+		"X-Ratelimit-Limit-Requests":     "500",
+		"X-Ratelimit-Limit-Tokens":       "30000",
+		"X-Ratelimit-Remaining-Requests": "499",
+		"X-Ratelimit-Remaining-Tokens":   "29975",
+		"X-Ratelimit-Reset-Requests":     "120ms",
+		"X-Ratelimit-Reset-Tokens":       "50ms",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range upstreamHeaders {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	var r mockCallResourceResponseSender
+	err = app.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Method:        http.MethodPost,
+		Path:          "/openai/v1/chat/completions",
+		Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+	}, &r)
+	if err != nil {
+		t.Fatalf("CallResource error: %s", err)
+	}
+	if r.response.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+	}
+	headers := http.Header(r.response.Headers)
+	for k, v := range upstreamHeaders {
+		if got := headers.Get(k); got != v {
+			t.Errorf("expected header %s to be %q, got %q", k, v, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		got  float64
+		exp  float64
+	}{
+		{name: "requests limit", got: testutil.ToFloat64(rateLimitLimit.WithLabelValues("requests")), exp: 500},
+		{name: "tokens limit", got: testutil.ToFloat64(rateLimitLimit.WithLabelValues("tokens")), exp: 30000},
+		{name: "requests remaining", got: testutil.ToFloat64(rateLimitRemaining.WithLabelValues("requests")), exp: 499},
+		{name: "tokens remaining", got: testutil.ToFloat64(rateLimitRemaining.WithLabelValues("tokens")), exp: 29975},
+	} {
+		if tc.got != tc.exp {
+			t.Errorf("expected %s gauge to be %v, got %v", tc.name, tc.exp, tc.got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// requestIDHeader correlates a request across the plugin's logs, the provider
+// and the response, and identifies proxied requests so they can be
+// cancelled. Clients may set it themselves, so they know the ID before the
+// response arrives; otherwise one is generated. Either way it is returned in
+// the response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the length of the longest request ID accepted from
+// clients. Longer IDs are replaced, since they end up in every log line.
+const maxRequestIDLength = 128
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error on supported platforms.
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// validRequestID reports whether a client's request ID can be used: it must
+// be printable ASCII and not too long.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware gives each request an ID, reusing the client's if valid.
+// The ID is set as the request's X-Request-ID header, so that the proxy
+// forwards it to the provider, added to the request's context so that log
+// lines written with log.DefaultLogger.FromContext include it, and returned
+// in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		req.Header.Set(requestIDHeader, id)
+		ctx := log.WithContextualAttributes(req.Context(), []any{"requestID", id})
+		next.ServeHTTP(&requestIDResponseWriter{ResponseWriter: w, id: id}, req.WithContext(ctx))
+	})
+}
+
+// requestIDResponseWriter sets the X-Request-ID header just before the
+// response is written, replacing any request ID sent by the provider.
+type requestIDResponseWriter struct {
+	http.ResponseWriter
+	id          string
+	wroteHeader bool
+}
+
+func (w *requestIDResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set(requestIDHeader, w.id)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *requestIDResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher so streamed responses are still flushed.
+func (w *requestIDResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
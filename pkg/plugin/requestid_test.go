@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// recordingLogger records each line logged, with its key/value pairs.
+type recordingLogger struct {
+	mu    *sync.Mutex
+	lines *[]string
+	args  []interface{}
+}
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{mu: &sync.Mutex{}, lines: &[]string{}}
+}
+
+func (l *recordingLogger) record(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.lines = append(*l.lines, fmt.Sprint(msg, append(append([]interface{}{}, l.args...), args...)))
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record(msg, args...) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record(msg, args...) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record(msg, args...) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record(msg, args...) }
+func (l *recordingLogger) Level() log.Level                      { return log.Debug }
+
+func (l *recordingLogger) With(args ...interface{}) log.Logger {
+	return &recordingLogger{mu: l.mu, lines: l.lines, args: append(append([]interface{}{}, l.args...), args...)}
+}
+
+func (l *recordingLogger) FromContext(ctx context.Context) log.Logger {
+	return l.With(log.ContextualAttributesFromContext(ctx)...)
+}
+
+// linesWith returns the lines logged which contain s.
+func (l *recordingLogger) linesWith(s string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var lines []string
+	for _, line := range *l.lines {
+		if strings.Contains(line, s) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	ctx := context.Background()
+	logger := newRecordingLogger()
+	defaultLogger := log.DefaultLogger
+	log.DefaultLogger = logger
+	defer func() { log.DefaultLogger = defaultLogger }()
+
+	upstreamIDs := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamIDs <- r.Header.Get(requestIDHeader)
+		// Hang up, so that the proxy logs an error.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+	app, appSettings := newTransformTestApp(t, server.URL)
+
+	for _, tc := range []struct {
+		name     string
+		clientID string
+	}{
+		{name: "client's ID", clientID: "trace-123"},
+		{name: "generated ID"},
+		{name: "invalid client ID replaced", clientID: strings.Repeat("x", maxRequestIDLength+1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := map[string][]string{}
+			if tc.clientID != "" {
+				headers[http.CanonicalHeaderKey(requestIDHeader)] = []string{tc.clientID}
+			}
+			var r mockCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Headers:       headers,
+				Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			id := http.Header(r.response.Headers).Get(requestIDHeader)
+			switch {
+			case tc.clientID != "" && len(tc.clientID) <= maxRequestIDLength:
+				if id != tc.clientID {
+					t.Errorf("expected the client's request ID %s, got %s", tc.clientID, id)
+				}
+			case !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id):
+				t.Errorf("expected a generated UUID, got %q", id)
+			}
+			select {
+			case upstreamID := <-upstreamIDs:
+				if upstreamID != id {
+					t.Errorf("expected the provider to receive request ID %s, got %q", id, upstreamID)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("request never reached the provider")
+			}
+			if lines := logger.linesWith("requestID " + id); len(lines) == 0 {
+				t.Errorf("expected log lines with request ID %s, got none", id)
+			}
+		})
+	}
+}
@@ -10,15 +10,20 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
+	"time"
 
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector"
 	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/store"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 )
 
-func handleError(w http.ResponseWriter, err error, status int) {
-	log.DefaultLogger.Error(err.Error())
+// errProviderNotConfigured is returned by the proxy when no LLM provider is enabled.
+var errProviderNotConfigured = errors.New("LLM provider not configured")
+
+func handleError(w http.ResponseWriter, req *http.Request, err error, status int) {
+	logger := log.DefaultLogger.FromContext(req.Context())
+	logger.Error(err.Error())
 	// Attempt to write the error as JSON.
 	jd, err := json.Marshal(map[string]string{"error": err.Error()})
 	if err != nil {
@@ -26,14 +31,14 @@ func handleError(w http.ResponseWriter, err error, status int) {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, err = w.Write([]byte(err.Error()))
 		if err != nil {
-			log.DefaultLogger.Error("Unable to write error response", "err", err)
+			logger.Error("Unable to write error response", "err", err)
 		}
 		return
 	}
 	w.WriteHeader(status)
 	_, err = w.Write(jd)
 	if err != nil {
-		log.DefaultLogger.Error("Unable to write error response", "err", err)
+		logger.Error("Unable to write error response", "err", err)
 	}
 }
 
@@ -49,189 +54,322 @@ func modifyURL(openAIUrl string, req *http.Request) error {
 	return nil
 }
 
-// openAIProxy is a reverse proxy for OpenAI API calls.
-// It modifies the request to point to the configured OpenAI API, returning
-// a 400 error if the URL in settings cannot be parsed, then proxies the request
-// using the configured API key and OpenAI organization.
-type openAIProxy struct {
-	settings Settings
+// providerProxy is a reverse proxy for OpenAI API calls.
+// It uses the configured Provider to point the request at the provider's API
+// and translate it into the provider's format, returning a 400 error if that
+// fails (e.g. if the URL in settings cannot be parsed), then proxies the request
+// using the provider's credentials.
+type providerProxy struct {
+	provider Provider
 	// rp is a reverse proxy handling the modified request. Use this rather than
 	// our own client, since it handles things like buffering.
 	rp *httputil.ReverseProxy
+	// transformers are applied to requests before they are proxied.
+	transformers *transformers
+	// forwardHeaders are the incoming request headers passed to the provider.
+	forwardHeaders headerAllowList
+	// stripHeaders are never sent to the provider, even if forwarded or set
+	// by a transformer.
+	stripHeaders headerStripList
+	// latency is updated with the latency of successful chat completions requests.
+	latency *latencyEMA
+	// extraBodyFields are added to request bodies sent to the provider.
+	extraBodyFields map[string]interface{}
+	// audit records each call made to the provider, if enabled.
+	audit *auditLogger
+	// translateCompletions sends legacy completions requests to the chat
+	// completions endpoint even if the provider supports the legacy one.
+	translateCompletions bool
+	// maxResponseBytes limits the size of non-streamed responses.
+	maxResponseBytes int64
+	// userAgent is sent as the User-Agent header of every request.
+	userAgent string
+	// keepAlive is the interval between keep-alive comments sent in streamed
+	// responses before the first data, or zero to send none.
+	keepAlive time.Duration
+	// idleTimeout aborts streamed responses which stall for longer than it
+	// once they've started, or zero to never abort them.
+	idleTimeout time.Duration
+	// compress enables gzip compression of non-streamed responses for
+	// clients which accept it.
+	compress bool
+	// structuredOutputsFallback is what happens to structured outputs
+	// requests if the provider doesn't support them.
+	structuredOutputsFallback StructuredOutputsFallback
+	// trackStreamUsage asks providers to report the usage of streamed
+	// completions, for clients which didn't ask, so that it can be counted.
+	trackStreamUsage bool
 }
 
-func (a *openAIProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	err := modifyURL(a.settings.OpenAI.URL, req)
-	if err != nil {
-		// Attempt to write the error as JSON.
-		jd, err := json.Marshal(map[string]string{"error": err.Error()})
-		if err != nil {
-			// We can't write JSON, so just write the error string.
-			w.WriteHeader(http.StatusInternalServerError)
-			_, err = w.Write([]byte(err.Error()))
+// proxyRequestInfoKey is the context key for a proxied request's proxyRequestInfo.
+type proxyRequestInfoKey struct{}
+
+// proxyRequestInfo is what we know about a proxied request when its response arrives.
+type proxyRequestInfo struct {
+	// start is when the request was sent.
+	start time.Time
+	// path is the path requested by the client, before the provider
+	// rewrote it, so that responses are handled by endpoint whatever the
+	// provider calls it.
+	path string
+	// model is the model sent to the provider, after the request
+	// transformers ran, if any.
+	model string
+	// legacyCompletions is set if the request was translated from a legacy
+	// completions request, so the response must be translated back.
+	legacyCompletions bool
+	// gzip is set if the response should be compressed for the client.
+	gzip bool
+	// streamUsage is set if usage events should be added to a streamed
+	// response, with the estimated prompt tokens in promptTokens.
+	streamUsage  bool
+	promptTokens int64
+	// dropStreamUsage is set if the chunk reporting a streamed response's
+	// usage was only asked for by the proxy, so shouldn't reach the client.
+	dropStreamUsage bool
+}
+
+// modifyResponse records the latency of successful chat completions requests
+// and the provider's rate limits, and audits the call before applying the
+// response transformers. Latency is measured to the response headers, so
+// streamed responses are counted fairly.
+func (a *providerProxy) modifyResponse(resp *http.Response) error {
+	limitResponseBody(resp, a.maxResponseBytes)
+	if err := translateResponse(a.provider, resp); err != nil {
+		return err
+	}
+	recordRateLimits(resp.Header)
+	if o, ok := a.provider.(rateLimitObserver); ok {
+		o.ObserveRateLimits(resp)
+	}
+	info, ok := resp.Request.Context().Value(proxyRequestInfoKey{}).(proxyRequestInfo)
+	if info.model != "" {
+		resp.Header.Set(resolvedModelHeader, info.model)
+	}
+	if ok && a.latency != nil && resp.StatusCode == http.StatusOK && isChatCompletionsPath(info.path) {
+		a.latency.observe(time.Since(info.start))
+	}
+	if a.audit != nil {
+		user := httpadapter.UserFromContext(resp.Request.Context())
+		record := auditRecord{Path: resp.Request.URL.Path, Model: info.model, StatusCode: resp.StatusCode}
+		if isEventStream(resp) {
+			a.audit.logStream(user, record, resp)
+		} else {
+			usage, _, err := responseUsage(resp)
 			if err != nil {
-				log.DefaultLogger.Error("Unable to write error response", "err", err)
+				return err
 			}
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write(jd)
-		if err != nil {
-			log.DefaultLogger.Error("Unable to write error response", "err", err)
+			record.Usage = usage
+			a.audit.log(user, record)
 		}
 	}
-	a.rp.ServeHTTP(w, req)
-}
-
-func newOpenAIProxy(settings Settings) http.Handler {
-	director := func(req *http.Request) {
-		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/openai")
-		req.Header.Add("Authorization", "Bearer "+settings.OpenAI.apiKey)
-		req.Header.Add("OpenAI-Organization", settings.OpenAI.OrganizationID)
+	if err := a.transformers.transformResponse(resp); err != nil {
+		return err
 	}
-	return &openAIProxy{
-		settings: settings,
-		rp:       &httputil.ReverseProxy{Director: director},
+	// Before legacy completions are translated back, so the usage events
+	// are added once and their text counted the same way.
+	if info.streamUsage {
+		streamUsage(resp, info.promptTokens)
 	}
-}
-
-// azureOpenAIProxy is a reverse proxy for Azure OpenAI API calls.
-// It modifies the request to point to the configured Azure OpenAI API, returning
-// a 400 error if the URL in settings cannot be parsed or if the request refers
-// to a model without a corresponding deployment in settings. It then proxies the request
-// using the configured API key and deployment.
-type azureOpenAIProxy struct {
-	settings Settings
-	// rp is a reverse proxy handling the modified request. Use this rather than
-	// our own client, since it handles things like buffering.
-	rp *httputil.ReverseProxy
-}
-
-func (a *azureOpenAIProxy) modifyRequest(req *http.Request) error {
-	err := modifyURL(a.settings.OpenAI.URL, req)
-	if err != nil {
-		return fmt.Errorf("modify url: %w", err)
-	}
-
-	// Read the body so we can determine the deployment to use
-	// by mapping the model in the request to a deployment in settings.
-	// Azure OpenAI API requires this deployment name in the URL.
-	bodyBytes, _ := io.ReadAll(req.Body)
-	var requestBody map[string]interface{}
-	err = json.Unmarshal(bodyBytes, &requestBody)
-	if err != nil {
-		return fmt.Errorf("unmarshal request body: %w", err)
+	if info.dropStreamUsage {
+		dropStreamUsage(resp)
 	}
-
-	// Find the deployment for the model.
-	// Models are mapped to deployments in settings.OpenAI.AzureMapping.
-	var deployment string = ""
-	for _, v := range a.settings.OpenAI.AzureMapping {
-		if val, ok := requestBody["model"].(string); ok && val == v[0] {
-			deployment = v[1]
-			break
+	if info.legacyCompletions {
+		if err := translateCompletionsResponse(resp); err != nil {
+			return err
 		}
 	}
-
-	if deployment == "" {
-		return fmt.Errorf("no deployment found for model: %s", requestBody["model"])
-	}
-
-	// We've got a deployment, so finish modifying the request.
-	req.URL.Path = fmt.Sprintf("/openai/deployments/%s/%s", deployment, strings.TrimPrefix(req.URL.Path, "/openai/v1/"))
-	req.Header.Add("api-key", a.settings.OpenAI.apiKey)
-	req.URL.RawQuery = "api-version=2023-03-15-preview"
-
-	// Remove extra fields
-	delete(requestBody, "model")
-
-	newBodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("unmarshal request body: %w", err)
+	// Last, so that nothing else sees the comments or compressed body. The
+	// idle timeout is inside the keep-alives, so they don't reset it.
+	idleTimeoutStream(resp, a.idleTimeout)
+	keepAliveStream(resp, a.keepAlive)
+	if info.gzip {
+		gzipResponse(resp)
 	}
-	req.Body = io.NopCloser(bytes.NewBuffer(newBodyBytes))
-	req.ContentLength = int64(len(newBodyBytes))
 	return nil
 }
 
-func (a *azureOpenAIProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	err := a.modifyRequest(req)
-	if err != nil {
-		// Attempt to write the error as JSON.
-		jd, err := json.Marshal(map[string]string{"error": err.Error()})
+// modifyRequest prepares the request for the provider, returning the model
+// requested, if any.
+func (a *providerProxy) modifyRequest(req *http.Request) (string, error) {
+	// Check the path before it's rewritten, since providers may use a
+	// different path for completions.
+	completions := isCompletionsPath(req.URL.Path)
+	if err := a.provider.RewriteRequest(req); err != nil {
+		return "", err
+	}
+	var model string
+	// Audio transcriptions bodies are forms, forwarded unmodified.
+	if req.Body != nil && req.Body != http.NoBody && !isAudioTranscriptionsPath(req.URL.Path) {
+		bodyBytes, err := io.ReadAll(req.Body)
 		if err != nil {
-			// We can't write JSON, so just write the error string.
-			w.WriteHeader(http.StatusInternalServerError)
-			_, err = w.Write([]byte(err.Error()))
+			return "", fmt.Errorf("read request body: %w", err)
+		}
+		if completions {
+			var requestBody struct {
+				Model string `json:"model"`
+			}
+			// Ignore errors; the provider will reject malformed requests.
+			_ = json.Unmarshal(bodyBytes, &requestBody)
+			model = requestBody.Model
+			bodyBytes, err = normalizeChatBody(bodyBytes, a.provider.Capabilities(), a.structuredOutputsFallback)
 			if err != nil {
-				log.DefaultLogger.Error("Unable to write error response", "err", err)
+				return "", err
 			}
-			return
 		}
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write(jd)
+		newBodyBytes, err := a.provider.TranslateBody(bodyBytes)
 		if err != nil {
-			log.DefaultLogger.Error("Unable to write error response", "err", err)
+			return "", fmt.Errorf("translate request body: %w", err)
 		}
-		return
-	}
-	a.rp.ServeHTTP(w, req)
+		newBodyBytes, err = mergeExtraBodyFields(newBodyBytes, a.extraBodyFields)
+		if err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(newBodyBytes))
+		req.ContentLength = int64(len(newBodyBytes))
+	}
+	a.stripHeaders.strip(req.Header)
+	name, value := a.provider.AuthHeader()
+	req.Header.Set(name, value)
+	req.Header.Set("User-Agent", a.userAgent)
+	return model, nil
 }
 
-func newAzureOpenAIProxy(settings Settings) http.Handler {
-	// We make all of the actual modifications in ServeHTTP, since they can fail
-	// and we want to early-return from HTTP requests in that case.
-	director := func(req *http.Request) {}
-	return &azureOpenAIProxy{
-		settings: settings,
-		rp: &httputil.ReverseProxy{
-			Director: director,
-		},
+func (a *providerProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Check before the client's headers are filtered, since Accept-Encoding
+	// is never forwarded.
+	acceptGzip := a.compress && acceptsGzip(req.Header.Get("Accept-Encoding"))
+	wantStreamUsage := isCompletionsPath(req.URL.Path) && streamUsageRequested(req.Header)
+	requestID := req.Header.Get(requestIDHeader)
+	// Drop any client headers which shouldn't reach the provider, such as
+	// Grafana's own auth and user headers.
+	a.forwardHeaders.filter(req.Header)
+	// Always send the request ID, so the provider's logs can be correlated
+	// with ours, unless it is in stripHeaders.
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	if isAudioTranscriptionsPath(req.URL.Path) && !a.provider.Capabilities().AudioTranscriptions {
+		writeProxyError(w, req, errAudioTranscriptionsUnsupported, http.StatusNotImplemented, "unsupported_endpoint")
+		return
 	}
-}
-
-// grafanaOpenAIProxy is a reverse proxy for OpenAI API calls, that proxies all
-// requests via the llm-gateway.
-type grafanaOpenAIProxy struct {
-	settings Settings
-	// rp is a reverse proxy handling the modified request. Use this rather than
-	// our own client, since it handles things like buffering.
-	rp *httputil.ReverseProxy
-}
-
-func (a *grafanaOpenAIProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	err := modifyURL(a.settings.LLMGateway.URL+"/openai", req) // GER: FIXME - not durable to / added
-	if err != nil {
-		// Attempt to write the error as JSON.
-		jd, err := json.Marshal(map[string]string{"error": err.Error()})
-		if err != nil {
-			// We can't write JSON, so just write the error string.
-			w.WriteHeader(http.StatusInternalServerError)
-			_, err = w.Write([]byte(err.Error()))
-			if err != nil {
-				log.DefaultLogger.Error("Unable to write error response", "err", err)
-			}
+	// Translate legacy completions requests first, so that transformers see
+	// chat completions requests.
+	legacyCompletions := isLegacyCompletionsPath(req.URL.Path) &&
+		(a.translateCompletions || !a.provider.Capabilities().LegacyCompletions)
+	if legacyCompletions {
+		if err := translateCompletionsRequest(req); err != nil {
+			writeProxyError(w, req, err, http.StatusBadRequest, "")
 			return
 		}
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write(jd)
+	}
+	// Transform the request before handing it to the provider, so that
+	// transformers see the same request shape regardless of provider.
+	if err := a.transformers.transformRequest(req); err != nil {
+		writeProxyError(w, req, err, http.StatusBadRequest, "")
+		return
+	}
+	var dropUsage bool
+	if a.trackStreamUsage && isCompletionsPath(req.URL.Path) && req.Body != nil && req.Body != http.NoBody && a.provider.Capabilities().StreamUsage {
+		err := rewriteJSONBody(req, func(body map[string]interface{}) error {
+			dropUsage = forceStreamUsage(body)
+			return nil
+		})
 		if err != nil {
-			log.DefaultLogger.Error("Unable to write error response", "err", err)
+			writeProxyError(w, req, err, http.StatusBadRequest, "")
+			return
 		}
 	}
-	a.rp.ServeHTTP(w, req)
+	var promptTokens int64
+	if wantStreamUsage {
+		var err error
+		// Estimate before the body is translated into the provider's format.
+		if promptTokens, err = requestPromptTokens(req); err != nil {
+			writeProxyError(w, req, err, http.StatusBadRequest, "")
+			return
+		}
+	}
+	// Keep the path before the provider rewrites it.
+	path := req.URL.Path
+	model, err := a.modifyRequest(req)
+	if err != nil {
+		writeProxyError(w, req, err, http.StatusBadRequest, "")
+		return
+	}
+	info := proxyRequestInfo{start: time.Now(), path: path, model: model, legacyCompletions: legacyCompletions, gzip: acceptGzip, streamUsage: wantStreamUsage, promptTokens: promptTokens, dropStreamUsage: dropUsage}
+	a.rp.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), proxyRequestInfoKey{}, info)))
 }
 
-func newGrafanaOpenAIProxy(settings Settings) http.Handler {
-	director := func(req *http.Request) {
-		req.SetBasicAuth(settings.Tenant, settings.GrafanaComAPIKey)
-		req.Header.Add("X-Scope-OrgID", settings.Tenant)
-	}
+// providerProxyOptions configures a providerProxy. The zero value proxies
+// requests unchanged apart from the provider's own translation.
+type providerProxyOptions struct {
+	// transport sends requests to the provider. Defaults to
+	// http.DefaultTransport.
+	transport http.RoundTripper
+	// transformers are applied to requests and responses. Defaults to none.
+	transformers *transformers
+	// forwardHeaders are the incoming request headers passed to the provider.
+	forwardHeaders []string
+	// stripHeaders are never sent to the provider.
+	stripHeaders []string
+	// extraBodyFields are merged into request bodies.
+	extraBodyFields map[string]interface{}
+	// latency is updated with the latency of successful chat completions requests.
+	latency *latencyEMA
+	// audit records each call, if set.
+	audit *auditLogger
+	// translateCompletions serves legacy completions with the chat completions API.
+	translateCompletions bool
+	// maxResponseBytes limits buffered responses, if positive.
+	maxResponseBytes int64
+	// userAgent is sent to the provider.
+	userAgent string
+	// keepAlive is the interval between keep-alive comments in streams, if positive.
+	keepAlive time.Duration
+	// idleTimeout ends streams which go quiet for this long, if positive.
+	idleTimeout time.Duration
+	// compress gzips responses for clients which accept it.
+	compress bool
+	// structuredOutputsFallback is what happens to structured outputs
+	// requests if the provider doesn't support them.
+	structuredOutputsFallback StructuredOutputsFallback
+	// trackStreamUsage asks providers for usage at the end of streams.
+	trackStreamUsage bool
+}
 
-	return &grafanaOpenAIProxy{
-		settings: settings,
-		rp:       &httputil.ReverseProxy{Director: director},
-	}
+// newProviderProxy creates a proxy for the given provider.
+func newProviderProxy(provider Provider, opts providerProxyOptions) http.Handler {
+	// We make all of the actual modifications in ServeHTTP, since they can fail
+	// and we want to early-return from HTTP requests in that case.
+	director := func(req *http.Request) {}
+	if opts.transformers == nil {
+		opts.transformers = &transformers{}
+	}
+	strip := newHeaderStripList(opts.stripHeaders)
+	p := &providerProxy{
+		provider:                  provider,
+		transformers:              opts.transformers,
+		forwardHeaders:            newHeaderAllowList(opts.forwardHeaders, strip),
+		stripHeaders:              strip,
+		extraBodyFields:           opts.extraBodyFields,
+		latency:                   opts.latency,
+		audit:                     opts.audit,
+		translateCompletions:      opts.translateCompletions,
+		maxResponseBytes:          opts.maxResponseBytes,
+		userAgent:                 opts.userAgent,
+		keepAlive:                 opts.keepAlive,
+		idleTimeout:               opts.idleTimeout,
+		compress:                  opts.compress,
+		structuredOutputsFallback: opts.structuredOutputsFallback,
+		trackStreamUsage:          opts.trackStreamUsage,
+	}
+	p.rp = &httputil.ReverseProxy{
+		Director:       director,
+		Transport:      opts.transport,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   proxyErrorHandler,
+	}
+	return p
 }
 
 type vectorSearchRequest struct {
@@ -263,6 +401,10 @@ func (app *App) handleVectorSearch(w http.ResponseWriter, req *http.Request) {
 		body.TopK = 10
 	}
 	results, err := app.vectorService.Search(req.Context(), body.Collection, body.Query, body.TopK, body.Filter)
+	if errors.Is(err, store.ErrInvalidCollection) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -277,6 +419,230 @@ func (app *App) handleVectorSearch(w http.ResponseWriter, req *http.Request) {
 	w.Write(bodyJSON)
 }
 
+type vectorMultiSearchRequest struct {
+	Query       string                 `json:"query"`
+	Collections []string               `json:"collections"`
+	TopK        uint64                 `json:"topK"`
+	Filter      map[string]interface{} `json:"filter"`
+}
+
+// handleVectorMultiSearch searches several collections at once, returning the
+// best results across all of them and the names of any which don't exist.
+func (app *App) handleVectorMultiSearch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if app.vectorService == nil {
+		handleError(w, req, errors.New("vector services are not enabled in the plugin settings"), http.StatusServiceUnavailable)
+		return
+	}
+	body := vectorMultiSearchRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		handleError(w, req, fmt.Errorf("decode request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	if body.Query == "" {
+		handleError(w, req, errors.New("`query` field is required"), http.StatusBadRequest)
+		return
+	}
+	if len(body.Collections) == 0 {
+		handleError(w, req, errors.New("`collections` field is required"), http.StatusBadRequest)
+		return
+	}
+	if body.TopK == 0 {
+		body.TopK = 10
+	}
+	results, err := app.vectorService.MultiSearch(req.Context(), body.Collections, body.Query, body.TopK, body.Filter)
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	bodyJSON, err := json.Marshal(results)
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	//nolint:errcheck // Just do our best to write.
+	w.Write(bodyJSON)
+}
+
+type vectorSearchByVectorRequest struct {
+	Vector     []float32              `json:"vector"`
+	Collection string                 `json:"collection"`
+	TopK       uint64                 `json:"topK"`
+	Filter     map[string]interface{} `json:"filter"`
+}
+
+// handleVectorSearchByVector searches a collection with a precomputed
+// embedding. The embedder isn't used, so searches keep working while it is
+// unavailable.
+func (app *App) handleVectorSearchByVector(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if app.vectorService == nil {
+		handleError(w, req, errors.New("vector services are not enabled in the plugin settings"), http.StatusServiceUnavailable)
+		return
+	}
+	body := vectorSearchByVectorRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		handleError(w, req, fmt.Errorf("decode request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	if len(body.Vector) == 0 {
+		handleError(w, req, errors.New("`vector` field is required"), http.StatusBadRequest)
+		return
+	}
+	if body.Collection == "" {
+		handleError(w, req, errors.New("`collection` field is required"), http.StatusBadRequest)
+		return
+	}
+	if body.TopK == 0 {
+		body.TopK = 10
+	}
+	results, err := app.vectorService.SearchByVector(req.Context(), body.Collection, body.Vector, body.TopK, body.Filter)
+	if errors.Is(err, vector.ErrDimensionMismatch) || errors.Is(err, store.ErrInvalidCollection) {
+		handleError(w, req, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	bodyJSON, err := json.Marshal(vectorSearchResponse{Results: results})
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	//nolint:errcheck // Just do our best to write.
+	w.Write(bodyJSON)
+}
+
+type vectorHybridSearchRequest struct {
+	Query string `json:"query"`
+	// Vector is the embedding of Query. If empty, Query is embedded.
+	Vector     []float32 `json:"vector"`
+	Collection string    `json:"collection"`
+	// Alpha weights vector similarity against keyword scoring, from 0 to 1.
+	// Defaults to 0.5.
+	Alpha  *float64               `json:"alpha"`
+	TopK   uint64                 `json:"topK"`
+	Filter map[string]interface{} `json:"filter"`
+}
+
+type vectorHybridSearchResponse struct {
+	Results []store.SearchResult `json:"results"`
+	// Hybrid is false if the store can't do hybrid searches, so a vector
+	// search was done instead.
+	Hybrid bool `json:"hybrid"`
+}
+
+// handleVectorHybridSearch searches a collection combining keyword scoring
+// with vector similarity, for stores which support it, and by vector
+// similarity alone otherwise.
+func (app *App) handleVectorHybridSearch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if app.vectorService == nil {
+		handleError(w, req, errors.New("vector services are not enabled in the plugin settings"), http.StatusServiceUnavailable)
+		return
+	}
+	body := vectorHybridSearchRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		handleError(w, req, fmt.Errorf("decode request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	if body.Query == "" {
+		handleError(w, req, errors.New("`query` field is required"), http.StatusBadRequest)
+		return
+	}
+	if body.Collection == "" {
+		handleError(w, req, errors.New("`collection` field is required"), http.StatusBadRequest)
+		return
+	}
+	alpha := 0.5
+	if body.Alpha != nil {
+		alpha = *body.Alpha
+	}
+	if alpha < 0 || alpha > 1 {
+		handleError(w, req, fmt.Errorf("`alpha` must be between 0 and 1, got %g", alpha), http.StatusBadRequest)
+		return
+	}
+	if body.TopK == 0 {
+		body.TopK = 10
+	}
+	results, hybrid, err := app.vectorService.HybridSearch(req.Context(), body.Collection, body.Query, body.Vector, alpha, body.TopK, body.Filter)
+	if errors.Is(err, vector.ErrDimensionMismatch) || errors.Is(err, store.ErrInvalidCollection) {
+		handleError(w, req, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	bodyJSON, err := json.Marshal(vectorHybridSearchResponse{Results: results, Hybrid: hybrid})
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	//nolint:errcheck // Just do our best to write.
+	w.Write(bodyJSON)
+}
+
+type embedRequest struct {
+	Text  string `json:"text"`
+	Model string `json:"model"`
+}
+
+type embedResponse struct {
+	Embedding []float32 `json:"embedding"`
+	Model     string    `json:"model"`
+	Dimension int       `json:"dimension"`
+}
+
+func (app *App) handleEmbed(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if app.vectorService == nil {
+		handleError(w, req, errors.New("no embedder configured, enable vector services in the plugin settings"), http.StatusBadRequest)
+		return
+	}
+	body := embedRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		handleError(w, req, fmt.Errorf("decode request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	if body.Text == "" {
+		handleError(w, req, errors.New("`text` field is required"), http.StatusBadRequest)
+		return
+	}
+	if body.Model == "" {
+		body.Model = app.settings.Vector.Model
+	}
+	embedding, err := app.vectorService.Embed(req.Context(), body.Model, body.Text)
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	bodyJSON, err := json.Marshal(embedResponse{
+		Embedding: embedding,
+		Model:     body.Model,
+		Dimension: len(embedding),
+	})
+	if err != nil {
+		handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	//nolint:errcheck // Just do our best to write.
+	w.Write(bodyJSON)
+}
+
 type llmGatewayResponseData struct {
 	Allowed       bool   `json:"allowed"`
 	LastUpdatedBy string `json:"lastUpdatedBy"`
@@ -297,6 +663,7 @@ func getLLMOptInState(ctx context.Context, settings *Settings) (llmGatewayRespon
 	// proxyReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s:%s", app.settings.GComToken))
 	proxyReq.SetBasicAuth(settings.Tenant, settings.GrafanaComAPIKey)
 	proxyReq.Header.Add("X-Scope-OrgID", settings.Tenant)
+	proxyReq.Header.Set("User-Agent", settings.userAgent())
 	proxyReq.Header.Set("Content-Type", "application/json")
 
 	httpClient := &http.Client{}
@@ -330,7 +697,7 @@ func (app *App) handleGetLLMOptInState(w http.ResponseWriter, req *http.Request)
 
 	llmState, err := getLLMOptInState(req.Context(), app.settings)
 	if err != nil {
-		handleError(w, err, http.StatusBadRequest)
+		handleError(w, req, err, http.StatusBadRequest)
 		return
 	}
 
@@ -343,7 +710,7 @@ func (app *App) handleGetLLMOptInState(w http.ResponseWriter, req *http.Request)
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(bodyJSON)
 	if err != nil {
-		handleError(w, fmt.Errorf("failed to write response body %w", err), http.StatusInternalServerError)
+		handleError(w, req, fmt.Errorf("failed to write response body %w", err), http.StatusInternalServerError)
 		return
 	}
 }
@@ -365,40 +732,40 @@ func (app *App) handleSaveLLMOptInState(w http.ResponseWriter, req *http.Request
 	// Read the request body
 	if req.Body == nil {
 		log.DefaultLogger.Warn("Request body is nil")
-		handleError(w, errors.New("request body required"), http.StatusBadRequest)
+		handleError(w, req, errors.New("request body required"), http.StatusBadRequest)
 		return
 	}
 	requestData := llmOptInState{}
 	defer func() {
 		if err := req.Body.Close(); err != nil {
-			handleError(w, fmt.Errorf("failed to close request body %w", err), http.StatusInternalServerError)
+			handleError(w, req, fmt.Errorf("failed to close request body %w", err), http.StatusInternalServerError)
 			return
 		}
 	}()
 	b, err := io.ReadAll(req.Body)
 	if err != nil {
-		handleError(w, fmt.Errorf("failed to read request body to bytes %w", err), http.StatusInternalServerError)
+		handleError(w, req, fmt.Errorf("failed to read request body to bytes %w", err), http.StatusInternalServerError)
 		return
 	}
 	err = json.Unmarshal(b, &requestData)
 	if err != nil {
-		handleError(w, fmt.Errorf("failed to unmarshal request body to JSON %w", err), http.StatusInternalServerError)
+		handleError(w, req, fmt.Errorf("failed to unmarshal request body to JSON %w", err), http.StatusInternalServerError)
 		return
 	}
 	if requestData.Allowed == nil {
-		handleError(w, errors.New("`allowed` field is required"), http.StatusBadRequest)
+		handleError(w, req, errors.New("`allowed` field is required"), http.StatusBadRequest)
 		return
 	}
 
 	user := httpadapter.UserFromContext(req.Context())
 
 	if user == nil || user.Email == "" {
-		handleError(w, fmt.Errorf("valid user not found (please sign in and retry)"), http.StatusUnauthorized)
+		handleError(w, req, fmt.Errorf("valid user not found (please sign in and retry)"), http.StatusUnauthorized)
 		return
 	}
 
 	if user.Role != "Admin" {
-		handleError(w, fmt.Errorf("only admins can change opt-in state for the Grafana managed LLM"), http.StatusForbidden)
+		handleError(w, req, fmt.Errorf("only admins can change opt-in state for the Grafana managed LLM"), http.StatusForbidden)
 		return
 	}
 
@@ -410,14 +777,14 @@ func (app *App) handleSaveLLMOptInState(w http.ResponseWriter, req *http.Request
 	// Prepare the request to llm-gateway
 	jsonData, err := json.Marshal(newOptInState)
 	if err != nil {
-		handleError(w, fmt.Errorf("failed to marshal plugin jsonData %w", err), http.StatusInternalServerError)
+		handleError(w, req, fmt.Errorf("failed to marshal plugin jsonData %w", err), http.StatusInternalServerError)
 		return
 	}
 
 	path := app.settings.LLMGateway.URL + "/vendor/api/v1/vendors/openai" // hard-coded to openai for now
 	proxyReq, err := http.NewRequestWithContext(req.Context(), "POST", path, bytes.NewReader(jsonData))
 	if err != nil {
-		handleError(w, fmt.Errorf("failed to create http request %w", err), http.StatusBadRequest)
+		handleError(w, req, fmt.Errorf("failed to create http request %w", err), http.StatusBadRequest)
 		return
 	}
 	// Basic auth for use with Grafana Cloud.
@@ -425,11 +792,12 @@ func (app *App) handleSaveLLMOptInState(w http.ResponseWriter, req *http.Request
 	// X-Scope-OrgID for use in local settings.
 	proxyReq.Header.Add("X-Scope-OrgID", app.settings.Tenant)
 	proxyReq.Header.Set("Content-Type", "application/json")
+	proxyReq.Header.Set("User-Agent", app.settings.userAgent())
 
 	httpClient := &http.Client{}
 	resp, err := httpClient.Do(proxyReq)
 	if err != nil {
-		handleError(w, fmt.Errorf("failed to send request to llm-gateway %w", err), http.StatusBadRequest)
+		handleError(w, req, fmt.Errorf("failed to send request to llm-gateway %w", err), http.StatusBadRequest)
 		return
 	}
 	defer resp.Body.Close()
@@ -438,10 +806,10 @@ func (app *App) handleSaveLLMOptInState(w http.ResponseWriter, req *http.Request
 		// parse the response body and return it
 		b, err := io.ReadAll(resp.Body)
 		if err != nil {
-			handleError(w, fmt.Errorf("failed to read response body to bytes %w", err), http.StatusInternalServerError)
+			handleError(w, req, fmt.Errorf("failed to read response body to bytes %w", err), http.StatusInternalServerError)
 			return
 		}
-		handleError(w, fmt.Errorf("failed to save state in llm-gateway: %s %s", resp.Status, string(b)), http.StatusInternalServerError)
+		handleError(w, req, fmt.Errorf("failed to save state in llm-gateway: %s %s", resp.Status, string(b)), http.StatusInternalServerError)
 		return
 	}
 	log.DefaultLogger.Debug("Saved state in llm-gateway", "status", resp.Status)
@@ -459,24 +827,73 @@ func (a *App) handleLLMState(w http.ResponseWriter, req *http.Request) {
 	case "POST":
 		a.handleSaveLLMOptInState(w, req)
 	default:
-		handleError(w, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		handleError(w, req, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
 		return
 	}
 }
 
+// handleProviderNotConfigured is used in place of the proxy when LLM support
+// is disabled, so callers get a clear error rather than a 404 or a request
+// forwarded to an empty URL.
+func handleProviderNotConfigured(w http.ResponseWriter, req *http.Request) {
+	handleError(w, req, errProviderNotConfigured, http.StatusServiceUnavailable)
+}
+
 // registerRoutes takes a *http.ServeMux and registers some HTTP handlers.
 func (a *App) registerRoutes(mux *http.ServeMux, settings Settings) {
-	switch settings.OpenAI.Provider {
-	case openAIProviderOpenAI:
-		mux.Handle("/openai/", newOpenAIProxy(settings))
-	case openAIProviderAzure:
-		mux.Handle("/openai/", newAzureOpenAIProxy(settings))
-	case openAIProviderGrafana:
-		mux.Handle("/openai/", newGrafanaOpenAIProxy(settings))
-	default:
+	newProxy := func(provider Provider, transport http.RoundTripper, openAI OpenAISettings) http.Handler {
+		timeouts := newEndpointTimeouts(openAI.TimeoutSeconds, settings.TimeoutsByEndpoint)
+		return timeouts.middleware(newProviderProxy(provider, providerProxyOptions{
+			transport:                 transport,
+			transformers:              &a.transformers,
+			forwardHeaders:            settings.ForwardHeaders,
+			stripHeaders:              settings.StripHeaders,
+			extraBodyFields:           openAI.ExtraBodyFields,
+			latency:                   &a.latency,
+			audit:                     a.audit,
+			translateCompletions:      openAI.TranslateCompletions,
+			maxResponseBytes:          settings.maxResponseBytes(),
+			userAgent:                 settings.userAgent(),
+			keepAlive:                 settings.StreamKeepAlive.interval(),
+			idleTimeout:               settings.streamIdleTimeout(),
+			compress:                  settings.CompressResponses,
+			structuredOutputsFallback: openAI.StructuredOutputsFallback,
+			trackStreamUsage:          a.budget != nil || a.audit != nil,
+		}))
+	}
+	var proxy http.Handler
+	switch {
+	case len(settings.LoadBalance) > 0:
+		handlers := make([]weightedHandler, 0, len(settings.LoadBalance))
+		for _, p := range settings.LoadBalance {
+			s := settings
+			s.OpenAI = p.OpenAI
+			handler := a.maintenance.middleware(p.Name, newProxy(newProvider(s, nil), nil, p.OpenAI))
+			handlers = append(handlers, weightedHandler{name: p.Name, weight: p.Weight, handler: handler})
+		}
+		// Models prefixed with a provider's name, such as `azure/gpt-4`, go
+		// straight to that provider; the rest are load balanced.
+		proxy = newModelRouter(handlers, newLoadBalancer(handlers))
+	case a.provider != nil:
+		proxy = a.maintenance.middleware(string(settings.OpenAI.Provider), newProxy(a.provider, a.providerTransport(), settings.OpenAI))
+	}
+	if proxy != nil {
+		// Disabled endpoints are rejected first, and windows for all
+		// providers apply before requests are queued.
+		mux.Handle("/openai/", a.endpoints.middleware(a.maintenance.middleware("", a.activeRequests.middleware(a.streamLimit.middleware(a.promptWarnings.middleware(a.idempotency.middleware(a.streamCoalescer.middleware(a.limiter.middleware(proxy)))))))))
+	} else {
 		log.DefaultLogger.Warn("Unknown OpenAI provider configured", "provider", settings.OpenAI.Provider)
+		mux.HandleFunc("/openai/", handleProviderNotConfigured)
 	}
 	mux.HandleFunc("/vector/search", a.handleVectorSearch)
+	mux.HandleFunc("/vector/multi-search", a.handleVectorMultiSearch)
+	mux.HandleFunc("/vector/search-by-vector", a.handleVectorSearchByVector)
+	mux.HandleFunc("/vector/hybrid-search", a.handleVectorHybridSearch)
+	mux.HandleFunc("/embed", a.handleEmbed)
 	mux.HandleFunc("/grafana-llm-state", a.handleLLMState)
+	mux.HandleFunc("/health/history", a.handleHealthHistory)
+	mux.HandleFunc("/settings/effective", a.handleEffectiveSettings)
+	mux.HandleFunc("/cache/stats", a.handleCacheStats)
+	mux.HandleFunc("/cancel", a.handleCancel)
 
 }
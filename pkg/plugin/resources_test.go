@@ -6,8 +6,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector"
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/store"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
@@ -246,6 +249,24 @@ func TestCallOpenAIProxy(t *testing.T) {
 
 			expStatus: http.StatusOK,
 		},
+		{
+			name: "disabled provider",
+
+			settings: Settings{
+				OpenAI: OpenAISettings{
+					Provider: "",
+				},
+			},
+
+			method: http.MethodPost,
+			path:   "/openai/v1/chat/completions",
+			body:   []byte(`{"model": "gpt-3.5-turbo", "messages": ["some stuff"]}`),
+
+			expNilRequest: true,
+
+			expStatus: http.StatusServiceUnavailable,
+			expBody:   []byte(`{"error":"LLM provider not configured"}`),
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx := context.Background()
@@ -328,3 +349,359 @@ func TestCallOpenAIProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestEmbed(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name     string
+		vService vector.Service
+
+		method string
+		body   []byte
+
+		expStatus int
+		expBody   embedResponse
+	}{
+		{
+			name:      "embeds text",
+			vService:  &mockVectorService{},
+			method:    http.MethodPost,
+			body:      []byte(`{"text": "what is the error rate?", "model": "text-embedding-3-small"}`),
+			expStatus: http.StatusOK,
+			expBody: embedResponse{
+				Embedding: []float32{0.1, 0.2, 0.3},
+				Model:     "text-embedding-3-small",
+				Dimension: 3,
+			},
+		},
+		{
+			name:      "no embedder configured",
+			method:    http.MethodPost,
+			body:      []byte(`{"text": "what is the error rate?"}`),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "missing text",
+			vService:  &mockVectorService{},
+			method:    http.MethodPost,
+			body:      []byte(`{"model": "text-embedding-3-small"}`),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "wrong method",
+			vService:  &mockVectorService{},
+			method:    http.MethodGet,
+			expStatus: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := NewApp(ctx, backend.AppInstanceSettings{})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			app.vectorService = tc.vService
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				Method: tc.method,
+				Path:   "/embed",
+				Body:   tc.body,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("response status should be %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if tc.expStatus != http.StatusOK {
+				return
+			}
+			var got embedResponse
+			if err := json.Unmarshal(r.response.Body, &got); err != nil {
+				t.Fatalf("unmarshal response: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.expBody) {
+				t.Errorf("response body should be %+v, got %+v", tc.expBody, got)
+			}
+		})
+	}
+}
+
+func TestVectorMultiSearch(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name     string
+		vService vector.Service
+		body     []byte
+
+		expStatus int
+		expBody   store.MultiSearchResult
+	}{
+		{
+			name:      "searches collections",
+			vService:  &mockVectorService{},
+			body:      []byte(`{"query": "what is the error rate?", "collections": ["docs", "runbooks"]}`),
+			expStatus: http.StatusOK,
+			expBody: store.MultiSearchResult{Results: []store.SearchResult{
+				{Payload: map[string]any{"a": "b"}, Score: 1.0, Collection: "docs"},
+				{Payload: map[string]any{"a": "b"}, Score: 1.0, Collection: "runbooks"},
+			}},
+		},
+		{
+			name:      "no vector service",
+			body:      []byte(`{"query": "what is the error rate?", "collections": ["docs"]}`),
+			expStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:      "missing collections",
+			vService:  &mockVectorService{},
+			body:      []byte(`{"query": "what is the error rate?"}`),
+			expStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := NewApp(ctx, backend.AppInstanceSettings{})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			app.vectorService = tc.vService
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				Method: http.MethodPost,
+				Path:   "/vector/multi-search",
+				Body:   tc.body,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("response status should be %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if tc.expStatus != http.StatusOK {
+				return
+			}
+			var got store.MultiSearchResult
+			if err := json.Unmarshal(r.response.Body, &got); err != nil {
+				t.Fatalf("unmarshal response: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.expBody) {
+				t.Errorf("response body should be %+v, got %+v", tc.expBody, got)
+			}
+		})
+	}
+}
+
+func TestVectorSearchByVector(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name     string
+		vService vector.Service
+		body     []byte
+
+		expStatus int
+		expBody   vectorSearchResponse
+	}{
+		{
+			name:      "searches collection",
+			vService:  &mockVectorService{},
+			body:      []byte(`{"vector": [0.1, 0.2, 0.3], "collection": "docs"}`),
+			expStatus: http.StatusOK,
+			expBody: vectorSearchResponse{Results: []store.SearchResult{
+				{Payload: map[string]any{"a": "b"}, Score: 1.0},
+			}},
+		},
+		{
+			name:      "wrong dimension",
+			vService:  &mockVectorService{},
+			body:      []byte(`{"vector": [0.1, 0.2], "collection": "docs"}`),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "missing vector",
+			vService:  &mockVectorService{},
+			body:      []byte(`{"collection": "docs"}`),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "no vector service",
+			body:      []byte(`{"vector": [0.1, 0.2, 0.3], "collection": "docs"}`),
+			expStatus: http.StatusServiceUnavailable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := NewApp(ctx, backend.AppInstanceSettings{})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			app.vectorService = tc.vService
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				Method: http.MethodPost,
+				Path:   "/vector/search-by-vector",
+				Body:   tc.body,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("response status should be %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if tc.expStatus != http.StatusOK {
+				return
+			}
+			var got vectorSearchResponse
+			if err := json.Unmarshal(r.response.Body, &got); err != nil {
+				t.Fatalf("unmarshal response: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.expBody) {
+				t.Errorf("response body should be %+v, got %+v", tc.expBody, got)
+			}
+		})
+	}
+}
+
+func TestVectorHybridSearch(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		body []byte
+
+		expStatus int
+		expBody   vectorHybridSearchResponse
+	}{
+		{
+			name:      "default alpha",
+			body:      []byte(`{"query": "error rate", "collection": "docs"}`),
+			expStatus: http.StatusOK,
+			expBody: vectorHybridSearchResponse{Hybrid: true, Results: []store.SearchResult{
+				{Payload: map[string]any{"a": "b"}, Score: 0.5},
+			}},
+		},
+		{
+			name:      "with embedding and alpha",
+			body:      []byte(`{"query": "error rate", "vector": [0.1, 0.2, 0.3], "alpha": 0, "collection": "docs"}`),
+			expStatus: http.StatusOK,
+			expBody: vectorHybridSearchResponse{Hybrid: true, Results: []store.SearchResult{
+				{Payload: map[string]any{"a": "b"}, Score: 0},
+			}},
+		},
+		{
+			name:      "alpha out of range",
+			body:      []byte(`{"query": "error rate", "alpha": 1.5, "collection": "docs"}`),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "wrong dimension",
+			body:      []byte(`{"query": "error rate", "vector": [0.1, 0.2], "collection": "docs"}`),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "missing query",
+			body:      []byte(`{"vector": [0.1, 0.2, 0.3], "collection": "docs"}`),
+			expStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := NewApp(ctx, backend.AppInstanceSettings{})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+			app.vectorService = &mockVectorService{}
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				Method: http.MethodPost,
+				Path:   "/vector/hybrid-search",
+				Body:   tc.body,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("response status should be %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if tc.expStatus != http.StatusOK {
+				return
+			}
+			var got vectorHybridSearchResponse
+			if err := json.Unmarshal(r.response.Body, &got); err != nil {
+				t.Fatalf("unmarshal response: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.expBody) {
+				t.Errorf("response body should be %+v, got %+v", tc.expBody, got)
+			}
+		})
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	ctx := context.Background()
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name      string
+		userAgent string
+
+		exp string
+	}{
+		{name: "default", exp: "grafana-llm-app/" + getVersion()},
+		{name: "configured", userAgent: "my-grafana/1.0", exp: "my-grafana/1.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			userAgent = ""
+			settings := Settings{
+				OpenAI:    OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+				UserAgent: tc.userAgent,
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Headers:       map[string][]string{"User-Agent": {"Grafana/10.0"}},
+				Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if userAgent != tc.exp {
+				t.Errorf("expected proxied request to have User-Agent %q, got %q", tc.exp, userAgent)
+			}
+
+			// Requests made by the plugin itself, such as for streams, use it too.
+			req, err := app.newOpenAIChatCompletionsRequest(ctx, map[string]interface{}{"model": "gpt-3.5-turbo"})
+			if err != nil {
+				t.Fatalf("new request: %s", err)
+			}
+			if got := req.Header.Get("User-Agent"); got != tc.exp {
+				t.Errorf("expected stream request to have User-Agent %q, got %q", tc.exp, got)
+			}
+		})
+	}
+}
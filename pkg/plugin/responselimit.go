@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxResponseBytes is the largest provider response the plugin will
+// buffer if no other limit is configured.
+const defaultMaxResponseBytes = 32 * 1024 * 1024
+
+var errResponseTooLarge = errors.New("response from provider is too large")
+
+// maxResponseBytes returns the configured response size limit, or the default.
+func (s Settings) maxResponseBytes() int64 {
+	if s.MaxResponseBytes <= 0 {
+		return defaultMaxResponseBytes
+	}
+	return s.MaxResponseBytes
+}
+
+// limitedBody is a response body which fails with errResponseTooLarge once
+// more than limit bytes have been read from it.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, fmt.Errorf("%w: more than %d bytes", errResponseTooLarge, b.limit)
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, fmt.Errorf("%w: more than %d bytes", errResponseTooLarge, b.limit)
+	}
+	return n, err
+}
+
+// limitResponseBody caps the size of a non-streamed response body, which the
+// proxy may buffer to read its usage or translate it. Streamed responses are
+// passed through incrementally, so are left alone.
+func limitResponseBody(resp *http.Response, limit int64) {
+	if limit <= 0 || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, limit: limit}
+}
@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestMaxResponseBytesStream(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Each chunk is about 100 bytes, so this is well over the limit.
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(w, "data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": %q}}]}\n\n", strings.Repeat("a", 50))
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI:           OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		MaxResponseBytes: 1000,
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	s := mockStreamPacketSender{messages: []json.RawMessage{}}
+	err = app.RunStream(ctx, &backend.RunStreamRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Path:          openAIChatCompletionsPath + "/abcd1234",
+		Data:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+	}, backend.NewStreamSender(&s))
+	if err != nil {
+		t.Fatalf("RunStream error: %s", err)
+	}
+	if n := len(s.messages); n == 0 || n > 15 {
+		t.Fatalf("expected the stream to be cut short, got %d messages", n)
+	}
+	var got EventError
+	if err := json.Unmarshal(s.messages[len(s.messages)-1], &got); err != nil {
+		t.Fatalf("got non-JSON error message %s", s.messages[len(s.messages)-1])
+	}
+	if !strings.Contains(got.Error, errResponseTooLarge.Error()) {
+		t.Errorf("expected error to mention %q, got %q", errResponseTooLarge, got.Error)
+	}
+}
+
+func TestMaxResponseBytesProxy(t *testing.T) {
+	big := strings.Repeat("a", 2000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Stream bool `json:"stream"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: content-delta\ndata: {\"type\": \"content-delta\", \"delta\": {\"message\": {\"content\": {\"text\": %q}}}}\n\n", big)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id": "abc", "finish_reason": "COMPLETE", "message": {"role": "assistant", "content": [{"type": "text", "text": %q}]}}`, big)
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name   string
+		stream bool
+
+		expStatus int
+	}{
+		// The Cohere provider buffers responses to translate them.
+		{name: "buffered response over the limit", expStatus: http.StatusBadGateway},
+		{name: "streamed response is unaffected", stream: true, expStatus: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &cohereProvider{settings: OpenAISettings{Provider: openAIProviderCohere, URL: server.URL}}
+			proxy := newProviderProxy(provider, providerProxyOptions{maxResponseBytes: 1000})
+			body := fmt.Sprintf(`{"model": "command-r", "messages": [], "stream": %t}`, tc.stream)
+			req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, w.Code, w.Body)
+			}
+			if tc.expStatus != http.StatusOK {
+				if !strings.Contains(w.Body.String(), errResponseTooLarge.Error()) {
+					t.Errorf("expected error to mention %q, got %s", errResponseTooLarge, w.Body)
+				}
+				return
+			}
+			if !strings.Contains(w.Body.String(), big) {
+				t.Errorf("expected the full streamed response, got %d bytes", w.Body.Len())
+			}
+		})
+	}
+}
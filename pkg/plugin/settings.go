@@ -1,9 +1,14 @@
 package plugin
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/grafana/grafana-llm-app/pkg/plugin/vector"
@@ -12,7 +17,21 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
-const openAIKey = "openAIKey"
+// defaultAzureAPIVersion is the Azure OpenAI API version used if none is configured.
+const defaultAzureAPIVersion = "2024-02-01"
+
+// azureAPIVersionPattern matches Azure OpenAI API versions, such as
+// 2024-02-01 or 2024-05-01-preview.
+var azureAPIVersionPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(-preview)?$`)
+
+// openAIKey is the secure setting holding the provider's API key, which is
+// shared with the vector embedder.
+const openAIKey = embed.OpenAIKeySecret
+
+// legacyAPIKeyKey is the secure setting which held the provider's API key
+// before it was renamed to openAIKey. It is still read if openAIKey is empty.
+const legacyAPIKeyKey = "apiKey"
+
 const encodedTenantAndTokenKey = "base64EncodedAccessToken"
 
 type openAIProvider string
@@ -21,6 +40,7 @@ const (
 	openAIProviderOpenAI  openAIProvider = "openai"
 	openAIProviderAzure   openAIProvider = "azure"
 	openAIProviderGrafana openAIProvider = "grafana" // via llm-gateway
+	openAIProviderCohere  openAIProvider = "cohere"
 )
 
 // OpenAISettings contains the user-specified OpenAI connection details
@@ -37,9 +57,89 @@ type OpenAISettings struct {
 	// Model mappings required for Azure's OpenAI
 	AzureMapping [][]string `json:"azureModelMapping"`
 
+	// ExtraBodyFields are added to the body of every request sent to the
+	// provider, after it has been translated into the provider's format, for
+	// provider-specific options such as routing. Fields already in the body
+	// are never overridden.
+	ExtraBodyFields map[string]interface{} `json:"extraBodyFields"`
+
+	// TranslateCompletions sends requests to the legacy completions endpoint
+	// to the chat completions endpoint instead, with the prompt as a single
+	// user message. This always happens for providers without the legacy
+	// endpoint.
+	TranslateCompletions bool `json:"translateCompletions"`
+
+	// DisableLogprobs removes the `logprobs` and `top_logprobs` fields from
+	// requests, for providers (such as some self-hosted models) which reject
+	// them. Cohere never receives them.
+	DisableLogprobs bool `json:"disableLogprobs"`
+
+	// StructuredOutputsFallback is what happens to structured outputs
+	// requests sent to providers which don't support them, such as Cohere.
+	// Defaults to strip.
+	StructuredOutputsFallback StructuredOutputsFallback `json:"structuredOutputsFallback"`
+
+	// The Azure OpenAI API version, sent as the api-version query parameter.
+	// Defaults to defaultAzureAPIVersion, or defaultAzureFoundryAPIVersion
+	// with the Foundry endpoint style.
+	AzureAPIVersion string `json:"azureApiVersion"`
+
+	// AzureEndpointStyle is the shape of the Azure endpoint at URL. Defaults
+	// to classic.
+	AzureEndpointStyle AzureEndpointStyle `json:"azureEndpointStyle"`
+
+	// TimeoutSeconds limits how long requests to the provider may take,
+	// including streamed responses. Zero, the default, means no timeout.
+	TimeoutSeconds int `json:"timeoutSeconds"`
+
+	// KeyRotation is how requests are spread between the provider's API
+	// keys, if it has several. Defaults to round-robin.
+	KeyRotation KeyRotation `json:"keyRotation"`
+
+	// HealthCheckMethod is how health checks probe the provider. Defaults to
+	// chat, sending each health check model a chat completions request.
+	HealthCheckMethod HealthCheckMethod `json:"healthCheckMethod"`
+
 	// apiKey is the user-specified  api key needed to authenticate requests to the OpenAI
-	// provider (excluding the LLMGateway). Stored securely.
+	// provider (excluding the LLMGateway). Stored securely. If there are
+	// several keys it is the first.
 	apiKey string
+
+	// apiKeys rotates between the keys if there are several. It is shared by
+	// copies of the settings.
+	apiKeys *apiKeyPool
+
+	// embeddingsURL, if set, is used instead of URL for embeddings requests.
+	// It is copied from the vector embedder's EmbeddingsURL setting.
+	embeddingsURL string
+}
+
+// urlFor returns the URL of the provider to send a request for path to.
+func (s OpenAISettings) urlFor(path string) string {
+	if s.embeddingsURL != "" && isEmbeddingsPath(path) {
+		return s.embeddingsURL
+	}
+	return s.URL
+}
+
+// key returns the API key to use for the next request.
+func (s OpenAISettings) key() string {
+	if s.apiKeys == nil {
+		return s.apiKey
+	}
+	return s.apiKeys.key()
+}
+
+// validateAzureAPIVersion returns an error if the configured Azure API
+// version is missing or malformed.
+func (s OpenAISettings) validateAzureAPIVersion() error {
+	if s.AzureAPIVersion == "" {
+		return errors.New("Azure API version is not configured")
+	}
+	if !azureAPIVersionPattern.MatchString(s.AzureAPIVersion) {
+		return fmt.Errorf("invalid Azure API version %q, expected a version such as %s", s.AzureAPIVersion, defaultAzureAPIVersion)
+	}
+	return nil
 }
 
 // LLMGatewaySettings contains the configuration for the Grafana Managed Key LLM solution.
@@ -47,6 +147,24 @@ type LLMGatewaySettings struct {
 	// This is the URL of the LLM endpoint of the machine learning backend which proxies
 	// the request to our llm-gateway. If empty, the gateway is disabled.
 	URL string `json:"url"`
+
+	// URLs optionally lists additional regional llm-gateway endpoints to fail over
+	// to, in order of preference. If URL is set it is always treated as the primary.
+	URLs []string `json:"urls"`
+}
+
+// endpoints returns all configured llm-gateway URLs, primary first.
+func (s LLMGatewaySettings) endpoints() []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, u := range append([]string{s.URL}, s.URLs...) {
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	return urls
 }
 
 // Settings contains the plugin's settings and secrets required by the plugin backend.
@@ -70,12 +188,218 @@ type Settings struct {
 
 	// LLMGateway provides Grafana-managed OpenAI.
 	LLMGateway LLMGatewaySettings `json:"llmGateway"`
+
+	// Budget limits the number of tokens a tenant can use per day.
+	Budget BudgetSettings `json:"budget"`
+
+	// CostEstimate configures reporting the estimated cost of each request.
+	CostEstimate CostEstimateSettings `json:"costEstimate"`
+
+	// RateLimit limits the rate of chat completions requests, per model.
+	RateLimit RateLimitSettings `json:"rateLimit"`
+
+	// Idempotency configures deduplication of requests with an Idempotency-Key header.
+	Idempotency IdempotencySettings `json:"idempotency"`
+
+	// ForwardHeaders lists the headers copied from incoming requests to the
+	// provider, in addition to those needed for the request to work (Accept,
+	// Content-Type and Idempotency-Key). All other headers are dropped.
+	// Hop-by-hop headers are never forwarded.
+	ForwardHeaders []string `json:"forwardHeaders"`
+
+	// LoadBalance, if set, splits proxied requests between these providers
+	// at random, in proportion to their weights, instead of sending them to
+	// the provider above. Health checks, streams and request checks such as
+	// vision support still use the provider above, though health checks can
+	// also check these with CheckAllProviders.
+	LoadBalance []WeightedProvider `json:"loadBalance"`
+
+	// TenantOverrides replaces the provider above for particular tenants, by
+	// their stack ID, so that shared provisioning can give stacks their own
+	// providers and keys.
+	TenantOverrides map[string]ProviderConfig `json:"tenantOverrides"`
+
+	// StripHeaders lists headers which are never sent to the provider, even
+	// if in ForwardHeaders, in addition to Cookie, Authorization and
+	// X-Grafana-*. A name ending in `*` matches all headers starting with
+	// the rest of it.
+	StripHeaders []string `json:"stripHeaders"`
+
+	// Audit configures the audit log of which users made LLM calls.
+	Audit AuditSettings `json:"audit"`
+
+	// BlockedPatterns are regular expressions which user prompts must not
+	// match. They are case-insensitive unless they start with `(?-i)`.
+	BlockedPatterns []string `json:"blockedPatterns"`
+
+	// ContextLimit configures rejecting requests too long for their model's
+	// context window.
+	ContextLimit ContextLimitSettings `json:"contextLimit"`
+
+	// Moderation configures checking prompts with a moderation endpoint,
+	// which may be a different service from the provider.
+	Moderation ModerationSettings `json:"moderation"`
+
+	// TimeoutsByEndpoint gives proxy endpoints, as paths relative to /openai
+	// such as /v1/embeddings, their own timeout in seconds, overriding the
+	// provider's TimeoutSeconds, with zero meaning no timeout. A trailing `*`
+	// matches any path starting with the rest.
+	TimeoutsByEndpoint map[string]int `json:"timeoutsByEndpoint"`
+
+	// EnabledEndpoints lists the proxy endpoints which may be called, as
+	// paths relative to /openai such as /v1/chat/completions, with a trailing
+	// `*` matching any path starting with the rest. Requests to other
+	// endpoints are rejected with HTTP 403. Empty enables all endpoints.
+	EnabledEndpoints []string `json:"enabledEndpoints"`
+
+	// MaintenanceWindows are periods during which requests are answered with
+	// a maintenance message instead of being sent to the provider.
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows"`
+
+	// Warmup configures keeping connections to the provider open.
+	Warmup WarmupSettings `json:"warmup"`
+
+	// StreamKeepAlive configures keep-alive comments in streamed responses.
+	StreamKeepAlive StreamKeepAliveSettings `json:"streamKeepAlive"`
+
+	// CompressResponses gzips non-streamed responses from the provider for
+	// clients which send `Accept-Encoding: gzip`.
+	CompressResponses bool `json:"compressResponses"`
+
+	// ForceModel, if set, replaces the model of every chat completions
+	// request, whatever the client asked for.
+	ForceModel string `json:"forceModel"`
+
+	// DefaultParams are chat completions parameters, such as temperature,
+	// applied to requests which don't specify them.
+	DefaultParams map[string]interface{} `json:"defaultParams"`
+
+	// BodyTransforms are rules setting or removing values in chat and legacy
+	// completions request bodies, applied in order after DefaultParams.
+	BodyTransforms []BodyTransform `json:"bodyTransforms"`
+
+	// MaxMessages is the maximum number of messages in a chat completions
+	// request, or zero for no limit. MessageOverflow controls what happens to
+	// requests with more.
+	MaxMessages     int             `json:"maxMessages"`
+	MessageOverflow MessageOverflow `json:"messageOverflow"`
+
+	// HealthHistorySize is the number of recent health check results kept for
+	// the /health/history endpoint. Defaults to 100.
+	HealthHistorySize int `json:"healthHistorySize"`
+
+	// HealthCheckPrompt is the prompt health checks send to each model, for
+	// providers whose guardrails react badly to the default of "Hello".
+	HealthCheckPrompt string `json:"healthCheckPrompt"`
+
+	// HealthStaleWhileRevalidate makes health checks return the last result
+	// immediately, whether or not it was successful, while refreshing it in
+	// the background. Only the first check waits for the result.
+	HealthStaleWhileRevalidate bool `json:"healthStaleWhileRevalidate"`
+
+	// HealthPersistence keeps the last successful health check result on
+	// disk, so that new instances report it while their first check runs.
+	HealthPersistence HealthPersistenceSettings `json:"healthPersistence"`
+
+	// AdaptiveHealthChecks makes health check results expire, after an
+	// interval which adapts to how reliable each feature has been.
+	AdaptiveHealthChecks AdaptiveHealthCheckSettings `json:"adaptiveHealthChecks"`
+
+	// CheckAllProviders makes health checks also check each of the
+	// LoadBalance providers, concurrently, so that operators know they're all
+	// ready. Off by default, since each check costs a request per model.
+	CheckAllProviders bool `json:"checkAllProviders"`
+
+	// StreamIdleTimeoutSeconds is how long a streamed response may go without
+	// data from the provider, once it has started, before it is aborted with
+	// an error event. Defaults to 60 seconds.
+	StreamIdleTimeoutSeconds int `json:"streamIdleTimeoutSeconds"`
+
+	// MaxResponseBytes is the largest provider response the plugin will
+	// buffer, for example to aggregate a stream. Larger responses fail.
+	// Defaults to 32 MiB.
+	MaxResponseBytes int64 `json:"maxResponseBytes"`
+
+	// MaxConcurrentRequests limits the number of requests to the provider in
+	// flight at once, or zero for no limit. Requests over the limit wait, with
+	// those sent with `X-LLM-Priority: low` waiting for all others.
+	MaxConcurrentRequests int `json:"maxConcurrentRequests"`
+
+	// MaxConcurrentStreams limits the number of streamed responses open at
+	// once, across proxied requests and Grafana Live streams, or zero for no
+	// limit. StreamOverflow controls what happens to proxied streaming
+	// requests over the limit; Live streams over it always fail.
+	MaxConcurrentStreams int            `json:"maxConcurrentStreams"`
+	StreamOverflow       StreamOverflow `json:"streamOverflow"`
+
+	// StreamCoalesce makes identical proxied streaming completions requests
+	// made at the same time share one upstream stream, each receiving a copy
+	// of every chunk. Only the first of them reaches the provider, so the
+	// others aren't rate limited, costed or audited separately.
+	StreamCoalesce bool `json:"streamCoalesce"`
+
+	// PromptWarnings adds warnings about questionable completions requests,
+	// such as those with a very high temperature, to their responses.
+	PromptWarnings PromptWarningSettings `json:"promptWarnings"`
+
+	// UserAgent is the User-Agent header sent with requests to the provider
+	// and vector services. Defaults to grafana-llm-app/<plugin version>.
+	UserAgent string `json:"userAgent"`
+
+	// fingerprint identifies the settings (including secrets) these were loaded
+	// from, so that changes can be detected.
+	fingerprint string
+
+	// secretNames are the names of the non-empty secrets the settings were
+	// loaded with, so they can be reported without their values.
+	secretNames []string
+}
+
+// settingsFingerprint returns a hash of the raw app settings and secrets.
+func settingsFingerprint(appSettings backend.AppInstanceSettings) string {
+	h := sha256.New()
+	h.Write(appSettings.JSONData)
+	keys := make([]string, 0, len(appSettings.DecryptedSecureJSONData))
+	for k := range appSettings.DecryptedSecureJSONData {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// Separate entries with NUL bytes so keys and values can't run together.
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(appSettings.DecryptedSecureJSONData[k]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// userAgent returns the User-Agent header to send with outbound requests.
+func (s Settings) userAgent() string {
+	if s.UserAgent != "" {
+		return s.UserAgent
+	}
+	return "grafana-llm-app/" + getVersion()
+}
+
+// migrateSecrets returns secrets with the provider's API key copied from the
+// legacy apiKey secret to openAIKey if only the former is set, and whether it
+// was. secrets itself is never modified.
+func migrateSecrets(secrets map[string]string) (map[string]string, bool) {
+	if secrets[openAIKey] != "" || secrets[legacyAPIKeyKey] == "" {
+		return secrets, false
+	}
+	migrated := make(map[string]string, len(secrets)+1)
+	for name, value := range secrets {
+		migrated[name] = value
+	}
+	migrated[openAIKey] = secrets[legacyAPIKeyKey]
+	return migrated, true
 }
 
 func loadSettings(appSettings backend.AppInstanceSettings) (*Settings, error) {
 	settings := Settings{
 		OpenAI: OpenAISettings{
-			URL:      "https://api.openai.com",
 			Provider: openAIProviderOpenAI,
 		},
 	}
@@ -93,10 +417,18 @@ func loadSettings(appSettings backend.AppInstanceSettings) (*Settings, error) {
 	// an empty string.
 	if settings.OpenAI.URL == "" {
 		settings.OpenAI.URL = "https://api.openai.com"
+		if settings.OpenAI.Provider == openAIProviderCohere {
+			settings.OpenAI.URL = defaultCohereURL
+		}
 	}
-	if settings.Vector.Embed.Type == embed.EmbedderOpenAI {
-		settings.Vector.Embed.OpenAI.URL = settings.OpenAI.URL
-		settings.Vector.Embed.OpenAI.AuthType = "openai-key-auth"
+	// Embeddings requests made through the proxy go to the same place.
+	settings.OpenAI.embeddingsURL = settings.Vector.Embed.EmbeddingsURL
+	settings.Vector.Embed.UserAgent = settings.userAgent()
+	settings.Vector.Store.UserAgent = settings.userAgent()
+
+	// If only a list of gateway URLs was provided, treat the first as the primary.
+	if settings.LLMGateway.URL == "" && len(settings.LLMGateway.URLs) > 0 {
+		settings.LLMGateway.URL = settings.LLMGateway.URLs[0]
 	}
 
 	// Fallback logic if no LLMGateway URL provided by the provisioning/GCom.
@@ -107,6 +439,10 @@ func loadSettings(appSettings backend.AppInstanceSettings) (*Settings, error) {
 	switch settings.OpenAI.Provider {
 	case openAIProviderOpenAI:
 	case openAIProviderAzure:
+		if err := loadAzureSettings(&settings.OpenAI); err != nil {
+			return nil, err
+		}
+	case openAIProviderCohere:
 	case openAIProviderGrafana:
 		if settings.LLMGateway.URL == "" {
 			// llm-gateway not available, this provider is invalid so switch to disabled
@@ -119,8 +455,36 @@ func loadSettings(appSettings backend.AppInstanceSettings) (*Settings, error) {
 		settings.OpenAI.Provider = ""
 	}
 
+	for name, value := range appSettings.DecryptedSecureJSONData {
+		if value != "" {
+			settings.secretNames = append(settings.secretNames, name)
+		}
+	}
+	sort.Strings(settings.secretNames)
+
 	// Read user's OpenAI key & the LLMGateway key
-	settings.OpenAI.apiKey = appSettings.DecryptedSecureJSONData[openAIKey]
+	if err := settings.OpenAI.KeyRotation.validate(); err != nil {
+		return nil, err
+	}
+	if err := settings.OpenAI.HealthCheckMethod.validate(); err != nil {
+		return nil, err
+	}
+	if err := settings.OpenAI.StructuredOutputsFallback.validate(); err != nil {
+		return nil, err
+	}
+	secrets, migrated := migrateSecrets(appSettings.DecryptedSecureJSONData)
+	if migrated {
+		log.DefaultLogger.Warn("The apiKey secure setting is deprecated, save the key as openAIKey instead")
+	}
+	keysSecret := openAIKey
+	if secrets[openAIKeysKey] != "" {
+		keysSecret = openAIKeysKey
+	}
+	loadAPIKeys(&settings.OpenAI, secrets[keysSecret])
+	loadModeration(&settings.Moderation, secrets)
+	if err := loadWeightedProviders(settings.LoadBalance, secrets); err != nil {
+		return nil, err
+	}
 
 	// TenantID and GrafanaCom token are combined as "tenantId:GComToken" and base64 encoded, the following undoes that.
 	encodedTenantAndToken, ok := appSettings.DecryptedSecureJSONData[encodedTenantAndTokenKey]
@@ -144,5 +508,20 @@ func loadSettings(appSettings backend.AppInstanceSettings) (*Settings, error) {
 		}
 	}
 
+	if err := settings.applyTenantOverride(secrets); err != nil {
+		return nil, err
+	}
+	// The embedder follows the provider, so this comes after the tenant
+	// override has been applied.
+	if settings.Vector.Embed.Type == embed.EmbedderOpenAI {
+		settings.Vector.Embed.OpenAI.URL = settings.OpenAI.URL
+		if settings.Vector.Embed.EmbeddingsURL != "" {
+			settings.Vector.Embed.OpenAI.URL = settings.Vector.Embed.EmbeddingsURL
+		}
+		settings.Vector.Embed.OpenAI.AuthType = "openai-key-auth"
+	}
+	settings.Vector.Store.Tenant = settings.Tenant
+
+	settings.fingerprint = settingsFingerprint(appSettings)
 	return &settings, nil
 }
@@ -145,3 +145,126 @@ func TestManagedLLMSettingsLogic(t *testing.T) {
 		})
 	}
 }
+
+func TestProviderURLDefaults(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		jsonData string
+		expURL   string
+	}{
+		{
+			name:     "openai",
+			jsonData: `{"openAI": {"provider": "openai"}}`,
+			expURL:   "https://api.openai.com",
+		},
+		{
+			name:     "cohere",
+			jsonData: `{"openAI": {"provider": "cohere", "url": ""}}`,
+			expURL:   defaultCohereURL,
+		},
+		{
+			name:     "cohere custom url",
+			jsonData: `{"openAI": {"provider": "cohere", "url": "https://cohere.example.com"}}`,
+			expURL:   "https://cohere.example.com",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := loadSettings(backend.AppInstanceSettings{JSONData: []byte(tc.jsonData)})
+			if err != nil {
+				t.Fatalf("loadSettings failed: %s", err)
+			}
+			if settings.OpenAI.URL != tc.expURL {
+				t.Errorf("expected URL %s, got %s", tc.expURL, settings.OpenAI.URL)
+			}
+		})
+	}
+}
+
+func TestLegacyAPIKey(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		secrets map[string]string
+		expKey  string
+	}{
+		{
+			name:    "only legacy key",
+			secrets: map[string]string{legacyAPIKeyKey: "legacy"},
+			expKey:  "legacy",
+		},
+		{
+			name:    "both keys",
+			secrets: map[string]string{legacyAPIKeyKey: "legacy", openAIKey: "current"},
+			expKey:  "current",
+		},
+		{
+			name:    "empty key",
+			secrets: map[string]string{legacyAPIKeyKey: "legacy", openAIKey: ""},
+			expKey:  "legacy",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := loadSettings(backend.AppInstanceSettings{
+				JSONData:                []byte(`{"openAI": {"provider": "openai"}}`),
+				DecryptedSecureJSONData: tc.secrets,
+			})
+			if err != nil {
+				t.Fatalf("loadSettings failed: %s", err)
+			}
+			if got := settings.OpenAI.key(); got != tc.expKey {
+				t.Errorf("expected key %q, got %q", tc.expKey, got)
+			}
+		})
+	}
+
+	secrets := map[string]string{legacyAPIKeyKey: "legacy"}
+	migrateSecrets(secrets)
+	if secrets[openAIKey] != "" {
+		t.Error("expected the secrets not to be modified")
+	}
+}
+
+func TestSettingsFingerprint(t *testing.T) {
+	base := backend.AppInstanceSettings{
+		JSONData:                []byte(`{"openAI": {"provider": "openai"}}`),
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	for _, tc := range []struct {
+		name     string
+		settings backend.AppInstanceSettings
+		expSame  bool
+	}{
+		{
+			name:     "identical settings",
+			settings: base,
+			expSame:  true,
+		},
+		{
+			name: "different provider",
+			settings: backend.AppInstanceSettings{
+				JSONData:                []byte(`{"openAI": {"provider": "azure"}}`),
+				DecryptedSecureJSONData: base.DecryptedSecureJSONData,
+			},
+		},
+		{
+			name: "different secret",
+			settings: backend.AppInstanceSettings{
+				JSONData:                base.JSONData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "efgh5678"},
+			},
+		},
+		{
+			name: "secret key and value run together",
+			settings: backend.AppInstanceSettings{
+				JSONData:                base.JSONData,
+				DecryptedSecureJSONData: map[string]string{openAIKey + "abcd": "1234"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			same := settingsFingerprint(base) == settingsFingerprint(tc.settings)
+			if same != tc.expSame {
+				t.Errorf("expected fingerprints to match: %t, got %t", tc.expSame, same)
+			}
+		})
+	}
+}
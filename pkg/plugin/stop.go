@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// StopFormat is the form of the `stop` parameter a provider accepts.
+type StopFormat int
+
+const (
+	// StopFormatAny means the provider accepts `stop` as either a string or an
+	// array of strings, so it is passed through unchanged.
+	StopFormatAny StopFormat = iota
+	// StopFormatArray means `stop` must be an array of strings.
+	StopFormatArray
+	// StopFormatString means `stop` must be a single string.
+	StopFormatString
+)
+
+// ProviderCapabilities describes the request shapes a provider accepts, so the
+// proxy can normalize requests before sending them.
+type ProviderCapabilities struct {
+	// StopFormat is the form of the `stop` parameter the provider accepts.
+	StopFormat StopFormat
+	// LegacyCompletions is whether the provider serves the legacy `prompt`
+	// based completions endpoint. If not, such requests are translated into
+	// chat completions requests.
+	LegacyCompletions bool
+	// Logprobs is whether the provider accepts the `logprobs` and
+	// `top_logprobs` fields. If not, they are removed from requests.
+	Logprobs bool
+	// AudioTranscriptions is whether the provider serves the multipart
+	// audio transcriptions endpoint. If not, such requests are rejected.
+	AudioTranscriptions bool
+	// ModelsList is whether the provider serves a list of models, which
+	// health checks can request instead of chat completions.
+	ModelsList bool
+	// StructuredOutputs is whether the provider accepts a `json_schema`
+	// response format. If not, it is removed from requests, and optionally
+	// replaced with an instruction to follow the schema.
+	StructuredOutputs bool
+	// StreamUsage is whether the provider accepts
+	// `stream_options.include_usage`, reporting the usage of streamed
+	// responses in a final chunk.
+	StreamUsage bool
+}
+
+// normalizeChatBody adapts a chat completions request body to a provider with
+// the given capabilities, removing or rewriting the fields it doesn't accept.
+func normalizeChatBody(body []byte, capabilities ProviderCapabilities, fallback StructuredOutputsFallback) ([]byte, error) {
+	body, err := normalizeStop(body, capabilities.StopFormat)
+	if err != nil {
+		return nil, err
+	}
+	if !capabilities.Logprobs {
+		body, err = stripLogprobs(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !capabilities.StructuredOutputs {
+		body, err = stripStructuredOutputs(body, fallback)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// normalizeStop coerces the `stop` parameter of a chat completions request body
+// to the given format. The body is only re-encoded if it needed changing.
+func normalizeStop(body []byte, format StopFormat) ([]byte, error) {
+	if format == StopFormatAny {
+		return body, nil
+	}
+	var requestBody map[string]interface{}
+	if err := json.Unmarshal(body, &requestBody); err != nil {
+		return nil, fmt.Errorf("unmarshal request body: %w", err)
+	}
+	stop, ok := requestBody["stop"]
+	if !ok || stop == nil {
+		return body, nil
+	}
+
+	switch s := stop.(type) {
+	case string:
+		if format == StopFormatString {
+			return body, nil
+		}
+		requestBody["stop"] = []string{s}
+	case []interface{}:
+		if format == StopFormatArray {
+			return body, nil
+		}
+		switch len(s) {
+		case 0:
+			delete(requestBody, "stop")
+		case 1:
+			requestBody["stop"] = s[0]
+		default:
+			return nil, fmt.Errorf("provider only supports a single stop sequence, got %d", len(s))
+		}
+	default:
+		return nil, fmt.Errorf("stop must be a string or an array of strings, got %T", stop)
+	}
+
+	captureOrderedFields(body).restore(requestBody)
+	newBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return newBody, nil
+}
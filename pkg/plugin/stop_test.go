@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeStop(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		format StopFormat
+
+		expBody string
+		expErr  bool
+	}{
+		{
+			name:    "any leaves string unchanged",
+			body:    `{"stop": "\n"}`,
+			format:  StopFormatAny,
+			expBody: `{"stop": "\n"}`,
+		},
+		{
+			name:    "any leaves array unchanged",
+			body:    `{"stop": ["a", "b"]}`,
+			format:  StopFormatAny,
+			expBody: `{"stop": ["a", "b"]}`,
+		},
+		{
+			name:    "string to array",
+			body:    `{"model": "gpt-4", "stop": "\n"}`,
+			format:  StopFormatArray,
+			expBody: `{"model":"gpt-4","stop":["\n"]}`,
+		},
+		{
+			name:    "array already an array",
+			body:    `{"stop": ["a", "b"]}`,
+			format:  StopFormatArray,
+			expBody: `{"stop": ["a", "b"]}`,
+		},
+		{
+			name:    "single element array to string",
+			body:    `{"model": "gpt-4", "stop": ["a"]}`,
+			format:  StopFormatString,
+			expBody: `{"model":"gpt-4","stop":"a"}`,
+		},
+		{
+			name:    "string already a string",
+			body:    `{"stop": "a"}`,
+			format:  StopFormatString,
+			expBody: `{"stop": "a"}`,
+		},
+		{
+			name:    "empty array removed for string providers",
+			body:    `{"model": "gpt-4", "stop": []}`,
+			format:  StopFormatString,
+			expBody: `{"model":"gpt-4"}`,
+		},
+		{
+			name:   "multiple stop sequences for string providers",
+			body:   `{"stop": ["a", "b"]}`,
+			format: StopFormatString,
+			expErr: true,
+		},
+		{
+			name:    "no stop",
+			body:    `{"model": "gpt-4"}`,
+			format:  StopFormatArray,
+			expBody: `{"model": "gpt-4"}`,
+		},
+		{
+			name:   "invalid stop",
+			body:   `{"stop": 1}`,
+			format: StopFormatArray,
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := normalizeStop([]byte(tc.body), tc.format)
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("expected error, got body %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(got) != tc.expBody {
+				t.Errorf("expected body %s, got %s", tc.expBody, got)
+			}
+		})
+	}
+}
+
+// arrayStopProvider is an OpenAI provider which only accepts `stop` as an array.
+type arrayStopProvider struct {
+	directOpenAIProvider
+}
+
+func (p *arrayStopProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{StopFormat: StopFormatArray}
+}
+
+func TestProxyNormalizesStop(t *testing.T) {
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	provider := &arrayStopProvider{directOpenAIProvider{settings: OpenAISettings{URL: server.URL}}}
+	proxy := newProviderProxy(provider, providerProxyOptions{})
+	for _, tc := range []struct {
+		name string
+		body string
+
+		expBody string
+	}{
+		{
+			name:    "string",
+			body:    `{"model":"gpt-4","stop":"\n\n"}`,
+			expBody: `{"model":"gpt-4","stop":["\n\n"]}`,
+		},
+		{
+			name:    "array",
+			body:    `{"model":"gpt-4","stop":["\n\n","END"]}`,
+			expBody: `{"model":"gpt-4","stop":["\n\n","END"]}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", bytes.NewReader([]byte(tc.body)))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+			if string(upstreamBody) != tc.expBody {
+				t.Errorf("expected upstream body %s, got %s", tc.expBody, upstreamBody)
+			}
+		})
+	}
+}
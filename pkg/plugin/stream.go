@@ -1,9 +1,12 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -26,33 +29,71 @@ func (a *App) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamR
 }
 
 func (a *App) runOpenAIChatCompletionsStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	if err := a.endpoints.check(openAIChatCompletionsPath); err != nil {
+		return fmt.Errorf("proxy: stream: %w", err)
+	}
+	if message, _, ok := a.maintenance.active("", string(a.settings.OpenAI.Provider)); ok {
+		return fmt.Errorf("proxy: stream: %s", message)
+	}
 
-	requestBody := map[string]interface{}{}
-	var err error
-	err = json.Unmarshal(req.Data, &requestBody)
+	// Live streams are always rejected over the limit, rather than
+	// downgraded, since the frontend expects events.
+	if !a.streamLimit.acquire() {
+		return fmt.Errorf("proxy: stream: %w", errTooManyStreams)
+	}
+	defer a.streamLimit.release()
+
+	// Streams can be cancelled using the last element of their path as the request ID.
+	id := strings.TrimPrefix(req.Path, openAIChatCompletionsPath+"/")
+	ctx, done, err := a.activeRequests.start(ctx, id, userLogin(req.PluginContext.User))
 	if err != nil {
-		return fmt.Errorf("Unable to unmarshal request body: %w", err)
+		return fmt.Errorf("proxy: stream: %w", err)
 	}
+	defer done()
 
-	// set stream to true
-	requestBody["stream"] = true
+	// Streams come from interactive chat, so they take priority.
+	if err := a.limiter.acquire(ctx, priorityHigh); err != nil {
+		return fmt.Errorf("proxy: stream: waiting for a request slot: %w", err)
+	}
+	defer a.limiter.release()
 
-	httpReq, err := a.newOpenAIChatCompletionsRequest(ctx, requestBody)
+	transformReq, dropUsage, err := a.transformStreamRequest(ctx, req.Data)
 	if err != nil {
-		return fmt.Errorf("proxy: stream: error creating request: %w", err)
+		return fmt.Errorf("proxy: stream: %w", err)
+	}
+	data, err := io.ReadAll(transformReq.Body)
+	if err != nil {
+		return fmt.Errorf("proxy: stream: read request body: %w", err)
 	}
+	// Audit the request as transformed, with the model actually used.
+	req.Data = data
 
-	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq, err := a.newProviderRequest(ctx, http.MethodPost, "/"+openAIChatCompletionsPath, data)
+	if err != nil {
+		return fmt.Errorf("proxy: stream: error creating request: %w", err)
+	}
 
 	// Subscribe to the stream, handling errors by immediately sending an 'error' message over the
 	// stream sender, then closing the underlying stream.
 	// This is the only way we can handle errors from the initial connection; see the docs for
 	// eventsource.StreamOptionErrorHandler for more details.
-	eventStream, err := eventsource.SubscribeWithRequestAndOptions(httpReq, eventsource.StreamOptionErrorHandler(func(err error) eventsource.StreamErrorHandlerResult {
+	opts := []eventsource.StreamOption{eventsource.StreamOptionErrorHandler(func(err error) eventsource.StreamErrorHandlerResult {
 		payload := EventError{Error: err.Error()}
 		sendError(payload, sender)
 		return eventsource.StreamErrorHandlerResult{CloseNow: true}
-	}))
+	})}
+	// Use the proxy's transport, so streams fail over between llm-gateway
+	// endpoints and reuse warmed up connections.
+	transport := a.providerTransport()
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if _, ok := a.provider.(responseTranslator); ok {
+		// Convert the provider's events into OpenAI's format before parsing them.
+		transport = &responseTranslatingTransport{provider: a.provider, base: transport}
+	}
+	opts = append(opts, eventsource.StreamOptionHTTPClient(&http.Client{Transport: transport}))
+	eventStream, err := eventsource.SubscribeWithRequestAndOptions(httpReq, opts...)
 	if err != nil {
 		return fmt.Errorf("proxy: stream: eventsource.SubscribeWithRequest: %s: %w", httpReq.URL, err)
 	}
@@ -62,7 +103,9 @@ func (a *App) runOpenAIChatCompletionsStream(ctx context.Context, req *backend.R
 		eventStream.Close()
 	}()
 
-	// Stream response back to frontend.
+	// Stream response back to frontend, keeping track of the choices and any
+	// usage so it can be counted against the budget.
+	agg := newStreamAggregator()
 	for {
 		select {
 		case <-ctx.Done():
@@ -78,7 +121,9 @@ func (a *App) runOpenAIChatCompletionsStream(ctx context.Context, req *backend.R
 					log.DefaultLogger.Error(err.Error())
 					return err
 				}
-				log.DefaultLogger.Debug(fmt.Sprintf("proxy: stream: done==true, ending (in happy branch): %s", req.Path))
+				log.DefaultLogger.Debug(fmt.Sprintf("proxy: stream: done==true, ending (in happy branch): %s", req.Path), "choices", len(agg.choices))
+				a.transformStreamResponse(transformReq, req.Data, agg)
+				a.auditStream(req, agg)
 				return nil
 			}
 			// Make sure we can unmarshal the data.
@@ -88,6 +133,15 @@ func (a *App) runOpenAIChatCompletionsStream(ctx context.Context, req *backend.R
 				log.DefaultLogger.Error(err.Error())
 				return err
 			}
+			if err := agg.add([]byte(eventData)); err != nil {
+				log.DefaultLogger.Warn("proxy: stream: unable to aggregate event", "err", err)
+			}
+			if limit := a.settings.maxResponseBytes(); agg.size > limit {
+				return fmt.Errorf("proxy: stream: %w: more than %d bytes", errResponseTooLarge, limit)
+			}
+			if _, usageOnly := chunkUsage([]byte(eventData)); dropUsage && usageOnly {
+				continue
+			}
 			err = sender.SendJSON([]byte(event.Data()))
 			if err != nil {
 				err = fmt.Errorf("proxy: stream: error sending event data: %w", err)
@@ -98,6 +152,85 @@ func (a *App) runOpenAIChatCompletionsStream(ctx context.Context, req *backend.R
 	}
 }
 
+// transformStreamRequest runs the request transformers on a request built
+// from the data of a Live stream, so that Live streams go through the same
+// pipeline as proxied requests. It returns the transformed request and
+// whether the chunk reporting usage, asked for here for the budget and audit,
+// should be dropped from the stream.
+func (a *App) transformStreamRequest(ctx context.Context, data []byte) (*http.Request, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/"+openAIChatCompletionsPath, bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := rewriteJSONBody(req, func(body map[string]interface{}) error {
+		body["stream"] = true
+		return nil
+	}); err != nil {
+		return nil, false, err
+	}
+	if err := a.transformers.transformRequest(req); err != nil {
+		return nil, false, err
+	}
+	// Ask for the usage to count against the budget and audit, without
+	// passing it on to clients which didn't ask for it.
+	dropUsage := false
+	if (a.budget != nil || a.audit != nil) && a.provider != nil && a.provider.Capabilities().StreamUsage {
+		if err := rewriteJSONBody(req, func(body map[string]interface{}) error {
+			dropUsage = forceStreamUsage(body)
+			return nil
+		}); err != nil {
+			return nil, false, err
+		}
+	}
+	return req, dropUsage, nil
+}
+
+// transformStreamResponse runs the response transformers on the completion
+// aggregated from a finished Live stream, as if it were a non-streamed
+// response to req, whose body was data. Its events have already been sent, so changes to the
+// response are discarded; transformers see the completion and its usage.
+func (a *App) transformStreamResponse(req *http.Request, data []byte, agg *streamAggregator) {
+	var requestBody struct {
+		Model string `json:"model"`
+	}
+	_ = json.Unmarshal(data, &requestBody)
+	completion, err := agg.completion(requestBody.Model)
+	if err != nil {
+		log.DefaultLogger.Warn("proxy: stream: unable to build the aggregated completion", "err", err)
+		return
+	}
+	info := proxyRequestInfo{path: req.URL.Path, model: requestBody.Model}
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(completion)),
+		ContentLength: int64(len(completion)),
+		Request:       req.WithContext(context.WithValue(req.Context(), proxyRequestInfoKey{}, info)),
+	}
+	defer resp.Body.Close()
+	if err := a.transformers.transformResponse(resp); err != nil {
+		log.DefaultLogger.Warn("proxy: stream: response transformer failed", "err", err)
+	}
+}
+
+// auditStream records a completed stream in the audit log, if enabled.
+func (a *App) auditStream(req *backend.RunStreamRequest, agg *streamAggregator) {
+	if a.audit == nil {
+		return
+	}
+	// Take the model from the original request, since it's removed for Azure.
+	var requestBody struct {
+		Model string `json:"model"`
+	}
+	_ = json.Unmarshal(req.Data, &requestBody)
+	r := auditRecord{Path: req.Path, Model: requestBody.Model, StatusCode: http.StatusOK}
+	if agg.usage != nil {
+		r.Usage = *agg.usage
+	}
+	a.audit.log(req.PluginContext.User, r)
+}
+
 func (a *App) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
 	log.DefaultLogger.Debug(fmt.Sprintf("RunStream: %s", req.Path), "data", string(req.Data))
 	if strings.HasPrefix(req.Path, openAIChatCompletionsPath) {
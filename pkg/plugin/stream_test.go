@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -158,3 +159,168 @@ func TestRunStream(t *testing.T) {
 		})
 	}
 }
+
+func TestRunStreamMultipleChoices(t *testing.T) {
+	ctx := context.Background()
+	// Chunks for the two choices are interleaved, as they are from OpenAI with `n: 2`.
+	chunks := []string{
+		`{"choices": [{"index": 0, "delta": {"role": "assistant", "content": ""}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 1, "delta": {"role": "assistant", "content": ""}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 1, "delta": {"content": "Bonjour"}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 0, "delta": {"content": "Hello"}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 0, "delta": {"content": " world"}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 1, "delta": {"content": " le monde"}, "finish_reason": null}]}`,
+		`{"choices": [{"index": 1, "delta": {}, "finish_reason": "stop"}]}`,
+		`{"choices": [{"index": 0, "delta": {}, "finish_reason": "length"}]}`,
+		`{"choices": [], "usage": {"prompt_tokens": 10, "completion_tokens": 6, "total_tokens": 16}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			_, _ = w.Write([]byte("data: " + c + "\n\n"))
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+		w.(http.Flusher).Flush()
+		// Hold the connection open until the client is done, so closing it
+		// doesn't trigger the stream's error handler.
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		Budget: BudgetSettings{DailyTokenBudget: 100},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	r := mockStreamPacketSender{messages: []json.RawMessage{}}
+	err = app.RunStream(ctx, &backend.RunStreamRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Path:          openAIChatCompletionsPath + "/abcd1234",
+		Data:          []byte(`{"model": "gpt-3.5-turbo", "messages": [], "n": 2, "stream_options": {"include_usage": true}}`),
+	}, backend.NewStreamSender(&r))
+	if err != nil {
+		t.Fatalf("RunStream error: %s", err)
+	}
+	if len(r.messages) != len(chunks)+1 {
+		t.Fatalf("expected %d messages, got %d: %s", len(chunks)+1, len(r.messages), r.messages)
+	}
+
+	// Reconstruct the choices from what the frontend received, ignoring the final done message.
+	agg := newStreamAggregator()
+	for _, m := range r.messages[:len(chunks)] {
+		if err := agg.add(m); err != nil {
+			t.Fatalf("aggregate %s: %s", m, err)
+		}
+	}
+	exp := []streamedChoice{
+		{Index: 0, Role: "assistant", Content: "Hello world", FinishReason: "length"},
+		{Index: 1, Role: "assistant", Content: "Bonjour le monde", FinishReason: "stop"},
+	}
+	got := agg.result()
+	if len(got) != len(exp) {
+		t.Fatalf("expected %d choices, got %d: %+v", len(exp), len(got), got)
+	}
+	for i := range exp {
+		if !reflect.DeepEqual(got[i], exp[i]) {
+			t.Errorf("expected choice %d to be %+v, got %+v", i, exp[i], got[i])
+		}
+	}
+
+	// Usage for the whole request is counted once, not per choice.
+	if remaining := app.budget.remaining(app.settings.Tenant); remaining != 84 {
+		t.Errorf("expected 84 tokens remaining, got %d", remaining)
+	}
+}
+
+func TestRunStreamTransformers(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range []string{
+			`{"choices": [{"index": 0, "delta": {"role": "assistant", "content": "Hello"}, "finish_reason": null}]}`,
+			`{"choices": [{"index": 0, "delta": {"content": " world"}, "finish_reason": "stop"}]}`,
+			`{"choices": [], "usage": {"prompt_tokens": 10, "completion_tokens": 2, "total_tokens": 12}}`,
+		} {
+			_, _ = w.Write([]byte("data: " + c + "\n\n"))
+		}
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	jsonData, err := json.Marshal(Settings{
+		OpenAI:     OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		ForceModel: "gpt-4o",
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	// Registered transformers run after the built-in ones, as for the proxy.
+	var transformedModel interface{}
+	app.RegisterRequestTransformer(func(req *http.Request) error {
+		return rewriteJSONBody(req, func(body map[string]interface{}) error {
+			transformedModel = body["model"]
+			body["user"] = "grafana"
+			return nil
+		})
+	})
+	var completion struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage openAIUsage `json:"usage"`
+	}
+	app.RegisterResponseTransformer(func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&completion)
+	})
+
+	r := mockStreamPacketSender{messages: []json.RawMessage{}}
+	err = app.RunStream(ctx, &backend.RunStreamRequest{
+		PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+		Path:          openAIChatCompletionsPath + "/abcd1234",
+		Data:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+	}, backend.NewStreamSender(&r))
+	if err != nil {
+		t.Fatalf("RunStream error: %s", err)
+	}
+	if transformedModel != "gpt-4o" {
+		t.Errorf("expected the registered transformer to see the forced model, got %v", transformedModel)
+	}
+	if upstreamBody["user"] != "grafana" || upstreamBody["model"] != "gpt-4o" || upstreamBody["stream"] != true {
+		t.Errorf("expected the transformed request to be sent, got %v", upstreamBody)
+	}
+	if completion.Model != "gpt-4o" || len(completion.Choices) != 1 || completion.Choices[0].Message.Content != "Hello world" || completion.Choices[0].FinishReason != "stop" || completion.Usage.TotalTokens != 12 {
+		t.Errorf("expected the response transformer to see the aggregated completion, got %+v", completion)
+	}
+}
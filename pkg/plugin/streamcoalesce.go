@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// streamCoalescedHeader is set on responses to streaming requests which
+// joined an identical stream already in flight, rather than calling the
+// provider themselves.
+const streamCoalescedHeader = "X-LLM-Stream-Coalesced"
+
+// leaderOnlyHeaders are response headers describing the upstream call itself,
+// which only the request that made it is charged for, so they aren't sent to
+// the requests sharing its stream.
+var leaderOnlyHeaders = []string{estimatedCostHeader}
+
+// streamCoalescer runs a single upstream stream for identical streaming
+// completions requests made at the same time, broadcasting its chunks to all
+// of them as they arrive.
+type streamCoalescer struct {
+	// audit records the requests sharing a stream; the request that started
+	// it is audited by the proxy.
+	audit *auditLogger
+
+	mu      sync.Mutex
+	streams map[string]*streamBroadcast
+}
+
+func newStreamCoalescer(audit *auditLogger) *streamCoalescer {
+	return &streamCoalescer{audit: audit, streams: map[string]*streamBroadcast{}}
+}
+
+// streamBroadcast is a response being streamed to several clients. It
+// implements http.ResponseWriter for the upstream handler. Every chunk is
+// kept until the stream ends, so clients joining late still get all of it.
+type streamBroadcast struct {
+	// header is the upstream handler's header, until it writes it.
+	header http.Header
+	// cancel cancels the upstream request.
+	cancel context.CancelFunc
+	// subscribers is the number of clients receiving the stream. It is
+	// guarded by the coalescer's mutex.
+	subscribers int
+
+	mu     sync.Mutex
+	status int
+	sent   http.Header
+	chunks [][]byte
+	done   bool
+	// changed is closed, and replaced, whenever the above change.
+	changed chan struct{}
+}
+
+// notify wakes subscribers waiting for a change. The caller must hold b.mu.
+func (b *streamBroadcast) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *streamBroadcast) Header() http.Header {
+	return b.header
+}
+
+func (b *streamBroadcast) WriteHeader(status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status != 0 {
+		return
+	}
+	b.status = status
+	b.sent = b.header.Clone()
+	b.notify()
+}
+
+func (b *streamBroadcast) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// The handler may reuse p.
+	b.chunks = append(b.chunks, bytes.Clone(p))
+	b.notify()
+	return len(p), nil
+}
+
+// Flush implements http.Flusher. Subscribers flush each chunk they send.
+func (b *streamBroadcast) Flush() {}
+
+// finish marks the stream as complete.
+func (b *streamBroadcast) finish() {
+	b.WriteHeader(http.StatusOK)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	b.notify()
+}
+
+// serve sends the stream to w until it ends, or the client goes away.
+func (b *streamBroadcast) serve(w http.ResponseWriter, req *http.Request, coalesced bool) {
+	flusher, _ := w.(http.Flusher)
+	sent, headerSent := 0, false
+	for {
+		b.mu.Lock()
+		status, header, chunks, done, changed := b.status, b.sent, b.chunks[sent:], b.done, b.changed
+		b.mu.Unlock()
+
+		if status != 0 && !headerSent {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			if coalesced {
+				for _, k := range leaderOnlyHeaders {
+					w.Header().Del(k)
+				}
+				w.Header().Set(streamCoalescedHeader, "true")
+			}
+			w.WriteHeader(status)
+			headerSent = true
+		}
+		for _, chunk := range chunks {
+			if _, err := w.Write(chunk); err != nil {
+				log.DefaultLogger.Debug("Unable to write coalesced stream", "err", err)
+				return
+			}
+		}
+		sent += len(chunks)
+		if len(chunks) > 0 && flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// auditRecord returns the audit record for a request sharing the stream, with
+// the usage reported in the chunks received so far, if any.
+func (b *streamBroadcast) auditRecord(path string) auditRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := auditRecord{Path: path, Model: b.sent.Get(resolvedModelHeader), StatusCode: b.status, Coalesced: true}
+	for _, line := range bytes.Split(bytes.Join(b.chunks, nil), []byte("\n")) {
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if usage, _ := chunkUsage(bytes.TrimSpace(data)); usage != nil {
+				r.Usage = *usage
+			}
+		}
+	}
+	return r
+}
+
+// join returns the stream for key, starting one if there is none, and
+// reports whether the caller started it and must run it.
+func (c *streamCoalescer) join(key string) (*streamBroadcast, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.streams[key]; ok {
+		b.subscribers++
+		return b, false
+	}
+	b := &streamBroadcast{header: http.Header{}, subscribers: 1, changed: make(chan struct{})}
+	c.streams[key] = b
+	return b, true
+}
+
+// leave unsubscribes a client from the stream for key, cancelling the
+// upstream request if it was the last one.
+func (c *streamCoalescer) leave(key string, b *streamBroadcast) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b.subscribers--
+	if b.subscribers > 0 {
+		return
+	}
+	if c.streams[key] == b {
+		delete(c.streams, key)
+	}
+	b.cancel()
+}
+
+// run streams the response to req into b. The upstream request isn't tied to
+// any one client, so it continues while any of them is still subscribed.
+func (c *streamCoalescer) run(key string, b *streamBroadcast, next http.Handler, req *http.Request) {
+	defer func() {
+		b.finish()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// Later requests start a new stream.
+		if c.streams[key] == b {
+			delete(c.streams, key)
+		}
+	}()
+	next.ServeHTTP(b, req)
+}
+
+// middleware wraps a proxy handler so that identical streaming completions
+// requests made while one is in flight share its upstream stream. Requests
+// are identical if they have the same path, body and stream usage header;
+// other headers, including the user's, are ignored. A nil coalescer returns
+// next unchanged.
+//
+// Every request sharing a stream gets the response headers of the one that
+// started it, which describe the shared response: the resolved model, the
+// provider's rate limits and the budget remaining. Its X-Request-ID is
+// replaced outside the coalescer, and leaderOnlyHeaders are removed. Each is
+// audited with its own user, marked as coalesced so that usage isn't counted
+// twice.
+func (c *streamCoalescer) middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isCompletionsPath(req.URL.Path) || req.Body == nil || req.Body == http.NoBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeProxyError(w, req, fmt.Errorf("read request body: %w", err), http.StatusBadRequest, "")
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		var requestBody struct {
+			Stream bool `json:"stream"`
+		}
+		// Ignore errors; the provider will reject malformed requests.
+		_ = json.Unmarshal(body, &requestBody)
+		if !requestBody.Stream {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		key := fmt.Sprintf("%s %s %t %x", req.Method, req.URL.Path, streamUsageRequested(req.Header), sha256.Sum256(body))
+		b, leader := c.join(key)
+		if leader {
+			ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+			b.cancel = cancel
+			upstream := req.Clone(ctx)
+			upstream.Body = io.NopCloser(bytes.NewReader(body))
+			go c.run(key, b, next, upstream)
+		}
+		defer c.leave(key, b)
+		b.serve(w, req, !leader)
+		if !leader {
+			c.audit.log(httpadapter.UserFromContext(req.Context()), b.auditRecord(req.URL.Path))
+		}
+	})
+}
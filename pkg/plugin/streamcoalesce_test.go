@@ -0,0 +1,329 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// waitForSubscribers waits until the stream for the only key has n
+// subscribers.
+func waitForSubscribers(t *testing.T, c *streamCoalescer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		subscribers := 0
+		for _, b := range c.streams {
+			subscribers += b.subscribers
+		}
+		c.mu.Unlock()
+		if subscribers == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d subscribers", n)
+}
+
+func TestStreamCoalesce(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set(resolvedModelHeader, "gpt-4")
+		w.Header().Set(estimatedCostHeader, "0.000100")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"Hel\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"lo\"}}]}\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	})
+	c := newStreamCoalescer(nil)
+	handler := c.middleware(upstream)
+
+	body := `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hi"}], "stream": true}`
+	recorders := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+	var wg sync.WaitGroup
+	for i, rec := range recorders {
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(body))
+			handler.ServeHTTP(rec, req)
+		}(rec)
+		// Start the second request once the first is streaming.
+		waitForSubscribers(t, c, i+1)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected one upstream request, got %d", n)
+	}
+	exp := "data: {\"choices\": [{\"delta\": {\"content\": \"Hel\"}}]}\n\n" +
+		"data: {\"choices\": [{\"delta\": {\"content\": \"lo\"}}]}\n\n" +
+		"data: [DONE]\n\n"
+	for i, rec := range recorders {
+		if rec.Code != http.StatusOK {
+			t.Errorf("request %d: expected status 200, got %d", i, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("request %d: expected an event stream, got %q", i, got)
+		}
+		if got := rec.Body.String(); got != exp {
+			t.Errorf("request %d: expected body %q, got %q", i, exp, got)
+		}
+	}
+	if recorders[0].Header().Get(streamCoalescedHeader) != "" {
+		t.Error("expected the first request not to be marked as coalesced")
+	}
+	if recorders[1].Header().Get(streamCoalescedHeader) != "true" {
+		t.Error("expected the second request to be marked as coalesced")
+	}
+	// Only the first request is charged for the upstream call.
+	if recorders[0].Header().Get(estimatedCostHeader) == "" || recorders[1].Header().Get(estimatedCostHeader) != "" {
+		t.Errorf("expected only the first request to have an estimated cost, got %q and %q",
+			recorders[0].Header().Get(estimatedCostHeader), recorders[1].Header().Get(estimatedCostHeader))
+	}
+	if got := recorders[1].Header().Get(resolvedModelHeader); got != "gpt-4" {
+		t.Errorf("expected the shared resolved model header, got %q", got)
+	}
+
+	// The stream has ended, so the next request starts another.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(body)))
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected a new upstream request after the stream ended, got %d in total", n)
+	}
+}
+
+// recordingAuditSink keeps the records written to it.
+type recordingAuditSink struct {
+	mu      sync.Mutex
+	records []auditRecord
+}
+
+func (s *recordingAuditSink) write(r auditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *recordingAuditSink) close() error { return nil }
+
+func TestStreamCoalesceAudit(t *testing.T) {
+	release := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set(resolvedModelHeader, "gpt-4")
+		_, _ = w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"Hi\"}}]}\n\n"))
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = w.Write([]byte("data: {\"choices\": [], \"usage\": {\"prompt_tokens\": 3, \"completion_tokens\": 1, \"total_tokens\": 4}}\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	})
+	sink := &recordingAuditSink{}
+	audit := startAuditLogger(sink, "123", 10, AuditBackpressureDropNewest, 0)
+	c := newStreamCoalescer(audit)
+	handler := httpadapter.New(c.middleware(upstream))
+
+	var wg sync.WaitGroup
+	for i, user := range []string{"leader", "follower"} {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			err := handler.CallResource(context.Background(), &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{User: &backend.User{Login: user}},
+				Method:        http.MethodPost,
+				Path:          "openai/v1/chat/completions",
+				URL:           "/openai/v1/chat/completions",
+				Body:          []byte(`{"model": "gpt-4", "messages": [], "stream": true}`),
+			}, &mockCallResourceResponseSender{})
+			if err != nil {
+				t.Errorf("%s: CallResource error: %s", user, err)
+			}
+		}(user)
+		waitForSubscribers(t, c, i+1)
+	}
+	close(release)
+	wg.Wait()
+	audit.close()
+
+	// The leader is audited by the proxy, which isn't under test here.
+	exp := []auditRecord{{
+		User:       "follower",
+		Tenant:     "123",
+		Path:       "/openai/v1/chat/completions",
+		Model:      "gpt-4",
+		StatusCode: http.StatusOK,
+		Usage:      openAIUsage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4},
+		Coalesced:  true,
+	}}
+	for i := range sink.records {
+		sink.records[i].Timestamp = time.Time{}
+	}
+	if !reflect.DeepEqual(sink.records, exp) {
+		t.Errorf("expected audit records %+v, got %+v", exp, sink.records)
+	}
+}
+
+func TestStreamCoalesceLeaderLeaves(t *testing.T) {
+	release := make(chan struct{})
+	upstreamDone := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamDone)
+		_, _ = w.Write([]byte("data: a\n\n"))
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = w.Write([]byte("data: b\n\n"))
+	})
+	c := newStreamCoalescer(nil)
+	handler := c.middleware(upstream)
+	body := `{"model": "gpt-4", "messages": [], "stream": true}`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(body)).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	waitForSubscribers(t, c, 1)
+	rec := httptest.NewRecorder()
+	followerDone := make(chan struct{})
+	go func() {
+		defer close(followerDone)
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(body)))
+	}()
+	waitForSubscribers(t, c, 2)
+
+	// The first client going away doesn't end the stream for the second.
+	cancel()
+	<-leaderDone
+	waitForSubscribers(t, c, 1)
+	close(release)
+	<-followerDone
+	<-upstreamDone
+	if exp := "data: a\n\ndata: b\n\n"; rec.Body.String() != exp {
+		t.Errorf("expected body %q, got %q", exp, rec.Body.String())
+	}
+}
+
+func TestStreamCoalesceCancelled(t *testing.T) {
+	upstreamCancelled := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data: a\n\n"))
+		<-r.Context().Done()
+		close(upstreamCancelled)
+	})
+	c := newStreamCoalescer(nil)
+	handler := c.middleware(upstream)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(`{"stream": true}`)).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	waitForSubscribers(t, c, 1)
+	cancel()
+	<-done
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the upstream request to be cancelled once every client had gone")
+	}
+}
+
+func TestStreamCoalesceNotCoalesced(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		path   string
+		bodies [2]string
+		usage  [2]string
+	}{
+		{
+			name:   "not streaming",
+			path:   "/openai/v1/chat/completions",
+			bodies: [2]string{`{"model": "gpt-4", "messages": []}`, `{"model": "gpt-4", "messages": []}`},
+		},
+		{
+			name:   "different bodies",
+			path:   "/openai/v1/chat/completions",
+			bodies: [2]string{`{"model": "gpt-4", "messages": [], "stream": true}`, `{"model": "gpt-4o", "messages": [], "stream": true}`},
+		},
+		{
+			name:   "different stream usage",
+			path:   "/openai/v1/chat/completions",
+			bodies: [2]string{`{"model": "gpt-4", "messages": [], "stream": true}`, `{"model": "gpt-4", "messages": [], "stream": true}`},
+			usage:  [2]string{"", "true"},
+		},
+		{
+			name:   "other endpoint",
+			path:   "/openai/v1/embeddings",
+			bodies: [2]string{`{"input": "a", "stream": true}`, `{"input": "a", "stream": true}`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				started <- struct{}{}
+				<-release
+				_, _ = w.Write([]byte("data: [DONE]\n\n"))
+			})
+			handler := newStreamCoalescer(nil).middleware(upstream)
+
+			var wg sync.WaitGroup
+			for i := range tc.bodies {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.bodies[i]))
+					if tc.usage[i] != "" {
+						req.Header.Set(streamUsageHeader, tc.usage[i])
+					}
+					handler.ServeHTTP(httptest.NewRecorder(), req)
+				}(i)
+			}
+			// Both requests reach the provider while neither has finished.
+			for range tc.bodies {
+				select {
+				case <-started:
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for both requests to reach the provider")
+				}
+			}
+			close(release)
+			wg.Wait()
+			if n := calls.Load(); n != 2 {
+				t.Errorf("expected two upstream requests, got %d", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStreamIdleTimeout is how long a streamed response may go without
+// data from the provider if no timeout is configured.
+const defaultStreamIdleTimeout = 60 * time.Second
+
+// errStreamIdle is sent in an error event when a streamed response is
+// aborted because the provider stopped sending data.
+var errStreamIdle = errors.New("stream from provider stalled")
+
+// streamIdleTimeout returns the configured stream idle timeout, or the
+// default.
+func (s Settings) streamIdleTimeout() time.Duration {
+	if s.StreamIdleTimeoutSeconds <= 0 {
+		return defaultStreamIdleTimeout
+	}
+	return time.Duration(s.StreamIdleTimeoutSeconds) * time.Second
+}
+
+// idleTimeoutStream aborts a successful streamed response if the provider
+// sends nothing for timeout after it has started sending data, ending it with
+// an SSE error event. A stall before the first data is left to the request
+// timeout, since models may take a long time to produce their first token.
+func idleTimeoutStream(resp *http.Response, timeout time.Duration) {
+	if timeout <= 0 || resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	pr, pw := io.Pipe()
+	b := &idleTimeoutBody{PipeReader: pr, pw: pw, body: resp.Body, timeout: timeout}
+	go b.copy()
+	resp.Body = b
+}
+
+// idleTimeoutBody is a response body which copies the provider's body until
+// it goes idle.
+type idleTimeoutBody struct {
+	*io.PipeReader
+	pw      *io.PipeWriter
+	body    io.ReadCloser
+	timeout time.Duration
+
+	// mu serializes writes, so that the error event never lands in the
+	// middle of an event. last is when data was last written, and timer
+	// starts with the first data.
+	mu    sync.Mutex
+	last  time.Time
+	timer *time.Timer
+	done  bool
+}
+
+// copy copies the provider's body to the pipe.
+func (b *idleTimeoutBody) copy() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := b.body.Read(buf)
+		b.mu.Lock()
+		if b.done {
+			b.mu.Unlock()
+			return
+		}
+		if n > 0 {
+			_, werr := b.pw.Write(buf[:n])
+			b.last = time.Now()
+			if b.timer == nil {
+				b.timer = time.AfterFunc(b.timeout, b.expire)
+			}
+			if werr != nil {
+				b.finish()
+				b.mu.Unlock()
+				return
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			b.pw.CloseWithError(err)
+			b.finish()
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+	}
+}
+
+// expire ends the stream with an error event if it has been idle for the
+// timeout, and otherwise waits for the rest of it.
+func (b *idleTimeoutBody) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return
+	}
+	if idle := time.Since(b.last); idle < b.timeout {
+		b.timer.Reset(b.timeout - idle)
+		return
+	}
+	event, _ := json.Marshal(proxyErrorResponse{Error: proxyErrorDetail{
+		Message: fmt.Sprintf("%s: no data for %s", errStreamIdle, b.timeout),
+		Type:    proxyErrorType(http.StatusGatewayTimeout),
+		Code:    "stream_idle_timeout",
+	}})
+	_, _ = fmt.Fprintf(b.pw, "event: error\ndata: %s\n\n", event)
+	b.pw.Close()
+	b.finish()
+	// Unblock copy, which is waiting for the provider.
+	b.body.Close()
+}
+
+// finish stops the timer. It must be called with mu held.
+func (b *idleTimeoutBody) finish() {
+	b.done = true
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+}
+
+func (b *idleTimeoutBody) Close() error {
+	// Close the reader first, so that a blocked write in copy or expire
+	// returns and releases mu.
+	b.PipeReader.Close()
+	b.mu.Lock()
+	b.finish()
+	b.mu.Unlock()
+	return b.body.Close()
+}
@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamIdleTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range []string{"Hello", ", world"} {
+			_, _ = w.Write([]byte(`data: {"choices": [{"delta": {"content": "` + content + `"}}]}` + "\n\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+		if r.URL.Query().Get("stall") == "" {
+			_, _ = w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		// Stall until the proxy gives up.
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			t.Error("expected the proxy to abort the stream")
+		}
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name  string
+		stall bool
+	}{
+		{name: "stalled", stall: true},
+		{name: "complete"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &directOpenAIProvider{settings: OpenAISettings{URL: server.URL}}
+			proxy := newProviderProxy(provider, providerProxyOptions{idleTimeout: 100 * time.Millisecond})
+			path := "/openai/v1/chat/completions"
+			if tc.stall {
+				path += "?stall=1"
+			}
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model": "gpt-4o", "stream": true}`))
+			w := httptest.NewRecorder()
+			start := time.Now()
+			proxy.ServeHTTP(w, req)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("expected the stream to end promptly, took %s", elapsed)
+			}
+
+			body := w.Body.String()
+			if !strings.Contains(body, `"content": ", world"`) {
+				t.Errorf("expected the chunks before the stall, got %q", body)
+			}
+			hasError := strings.HasSuffix(body, "event: error\ndata: {\"error\":{\"message\":\"stream from provider stalled: no data for 100ms\",\"type\":\"server_error\",\"code\":\"stream_idle_timeout\"}}\n\n")
+			if hasError != tc.stall {
+				t.Errorf("expected error event %v, got %q", tc.stall, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// errTooManyStreams is returned when a stream is rejected because the maximum
+// number are already open.
+var errTooManyStreams = errors.New("too many concurrent streams")
+
+// StreamOverflow is what happens to streaming requests made while the maximum
+// number of streams are open.
+type StreamOverflow string
+
+const (
+	// StreamOverflowReject rejects the request with HTTP 429. This is the
+	// default.
+	StreamOverflowReject StreamOverflow = "reject"
+	// StreamOverflowDowngrade sends the request without streaming, so the
+	// client gets the whole response at once rather than an event stream.
+	StreamOverflowDowngrade StreamOverflow = "downgrade"
+)
+
+// streamLimit limits the number of streamed responses open at once, since
+// each holds a connection and goroutines for as long as the model takes.
+type streamLimit struct {
+	max      int64
+	overflow StreamOverflow
+	active   atomic.Int64
+}
+
+// newStreamLimit returns a limit of max concurrent streams, or nil for no
+// limit.
+func newStreamLimit(max int, overflow StreamOverflow) (*streamLimit, error) {
+	switch overflow {
+	case "":
+		overflow = StreamOverflowReject
+	case StreamOverflowReject, StreamOverflowDowngrade:
+	default:
+		return nil, fmt.Errorf("unknown stream overflow mode: %s", overflow)
+	}
+	if max <= 0 {
+		return nil, nil
+	}
+	return &streamLimit{max: int64(max), overflow: overflow}, nil
+}
+
+// acquire takes a stream slot, reporting whether one was free. A nil
+// streamLimit always has a free slot.
+func (l *streamLimit) acquire() bool {
+	if l == nil {
+		return true
+	}
+	for {
+		n := l.active.Load()
+		if n >= l.max {
+			return false
+		}
+		if l.active.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release frees the slot taken by a successful acquire.
+func (l *streamLimit) release() {
+	if l == nil {
+		return
+	}
+	l.active.Add(-1)
+}
+
+// middleware wraps a proxy handler so that streaming completions requests
+// hold a slot until the response has been sent, or the client has gone away.
+// Streaming requests made when no slot is free are rejected or sent without
+// streaming, by the overflow mode. A nil streamLimit returns next unchanged.
+func (l *streamLimit) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isCompletionsPath(req.URL.Path) || req.Body == nil || req.Body == http.NoBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeProxyError(w, req, fmt.Errorf("read request body: %w", err), http.StatusBadRequest, "")
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		var requestBody struct {
+			Stream bool `json:"stream"`
+		}
+		// Ignore errors; the provider will reject malformed requests.
+		_ = json.Unmarshal(body, &requestBody)
+		if !requestBody.Stream {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if l.acquire() {
+			defer l.release()
+			next.ServeHTTP(w, req)
+			return
+		}
+		if l.overflow == StreamOverflowReject {
+			writeProxyError(w, req, fmt.Errorf("%w: the limit is %d", errTooManyStreams, l.max), http.StatusTooManyRequests, "")
+			return
+		}
+		err = rewriteJSONBody(req, func(body map[string]interface{}) error {
+			body["stream"] = false
+			// Providers reject stream options on requests which don't stream.
+			delete(body, "stream_options")
+			return nil
+		})
+		if err != nil {
+			writeProxyError(w, req, err, http.StatusBadRequest, "")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
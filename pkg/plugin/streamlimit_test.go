@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamLimit(t *testing.T) {
+	for _, tc := range []struct {
+		overflow StreamOverflow
+
+		expStatus int
+	}{
+		{overflow: StreamOverflowReject, expStatus: http.StatusTooManyRequests},
+		{overflow: StreamOverflowDowngrade, expStatus: http.StatusOK},
+	} {
+		t.Run(string(tc.overflow), func(t *testing.T) {
+			l, err := newStreamLimit(1, tc.overflow)
+			if err != nil {
+				t.Fatalf("new stream limit: %s", err)
+			}
+			started := make(chan struct{})
+			var gotBody map[string]interface{}
+			handler := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]interface{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				if body["stream"] == true {
+					// Stream until the client goes away.
+					close(started)
+					<-r.Context().Done()
+					return
+				}
+				gotBody = body
+				w.WriteHeader(http.StatusOK)
+			}))
+			request := func(ctx context.Context, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(body)).WithContext(ctx)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w
+			}
+			const streamBody = `{"model": "gpt-4o", "stream": true, "stream_options": {"include_usage": true}}`
+
+			// The first stream takes the only slot until its client disconnects.
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				request(ctx, streamBody)
+				close(done)
+			}()
+			<-started
+
+			w := request(context.Background(), streamBody)
+			if w.Code != tc.expStatus {
+				t.Fatalf("expected status %d over the limit, got %d: %s", tc.expStatus, w.Code, w.Body)
+			}
+			if tc.overflow == StreamOverflowDowngrade {
+				if gotBody["stream"] != false || gotBody["stream_options"] != nil {
+					t.Errorf("expected the request to be downgraded to non-streaming, got %v", gotBody)
+				}
+			}
+			// Requests which don't stream aren't limited.
+			if w := request(context.Background(), `{"model": "gpt-4o"}`); w.Code != http.StatusOK {
+				t.Errorf("expected a non-streaming request to succeed, got %d", w.Code)
+			}
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("stream didn't finish after its client disconnected")
+			}
+			if got := l.active.Load(); got != 0 {
+				t.Fatalf("expected the slot to be released when the client disconnected, got %d active", got)
+			}
+			if !l.acquire() {
+				t.Error("expected a new stream to be allowed once the first ended")
+			}
+		})
+	}
+}
+
+func TestNewStreamLimit(t *testing.T) {
+	if l, err := newStreamLimit(0, ""); err != nil || l != nil {
+		t.Errorf("expected no limit by default, got %v, %v", l, err)
+	}
+	if _, err := newStreamLimit(1, "queue"); err == nil {
+		t.Error("expected an error for an unknown overflow mode")
+	}
+	// A nil limit always allows streams.
+	var l *streamLimit
+	if !l.acquire() {
+		t.Error("expected a nil limit to allow streams")
+	}
+	l.release()
+	if h := l.middleware(http.NotFoundHandler()); h == nil {
+		t.Error("expected a nil limit's middleware to return the handler")
+	}
+}
@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// streamUsageHeader is a request header which, if true, adds usage events
+// to streamed completions responses, reporting the tokens used so far.
+const streamUsageHeader = "X-LLM-Stream-Usage"
+
+// streamUsageEvent is the SSE event type of usage events. OpenAI's clients
+// only handle events without a type, so they skip these.
+const streamUsageEvent = "llm.usage"
+
+// streamUsageRequested reports whether the client asked for usage events.
+func streamUsageRequested(h http.Header) bool {
+	requested, _ := strconv.ParseBool(h.Get(streamUsageHeader))
+	return requested
+}
+
+// streamUsageData is the data of a usage event. Counts are estimated from
+// the length of the text, except in the event sent for a chunk with the
+// provider's own usage.
+type streamUsageData struct {
+	openAIUsage
+	Estimated bool `json:"estimated"`
+}
+
+// requestPromptTokens estimates the prompt tokens of a chat completions
+// request, leaving its body unconsumed.
+func requestPromptTokens(req *http.Request) (int64, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return 0, nil
+	}
+	bodyBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		return 0, fmt.Errorf("read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	var body map[string]interface{}
+	// Ignore errors; the provider will reject malformed requests.
+	_ = json.Unmarshal(bodyBytes, &body)
+	return int64(estimatePromptTokens(body)), nil
+}
+
+// streamUsage adds a usage event after each event of a successful streamed
+// completions response which adds to the completion.
+func streamUsage(resp *http.Response, promptTokens int64) {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(streamUsageEvents(body, pw, promptTokens))
+	}()
+	resp.Body = &translatedStream{PipeReader: pr, body: body}
+}
+
+// chunkText returns the characters of completion text in a streamed chat or
+// legacy completions chunk.
+func chunkText(chunk map[string]interface{}) int {
+	chars := 0
+	choices, _ := chunk["choices"].([]interface{})
+	for _, choice := range choices {
+		c, _ := choice.(map[string]interface{})
+		if text, ok := c["text"].(string); ok {
+			chars += utf8.RuneCountInString(text)
+		}
+		delta, _ := c["delta"].(map[string]interface{})
+		if content, ok := delta["content"].(string); ok {
+			chars += utf8.RuneCountInString(content)
+		}
+		toolCalls, _ := delta["tool_calls"].([]interface{})
+		for _, call := range toolCalls {
+			tc, _ := call.(map[string]interface{})
+			function, _ := tc["function"].(map[string]interface{})
+			name, _ := function["name"].(string)
+			arguments, _ := function["arguments"].(string)
+			chars += utf8.RuneCountInString(name) + utf8.RuneCountInString(arguments)
+		}
+	}
+	return chars
+}
+
+// streamUsageEvents copies a stream of completions chunks from r to w, adding
+// a usage event after each event with completion text or usage.
+func streamUsageEvents(r io.Reader, w io.Writer, promptTokens int64) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var chars int64
+	var pending *streamUsageData
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+			return err
+		}
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			var chunk map[string]interface{}
+			if err := json.Unmarshal(bytes.TrimSpace(data), &chunk); err != nil {
+				continue
+			}
+			var withUsage struct {
+				Usage *openAIUsage `json:"usage"`
+			}
+			_ = json.Unmarshal(bytes.TrimSpace(data), &withUsage)
+			n := chunkText(chunk)
+			chars += int64(n)
+			completionTokens := (chars + charsPerToken - 1) / charsPerToken
+			switch {
+			case withUsage.Usage != nil:
+				pending = &streamUsageData{openAIUsage: *withUsage.Usage}
+			case n > 0:
+				pending = &streamUsageData{openAIUsage: openAIUsage{PromptTokens: promptTokens, CompletionTokens: completionTokens, TotalTokens: promptTokens + completionTokens}, Estimated: true}
+			}
+			continue
+		}
+		// A blank line ends the event, so the usage can follow it.
+		if len(line) == 0 && pending != nil {
+			b, err := json.Marshal(pending)
+			if err != nil {
+				return fmt.Errorf("marshal usage: %w", err)
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", streamUsageEvent, b); err != nil {
+				return err
+			}
+			pending = nil
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamUsageEvents(t *testing.T) {
+	in := strings.Join([]string{
+		`data: {"choices": [{"delta": {"role": "assistant"}}]}`,
+		``,
+		`data: {"choices": [{"delta": {"content": "Hello"}}]}`,
+		``,
+		`data: {"choices": [{"delta": {"content": ", world!"}}]}`,
+		``,
+		`data: {"choices": [], "usage": {"prompt_tokens": 9, "completion_tokens": 4, "total_tokens": 13}}`,
+		``,
+		`data: [DONE]`,
+		``,
+		``,
+	}, "\n")
+	var out bytes.Buffer
+	if err := streamUsageEvents(strings.NewReader(in), &out, 10); err != nil {
+		t.Fatalf("streamUsageEvents: %s", err)
+	}
+	exp := strings.Join([]string{
+		`data: {"choices": [{"delta": {"role": "assistant"}}]}`,
+		``,
+		`data: {"choices": [{"delta": {"content": "Hello"}}]}`,
+		``,
+		`event: llm.usage`,
+		`data: {"prompt_tokens":10,"completion_tokens":2,"total_tokens":12,"estimated":true}`,
+		``,
+		`data: {"choices": [{"delta": {"content": ", world!"}}]}`,
+		``,
+		`event: llm.usage`,
+		`data: {"prompt_tokens":10,"completion_tokens":4,"total_tokens":14,"estimated":true}`,
+		``,
+		`data: {"choices": [], "usage": {"prompt_tokens": 9, "completion_tokens": 4, "total_tokens": 13}}`,
+		``,
+		`event: llm.usage`,
+		`data: {"prompt_tokens":9,"completion_tokens":4,"total_tokens":13,"estimated":false}`,
+		``,
+		`data: [DONE]`,
+		``,
+		``,
+	}, "\n")
+	if out.String() != exp {
+		t.Errorf("unexpected stream:\n%s\nexpected:\n%s", out.String(), exp)
+	}
+}
+
+func TestStreamUsageHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(streamUsageHeader) != "" {
+			t.Errorf("expected %s not to be forwarded", streamUsageHeader)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"Hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name     string
+		header   string
+		expUsage bool
+	}{
+		{name: "requested", header: "true", expUsage: true},
+		{name: "not requested"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &directOpenAIProvider{settings: OpenAISettings{URL: server.URL}}
+			proxy := newProviderProxy(provider, providerProxyOptions{})
+			// 20 characters: 5 tokens, plus 4 for the message and 3 for the reply.
+			req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "12345678901234567890"}]}`))
+			if tc.header != "" {
+				req.Header.Set(streamUsageHeader, tc.header)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+			hasUsage := strings.Contains(w.Body.String(), "event: llm.usage\ndata: {\"prompt_tokens\":12,\"completion_tokens\":1,\"total_tokens\":13,\"estimated\":true}\n\n")
+			if hasUsage != tc.expUsage {
+				t.Errorf("expected usage event %v, got body %s", tc.expUsage, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// orderedFields are request fields whose key order matters, so they must
+// reach the provider as the client sent them: models generate structured
+// outputs with properties in the order of the schema, in `response_format`
+// or a strict tool's parameters.
+var orderedFields = []string{"response_format", "tools"}
+
+// orderedFieldValue is an order-sensitive field of a request body, both as
+// sent and decoded.
+type orderedFieldValue struct {
+	raw     json.RawMessage
+	decoded interface{}
+}
+
+// orderedFieldValues holds the order-sensitive fields of a request body, by
+// name, so that they survive the body being decoded into a map and encoded
+// again, which sorts keys.
+type orderedFieldValues map[string]orderedFieldValue
+
+// captureOrderedFields returns the order-sensitive fields of body.
+func captureOrderedFields(body []byte) orderedFieldValues {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+	var values orderedFieldValues
+	for _, field := range orderedFields {
+		r, ok := raw[field]
+		if !ok {
+			continue
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(r, &decoded); err != nil {
+			continue
+		}
+		if values == nil {
+			values = orderedFieldValues{}
+		}
+		values[field] = orderedFieldValue{raw: r, decoded: decoded}
+	}
+	return values
+}
+
+// restore replaces the fields of body which haven't changed since they were
+// captured with their JSON as sent, so that encoding body keeps their key
+// order. It must only be called just before body is encoded.
+func (v orderedFieldValues) restore(body map[string]interface{}) {
+	for field, value := range v {
+		if current, ok := body[field]; ok && reflect.DeepEqual(current, value.decoded) {
+			body[field] = value.raw
+		}
+	}
+}
+
+// StructuredOutputsFallback is what happens to structured outputs requests,
+// with a `json_schema` response format, sent to providers which don't
+// support them.
+type StructuredOutputsFallback string
+
+const (
+	// StructuredOutputsFallbackStrip removes the response format, so the
+	// model answers without a schema. This is the default.
+	StructuredOutputsFallbackStrip StructuredOutputsFallback = "strip"
+	// StructuredOutputsFallbackInstruct removes the response format and asks
+	// for JSON matching the schema in a system message instead. Unlike
+	// structured outputs, the model isn't guaranteed to comply.
+	StructuredOutputsFallbackInstruct StructuredOutputsFallback = "instruct"
+)
+
+func (f StructuredOutputsFallback) validate() error {
+	switch f {
+	case "", StructuredOutputsFallbackStrip, StructuredOutputsFallbackInstruct:
+		return nil
+	}
+	return fmt.Errorf("unknown structured outputs fallback: %s", f)
+}
+
+// structuredOutputsInstruction is the system message asking for JSON matching
+// a schema, with StructuredOutputsFallbackInstruct.
+const structuredOutputsInstruction = "Respond only with a JSON value matching this JSON schema, without any other text:\n"
+
+// stripStructuredOutputs removes a `json_schema` response format from a chat
+// completions request body, for providers which don't support structured
+// outputs, following fallback. Other response formats are left alone. The
+// body is only re-encoded if it had one.
+func stripStructuredOutputs(body []byte, fallback StructuredOutputsFallback) ([]byte, error) {
+	var requestBody map[string]json.RawMessage
+	if err := json.Unmarshal(body, &requestBody); err != nil {
+		return nil, fmt.Errorf("unmarshal request body: %w", err)
+	}
+	var responseFormat struct {
+		Type       string `json:"type"`
+		JSONSchema struct {
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema"`
+	}
+	if raw, ok := requestBody["response_format"]; !ok || json.Unmarshal(raw, &responseFormat) != nil || responseFormat.Type != "json_schema" {
+		return body, nil
+	}
+	delete(requestBody, "response_format")
+
+	if fallback == StructuredOutputsFallbackInstruct && len(responseFormat.JSONSchema.Schema) > 0 {
+		var messages []json.RawMessage
+		if raw, ok := requestBody["messages"]; ok {
+			if err := json.Unmarshal(raw, &messages); err != nil {
+				return nil, fmt.Errorf("unmarshal messages: %w", err)
+			}
+		}
+		var schema bytes.Buffer
+		if err := json.Compact(&schema, responseFormat.JSONSchema.Schema); err != nil {
+			return nil, fmt.Errorf("compact schema: %w", err)
+		}
+		instruction, err := json.Marshal(map[string]string{
+			"role":    "system",
+			"content": structuredOutputsInstruction + schema.String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("marshal instruction: %w", err)
+		}
+		messages = append([]json.RawMessage{instruction}, messages...)
+		requestBody["messages"], err = json.Marshal(messages)
+		if err != nil {
+			return nil, fmt.Errorf("marshal messages: %w", err)
+		}
+	}
+	return json.Marshal(requestBody)
+}
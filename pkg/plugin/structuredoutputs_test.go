@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// complexResponseFormat is a structured outputs response format whose keys,
+// at every level, aren't in alphabetical order.
+const complexResponseFormat = `{
+	"type": "json_schema",
+	"json_schema": {
+		"name": "incident_summary",
+		"strict": true,
+		"schema": {
+			"type": "object",
+			"properties": {
+				"title": {"type": "string"},
+				"severity": {"type": "string", "enum": ["critical", "major", "minor"]},
+				"timeline": {
+					"type": "array",
+					"items": {
+						"type": "object",
+						"properties": {
+							"time": {"type": "string", "format": "date-time"},
+							"event": {"type": "string"}
+						},
+						"required": ["time", "event"],
+						"additionalProperties": false
+					}
+				},
+				"impact": {
+					"anyOf": [
+						{"type": "null"},
+						{"type": "object", "properties": {"users": {"type": "integer"}, "services": {"type": "array", "items": {"type": "string"}}}, "required": ["users", "services"], "additionalProperties": false}
+					]
+				}
+			},
+			"required": ["title", "severity", "timeline", "impact"],
+			"additionalProperties": false
+		}
+	}
+}`
+
+func compactJSON(t *testing.T, s string) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(s)); err != nil {
+		t.Fatalf("compact: %s", err)
+	}
+	return b.String()
+}
+
+func TestStructuredOutputsRoundTrip(t *testing.T) {
+	var upstreamBody map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody = nil
+		_ = json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	// Transformers and extra fields re-encode the body, which must keep the
+	// schema's key order.
+	ts := &transformers{request: []RequestTransformer{
+		defaultParamsRequestTransformer(map[string]interface{}{"temperature": 0.2}),
+		forceModelRequestTransformer("gpt-4o-2024-08-06"),
+	}}
+	provider := &directOpenAIProvider{settings: OpenAISettings{URL: server.URL}}
+	proxy := newProviderProxy(provider, providerProxyOptions{transformers: ts, extraBodyFields: map[string]interface{}{"user": "grafana"}})
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Summarize the incident"}], "response_format": ` + complexResponseFormat + `}`
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	if got, exp := string(upstreamBody["response_format"]), compactJSON(t, complexResponseFormat); got != exp {
+		t.Errorf("expected the response format to be sent unchanged:\n%s\ngot:\n%s", exp, got)
+	}
+	if string(upstreamBody["model"]) != `"gpt-4o-2024-08-06"` || string(upstreamBody["temperature"]) != "0.2" || string(upstreamBody["user"]) != `"grafana"` {
+		t.Errorf("expected the other changes to be made, got %v", upstreamBody)
+	}
+}
+
+func TestStructuredOutputsStreamRequest(t *testing.T) {
+	app := &App{settings: &Settings{OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: "http://localhost"}}}
+	app.provider = &directOpenAIProvider{settings: app.settings.OpenAI}
+	data := []byte(`{"model": "gpt-4o", "messages": [], "response_format": ` + complexResponseFormat + `}`)
+	var requestBody map[string]interface{}
+	if err := json.Unmarshal(data, &requestBody); err != nil {
+		t.Fatalf("unmarshal: %s", err)
+	}
+	ordered := captureOrderedFields(data)
+	requestBody["stream"] = true
+	ordered.restore(requestBody)
+	req, err := app.newOpenAIChatCompletionsRequest(context.Background(), requestBody)
+	if err != nil {
+		t.Fatalf("new request: %s", err)
+	}
+	var sent map[string]json.RawMessage
+	if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if got, exp := string(sent["response_format"]), compactJSON(t, complexResponseFormat); got != exp {
+		t.Errorf("expected the response format to be sent unchanged:\n%s\ngot:\n%s", exp, got)
+	}
+}
+
+func TestStructuredOutputsRestoreChanged(t *testing.T) {
+	data := []byte(`{"response_format": {"type": "json_object"}, "tools": []}`)
+	var body map[string]interface{}
+	_ = json.Unmarshal(data, &body)
+	ordered := captureOrderedFields(data)
+	body["response_format"] = map[string]interface{}{"type": "text"}
+	ordered.restore(body)
+	if _, ok := body["response_format"].(map[string]interface{}); !ok {
+		t.Errorf("expected a changed field to be kept, got %#v", body["response_format"])
+	}
+	if _, ok := body["tools"].(json.RawMessage); !ok {
+		t.Errorf("expected an unchanged field to be restored, got %#v", body["tools"])
+	}
+}
+
+func TestStructuredOutputsFallback(t *testing.T) {
+	var upstreamBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody = nil
+		_ = json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"message": {"role": "assistant", "content": [{"type": "text", "text": "{}"}]}, "finish_reason": "COMPLETE"}`))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name     string
+		fallback StructuredOutputsFallback
+		system   string
+	}{
+		{name: "default", system: "Be brief."},
+		{name: "strip", fallback: StructuredOutputsFallbackStrip, system: "Be brief."},
+		{name: "instruct", fallback: StructuredOutputsFallbackInstruct, system: structuredOutputsInstruction},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &cohereProvider{settings: OpenAISettings{Provider: openAIProviderCohere, URL: server.URL}}
+			proxy := newProviderProxy(provider, providerProxyOptions{structuredOutputsFallback: tc.fallback})
+			body := `{"model": "command-r", "messages": [{"role": "system", "content": "Be brief."}, {"role": "user", "content": "Summarize the incident"}], "response_format": ` + complexResponseFormat + `}`
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", strings.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+			messages, _ := upstreamBody["messages"].([]interface{})
+			if len(messages) != 2 {
+				t.Fatalf("expected a system and a user message, got %v", upstreamBody["messages"])
+			}
+			system, _ := messages[0].(map[string]interface{})["content"].(string)
+			if !strings.HasPrefix(system, tc.system) {
+				t.Errorf("expected the system message to start with %q, got %q", tc.system, system)
+			}
+			if tc.fallback == StructuredOutputsFallbackInstruct && !strings.Contains(system, `"severity":{"type":"string","enum":["critical","major","minor"]}`) {
+				t.Errorf("expected the system message to include the schema, got %q", system)
+			}
+		})
+	}
+}
+
+func TestStripStructuredOutputs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		body     string
+		fallback StructuredOutputsFallback
+		exp      string
+	}{
+		{
+			name: "json schema",
+			body: `{"messages": [{"role": "user", "content": "Hi"}], "response_format": {"type": "json_schema", "json_schema": {"name": "a", "schema": {"type": "object"}}}}`,
+			exp:  `{"messages":[{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name:     "json schema with instruction",
+			body:     `{"messages": [{"role": "user", "content": "Hi"}], "response_format": {"type": "json_schema", "json_schema": {"name": "a", "schema": {"type": "object"}}}}`,
+			fallback: StructuredOutputsFallbackInstruct,
+			exp:      `{"messages":[{"content":"Respond only with a JSON value matching this JSON schema, without any other text:\n{\"type\":\"object\"}","role":"system"},{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name:     "json object",
+			body:     `{"messages": [], "response_format": {"type": "json_object"}}`,
+			fallback: StructuredOutputsFallbackInstruct,
+			exp:      `{"messages": [], "response_format": {"type": "json_object"}}`,
+		},
+		{
+			name: "no response format",
+			body: `{"messages": []}`,
+			exp:  `{"messages": []}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := stripStructuredOutputs([]byte(tc.body), tc.fallback)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(got) != tc.exp {
+				t.Errorf("expected %s, got %s", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestStructuredOutputsFallbackValidation(t *testing.T) {
+	if err := StructuredOutputsFallback("ignore").validate(); err == nil {
+		t.Error("expected an unknown fallback to be rejected")
+	}
+}
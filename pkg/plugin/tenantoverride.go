@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// ProviderConfig is a provider used instead of the top-level one, with its
+// own API key.
+type ProviderConfig struct {
+	// OpenAI configures the provider, like the top-level openAI settings.
+	OpenAI OpenAISettings `json:"openAI"`
+	// APIKeySecret is the name of the secure setting holding the provider's
+	// API key, or a list of keys separated by commas. Defaults to openAIKey.
+	APIKeySecret string `json:"apiKeySecret"`
+}
+
+// applyTenantOverride replaces the provider settings with those configured
+// for the tenant in TenantOverrides, if any. Only the tenant's override is
+// validated, so a mistake in another tenant's doesn't affect this one.
+func (s *Settings) applyTenantOverride(secrets map[string]string) error {
+	if s.Tenant == "" {
+		return nil
+	}
+	override, ok := s.TenantOverrides[s.Tenant]
+	if !ok {
+		return nil
+	}
+	openAI := override.OpenAI
+	if err := loadProviderSettings(&openAI, override.APIKeySecret, secrets); err != nil {
+		return fmt.Errorf("provider override for tenant %s: %w", s.Tenant, err)
+	}
+	openAI.embeddingsURL = s.OpenAI.embeddingsURL
+	s.OpenAI = openAI
+	// The OpenAI embedder authenticates with the tenant's key too.
+	s.Vector.Embed.OpenAI.APIKey = openAI.apiKey
+	log.DefaultLogger.Info("Using provider override for tenant", "tenant", s.Tenant, "provider", s.OpenAI.Provider)
+	return nil
+}
@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/embed"
+)
+
+func TestTenantOverrides(t *testing.T) {
+	ctx := context.Background()
+	type upstreamRequest struct {
+		server, path, auth string
+	}
+	var got upstreamRequest
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			if key := r.Header.Get("api-key"); key != "" {
+				auth = "api-key " + key
+			}
+			got = upstreamRequest{server: name, path: r.URL.Path, auth: auth}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"choices": []}`))
+		}))
+	}
+	gateway, openAI, azure := newServer("gateway"), newServer("openai"), newServer("azure")
+	defer gateway.Close()
+	defer openAI.Close()
+	defer azure.Close()
+
+	settings := Settings{
+		OpenAI:     OpenAISettings{Provider: openAIProviderGrafana},
+		LLMGateway: LLMGatewaySettings{URL: gateway.URL},
+		TenantOverrides: map[string]ProviderConfig{
+			"1": {
+				OpenAI:       OpenAISettings{Provider: openAIProviderOpenAI, URL: openAI.URL},
+				APIKeySecret: "tenant1Key",
+			},
+			"2": {
+				OpenAI:       OpenAISettings{Provider: openAIProviderAzure, URL: azure.URL, AzureMapping: [][]string{{"gpt-4", "gpt-4-deployment"}}},
+				APIKeySecret: "tenant2Key",
+			},
+		},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+
+	for _, tc := range []struct {
+		tenant string
+		exp    upstreamRequest
+	}{
+		{tenant: "1", exp: upstreamRequest{server: "openai", path: "/v1/chat/completions", auth: "Bearer key-1"}},
+		{tenant: "2", exp: upstreamRequest{server: "azure", path: "/openai/deployments/gpt-4-deployment/chat/completions", auth: "api-key key-2"}},
+		{tenant: "3", exp: upstreamRequest{server: "gateway", path: "/openai/v1/chat/completions", auth: "Basic " + base64.StdEncoding.EncodeToString([]byte("3:gcom-token"))}},
+	} {
+		t.Run(tc.tenant, func(t *testing.T) {
+			appSettings := backend.AppInstanceSettings{
+				JSONData: jsonData,
+				DecryptedSecureJSONData: map[string]string{
+					encodedTenantAndTokenKey: base64.StdEncoding.EncodeToString([]byte(tc.tenant + ":gcom-token")),
+					"tenant1Key":             "key-1",
+					"tenant2Key":             "key-2",
+				},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			got = upstreamRequest{}
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(`{"model": "gpt-4", "messages": []}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			if got != tc.exp {
+				t.Errorf("expected upstream request %+v, got %+v", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestTenantOverrideInvalid(t *testing.T) {
+	jsonData, err := json.Marshal(Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI},
+		TenantOverrides: map[string]ProviderConfig{
+			"1": {OpenAI: OpenAISettings{Provider: "unknown"}},
+			"2": {OpenAI: OpenAISettings{Provider: openAIProviderCohere}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	for tenant, expErr := range map[string]string{"1": "unsupported provider", "2": ""} {
+		_, err := NewApp(context.Background(), backend.AppInstanceSettings{
+			JSONData: jsonData,
+			DecryptedSecureJSONData: map[string]string{
+				openAIKey:                "abcd1234",
+				encodedTenantAndTokenKey: base64.StdEncoding.EncodeToString([]byte(tenant + ":gcom-token")),
+			},
+		})
+		switch {
+		case expErr == "" && err != nil:
+			t.Errorf("tenant %s: unexpected error: %s", tenant, err)
+		case expErr != "" && (err == nil || !strings.Contains(err.Error(), expErr)):
+			t.Errorf("tenant %s: expected an error containing %q, got %v", tenant, expErr, err)
+		}
+	}
+}
+
+func TestTenantOverrideEmbedder(t *testing.T) {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"openAI": map[string]interface{}{"provider": openAIProviderOpenAI, "url": "https://central.example.com"},
+		"vector": map[string]interface{}{"embed": map[string]interface{}{"type": embed.EmbedderOpenAI}},
+		"tenantOverrides": map[string]ProviderConfig{
+			"1": {
+				OpenAI:       OpenAISettings{Provider: openAIProviderOpenAI, URL: "https://tenant.example.com"},
+				APIKeySecret: "tenant1Key",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	for _, tc := range []struct {
+		tenant, expURL, expKey string
+	}{
+		{tenant: "1", expURL: "https://tenant.example.com", expKey: "key-1"},
+		{tenant: "2", expURL: "https://central.example.com", expKey: ""},
+	} {
+		t.Run(tc.tenant, func(t *testing.T) {
+			settings, err := loadSettings(backend.AppInstanceSettings{
+				JSONData: jsonData,
+				DecryptedSecureJSONData: map[string]string{
+					openAIKey:                "central-key",
+					encodedTenantAndTokenKey: base64.StdEncoding.EncodeToString([]byte(tc.tenant + ":gcom-token")),
+					"tenant1Key":             "key-1",
+				},
+			})
+			if err != nil {
+				t.Fatalf("load settings: %s", err)
+			}
+			got := settings.Vector.Embed.OpenAI
+			if got.URL != tc.expURL {
+				t.Errorf("expected embedder URL %q, got %q", tc.expURL, got.URL)
+			}
+			// An empty key means the embedder falls back to the openAIKey secret.
+			if got.APIKey != tc.expKey {
+				t.Errorf("expected embedder key %q, got %q", tc.expKey, got.APIKey)
+			}
+		})
+	}
+}
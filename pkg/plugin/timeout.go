@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// endpointTimeouts limits how long requests to a provider may take, by
+// endpoint. The timeout covers the whole response, including streamed ones,
+// and requests which time out get a 504 "provider_timeout" error.
+type endpointTimeouts struct {
+	// def is the timeout of endpoints without their own, or zero for none.
+	def   time.Duration
+	paths map[string]time.Duration
+	// prefixes are sorted longest first, so the most specific one matches.
+	prefixes []string
+	byPrefix map[string]time.Duration
+}
+
+// newEndpointTimeouts returns the timeouts for a provider with the default
+// timeout defaultSeconds, overridden by byEndpoint, or nil if there are
+// none. byEndpoint is keyed by paths relative to /openai, such as
+// /v1/embeddings, with a trailing `*` matching any path starting with the rest.
+// A timeout of zero disables the default for an endpoint.
+func newEndpointTimeouts(defaultSeconds int, byEndpoint map[string]int) *endpointTimeouts {
+	if defaultSeconds <= 0 && len(byEndpoint) == 0 {
+		return nil
+	}
+	t := &endpointTimeouts{
+		def:      time.Duration(defaultSeconds) * time.Second,
+		paths:    map[string]time.Duration{},
+		byPrefix: map[string]time.Duration{},
+	}
+	for endpoint, seconds := range byEndpoint {
+		timeout := time.Duration(seconds) * time.Second
+		if prefix, ok := strings.CutSuffix(endpoint, "*"); ok {
+			prefix = proxyPrefix(prefix)
+			t.prefixes = append(t.prefixes, prefix)
+			t.byPrefix[prefix] = timeout
+		} else {
+			t.paths[proxyEndpoint(endpoint)] = timeout
+		}
+	}
+	sort.Slice(t.prefixes, func(i, j int) bool { return len(t.prefixes[i]) > len(t.prefixes[j]) })
+	return t
+}
+
+// timeout returns the timeout of requests for path, or zero for none.
+func (t *endpointTimeouts) timeout(path string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	endpoint := proxyEndpoint(path)
+	if timeout, ok := t.paths[endpoint]; ok {
+		return timeout
+	}
+	for _, prefix := range t.prefixes {
+		if strings.HasPrefix(endpoint, prefix) {
+			return t.byPrefix[prefix]
+		}
+	}
+	return t.def
+}
+
+// middleware cancels requests which outlast their endpoint's timeout. A nil
+// endpointTimeouts returns next unchanged.
+func (t *endpointTimeouts) middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		timeout := t.timeout(req.URL.Path)
+		if timeout <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
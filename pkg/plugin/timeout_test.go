@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestEndpointTimeouts(t *testing.T) {
+	timeouts := newEndpointTimeouts(60, map[string]int{
+		"/v1/embeddings":              30,
+		"/v1/audio/*":                 300,
+		"/v1/audio/translations":      120,
+		"/openai/v1/chat/completions": 0,
+	})
+	for _, tc := range []struct {
+		path string
+		exp  time.Duration
+	}{
+		{path: "/openai/v1/embeddings", exp: 30 * time.Second},
+		{path: "/openai/v1/audio/transcriptions", exp: 5 * time.Minute},
+		{path: "/openai/v1/audio/translations", exp: 2 * time.Minute},
+		{path: "/openai/v1/chat/completions", exp: 0},
+		{path: "/openai/v1/completions", exp: time.Minute},
+	} {
+		if got := timeouts.timeout(tc.path); got != tc.exp {
+			t.Errorf("%s: expected timeout %s, got %s", tc.path, tc.exp, got)
+		}
+	}
+
+	if newEndpointTimeouts(0, nil) != nil {
+		t.Error("expected no timeouts by default")
+	}
+}
+
+func TestEndpointTimeoutsProxy(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(1500 * time.Millisecond):
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI:             OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL, TimeoutSeconds: 1},
+		TimeoutsByEndpoint: map[string]int{"/v1/embeddings": 5},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(ctx, appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	for _, tc := range []struct {
+		path      string
+		expStatus int
+	}{
+		// The provider's default of a second is too short.
+		{path: "/openai/v1/chat/completions", expStatus: http.StatusGatewayTimeout},
+		{path: "/openai/v1/embeddings", expStatus: http.StatusOK},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			var r mockCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          tc.path,
+				Body:          []byte(`{"model": "gpt-4o", "input": "hi", "messages": [{"role": "user", "content": "hi"}]}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RequestTransformer modifies a proxied chat or legacy completions request
+// before it is sent to the upstream provider. Returning an error aborts the
+// request; return a *TransformError to control the status code sent to the
+// client.
+type RequestTransformer func(*http.Request) error
+
+// ResponseTransformer modifies an upstream chat or legacy completions response
+// before it is returned to the client. Returning an error replaces the response
+// with an error; return a *TransformError to control the status code sent to
+// the client.
+type ResponseTransformer func(*http.Response) error
+
+// TransformError is returned by a transformer to short-circuit a request with a
+// specific HTTP status code.
+type TransformError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *TransformError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// transformers holds the ordered request and response transformers applied by
+// the proxy around the upstream call.
+type transformers struct {
+	mu       sync.RWMutex
+	request  []RequestTransformer
+	response []ResponseTransformer
+}
+
+func isChatCompletionsPath(path string) bool {
+	return strings.HasSuffix(path, "/chat/completions")
+}
+
+func isEmbeddingsPath(path string) bool {
+	return strings.HasSuffix(path, "/embeddings")
+}
+
+// transformRequest runs the registered request transformers in order, stopping
+// at the first error.
+func (t *transformers) transformRequest(req *http.Request) error {
+	if t == nil || !isCompletionsPath(req.URL.Path) {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, f := range t.request {
+		if err := f(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transformResponse runs the registered response transformers in order,
+// stopping at the first error. It is used as a ReverseProxy's ModifyResponse.
+func (t *transformers) transformResponse(resp *http.Response) error {
+	if t == nil || resp.Request == nil || !isCompletionsPath(requestedPath(resp)) {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, f := range t.response {
+		if err := f(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestedPath returns the path the client requested for a proxied
+// response, which may differ from the path sent to the provider.
+func requestedPath(resp *http.Response) string {
+	if info, ok := resp.Request.Context().Value(proxyRequestInfoKey{}).(proxyRequestInfo); ok {
+		return info.path
+	}
+	return resp.Request.URL.Path
+}
+
+// RegisterRequestTransformer adds a request transformer, to be run after any
+// already registered.
+func (a *App) RegisterRequestTransformer(f RequestTransformer) {
+	a.transformers.mu.Lock()
+	defer a.transformers.mu.Unlock()
+	a.transformers.request = append(a.transformers.request, f)
+}
+
+// RegisterResponseTransformer adds a response transformer, to be run after any
+// already registered.
+func (a *App) RegisterResponseTransformer(f ResponseTransformer) {
+	a.transformers.mu.Lock()
+	defer a.transformers.mu.Unlock()
+	a.transformers.response = append(a.transformers.response, f)
+}
+
+// rewriteJSONBody is a helper for transformers which need to inspect or modify
+// the JSON body of a request. The modified body is re-encoded and the request's
+// content length updated to match. The body is decoded into a map rather than a
+// struct so that fields the plugin doesn't know about are passed through, and
+// order-sensitive fields f leaves alone, such as a structured outputs schema,
+// are passed through as sent.
+func rewriteJSONBody(req *http.Request, f func(body map[string]interface{}) error) error {
+	bodyBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(bodyBytes, &body); err != nil {
+		return &TransformError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("unmarshal request body: %w", err)}
+	}
+	ordered := captureOrderedFields(bodyBytes)
+	if err := f(body); err != nil {
+		return err
+	}
+	ordered.restore(body)
+	newBodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshal request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(newBodyBytes))
+	req.ContentLength = int64(len(newBodyBytes))
+	return nil
+}
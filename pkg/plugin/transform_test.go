@@ -0,0 +1,247 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func newTransformTestApp(t *testing.T, serverURL string) (*App, backend.AppInstanceSettings) {
+	t.Helper()
+	settings := Settings{OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: serverURL}}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	appSettings := backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	}
+	inst, err := NewApp(context.Background(), appSettings)
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	return inst.(*App), appSettings
+}
+
+func TestTransformers(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name string
+
+		request  []RequestTransformer
+		response []ResponseTransformer
+
+		expUpstreamBody string
+		expStatus       int
+		expHeader       string
+	}{
+		{
+			name: "request transformers run in order",
+			request: []RequestTransformer{
+				func(req *http.Request) error {
+					return rewriteJSONBody(req, func(body map[string]interface{}) error {
+						body["model"] = "gpt-4"
+						return nil
+					})
+				},
+				func(req *http.Request) error {
+					return rewriteJSONBody(req, func(body map[string]interface{}) error {
+						body["model"] = body["model"].(string) + "-turbo"
+						return nil
+					})
+				},
+			},
+			expUpstreamBody: `{"messages":[],"model":"gpt-4-turbo"}`,
+			expStatus:       http.StatusOK,
+		},
+		{
+			name: "request transformer short-circuits",
+			request: []RequestTransformer{
+				func(req *http.Request) error {
+					return &TransformError{StatusCode: http.StatusForbidden, Err: errors.New("not allowed")}
+				},
+			},
+			expStatus: http.StatusForbidden,
+		},
+		{
+			name: "response transformer modifies response",
+			response: []ResponseTransformer{
+				func(resp *http.Response) error {
+					resp.Header.Set("X-Transformed", "true")
+					return nil
+				},
+			},
+			expStatus: http.StatusOK,
+			expHeader: "true",
+		},
+		{
+			name: "response transformer short-circuits",
+			response: []ResponseTransformer{
+				func(resp *http.Response) error {
+					return &TransformError{StatusCode: http.StatusUnprocessableEntity, Err: errors.New("bad response")}
+				},
+			},
+			expStatus: http.StatusUnprocessableEntity,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstreamBody = nil
+			app, appSettings := newTransformTestApp(t, server.URL)
+			for _, f := range tc.request {
+				app.RegisterRequestTransformer(f)
+			}
+			for _, f := range tc.response {
+				app.RegisterResponseTransformer(f)
+			}
+
+			var r mockCallResourceResponseSender
+			err := app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, r.response.Status)
+			}
+			if tc.expUpstreamBody != "" && string(upstreamBody) != tc.expUpstreamBody {
+				t.Errorf("upstream body should be %s, got %s", tc.expUpstreamBody, upstreamBody)
+			}
+			if tc.expStatus == http.StatusForbidden && upstreamBody != nil {
+				t.Errorf("request should not have been proxied")
+			}
+			if tc.expHeader != "" && http.Header(r.response.Headers).Get("X-Transformed") != tc.expHeader {
+				t.Errorf("expected X-Transformed header %s, got %v", tc.expHeader, r.response.Headers)
+			}
+		})
+	}
+}
+
+// newerFields are request fields the plugin doesn't know about, which must
+// reach the provider unchanged however the request is rewritten.
+var newerFields = map[string]interface{}{
+	"store":               true,
+	"metadata":            map[string]interface{}{"feature": "explain-query", "dashboard": "abc"},
+	"parallel_tool_calls": false,
+	"service_tier":        "auto",
+	"reasoning_effort":    "low",
+	"response_format":     map[string]interface{}{"type": "json_object"},
+	"stream_options":      map[string]interface{}{"include_usage": true},
+	"prediction":          map[string]interface{}{"type": "content", "content": "SELECT 1"},
+	"modalities":          []interface{}{"text"},
+	"user":                "user-1",
+}
+
+func TestUnknownFieldsPreserved(t *testing.T) {
+	ctx := context.Background()
+	var upstreamBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody = nil
+		_ = json.NewDecoder(r.Body).Decode(&upstreamBody)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": []}`))
+	}))
+	defer server.Close()
+
+	body := map[string]interface{}{
+		"model":    "gpt-4o",
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "Hello"}},
+		"stop":     "\n",
+	}
+	for k, v := range newerFields {
+		body[k] = v
+	}
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+
+	check := func(t *testing.T, got map[string]interface{}) {
+		t.Helper()
+		for k, v := range newerFields {
+			exp, _ := json.Marshal(v)
+			gotJSON, _ := json.Marshal(got[k])
+			if string(gotJSON) != string(exp) {
+				t.Errorf("expected %s to be %s, got %s", k, exp, gotJSON)
+			}
+		}
+	}
+
+	for _, provider := range []openAIProvider{openAIProviderOpenAI, openAIProviderAzure} {
+		t.Run(string(provider), func(t *testing.T) {
+			// Enable everything which rewrites the request body.
+			settings := Settings{
+				OpenAI: OpenAISettings{
+					Provider:        provider,
+					URL:             server.URL,
+					AzureMapping:    [][]string{{"gpt-4o-mini", "gpt-4o-mini"}},
+					ExtraBodyFields: map[string]interface{}{"seed": 1},
+				},
+				ForceModel:      "gpt-4o-mini",
+				DefaultParams:   map[string]interface{}{"temperature": 0.2},
+				MaxMessages:     10,
+				BlockedPatterns: []string{"forbidden"},
+			}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          bodyJSON,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", r.response.Status, r.response.Body)
+			}
+			check(t, upstreamBody)
+
+			// Streams build their requests separately.
+			streamBody := map[string]interface{}{}
+			_ = json.Unmarshal(bodyJSON, &streamBody)
+			streamBody["model"] = "gpt-4o-mini"
+			req, err := app.newOpenAIChatCompletionsRequest(ctx, streamBody)
+			if err != nil {
+				t.Fatalf("new request: %s", err)
+			}
+			var sent map[string]interface{}
+			if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+				t.Fatalf("decode stream request: %s", err)
+			}
+			check(t, sent)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// openAIUsage is the token usage reported in OpenAI-compatible API responses.
+type openAIUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// responseUsage reads the usage reported in a successful, non-streaming JSON
+// response, restoring the body so it can still be sent to the client. ok is
+// false if the response doesn't include usage, for example because it is
+// streamed or compressed.
+func responseUsage(resp *http.Response) (usage openAIUsage, ok bool, err error) {
+	if resp.StatusCode != http.StatusOK ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") ||
+		resp.Header.Get("Content-Encoding") != "" {
+		return openAIUsage{}, false, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return openAIUsage{}, false, fmt.Errorf("read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var parsed struct {
+		Usage *openAIUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil || parsed.Usage == nil {
+		return openAIUsage{}, false, nil
+	}
+	return *parsed.Usage, true, nil
+}
+
+// forceStreamUsage asks for the usage of a streamed completions request body
+// to be reported in a final chunk, by setting `stream_options.include_usage`.
+// It returns whether it changed the body, in which case the client didn't
+// ask for the chunk and it should be dropped from the response.
+func forceStreamUsage(body map[string]interface{}) bool {
+	if stream, _ := body["stream"].(bool); !stream {
+		return false
+	}
+	options, _ := body["stream_options"].(map[string]interface{})
+	if include, _ := options["include_usage"].(bool); include {
+		return false
+	}
+	if options == nil {
+		options = map[string]interface{}{}
+	}
+	options["include_usage"] = true
+	body["stream_options"] = options
+	return true
+}
+
+// chunkUsage returns the usage in the data of a streamed completions chunk,
+// if it has any. usageOnly is set if the chunk has no choices, so it was only
+// sent to report the usage.
+func chunkUsage(data []byte) (usage *openAIUsage, usageOnly bool) {
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return nil, false
+	}
+	var chunk struct {
+		Choices []json.RawMessage `json:"choices"`
+		Usage   *openAIUsage      `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil || chunk.Usage == nil {
+		return nil, false
+	}
+	return chunk.Usage, len(chunk.Choices) == 0
+}
+
+// isEventStream reports whether resp is a successful, uncompressed streamed
+// response.
+func isEventStream(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusOK && resp.Header.Get("Content-Encoding") == "" &&
+		strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// watchStreamUsage calls f with the usage reported in a streamed completions
+// response as the chunk reporting it is read by the client. Other responses
+// are left alone.
+func watchStreamUsage(resp *http.Response, f func(openAIUsage)) {
+	if !isEventStream(resp) {
+		return
+	}
+	resp.Body = &usageWatcher{ReadCloser: resp.Body, f: f}
+}
+
+// maxWatchedLine is the longest line usageWatcher buffers. The rest of longer
+// lines is skipped; usage chunks are much shorter.
+const maxWatchedLine = 1024 * 1024
+
+// usageWatcher passes a stream through unchanged, looking for usage in each
+// line as it goes by.
+type usageWatcher struct {
+	io.ReadCloser
+	f    func(openAIUsage)
+	line []byte
+	skip bool
+}
+
+func (w *usageWatcher) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			w.buffer(data)
+			break
+		}
+		w.buffer(data[:i])
+		if !w.skip {
+			if line, ok := bytes.CutPrefix(w.line, []byte("data:")); ok {
+				if usage, _ := chunkUsage(bytes.TrimSpace(line)); usage != nil {
+					w.f(*usage)
+				}
+			}
+		}
+		w.line, w.skip = w.line[:0], false
+		data = data[i+1:]
+	}
+	return n, err
+}
+
+func (w *usageWatcher) buffer(data []byte) {
+	if w.skip || len(w.line)+len(data) > maxWatchedLine {
+		w.skip = true
+		return
+	}
+	w.line = append(w.line, data...)
+}
+
+// dropStreamUsage removes the chunks which only report usage from a streamed
+// completions response, for clients which didn't ask for them.
+func dropStreamUsage(resp *http.Response) {
+	if !isEventStream(resp) {
+		return
+	}
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(dropUsageChunks(body, pw))
+	}()
+	resp.Body = &translatedStream{PipeReader: pr, body: body}
+}
+
+// dropUsageChunks copies a stream of completions chunks from r to w, leaving
+// out the events of chunks which only report usage. Events with a type, such
+// as usage events, are always kept.
+func dropUsageChunks(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var event bytes.Buffer
+	drop, typed := false, false
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if bytes.HasPrefix(line, []byte("event:")) {
+			typed = true
+		}
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if _, usageOnly := chunkUsage(bytes.TrimSpace(data)); usageOnly {
+				drop = true
+			}
+		}
+		event.Write(line)
+		event.WriteByte('\n')
+		// A blank line ends the event.
+		if len(line) > 0 {
+			continue
+		}
+		if !drop || typed {
+			if _, err := w.Write(event.Bytes()); err != nil {
+				return err
+			}
+		}
+		event.Reset()
+		drop, typed = false, false
+	}
+	if !drop || typed {
+		if _, err := w.Write(event.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,110 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultEmbedConcurrency is the number of batches embedded in parallel when
+// no concurrency is configured.
+const DefaultEmbedConcurrency = 4
+
+// BatchEmbedder is implemented by embedders which can embed several texts in
+// a single request.
+type BatchEmbedder interface {
+	EmbedBatch(ctx context.Context, model string, texts []string) ([][]float32, error)
+}
+
+// TokenBatchEmbedder is implemented by embedders which can embed several
+// pre-tokenized texts in a single request, such as OpenAI's, which accepts
+// lists of token IDs as input.
+type TokenBatchEmbedder interface {
+	EmbedTokenBatch(ctx context.Context, model string, tokens [][]int) ([][]float32, error)
+}
+
+// errTokensNotSupported is the error for each batch of tokens given to an
+// embedder which can't embed them.
+var errTokensNotSupported = errors.New("embedder does not support tokenized input")
+
+// BatchResult is the result of embedding a single batch of texts.
+type BatchResult struct {
+	// Embeddings are the embeddings of the batch's texts, in order, if Err is nil.
+	Embeddings [][]float32
+	Err        error
+}
+
+// EmbedBatches embeds each of batches using e, with at most concurrency
+// batches in flight at once so as to stay within the provider's rate limits.
+// A failed batch doesn't stop the others; the result for each batch, at the
+// same index, holds either its embeddings or its error.
+//
+// Embedders which don't implement BatchEmbedder embed a batch's texts one at
+// a time.
+func EmbedBatches(ctx context.Context, e Embedder, model string, batches [][]string, concurrency int) []BatchResult {
+	return embedConcurrently(batches, concurrency, func(texts []string) BatchResult {
+		return embedBatch(ctx, e, model, texts)
+	})
+}
+
+// EmbedTokenBatches is like EmbedBatches, but each batch is a list of
+// pre-tokenized texts. Every batch fails if e doesn't implement
+// TokenBatchEmbedder.
+func EmbedTokenBatches(ctx context.Context, e Embedder, model string, batches [][][]int, concurrency int) []BatchResult {
+	return embedConcurrently(batches, concurrency, func(tokens [][]int) BatchResult {
+		if err := ctx.Err(); err != nil {
+			return BatchResult{Err: err}
+		}
+		b, ok := e.(TokenBatchEmbedder)
+		if !ok {
+			return BatchResult{Err: errTokensNotSupported}
+		}
+		embeddings, err := b.EmbedTokenBatch(ctx, model, tokens)
+		return BatchResult{Embeddings: embeddings, Err: err}
+	})
+}
+
+// embedConcurrently calls embed for each of batches, with at most concurrency
+// calls in flight at once, returning the results in order.
+func embedConcurrently[T any](batches []T, concurrency int, embed func(T) BatchResult) []BatchResult {
+	if concurrency <= 0 {
+		concurrency = DefaultEmbedConcurrency
+	}
+	results := make([]BatchResult, len(batches))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(batches); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = embed(batches[i])
+			}
+		}()
+	}
+	for i := range batches {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func embedBatch(ctx context.Context, e Embedder, model string, texts []string) BatchResult {
+	if err := ctx.Err(); err != nil {
+		return BatchResult{Err: err}
+	}
+	if b, ok := e.(BatchEmbedder); ok {
+		embeddings, err := b.EmbedBatch(ctx, model, texts)
+		return BatchResult{Embeddings: embeddings, Err: err}
+	}
+	embeddings := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		embedding, err := e.Embed(ctx, model, text)
+		if err != nil {
+			return BatchResult{Err: err}
+		}
+		embeddings = append(embeddings, embedding)
+	}
+	return BatchResult{Embeddings: embeddings}
+}
@@ -0,0 +1,150 @@
+package embed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEmbedder embeds a text as a single value, its length, failing for texts
+// containing "fail".
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) Embed(ctx context.Context, model string, text string) ([]float32, error) {
+	if strings.Contains(text, "fail") {
+		return nil, errors.New("embedding failed")
+	}
+	return []float32{float32(len(text))}, nil
+}
+
+func (fakeEmbedder) Health(ctx context.Context, model string) error { return nil }
+
+func TestEmbedBatches(t *testing.T) {
+	batches := [][]string{{"a", "bb"}, {"ccc", "fail"}, {"dddd"}}
+	results := EmbedBatches(context.Background(), fakeEmbedder{}, "model", batches, 2)
+	if len(results) != len(batches) {
+		t.Fatalf("expected %d results, got %d", len(batches), len(results))
+	}
+	// The failed batch shouldn't affect the others.
+	if results[1].Err == nil {
+		t.Errorf("expected batch 1 to fail")
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil {
+			t.Fatalf("batch %d failed: %s", i, results[i].Err)
+		}
+		for j, text := range batches[i] {
+			if got := results[i].Embeddings[j]; len(got) != 1 || got[0] != float32(len(text)) {
+				t.Errorf("unexpected embedding for batch %d text %d: %v", i, j, got)
+			}
+		}
+	}
+}
+
+func TestEmbedBatchesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, r := range EmbedBatches(ctx, fakeEmbedder{}, "model", [][]string{{"a"}, {"b"}}, 1) {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("expected batch %d to be cancelled, got %v", i, r.Err)
+		}
+	}
+}
+
+func TestOpenAIEmbedBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Return the embeddings out of order, as the API may.
+		data := make([]string, 0, len(req.Input))
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			data = append(data, fmt.Sprintf(`{"index": %d, "embedding": [%d]}`, i, len(req.Input[i])))
+		}
+		_, _ = w.Write([]byte(`{"data": [` + strings.Join(data, ",") + `]}`))
+	}))
+	defer server.Close()
+
+	e := newOpenAIEmbedder(Settings{Type: EmbedderOpenAI, OpenAI: openAISettings{URL: server.URL}}, nil)
+	results := EmbedBatches(context.Background(), e, "model", [][]string{{"a", "bb", "ccc"}}, 1)
+	if results[0].Err != nil {
+		t.Fatalf("embed batch: %s", results[0].Err)
+	}
+	for i, exp := range []float32{1, 2, 3} {
+		if got := results[0].Embeddings[i]; len(got) != 1 || got[0] != exp {
+			t.Errorf("expected embedding %d to be [%v], got %v", i, exp, got)
+		}
+	}
+}
+
+func TestOpenAIEmbedTokenBatches(t *testing.T) {
+	var input json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input json.RawMessage `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		input = req.Input
+		_, _ = w.Write([]byte(`{"data": [{"index": 1, "embedding": [2]}, {"index": 0, "embedding": [3]}]}`))
+	}))
+	defer server.Close()
+
+	e := newOpenAIEmbedder(Settings{Type: EmbedderOpenAI, OpenAI: openAISettings{URL: server.URL}}, nil)
+	results := EmbedTokenBatches(context.Background(), e, "model", [][][]int{{{1, 2, 3}, {4, 5}}}, 1)
+	if results[0].Err != nil {
+		t.Fatalf("embed token batch: %s", results[0].Err)
+	}
+	if string(input) != "[[1,2,3],[4,5]]" {
+		t.Errorf("expected the tokens to be sent as the input, got %s", input)
+	}
+	for i, exp := range []float32{3, 2} {
+		if got := results[0].Embeddings[i]; len(got) != 1 || got[0] != exp {
+			t.Errorf("expected embedding %d to be [%v], got %v", i, exp, got)
+		}
+	}
+
+	results = EmbedTokenBatches(context.Background(), fakeEmbedder{}, "model", [][][]int{{{1}}}, 1)
+	if !errors.Is(results[0].Err, errTokensNotSupported) {
+		t.Errorf("expected an error for an embedder without token support, got %v", results[0].Err)
+	}
+}
+
+// BenchmarkEmbedBatches compares embedding batches serially and in parallel
+// against a server with a fixed latency per request.
+func BenchmarkEmbedBatches(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"data": [{"index": 0, "embedding": [0.1]}, {"index": 1, "embedding": [0.2]}]}`))
+	}))
+	defer server.Close()
+
+	e := newOpenAIEmbedder(Settings{Type: EmbedderOpenAI, OpenAI: openAISettings{URL: server.URL}}, nil)
+	batches := make([][]string, 32)
+	for i := range batches {
+		batches[i] = []string{"hello", "world"}
+	}
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j, r := range EmbedBatches(context.Background(), e, "model", batches, concurrency) {
+					if r.Err != nil {
+						b.Fatalf("batch %d: %s", j, r.Err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -2,12 +2,20 @@ package embed
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
 type EmbedderType string
 
+// OpenAIKeySecret is the name of the secure setting holding the OpenAI API
+// key. The plugin's provider settings read the key from the same one.
+const OpenAIKeySecret = "openAIKey"
+
 const (
 	EmbedderOpenAI           EmbedderType = "openai"
 	EmbedderGrafanaVectorAPI EmbedderType = "grafana/vectorapi"
@@ -23,6 +31,59 @@ type Settings struct {
 
 	OpenAI                   openAISettings
 	GrafanaVectorAPISettings grafanaVectorAPISettings `json:"grafanaVectorAPI"`
+
+	// Dimensions, if set, asks models which support it (such as OpenAI's
+	// text-embedding-3 models) for shorter embeddings of this many
+	// dimensions. It must match the dimension of the stored embeddings.
+	Dimensions int `json:"dimensions"`
+
+	// NormalizeEmbeddings scales every embedding to unit length (L2 norm),
+	// for stores whose similarity search expects normalized vectors. It
+	// applies to embeddings for both indexing and searches, so they stay
+	// comparable.
+	NormalizeEmbeddings bool `json:"normalizeEmbeddings"`
+
+	// FallbackEmbedModel, if set, is used for searches when the vector
+	// model fails with a retryable error, such as being rate limited. Its
+	// embeddings are only used if their dimension matches the collection's,
+	// so it must produce embeddings comparable with the stored ones.
+	FallbackEmbedModel string `json:"fallbackEmbedModel"`
+
+	// EmbeddingsURL, if set, is the base URL of the OpenAI compatible API
+	// embeddings are requested from, instead of the OpenAI provider's URL,
+	// for setups with a separate embeddings endpoint.
+	EmbeddingsURL string `json:"embeddingsUrl"`
+
+	// UserAgent is sent as the User-Agent header of embedding requests. It is
+	// set by the plugin rather than configured directly.
+	UserAgent string `json:"-"`
+}
+
+// StatusError is returned when the embeddings API responds with an error
+// status.
+type StatusError struct {
+	Provider   string
+	Status     string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("got non-2xx status from %s: %s", e.Provider, e.Status)
+}
+
+// IsRetryable reports whether an error from an Embedder might not happen
+// again, perhaps with another model: the API was rate limited, failed or
+// couldn't be reached. Cancelled requests aren't retryable.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
 }
 
 // NewEmbedder creates a new embedder.
@@ -0,0 +1,19 @@
+package embed
+
+import "math"
+
+// normalizeL2 scales v in place to unit length. Zero vectors have no
+// direction, so are left alone.
+func normalizeL2(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := math.Sqrt(sum)
+	for i, x := range v {
+		v[i] = float32(float64(x) / norm)
+	}
+}
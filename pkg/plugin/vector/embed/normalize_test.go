@@ -0,0 +1,88 @@
+package embed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func l2Norm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
+
+func TestNormalizeEmbeddings(t *testing.T) {
+	// Each text's embedding is given by the text itself.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input interface{} `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		inputs, ok := req.Input.([]interface{})
+		if !ok {
+			inputs = []interface{}{req.Input}
+		}
+		data := make([]openAIEmbeddingData, len(inputs))
+		for i, input := range inputs {
+			data[i].Index = i
+			_ = json.Unmarshal([]byte(input.(string)), &data[i].Embedding)
+		}
+		_ = json.NewEncoder(w).Encode(openAIEmbeddingsResponse{Data: data})
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	for _, normalize := range []bool{false, true} {
+		t.Run(fmt.Sprintf("normalize=%t", normalize), func(t *testing.T) {
+			e := newOpenAIEmbedder(Settings{Type: EmbedderOpenAI, OpenAI: openAISettings{URL: server.URL}, NormalizeEmbeddings: normalize}, nil)
+
+			// Queries.
+			embedding, err := e.Embed(ctx, "model", "[3, 4]")
+			if err != nil {
+				t.Fatalf("embed: %s", err)
+			}
+			exp := []float32{3, 4}
+			if normalize {
+				exp = []float32{0.6, 0.8}
+			}
+			for i := range exp {
+				if math.Abs(float64(embedding[i]-exp[i])) > 1e-6 {
+					t.Errorf("expected %v, got %v", exp, embedding)
+					break
+				}
+			}
+
+			// Indexing.
+			results := EmbedBatches(ctx, e, "model", [][]string{{"[1, 2, 2]", "[0.1, -0.2, 0.3, 0.4]"}, {"[10]"}}, 2)
+			for _, r := range results {
+				if r.Err != nil {
+					t.Fatalf("embed batch: %s", r.Err)
+				}
+				for _, v := range r.Embeddings {
+					if n := l2Norm(v); normalize && math.Abs(n-1) > 1e-6 {
+						t.Errorf("expected unit norm, got %f for %v", n, v)
+					} else if !normalize && n == 1 {
+						t.Errorf("expected the embedding to be left alone, got %v", v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeL2Zero(t *testing.T) {
+	v := []float32{0, 0, 0}
+	normalizeL2(v)
+	for _, x := range v {
+		if x != 0 {
+			t.Fatalf("expected a zero vector to be left alone, got %v", v)
+		}
+	}
+}
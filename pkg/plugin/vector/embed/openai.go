@@ -15,6 +15,8 @@ import (
 type openAISettings struct {
 	URL      string
 	AuthType string
+	// APIKey, if set, is used instead of the openAIKey secret.
+	APIKey string `json:"-"`
 }
 
 type grafanaVectorAPISettings struct {
@@ -35,11 +37,45 @@ type openAIClient struct {
 	authType     string
 	providerType EmbedderType
 	authSettings openAIEmbeddingsAuthSettings
+	userAgent    string
+	dimensions   int
+	normalize    bool
 }
 
 type openAIEmbeddingsRequest struct {
 	Model string `json:"model"`
-	Input string `json:"input"`
+	// Input is the text to embed, a list of texts, or a list of pre-tokenized
+	// texts.
+	Input interface{} `json:"input"`
+	// Dimensions is the number of dimensions of the embeddings returned, for
+	// models which support shortening them.
+	Dimensions int `json:"dimensions,omitempty"`
+}
+
+// maxDimensions are the full embedding dimensions of OpenAI models which
+// support shortening their embeddings with the `dimensions` parameter.
+var maxDimensions = map[string]int{
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+}
+
+// checkDimensions checks that model can return embeddings of dimensions
+// dimensions. Models we don't know about are left to reject the request
+// themselves, since other OpenAI compatible services may support it.
+func checkDimensions(model string, dimensions int) error {
+	if dimensions < 0 {
+		return fmt.Errorf("invalid embedding dimensions %d", dimensions)
+	}
+	if dimensions == 0 {
+		return nil
+	}
+	if model == "text-embedding-ada-002" {
+		return fmt.Errorf("model %s does not support setting embedding dimensions", model)
+	}
+	if limit, ok := maxDimensions[model]; ok && dimensions > limit {
+		return fmt.Errorf("model %s has at most %d embedding dimensions, got %d", model, limit, dimensions)
+	}
+	return nil
 }
 
 type openAIEmbeddingsResponse struct {
@@ -47,6 +83,7 @@ type openAIEmbeddingsResponse struct {
 }
 
 type openAIEmbeddingData struct {
+	Index     int       `json:"index"`
 	Embedding []float32 `json:"embedding"`
 }
 
@@ -72,7 +109,54 @@ func (o *openAIClient) getProviderString() string {
 }
 
 func (o *openAIClient) Embed(ctx context.Context, model string, payload string) ([]float32, error) {
+	data, err := o.embed(ctx, model, payload)
+	if err != nil {
+		return nil, err
+	}
+	return data[0].Embedding, nil
+}
+
+// EmbedBatch embeds all of texts in a single request.
+func (o *openAIClient) EmbedBatch(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	data, err := o.embed(ctx, model, texts)
+	if err != nil {
+		return nil, err
+	}
+	return orderEmbeddings(data, len(texts))
+}
+
+// EmbedTokenBatch embeds all of the pre-tokenized texts in a single request.
+func (o *openAIClient) EmbedTokenBatch(ctx context.Context, model string, tokens [][]int) ([][]float32, error) {
+	data, err := o.embed(ctx, model, tokens)
+	if err != nil {
+		return nil, err
+	}
+	return orderEmbeddings(data, len(tokens))
+}
+
+// orderEmbeddings returns the embeddings in data in the order of the n inputs
+// they were requested for.
+func orderEmbeddings(data []openAIEmbeddingData, n int) ([][]float32, error) {
+	if len(data) != n {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", n, len(data))
+	}
+	embeddings := make([][]float32, n)
+	for _, d := range data {
+		if d.Index < 0 || d.Index >= n {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	return embeddings, nil
+}
+
+// embed requests the embeddings of input, which is either a string, a list
+// of strings or a list of token lists, returning at least one embedding.
+func (o *openAIClient) embed(ctx context.Context, model string, input interface{}) ([]openAIEmbeddingData, error) {
 	// TODO: ensure payload is under 8191 tokens, somehow.
+	if err := checkDimensions(model, o.dimensions); err != nil {
+		return nil, err
+	}
 	url := o.url
 	if url == "" {
 		url = "https://api.openai.com"
@@ -80,8 +164,9 @@ func (o *openAIClient) Embed(ctx context.Context, model string, payload string)
 	url = strings.TrimSuffix(url, "/")
 	url = url + "/v1/embeddings"
 	reqBody := openAIEmbeddingsRequest{
-		Model: model,
-		Input: payload,
+		Model:      model,
+		Input:      input,
+		Dimensions: o.dimensions,
 	}
 	bodyJSON, err := json.Marshal(reqBody)
 	if err != nil {
@@ -92,6 +177,9 @@ func (o *openAIClient) Embed(ctx context.Context, model string, payload string)
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if o.userAgent != "" {
+		req.Header.Set("User-Agent", o.userAgent)
+	}
 	o.setAuth(req)
 
 	resp, err := o.client.Do(req)
@@ -104,9 +192,17 @@ func (o *openAIClient) Embed(ctx context.Context, model string, payload string)
 		}
 	}()
 	if resp.StatusCode/100 != 2 {
-		return nil, fmt.Errorf("got non-2xx status from %s: %s", o.getProviderString(), resp.Status)
+		return nil, &StatusError{Provider: o.getProviderString(), Status: resp.Status, StatusCode: resp.StatusCode}
 	}
-	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024*2))
+	// Allow up to 2MiB per embedding requested.
+	limit := int64(1024 * 1024 * 2)
+	switch input := input.(type) {
+	case []string:
+		limit *= int64(max(len(input), 1))
+	case [][]int:
+		limit *= int64(max(len(input), 1))
+	}
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, limit))
 	if err != nil {
 		return nil, fmt.Errorf("read response body: %w", err)
 	}
@@ -118,7 +214,21 @@ func (o *openAIClient) Embed(ctx context.Context, model string, payload string)
 	if len(body.Data) == 0 {
 		return nil, fmt.Errorf("no embeddings returned")
 	}
-	return body.Data[0].Embedding, nil
+	// The embeddings must match those in the store, so don't silently use
+	// a full-size embedding if the service ignored the dimensions.
+	if o.dimensions > 0 {
+		for _, d := range body.Data {
+			if len(d.Embedding) != o.dimensions {
+				return nil, fmt.Errorf("expected embeddings with %d dimensions, got %d", o.dimensions, len(d.Embedding))
+			}
+		}
+	}
+	if o.normalize {
+		for _, d := range body.Data {
+			normalizeL2(d.Embedding)
+		}
+	}
+	return body.Data, nil
 }
 
 func (o *openAIClient) Health(ctx context.Context, model string) error {
@@ -131,13 +241,17 @@ func newOpenAIEmbedder(settings Settings, secrets map[string]string) Embedder {
 	var impl openAIClient
 	switch settings.Type {
 	case EmbedderOpenAI:
+		key := settings.OpenAI.APIKey
+		if key == "" {
+			key = secrets[OpenAIKeySecret]
+		}
 		impl = openAIClient{
 			client:       &http.Client{},
 			url:          settings.OpenAI.URL,
 			authType:     string(settings.OpenAI.AuthType),
 			providerType: settings.Type,
 			authSettings: openAIEmbeddingsAuthSettings{
-				OpenAIKey: secrets["openAIKey"],
+				OpenAIKey: key,
 			},
 		}
 	case EmbedderGrafanaVectorAPI:
@@ -154,6 +268,9 @@ func newOpenAIEmbedder(settings Settings, secrets map[string]string) Embedder {
 	default:
 		return nil
 	}
+	impl.userAgent = settings.UserAgent
+	impl.dimensions = settings.Dimensions
+	impl.normalize = settings.NormalizeEmbeddings
 
 	return &impl
 }
@@ -0,0 +1,96 @@
+package embed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIEmbedDimensions(t *testing.T) {
+	var requested map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = nil
+		_ = json.NewDecoder(r.Body).Decode(&requested)
+		// Shorten the embedding if asked to, unless the model is "ignores-dimensions".
+		n := 8
+		if d, ok := requested["dimensions"].(float64); ok && requested["model"] != "ignores-dimensions" {
+			n = int(d)
+		}
+		embedding := strings.TrimSuffix(strings.Repeat("0.1,", n), ",")
+		_, _ = fmt.Fprintf(w, `{"data": [{"index": 0, "embedding": [%s]}]}`, embedding)
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name       string
+		model      string
+		dimensions int
+
+		expLen int
+		expErr string
+	}{
+		{name: "full size", model: "text-embedding-3-small", expLen: 8},
+		{name: "shortened", model: "text-embedding-3-small", dimensions: 4, expLen: 4},
+		{name: "unknown model", model: "my-model", dimensions: 4, expLen: 4},
+		{name: "more than the model's max", model: "text-embedding-3-small", dimensions: 2048, expErr: "at most 1536"},
+		{name: "unsupported model", model: "text-embedding-ada-002", dimensions: 4, expErr: "does not support"},
+		{name: "dimensions ignored", model: "ignores-dimensions", dimensions: 4, expErr: "expected embeddings with 4 dimensions, got 8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requested = nil
+			e := newOpenAIEmbedder(Settings{Type: EmbedderOpenAI, OpenAI: openAISettings{URL: server.URL}, Dimensions: tc.dimensions}, nil)
+			embedding, err := e.Embed(context.Background(), tc.model, "hello")
+			if tc.expErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("embed: %s", err)
+			}
+			if len(embedding) != tc.expLen {
+				t.Errorf("expected %d dimensions, got %d", tc.expLen, len(embedding))
+			}
+			if _, ok := requested["dimensions"]; ok != (tc.dimensions > 0) {
+				t.Errorf("expected dimensions to be sent only if set, got request %v", requested)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		exp    bool
+	}{
+		{status: http.StatusTooManyRequests, exp: true},
+		{status: http.StatusServiceUnavailable, exp: true},
+		{status: http.StatusBadRequest, exp: false},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+		}))
+		e := newOpenAIEmbedder(Settings{Type: EmbedderOpenAI, OpenAI: openAISettings{URL: server.URL}}, nil)
+		_, err := e.Embed(context.Background(), "model", "hello")
+		server.Close()
+		if got := IsRetryable(err); got != tc.exp {
+			t.Errorf("status %d: expected retryable to be %t, got %t (%v)", tc.status, tc.exp, got, err)
+		}
+	}
+
+	// Nothing listens on port 1, so the request fails to connect.
+	e := newOpenAIEmbedder(Settings{Type: EmbedderOpenAI, OpenAI: openAISettings{URL: "http://127.0.0.1:1"}}, nil)
+	if _, err := e.Embed(context.Background(), "model", "hello"); !IsRetryable(err) {
+		t.Errorf("expected a connection failure to be retryable, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.Embed(ctx, "model", "hello"); IsRetryable(err) {
+		t.Errorf("expected a cancelled request not to be retryable, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ package vector
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/embed"
@@ -13,22 +14,71 @@ import (
 
 type Service interface {
 	Search(ctx context.Context, collection string, query string, topK uint64, filter map[string]interface{}) ([]store.SearchResult, error)
+	// SearchByVector searches collection with a precomputed embedding,
+	// without using the embedder, so it works even if the embedder is down.
+	SearchByVector(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]store.SearchResult, error)
+	// HybridSearch searches collection combining keyword scoring of query
+	// with vector similarity, weighted by alpha between 0 and 1; see
+	// store.HybridSearcher. The query is embedded unless vector, its
+	// embedding, is given. Stores which can't do hybrid searches do a vector
+	// search instead; the returned bool reports whether the search was
+	// hybrid.
+	HybridSearch(ctx context.Context, collection string, query string, vector []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]store.SearchResult, bool, error)
+	// MultiSearch searches several collections with a single embedding of
+	// query, merging the results by score. See store.MultiSearch.
+	MultiSearch(ctx context.Context, collections []string, query string, topK uint64, filter map[string]interface{}) (store.MultiSearchResult, error)
+	// Embed returns the embedding of text using model, or the configured model if empty.
+	Embed(ctx context.Context, model string, text string) ([]float32, error)
+	// EmbedBatches embeds batches of texts in parallel using model, or the
+	// configured model if empty. See embed.EmbedBatches.
+	EmbedBatches(ctx context.Context, model string, batches [][]string) []embed.BatchResult
+	// CollectionExists reports whether collection exists in the store.
+	CollectionExists(ctx context.Context, collection string) (bool, error)
+	// CacheStats returns the stats of the store's search cache, and false if
+	// caching is disabled.
+	CacheStats() (store.CacheStats, bool)
 	Health(ctx context.Context) error
 	Cancel()
 }
 
+// ErrDimensionMismatch is returned by SearchByVector when the vector's
+// dimension differs from that of the collection.
+var ErrDimensionMismatch = errors.New("vector dimension does not match the collection")
+
 type VectorSettings struct {
 	Enabled bool           `json:"enabled"`
 	Model   string         `json:"model"`
 	Embed   embed.Settings `json:"embed"`
 	Store   store.Settings `json:"store"`
+	// EmbedConcurrency is the number of batches embedded in parallel by
+	// EmbedBatches. Defaults to embed.DefaultEmbedConcurrency.
+	EmbedConcurrency int `json:"embedConcurrency"`
+	// HealthCollections are the collections whose existence is checked by
+	// health checks, so that a missing one is reported by name.
+	HealthCollections []string `json:"healthCollections"`
+	// DeepCheck configures an end to end search made by health checks.
+	DeepCheck DeepCheckSettings `json:"deepCheck"`
+}
+
+// DeepCheckSettings configures a health check of the search pipeline:
+// embedding Query, searching Collection with it, and checking that the
+// document with ID ExpectedID is the top result. The stores are read-only, so
+// the document must already be in the collection, and the check can't cover
+// storing documents the way a write and read round trip would.
+type DeepCheckSettings struct {
+	Enabled    bool   `json:"enabled"`
+	Collection string `json:"collection"`
+	Query      string `json:"query"`
+	ExpectedID string `json:"expectedId"`
 }
 
 type vectorService struct {
-	embedder embed.Embedder
-	model    string
-	store    store.ReadVectorStore
-	cancel   context.CancelFunc
+	embedder         embed.Embedder
+	model            string
+	fallbackModel    string
+	embedConcurrency int
+	store            store.ReadVectorStore
+	cancel           context.CancelFunc
 }
 
 func NewService(s VectorSettings, secrets map[string]string) (Service, error) {
@@ -52,10 +102,12 @@ func NewService(s VectorSettings, secrets map[string]string) (Service, error) {
 	}
 
 	return &vectorService{
-		embedder: em,
-		store:    st,
-		model:    s.Model,
-		cancel:   cancel,
+		embedder:         em,
+		store:            st,
+		model:            s.Model,
+		fallbackModel:    s.Embed.FallbackEmbedModel,
+		embedConcurrency: s.EmbedConcurrency,
+		cancel:           cancel,
 	}, nil
 }
 
@@ -71,14 +123,13 @@ func (v *vectorService) Search(ctx context.Context, collection string, query str
 		return nil, fmt.Errorf("collection %s not found in store", collection)
 	}
 
-	log.DefaultLogger.Info("Embedding", "model", v.model, "query", query)
 	// Get the embedding for the search query.
-	e, err := v.embedder.Embed(ctx, v.model, query)
+	e, err := v.embedQuery(ctx, query, []string{collection})
 	if err != nil {
-		return nil, fmt.Errorf("embed query: %w", err)
+		return nil, err
 	}
 
-	log.DefaultLogger.Info("Searching", "collection", collection, "query", query)
+	log.DefaultLogger.FromContext(ctx).Info("Searching", "collection", collection, "query", query)
 	// Search the vector store for similar vectors.
 	results, err := v.store.Search(ctx, collection, e, topK, filter)
 	if err != nil {
@@ -88,6 +139,158 @@ func (v *vectorService) Search(ctx context.Context, collection string, query str
 	return results, nil
 }
 
+// embedQuery embeds a query for searching collections. If the model fails
+// with a retryable error, the fallback model is used instead, but only if its
+// embedding has the same dimension as every collection, since embeddings of
+// different dimensions can't be compared. Embeddings of the same dimension
+// from different models aren't necessarily comparable either, so the
+// fallback should be chosen with care.
+func (v *vectorService) embedQuery(ctx context.Context, query string, collections []string) ([]float32, error) {
+	log.DefaultLogger.FromContext(ctx).Info("Embedding", "model", v.model, "query", query)
+	e, err := v.embedder.Embed(ctx, v.model, query)
+	if err == nil {
+		return e, nil
+	}
+	if v.fallbackModel == "" || v.fallbackModel == v.model || !embed.IsRetryable(err) {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+	log.DefaultLogger.FromContext(ctx).Warn("Embedding failed, trying the fallback model", "model", v.model, "fallbackModel", v.fallbackModel, "err", err)
+	fallback, fallbackErr := v.embedder.Embed(ctx, v.fallbackModel, query)
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("embed query: %w (fallback model %s: %s)", err, v.fallbackModel, fallbackErr)
+	}
+	for _, collection := range collections {
+		// Missing collections can't be searched, so their dimension
+		// doesn't matter.
+		if exists, existsErr := v.store.CollectionExists(ctx, collection); existsErr == nil && !exists {
+			continue
+		}
+		dimension, dimensionErr := v.store.CollectionDimension(ctx, collection)
+		if dimensionErr != nil {
+			return nil, fmt.Errorf("embed query: %w (fallback model %s: collection %s dimension: %s)", err, v.fallbackModel, collection, dimensionErr)
+		}
+		if dimension > 0 && dimension != len(fallback) {
+			return nil, fmt.Errorf("embed query: %w (refusing fallback model %s: %w: collection %s has dimension %d, got %d)", err, v.fallbackModel, ErrDimensionMismatch, collection, dimension, len(fallback))
+		}
+	}
+	return fallback, nil
+}
+
+func (v *vectorService) SearchByVector(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]store.SearchResult, error) {
+	if len(vector) == 0 {
+		return nil, fmt.Errorf("vector cannot be empty")
+	}
+	exists, err := v.store.CollectionExists(ctx, collection)
+	if err != nil {
+		return nil, fmt.Errorf("vector store collections: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("collection %s not found in store", collection)
+	}
+	dimension, err := v.store.CollectionDimension(ctx, collection)
+	if err != nil {
+		return nil, fmt.Errorf("vector store collection dimension: %w", err)
+	}
+	// Stores which can't tell the dimension report 0, leaving the search
+	// itself to fail.
+	if dimension > 0 && len(vector) != dimension {
+		return nil, fmt.Errorf("%w: collection %s has dimension %d, got %d", ErrDimensionMismatch, collection, dimension, len(vector))
+	}
+
+	log.DefaultLogger.FromContext(ctx).Info("Searching by vector", "collection", collection, "dimension", len(vector))
+	results, err := v.store.Search(ctx, collection, vector, topK, filter)
+	if err != nil {
+		return nil, fmt.Errorf("vector store search: %w", err)
+	}
+	return results, nil
+}
+
+func (v *vectorService) HybridSearch(ctx context.Context, collection string, query string, vector []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]store.SearchResult, bool, error) {
+	if query == "" {
+		return nil, false, fmt.Errorf("query cannot be empty")
+	}
+	if alpha < 0 || alpha > 1 {
+		return nil, false, fmt.Errorf("alpha must be between 0 and 1, got %g", alpha)
+	}
+	exists, err := v.store.CollectionExists(ctx, collection)
+	if err != nil {
+		return nil, false, fmt.Errorf("vector store collections: %w", err)
+	}
+	if !exists {
+		return nil, false, fmt.Errorf("collection %s not found in store", collection)
+	}
+	if len(vector) == 0 {
+		if vector, err = v.embedQuery(ctx, query, []string{collection}); err != nil {
+			return nil, false, err
+		}
+	} else {
+		dimension, err := v.store.CollectionDimension(ctx, collection)
+		if err != nil {
+			return nil, false, fmt.Errorf("vector store collection dimension: %w", err)
+		}
+		if dimension > 0 && len(vector) != dimension {
+			return nil, false, fmt.Errorf("%w: collection %s has dimension %d, got %d", ErrDimensionMismatch, collection, dimension, len(vector))
+		}
+	}
+
+	log.DefaultLogger.FromContext(ctx).Info("Hybrid searching", "collection", collection, "query", query, "alpha", alpha)
+	results, hybrid, err := store.HybridSearch(ctx, v.store, collection, query, vector, alpha, topK, filter)
+	if err != nil {
+		return nil, false, fmt.Errorf("vector store search: %w", err)
+	}
+	return results, hybrid, nil
+}
+
+func (v *vectorService) MultiSearch(ctx context.Context, collections []string, query string, topK uint64, filter map[string]interface{}) (store.MultiSearchResult, error) {
+	if query == "" {
+		return store.MultiSearchResult{}, fmt.Errorf("query cannot be empty")
+	}
+	if len(collections) == 0 {
+		return store.MultiSearchResult{}, fmt.Errorf("at least one collection is required")
+	}
+
+	e, err := v.embedQuery(ctx, query, collections)
+	if err != nil {
+		return store.MultiSearchResult{}, err
+	}
+
+	log.DefaultLogger.FromContext(ctx).Info("Searching", "collections", collections, "query", query)
+	results, err := store.MultiSearch(ctx, v.store, collections, e, topK, filter)
+	if err != nil {
+		return store.MultiSearchResult{}, fmt.Errorf("vector store search: %w", err)
+	}
+	return results, nil
+}
+
+func (v *vectorService) Embed(ctx context.Context, model string, text string) ([]float32, error) {
+	if text == "" {
+		return nil, fmt.Errorf("text cannot be empty")
+	}
+	if model == "" {
+		model = v.model
+	}
+	e, err := v.embedder.Embed(ctx, model, text)
+	if err != nil {
+		return nil, fmt.Errorf("embed text: %w", err)
+	}
+	return e, nil
+}
+
+func (v *vectorService) EmbedBatches(ctx context.Context, model string, batches [][]string) []embed.BatchResult {
+	if model == "" {
+		model = v.model
+	}
+	return embed.EmbedBatches(ctx, v.embedder, model, batches, v.embedConcurrency)
+}
+
+func (v *vectorService) CollectionExists(ctx context.Context, collection string) (bool, error) {
+	return v.store.CollectionExists(ctx, collection)
+}
+
+func (v *vectorService) CacheStats() (store.CacheStats, bool) {
+	return store.SearchCacheStats(v.store)
+}
+
 func (v *vectorService) Health(ctx context.Context) error {
 	err := v.store.Health(ctx)
 	if err != nil {
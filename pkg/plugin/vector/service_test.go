@@ -0,0 +1,128 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/embed"
+	"github.com/grafana/grafana-llm-app/pkg/plugin/vector/store"
+)
+
+// modelEmbedder embeds texts with the embedding, or error, of each model,
+// recording the models used.
+type modelEmbedder struct {
+	embeddings map[string][]float32
+	errs       map[string]error
+	models     []string
+}
+
+func (m *modelEmbedder) Embed(ctx context.Context, model string, text string) ([]float32, error) {
+	m.models = append(m.models, model)
+	if err := m.errs[model]; err != nil {
+		return nil, err
+	}
+	return m.embeddings[model], nil
+}
+
+func (m *modelEmbedder) Health(ctx context.Context, model string) error { return nil }
+
+// dimensionStore is a store whose collections all have the same dimension,
+// recording the vectors searched for.
+type dimensionStore struct {
+	dimension int
+	searched  [][]float32
+}
+
+func (d *dimensionStore) CollectionExists(ctx context.Context, collection string) (bool, error) {
+	return true, nil
+}
+
+func (d *dimensionStore) CollectionDimension(ctx context.Context, collection string) (int, error) {
+	return d.dimension, nil
+}
+
+func (d *dimensionStore) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]store.SearchResult, error) {
+	d.searched = append(d.searched, vector)
+	return []store.SearchResult{{ID: "doc-1", Score: 1}}, nil
+}
+
+func (d *dimensionStore) Health(ctx context.Context) error { return nil }
+
+func TestSearchFallbackModel(t *testing.T) {
+	rateLimited := &embed.StatusError{Provider: "OpenAI", Status: "429 Too Many Requests", StatusCode: http.StatusTooManyRequests}
+	badRequest := &embed.StatusError{Provider: "OpenAI", Status: "400 Bad Request", StatusCode: http.StatusBadRequest}
+	for _, tc := range []struct {
+		name       string
+		primaryErr error
+		fallback   []float32
+
+		expModels   []string
+		expSearched []float32
+		expErr      error
+	}{
+		{
+			name:        "primary succeeds",
+			fallback:    []float32{0.4, 0.5, 0.6},
+			expModels:   []string{"primary"},
+			expSearched: []float32{0.1, 0.2, 0.3},
+		},
+		{
+			name:        "falls back after a retryable error",
+			primaryErr:  rateLimited,
+			fallback:    []float32{0.4, 0.5, 0.6},
+			expModels:   []string{"primary", "fallback"},
+			expSearched: []float32{0.4, 0.5, 0.6},
+		},
+		{
+			name:       "refuses a fallback of another dimension",
+			primaryErr: rateLimited,
+			fallback:   []float32{0.4, 0.5},
+			expModels:  []string{"primary", "fallback"},
+			expErr:     ErrDimensionMismatch,
+		},
+		{
+			name:       "doesn't fall back after other errors",
+			primaryErr: badRequest,
+			fallback:   []float32{0.4, 0.5, 0.6},
+			expModels:  []string{"primary"},
+			expErr:     badRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			embedder := &modelEmbedder{
+				embeddings: map[string][]float32{"primary": {0.1, 0.2, 0.3}, "fallback": tc.fallback},
+				errs:       map[string]error{"primary": tc.primaryErr},
+			}
+			st := &dimensionStore{dimension: 3}
+			v := &vectorService{embedder: embedder, model: "primary", fallbackModel: "fallback", store: st}
+
+			_, err := v.Search(context.Background(), "docs", "what is the error rate?", 5, nil)
+			if tc.expErr != nil {
+				if !errors.Is(err, tc.expErr) {
+					t.Fatalf("expected error %v, got %v", tc.expErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("search: %s", err)
+			}
+			if len(embedder.models) != len(tc.expModels) {
+				t.Fatalf("expected models %v to be used, got %v", tc.expModels, embedder.models)
+			}
+			for i, m := range tc.expModels {
+				if embedder.models[i] != m {
+					t.Errorf("expected models %v to be used, got %v", tc.expModels, embedder.models)
+				}
+			}
+			if tc.expSearched == nil {
+				if len(st.searched) != 0 {
+					t.Errorf("expected no search, got %v", st.searched)
+				}
+				return
+			}
+			if len(st.searched) != 1 || len(st.searched[0]) != len(tc.expSearched) || st.searched[0][0] != tc.expSearched[0] {
+				t.Errorf("expected a search for %v, got %v", tc.expSearched, st.searched)
+			}
+		})
+	}
+}
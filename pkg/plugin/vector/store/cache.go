@@ -0,0 +1,245 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL        = time.Minute
+	defaultCacheMaxEntries = 1000
+	// cacheVectorPrecision is the number of decimal places query vectors are
+	// rounded to when building cache keys, so that embeddings of the same query
+	// which differ only by floating point noise share an entry.
+	cacheVectorPrecision = 4
+)
+
+// VectorCacheSettings configures caching of search results.
+type VectorCacheSettings struct {
+	Enabled bool `json:"enabled"`
+	// TTLSeconds is how long results are cached for. Defaults to 60 seconds.
+	TTLSeconds int `json:"ttlSeconds"`
+	// MaxEntries is the maximum number of searches cached, after which the
+	// least recently used are evicted. Defaults to 1000.
+	MaxEntries int `json:"maxEntries"`
+	// Backend is where results are cached: CacheBackendMemory (the default) or
+	// CacheBackendDisk.
+	Backend string `json:"backend"`
+	// Path is the directory used by the disk backend. Instances sharing a path
+	// share cached results. Defaults to a directory in the OS temp directory.
+	Path string `json:"path"`
+	// MaxBytes is the maximum total size of the disk backend's files, after
+	// which the least recently used are evicted. Defaults to 100 MiB.
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// resultCache holds search results by cache key.
+type resultCache interface {
+	get(key string) ([]SearchResult, bool)
+	set(key string, results []SearchResult)
+	// stats returns the number of entries and the number evicted to keep
+	// within the size limits.
+	stats() (entries int, evictions uint64)
+}
+
+type cacheEntry struct {
+	key     string
+	results []SearchResult
+	expires time.Time
+}
+
+// searchCache is a TTL and size bounded LRU cache of search results.
+type searchCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	lru       *list.List
+	evictions uint64
+	now       func() time.Time
+}
+
+func newSearchCache(s VectorCacheSettings) *searchCache {
+	ttl := time.Duration(s.TTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	maxEntries := s.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	return &searchCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+func (c *searchCache) get(key string) ([]SearchResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if c.now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return append([]SearchResult(nil), e.results...), true
+}
+
+func (c *searchCache) set(key string, results []SearchResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &cacheEntry{key: key, results: append([]SearchResult(nil), results...), expires: c.now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions++
+	}
+}
+
+func (c *searchCache) stats() (int, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.evictions
+}
+
+// searchCacheKey returns the cache key for a search. The filter is encoded as
+// JSON, which sorts map keys, so equal filters give equal keys.
+func searchCacheKey(collection string, vector []float32, topK uint64, filter map[string]interface{}) (string, error) {
+	filterJSON, err := json.Marshal(filter)
+	if err != nil {
+		return "", fmt.Errorf("marshal filter: %w", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(collection))
+	h.Write([]byte{0})
+	scale := math.Pow10(cacheVectorPrecision)
+	buf := make([]byte, 8)
+	for _, v := range vector {
+		binary.LittleEndian.PutUint64(buf, uint64(int64(math.Round(float64(v)*scale))))
+		h.Write(buf)
+	}
+	binary.LittleEndian.PutUint64(buf, topK)
+	h.Write(buf)
+	h.Write(filterJSON)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachedStore wraps a ReadVectorStore, reusing the results of recent
+// identical searches.
+type cachedStore struct {
+	ReadVectorStore
+	cache    resultCache
+	counters cacheCounters
+}
+
+// newResultCache returns the cache for the configured backend.
+func newResultCache(settings VectorCacheSettings) (resultCache, error) {
+	switch settings.Backend {
+	case "", CacheBackendMemory:
+		return newSearchCache(settings), nil
+	case CacheBackendDisk:
+		return newDiskSearchCache(settings)
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", settings.Backend)
+}
+
+// withCache wraps s so its search results are cached, if enabled. The
+// returned store implements SearchStreamer if s does.
+func withCache(s ReadVectorStore, settings VectorCacheSettings) (ReadVectorStore, error) {
+	if s == nil || !settings.Enabled {
+		return s, nil
+	}
+	cache, err := newResultCache(settings)
+	if err != nil {
+		return nil, err
+	}
+	c := &cachedStore{ReadVectorStore: s, cache: cache}
+	if _, ok := s.(SearchStreamer); ok {
+		return &cachedStreamingStore{c}, nil
+	}
+	return c, nil
+}
+
+func (c *cachedStore) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	key, err := searchCacheKey(collection, vector, topK, filter)
+	if err != nil {
+		// Uncacheable, but the store may still be able to handle it.
+		return c.ReadVectorStore.Search(ctx, collection, vector, topK, filter)
+	}
+	results, ok := c.cache.get(key)
+	c.counters.record(collection, ok)
+	if ok {
+		return results, nil
+	}
+	results, err = c.ReadVectorStore.Search(ctx, collection, vector, topK, filter)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.set(key, results)
+	return results, nil
+}
+
+// CacheStats returns the stats of the cache. Hybrid searches aren't counted.
+func (c *cachedStore) CacheStats() (CacheStats, bool) {
+	stats := c.counters.stats()
+	stats.Entries, stats.Evictions = c.cache.stats()
+	return stats, true
+}
+
+// HybridSearch isn't cached, since the cache keys don't include the query
+// text or alpha.
+func (c *cachedStore) HybridSearch(ctx context.Context, collection string, query string, vector []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	h, err := hybridSearcher(c.ReadVectorStore)
+	if err != nil {
+		return nil, err
+	}
+	return h.HybridSearch(ctx, collection, query, vector, alpha, topK, filter)
+}
+
+// cachedStreamingStore is a cachedStore for stores which can stream search
+// results. Cached results are streamed from the cache; other searches are
+// streamed from the store without being cached.
+type cachedStreamingStore struct {
+	*cachedStore
+}
+
+func (c *cachedStreamingStore) SearchStream(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) (<-chan StreamedResult, error) {
+	if key, err := searchCacheKey(collection, vector, topK, filter); err == nil {
+		results, ok := c.cache.get(key)
+		c.counters.record(collection, ok)
+		if ok {
+			out := make(chan StreamedResult, len(results))
+			for _, r := range results {
+				out <- StreamedResult{SearchResult: r}
+			}
+			close(out)
+			return out, nil
+		}
+	}
+	return c.ReadVectorStore.(SearchStreamer).SearchStream(ctx, collection, vector, topK, filter)
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// countingStore counts the searches which reach it.
+type countingStore struct {
+	fakeStore
+	searches int
+}
+
+func (c *countingStore) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	c.searches++
+	return c.fakeStore.Search(ctx, collection, vector, topK, filter)
+}
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{fakeStore: fakeStore{results: []SearchResult{{Score: 0.9}}}}
+	cached, err := withCache(backend, VectorCacheSettings{Enabled: true, TTLSeconds: 60, MaxEntries: 2})
+	if err != nil {
+		t.Fatalf("with cache: %s", err)
+	}
+	s := cached.(*cachedStore)
+	now := time.Now()
+	s.cache.(*searchCache).now = func() time.Time { return now }
+
+	search := func(collection string, vector []float32, filter map[string]interface{}) {
+		t.Helper()
+		results, err := s.Search(ctx, collection, vector, 5, filter)
+		if err != nil {
+			t.Fatalf("search: %s", err)
+		}
+		if len(results) != 1 || results[0].Score != 0.9 {
+			t.Fatalf("unexpected results %v", results)
+		}
+	}
+	expSearches := func(n int) {
+		t.Helper()
+		if backend.searches != n {
+			t.Fatalf("expected %d searches to reach the store, got %d", n, backend.searches)
+		}
+	}
+
+	search("a", []float32{0.1, 0.2}, map[string]interface{}{"x": 1, "y": 2})
+	expSearches(1)
+	// Vectors differing only by floating point noise and equal filters hit the cache.
+	search("a", []float32{0.100001, 0.2}, map[string]interface{}{"y": 2, "x": 1})
+	expSearches(1)
+	// Different collections, vectors or filters don't.
+	search("b", []float32{0.1, 0.2}, map[string]interface{}{"x": 1, "y": 2})
+	expSearches(2)
+	search("a", []float32{0.1, 0.3}, map[string]interface{}{"x": 1, "y": 2})
+	expSearches(3)
+
+	// Only two entries are kept, so the first search was evicted.
+	search("a", []float32{0.1, 0.2}, map[string]interface{}{"x": 1, "y": 2})
+	expSearches(4)
+	search("a", []float32{0.1, 0.2}, map[string]interface{}{"x": 1, "y": 2})
+	expSearches(4)
+
+	// Entries expire after the TTL.
+	now = now.Add(61 * time.Second)
+	search("a", []float32{0.1, 0.2}, map[string]interface{}{"x": 1, "y": 2})
+	expSearches(5)
+
+	stats, ok := SearchCacheStats(s)
+	if !ok {
+		t.Fatal("expected the cached store to report stats")
+	}
+	exp := CacheStats{
+		Hits:      2,
+		Misses:    5,
+		HitRatio:  2.0 / 7,
+		Entries:   2,
+		Evictions: 2,
+		Collections: map[string]CollectionCacheStats{
+			"a": {Hits: 2, Misses: 4, HitRatio: 2.0 / 6},
+			"b": {Misses: 1},
+		},
+	}
+	if !reflect.DeepEqual(stats, exp) {
+		t.Errorf("expected stats %+v, got %+v", exp, stats)
+	}
+}
+
+func TestCacheStatsPrefix(t *testing.T) {
+	cached, err := withCache(&fakeStore{}, VectorCacheSettings{Enabled: true})
+	if err != nil {
+		t.Fatalf("with cache: %s", err)
+	}
+	s := withCollectionPrefix(cached, "tenant_")
+	if _, err := s.Search(context.Background(), "docs", []float32{0.1}, 5, nil); err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	stats, ok := SearchCacheStats(s)
+	if !ok {
+		t.Fatal("expected the prefixed store to report its cache's stats")
+	}
+	if _, ok := stats.Collections["docs"]; !ok || len(stats.Collections) != 1 {
+		t.Errorf("expected stats for the unprefixed collection, got %+v", stats.Collections)
+	}
+
+	if _, ok := SearchCacheStats(withCollectionPrefix(&fakeStore{}, "tenant_")); ok {
+		t.Error("expected no stats without a cache")
+	}
+}
+
+func TestWithCacheDisabled(t *testing.T) {
+	backend := &fakeStore{}
+	if s, _ := withCache(backend, VectorCacheSettings{}); s != backend {
+		t.Errorf("expected the store to be unwrapped when caching is disabled, got %T", s)
+	}
+	s, _ := withCache(&fakeStreamingStore{}, VectorCacheSettings{Enabled: true})
+	if _, ok := s.(SearchStreamer); !ok {
+		t.Error("expected a cached streaming store to still stream")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	settings := VectorCacheSettings{Enabled: true, Backend: CacheBackendDisk, Path: dir, TTLSeconds: 60, MaxEntries: 2}
+	backend := &countingStore{fakeStore: fakeStore{results: []SearchResult{{ID: "doc-1", Score: 0.9}}}}
+	newStore := func() *cachedStore {
+		t.Helper()
+		cached, err := withCache(backend, settings)
+		if err != nil {
+			t.Fatalf("with cache: %s", err)
+		}
+		return cached.(*cachedStore)
+	}
+	search := func(s *cachedStore, collection string) {
+		t.Helper()
+		results, err := s.Search(ctx, collection, []float32{0.1}, 5, nil)
+		if err != nil {
+			t.Fatalf("search: %s", err)
+		}
+		if len(results) != 1 || results[0].ID != "doc-1" || results[0].Score != 0.9 {
+			t.Fatalf("unexpected results %v", results)
+		}
+	}
+	expSearches := func(n int) {
+		t.Helper()
+		if backend.searches != n {
+			t.Fatalf("expected %d searches to reach the store, got %d", n, backend.searches)
+		}
+	}
+
+	s := newStore()
+	search(s, "a")
+	expSearches(1)
+	search(s, "a")
+	expSearches(1)
+
+	// A new instance using the same directory, as after a restart, shares
+	// the cached results.
+	restarted := newStore()
+	search(restarted, "a")
+	expSearches(1)
+
+	// Only two entries are kept; "b" was used least recently, so it goes.
+	now := time.Now()
+	cache := restarted.cache.(*diskSearchCache)
+	for i, collection := range []string{"b", "a", "c"} {
+		i := i
+		cache.now = func() time.Time { return now.Add(time.Duration(i) * time.Second) }
+		search(restarted, collection)
+	}
+	expSearches(3)
+	search(restarted, "a")
+	expSearches(3)
+	search(restarted, "b")
+	expSearches(4)
+
+	// Entries expire after the TTL.
+	cache.now = func() time.Time { return now.Add(2 * time.Minute) }
+	search(restarted, "b")
+	expSearches(5)
+}
+
+func TestUnknownCacheBackend(t *testing.T) {
+	if _, err := withCache(&fakeStore{}, VectorCacheSettings{Enabled: true, Backend: "redis"}); err == nil {
+		t.Error("expected an error for an unknown cache backend")
+	}
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"strings"
+	"sync"
+)
+
+// CacheStats describe how well the search cache has worked since the plugin
+// started.
+type CacheStats struct {
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+	// Entries is the number of searches currently cached, which may include
+	// expired ones not yet removed.
+	Entries int `json:"entries"`
+	// Evictions is the number of entries removed to keep the cache within
+	// its size limits, not counting expired ones.
+	Evictions uint64 `json:"evictions"`
+	// Collections breaks down the hits and misses by collection.
+	Collections map[string]CollectionCacheStats `json:"collections"`
+}
+
+// CollectionCacheStats are the hits and misses of searches of a collection.
+type CollectionCacheStats struct {
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+}
+
+// CacheStatsReporter is implemented by stores which may cache search results.
+type CacheStatsReporter interface {
+	// CacheStats returns the stats of the store's search cache, and false if
+	// it has none.
+	CacheStats() (CacheStats, bool)
+}
+
+// SearchCacheStats returns the stats of s's search cache, and false if it
+// has none.
+func SearchCacheStats(s ReadVectorStore) (CacheStats, bool) {
+	r, ok := s.(CacheStatsReporter)
+	if !ok {
+		return CacheStats{}, false
+	}
+	return r.CacheStats()
+}
+
+// hitRatio returns the fraction of lookups which hit, or zero if there were
+// none.
+func hitRatio(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// cacheCounters counts the hits and misses of a cache by collection.
+type cacheCounters struct {
+	mu          sync.Mutex
+	collections map[string]*CollectionCacheStats
+}
+
+func (c *cacheCounters) record(collection string, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.collections == nil {
+		c.collections = map[string]*CollectionCacheStats{}
+	}
+	s, ok := c.collections[collection]
+	if !ok {
+		s = &CollectionCacheStats{}
+		c.collections[collection] = s
+	}
+	if hit {
+		s.Hits++
+	} else {
+		s.Misses++
+	}
+}
+
+// stats returns the hits and misses counted so far.
+func (c *cacheCounters) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := CacheStats{Collections: make(map[string]CollectionCacheStats, len(c.collections))}
+	for collection, s := range c.collections {
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+		stats.Collections[collection] = CollectionCacheStats{Hits: s.Hits, Misses: s.Misses, HitRatio: hitRatio(s.Hits, s.Misses)}
+	}
+	stats.HitRatio = hitRatio(stats.Hits, stats.Misses)
+	return stats
+}
+
+// withoutCollectionPrefix returns stats with prefix removed from the names of
+// collections, dropping collections without it.
+func withoutCollectionPrefix(stats CacheStats, prefix string) CacheStats {
+	collections := make(map[string]CollectionCacheStats, len(stats.Collections))
+	for collection, s := range stats.Collections {
+		if name, ok := strings.CutPrefix(collection, prefix); ok {
+			collections[name] = s
+		}
+	}
+	stats.Collections = collections
+	return stats
+}
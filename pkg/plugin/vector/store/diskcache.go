@@ -0,0 +1,212 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// CacheBackendMemory keeps cached search results in memory. It is the default.
+	CacheBackendMemory = "memory"
+	// CacheBackendDisk keeps cached search results in files, so that they
+	// survive restarts and can be shared between instances.
+	CacheBackendDisk = "disk"
+
+	defaultDiskCacheMaxBytes = 100 * 1024 * 1024
+	diskCacheEntrySuffix     = ".json"
+	diskCacheLockFile        = ".lock"
+)
+
+// defaultDiskCachePath is where the disk cache is kept if no path is set.
+func defaultDiskCachePath() string {
+	return filepath.Join(os.TempDir(), "grafana-llm-app", "search-cache")
+}
+
+// diskCacheEntry is the content of a disk cache file.
+type diskCacheEntry struct {
+	Expires time.Time      `json:"expires"`
+	Results []SearchResult `json:"results"`
+}
+
+// diskSearchCache is a TTL and size bounded cache of search results kept in a
+// directory, one file per search. Files are written atomically by renaming, and
+// eviction and writes take an exclusive lock on the directory, so several
+// instances (or processes) may share the same directory. A file's modification
+// time is its last use, so the least recently used are evicted first.
+//
+// Errors reading or writing the cache are logged and treated as misses: the
+// cache is only an optimisation.
+type diskSearchCache struct {
+	dir        string
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+	now        func() time.Time
+	// evictions counts the entries this instance has evicted for size.
+	evictions atomic.Uint64
+}
+
+func newDiskSearchCache(s VectorCacheSettings) (*diskSearchCache, error) {
+	c := &diskSearchCache{
+		dir:        s.Path,
+		ttl:        time.Duration(s.TTLSeconds) * time.Second,
+		maxEntries: s.MaxEntries,
+		maxBytes:   s.MaxBytes,
+		now:        time.Now,
+	}
+	if c.dir == "" {
+		c.dir = defaultDiskCachePath()
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultCacheTTL
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = defaultCacheMaxEntries
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultDiskCacheMaxBytes
+	}
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+	return c, nil
+}
+
+func (c *diskSearchCache) path(key string) string {
+	return filepath.Join(c.dir, key+diskCacheEntrySuffix)
+}
+
+func (c *diskSearchCache) get(key string) ([]SearchResult, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.DefaultLogger.Warn("Failed to read search cache entry", "err", err)
+		}
+		return nil, false
+	}
+	var e diskCacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		log.DefaultLogger.Warn("Ignoring corrupt search cache entry", "key", key, "err", err)
+		return nil, false
+	}
+	now := c.now()
+	if now.After(e.Expires) {
+		return nil, false
+	}
+	// Mark the entry as recently used. Failing to isn't worth reporting.
+	_ = os.Chtimes(c.path(key), now, now)
+	return e.Results, true
+}
+
+// stats counts the entries in the directory, including any written by other
+// instances, but only the evictions made by this one.
+func (c *diskSearchCache) stats() (int, uint64) {
+	entries := 0
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.DefaultLogger.Warn("Failed to list search cache", "err", err)
+	}
+	for _, de := range dirEntries {
+		if !de.IsDir() && strings.HasSuffix(de.Name(), diskCacheEntrySuffix) {
+			entries++
+		}
+	}
+	return entries, c.evictions.Load()
+}
+
+func (c *diskSearchCache) set(key string, results []SearchResult) {
+	if err := c.write(key, results); err != nil {
+		log.DefaultLogger.Warn("Failed to write search cache entry", "err", err)
+	}
+}
+
+func (c *diskSearchCache) write(key string, results []SearchResult) error {
+	now := c.now()
+	b, err := json.Marshal(diskCacheEntry{Expires: now.Add(c.ttl), Results: results})
+	if err != nil {
+		return fmt.Errorf("marshal entry: %w", err)
+	}
+	unlock, err := lockDir(filepath.Join(c.dir, diskCacheLockFile))
+	if err != nil {
+		return fmt.Errorf("lock cache: %w", err)
+	}
+	defer unlock()
+
+	// Write to a temporary file and rename it into place, so that readers
+	// never see a partly written entry.
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create entry: %w", err)
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), now, now)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write entry: %w", err)
+	}
+	return c.evict()
+}
+
+// evict removes expired entries, then the least recently used until the cache
+// is within its size limits. The caller must hold the directory lock.
+func (c *diskSearchCache) evict() error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("list cache: %w", err)
+	}
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		files []file
+		total int64
+	)
+	cutoff := c.now().Add(-c.ttl)
+	for _, de := range dirEntries {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), diskCacheEntrySuffix) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			// Removed since we listed the directory.
+			continue
+		}
+		// Entries unused for longer than the TTL must have expired.
+		if info.ModTime().Before(cutoff) {
+			_ = os.Remove(filepath.Join(c.dir, de.Name()))
+			continue
+		}
+		files = append(files, file{name: de.Name(), size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for len(files) > c.maxEntries || (total > c.maxBytes && len(files) > 0) {
+		if err := os.Remove(filepath.Join(c.dir, files[0].name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("evict entry: %w", err)
+		}
+		total -= files[0].size
+		files = files[1:]
+		c.evictions.Add(1)
+	}
+	return nil
+}
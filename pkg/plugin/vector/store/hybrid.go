@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrHybridSearchUnsupported is returned by the HybridSearch method of stores
+// which can't do hybrid searches, such as a Vespa store without a hybrid rank
+// profile, or a wrapper around a store without HybridSearch.
+var ErrHybridSearchUnsupported = errors.New("hybrid search is not supported by the vector store")
+
+// HybridSearcher is implemented by stores which can combine keyword scoring,
+// such as BM25, with vector similarity in a single search.
+type HybridSearcher interface {
+	// HybridSearch searches collection for documents matching the text of
+	// query or near vector, its embedding. alpha weights the vector
+	// similarity against the keyword score: 1 is a pure vector search and 0
+	// a pure keyword search.
+	HybridSearch(ctx context.Context, collection string, query string, vector []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]SearchResult, error)
+}
+
+// HybridSearch does a hybrid search of collection if s supports it, and
+// otherwise falls back to a vector search, ignoring the query text and alpha.
+// It reports whether the search was hybrid.
+//
+// Of the stores, only Vespa supports hybrid searches, when it has a hybrid
+// rank profile; the Grafana VectorAPI and Qdrant stores always fall back.
+func HybridSearch(ctx context.Context, s ReadVectorStore, collection string, query string, vector []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]SearchResult, bool, error) {
+	if h, ok := s.(HybridSearcher); ok {
+		results, err := h.HybridSearch(ctx, collection, query, vector, alpha, topK, filter)
+		if !errors.Is(err, ErrHybridSearchUnsupported) {
+			return results, true, err
+		}
+	}
+	results, err := s.Search(ctx, collection, vector, topK, filter)
+	return results, false, err
+}
+
+// hybridSearcher returns s as a HybridSearcher, or an error if it isn't one,
+// for wrappers of stores.
+func hybridSearcher(s ReadVectorStore) (HybridSearcher, error) {
+	h, ok := s.(HybridSearcher)
+	if !ok {
+		return nil, ErrHybridSearchUnsupported
+	}
+	return h, nil
+}
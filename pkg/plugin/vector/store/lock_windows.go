@@ -0,0 +1,8 @@
+package store
+
+// lockDir is a no-op on Windows. Entries are still written atomically, so the
+// worst that can happen when instances share a cache directory is that it
+// briefly exceeds its size limit.
+func lockDir(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Search metrics are registered with the default registerer, which is what the
+// plugin SDK serves at /metrics.
+var (
+	searchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "grafana_llm_app",
+		Subsystem: "vector_store",
+		Name:      "search_duration_seconds",
+		Help:      "Duration of vector store searches.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"collection", "backend"})
+	searchResults = promauto.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: "grafana_llm_app",
+		Subsystem: "vector_store",
+		Name:      "search_results",
+		Help:      "Number of results returned by vector store searches.",
+	}, []string{"collection", "backend"})
+	searchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_llm_app",
+		Subsystem: "vector_store",
+		Name:      "search_errors_total",
+		Help:      "Number of failed vector store searches.",
+	}, []string{"collection", "backend"})
+)
+
+// instrumentedStore wraps a ReadVectorStore, recording metrics for each search.
+type instrumentedStore struct {
+	ReadVectorStore
+	backend string
+}
+
+// instrument wraps s so its searches are recorded in the search metrics. The
+// returned store implements SearchStreamer if s does.
+func instrument(s ReadVectorStore, backend VectorStoreType) ReadVectorStore {
+	if s == nil {
+		return nil
+	}
+	i := &instrumentedStore{ReadVectorStore: s, backend: string(backend)}
+	if _, ok := s.(SearchStreamer); ok {
+		return &instrumentedStreamingStore{i}
+	}
+	return i
+}
+
+func (i *instrumentedStore) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	start := time.Now()
+	results, err := i.ReadVectorStore.Search(ctx, collection, vector, topK, filter)
+	searchDuration.WithLabelValues(collection, i.backend).Observe(time.Since(start).Seconds())
+	if err != nil {
+		searchErrors.WithLabelValues(collection, i.backend).Inc()
+		return nil, err
+	}
+	searchResults.WithLabelValues(collection, i.backend).Observe(float64(len(results)))
+	return results, nil
+}
+
+// HybridSearch records hybrid searches in the same metrics as vector
+// searches. Unsupported searches aren't recorded, since the store isn't asked.
+func (i *instrumentedStore) HybridSearch(ctx context.Context, collection string, query string, vector []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	h, err := hybridSearcher(i.ReadVectorStore)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	results, err := h.HybridSearch(ctx, collection, query, vector, alpha, topK, filter)
+	if errors.Is(err, ErrHybridSearchUnsupported) {
+		return nil, err
+	}
+	searchDuration.WithLabelValues(collection, i.backend).Observe(time.Since(start).Seconds())
+	if err != nil {
+		searchErrors.WithLabelValues(collection, i.backend).Inc()
+		return nil, err
+	}
+	searchResults.WithLabelValues(collection, i.backend).Observe(float64(len(results)))
+	return results, nil
+}
+
+// instrumentedStreamingStore is an instrumentedStore for stores which can
+// stream search results.
+type instrumentedStreamingStore struct {
+	*instrumentedStore
+}
+
+// SearchStream records the search once the stream has been fully consumed,
+// counting an error sent on the stream as a failed search.
+func (i *instrumentedStreamingStore) SearchStream(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) (<-chan StreamedResult, error) {
+	start := time.Now()
+	in, err := i.ReadVectorStore.(SearchStreamer).SearchStream(ctx, collection, vector, topK, filter)
+	if err != nil {
+		searchDuration.WithLabelValues(collection, i.backend).Observe(time.Since(start).Seconds())
+		searchErrors.WithLabelValues(collection, i.backend).Inc()
+		return nil, err
+	}
+	out := make(chan StreamedResult)
+	go func() {
+		defer close(out)
+		n := 0
+		failed := false
+		for r := range in {
+			if r.Err != nil {
+				failed = true
+			}
+			select {
+			case out <- r:
+				if r.Err == nil {
+					n++
+				}
+			case <-ctx.Done():
+			}
+		}
+		searchDuration.WithLabelValues(collection, i.backend).Observe(time.Since(start).Seconds())
+		if failed {
+			searchErrors.WithLabelValues(collection, i.backend).Inc()
+			return
+		}
+		searchResults.WithLabelValues(collection, i.backend).Observe(float64(n))
+	}()
+	return out, nil
+}
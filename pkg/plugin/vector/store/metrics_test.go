@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeStore struct {
+	results []SearchResult
+	err     error
+}
+
+func (f *fakeStore) CollectionExists(ctx context.Context, collection string) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeStore) CollectionDimension(ctx context.Context, collection string) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeStore) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	return f.results, f.err
+}
+
+func (f *fakeStore) Health(ctx context.Context) error {
+	return nil
+}
+
+type fakeStreamingStore struct {
+	fakeStore
+}
+
+func (f *fakeStreamingStore) SearchStream(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) (<-chan StreamedResult, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	ch := make(chan StreamedResult, len(f.results))
+	for _, r := range f.results {
+		ch <- StreamedResult{SearchResult: r}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	results := []SearchResult{{Score: 0.9}, {Score: 0.8}}
+	for _, tc := range []struct {
+		name       string
+		store      ReadVectorStore
+		collection string
+		stream     bool
+
+		expErr bool
+	}{
+		{
+			name:       "search",
+			store:      &fakeStore{results: results},
+			collection: "metrics-search",
+		},
+		{
+			name:       "search error",
+			store:      &fakeStore{err: errors.New("boom")},
+			collection: "metrics-search-error",
+			expErr:     true,
+		},
+		{
+			name:       "stream",
+			store:      &fakeStreamingStore{fakeStore{results: results}},
+			collection: "metrics-stream",
+			stream:     true,
+		},
+		{
+			name:       "stream error",
+			store:      &fakeStreamingStore{fakeStore{err: errors.New("boom")}},
+			collection: "metrics-stream-error",
+			stream:     true,
+			expErr:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := instrument(tc.store, "fake")
+			_, isStreamer := s.(SearchStreamer)
+			if isStreamer != tc.stream {
+				t.Fatalf("expected SearchStreamer %t, got %t", tc.stream, isStreamer)
+			}
+
+			var err error
+			if tc.stream {
+				var ch <-chan StreamedResult
+				ch, err = s.(SearchStreamer).SearchStream(ctx, tc.collection, nil, 10, nil)
+				if err == nil {
+					n := 0
+					for range ch {
+						n++
+					}
+					if n != len(results) {
+						t.Errorf("expected %d results, got %d", len(results), n)
+					}
+				}
+			} else {
+				_, err = s.Search(ctx, tc.collection, nil, 10, nil)
+			}
+			if (err != nil) != tc.expErr {
+				t.Fatalf("expected error %t, got %v", tc.expErr, err)
+			}
+
+			if got := testutil.ToFloat64(searchErrors.WithLabelValues(tc.collection, "fake")); (got == 1) != tc.expErr {
+				t.Errorf("unexpected error count %v", got)
+			}
+			if got := testutil.CollectAndCount(searchDuration); got == 0 {
+				t.Error("expected search duration to be recorded")
+			}
+			if got := testutil.CollectAndCount(searchResults); !tc.expErr && got == 0 {
+				t.Error("expected result count to be recorded")
+			}
+		})
+	}
+}
+
+func TestInstrumentNil(t *testing.T) {
+	if s := instrument(nil, "fake"); s != nil {
+		t.Errorf("expected nil store, got %T", s)
+	}
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// errNoCollections is returned by MultiSearch if none of the collections exist.
+var errNoCollections = errors.New("none of the collections exist in the store")
+
+// MultiSearchResult is the merged result of searching several collections.
+type MultiSearchResult struct {
+	// Results are the top results across all collections, best first.
+	Results []SearchResult `json:"results"`
+	// Missing are the requested collections which don't exist in the store.
+	Missing []string `json:"missingCollections,omitempty"`
+}
+
+// MultiSearch searches each of the collections concurrently, returning the
+// topK results with the highest scores across all of them, each labelled with
+// its collection. Collections which don't exist are skipped and reported as
+// missing; an error is only returned if none exist or a search fails.
+//
+// Scores are only comparable if every collection was embedded with the same
+// model and uses the same distance metric.
+//
+// It works with any ReadVectorStore, so that searches go through the usual
+// caching and instrumentation; none of the stores can query several
+// collections in a single request.
+func MultiSearch(ctx context.Context, s ReadVectorStore, collections []string, vector []float32, topK uint64, filter map[string]interface{}) (MultiSearchResult, error) {
+	collections = dedupe(collections)
+	type collectionResult struct {
+		exists  bool
+		results []SearchResult
+		err     error
+	}
+	results := make([]collectionResult, len(collections))
+	var wg sync.WaitGroup
+	for i, collection := range collections {
+		wg.Add(1)
+		go func(i int, collection string) {
+			defer wg.Done()
+			r := &results[i]
+			r.exists, r.err = s.CollectionExists(ctx, collection)
+			if r.err != nil || !r.exists {
+				return
+			}
+			r.results, r.err = s.Search(ctx, collection, vector, topK, filter)
+		}(i, collection)
+	}
+	wg.Wait()
+
+	var merged MultiSearchResult
+	for i, r := range results {
+		collection := collections[i]
+		if r.err != nil {
+			return MultiSearchResult{}, fmt.Errorf("search collection %s: %w", collection, r.err)
+		}
+		if !r.exists {
+			log.DefaultLogger.Warn("Skipping missing collection in multi-collection search", "collection", collection)
+			merged.Missing = append(merged.Missing, collection)
+			continue
+		}
+		for _, result := range r.results {
+			result.Collection = collection
+			merged.Results = append(merged.Results, result)
+		}
+	}
+	if len(merged.Missing) == len(collections) {
+		return MultiSearchResult{}, fmt.Errorf("%w: %v", errNoCollections, collections)
+	}
+	// Stable, so ties keep the order of the requested collections.
+	sort.SliceStable(merged.Results, func(i, j int) bool {
+		return merged.Results[i].Score > merged.Results[j].Score
+	})
+	if uint64(len(merged.Results)) > topK {
+		merged.Results = merged.Results[:topK]
+	}
+	if merged.Results == nil {
+		merged.Results = []SearchResult{}
+	}
+	return merged, nil
+}
+
+// dedupe returns the unique values of s, in their original order.
+func dedupe(s []string) []string {
+	seen := make(map[string]bool, len(s))
+	out := make([]string, 0, len(s))
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
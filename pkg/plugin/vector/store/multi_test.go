@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newMultiCollectionServer(collections map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v1/collections/")
+		name, query := strings.CutSuffix(path, "/query")
+		body, ok := collections[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if query {
+			_, _ = w.Write([]byte(body))
+		}
+	}))
+}
+
+func TestMultiSearch(t *testing.T) {
+	server := newMultiCollectionServer(map[string]string{
+		"docs":    `[{"payload": {"id": "d1"}, "score": 0.9}, {"payload": {"id": "d2"}, "score": 0.5}]`,
+		"runbook": `[{"payload": {"id": "r1"}, "score": 0.7}, {"payload": {"id": "r2"}, "score": 0.6}]`,
+	})
+	defer server.Close()
+	s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		collections []string
+		topK        uint64
+
+		expResult MultiSearchResult
+		expErr    error
+	}{
+		{
+			name:        "merged by score",
+			collections: []string{"docs", "runbook"},
+			topK:        3,
+			expResult: MultiSearchResult{Results: []SearchResult{
+				{ID: "d1", Score: 0.9, Collection: "docs"},
+				{ID: "r1", Score: 0.7, Collection: "runbook"},
+				{ID: "r2", Score: 0.6, Collection: "runbook"},
+			}},
+		},
+		{
+			name:        "missing collections are skipped",
+			collections: []string{"tickets", "runbook", "runbook"},
+			topK:        10,
+			expResult: MultiSearchResult{
+				Results: []SearchResult{
+					{ID: "r1", Score: 0.7, Collection: "runbook"},
+					{ID: "r2", Score: 0.6, Collection: "runbook"},
+				},
+				Missing: []string{"tickets"},
+			},
+		},
+		{
+			name:        "no collections exist",
+			collections: []string{"tickets"},
+			topK:        10,
+			expErr:      errNoCollections,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := MultiSearch(context.Background(), s, tc.collections, []float32{0.1}, tc.topK, nil)
+			if tc.expErr != nil {
+				if !errors.Is(err, tc.expErr) {
+					t.Fatalf("expected error %v, got %v", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("multi search: %s", err)
+			}
+			if !reflect.DeepEqual(result, tc.expResult) {
+				t.Errorf("expected %+v, got %+v", tc.expResult, result)
+			}
+		})
+	}
+}
+
+func TestMultiSearchError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/query") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	if _, err := MultiSearch(context.Background(), s, []string{"docs", "runbook"}, []float32{0.1}, 10, nil); err == nil {
+		t.Error("expected error when a search fails, got nil")
+	}
+}
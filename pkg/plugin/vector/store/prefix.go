@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tenantPlaceholder is replaced by the tenant in a collection prefix.
+const tenantPlaceholder = "${tenant}"
+
+// expandCollectionPrefix replaces the tenant placeholder in prefix. Using the
+// placeholder without a tenant is an error rather than an empty prefix, which
+// would give access to every tenant's collections.
+func expandCollectionPrefix(prefix, tenant string) (string, error) {
+	if !strings.Contains(prefix, tenantPlaceholder) {
+		return prefix, nil
+	}
+	if tenant == "" {
+		return "", errors.New("collection prefix uses " + tenantPlaceholder + " but there is no tenant")
+	}
+	return strings.ReplaceAll(prefix, tenantPlaceholder, tenant), nil
+}
+
+// ErrInvalidCollection is returned by prefixed stores for collection names
+// which could escape the prefix.
+var ErrInvalidCollection = errors.New("invalid collection name")
+
+// safeCollectionName matches the collection names a prefixed store accepts:
+// letters, digits, `_`, `-` and `.`, not starting with `.`. Anything else,
+// such as `/` or `..`, could reach another tenant's collections once the
+// name is used in a path or query.
+var safeCollectionName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// prefixedStore wraps a ReadVectorStore, prepending a prefix to the name of
+// every collection, so that tenants sharing a store only see their own.
+type prefixedStore struct {
+	ReadVectorStore
+	prefix string
+}
+
+// withCollectionPrefix wraps s so its collection names are prefixed, if prefix
+// isn't empty. The returned store implements SearchStreamer if s does.
+func withCollectionPrefix(s ReadVectorStore, prefix string) ReadVectorStore {
+	if s == nil || prefix == "" {
+		return s
+	}
+	p := &prefixedStore{ReadVectorStore: s, prefix: prefix}
+	if _, ok := s.(SearchStreamer); ok {
+		return &prefixedStreamingStore{p}
+	}
+	return p
+}
+
+// prefixed returns collection with the prefix, or ErrInvalidCollection if
+// its name isn't safe.
+func (p *prefixedStore) prefixed(collection string) (string, error) {
+	if !safeCollectionName.MatchString(collection) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCollection, collection)
+	}
+	return p.prefix + collection, nil
+}
+
+func (p *prefixedStore) CollectionExists(ctx context.Context, collection string) (bool, error) {
+	prefixed, err := p.prefixed(collection)
+	if err != nil {
+		return false, err
+	}
+	return p.ReadVectorStore.CollectionExists(ctx, prefixed)
+}
+
+func (p *prefixedStore) CollectionDimension(ctx context.Context, collection string) (int, error) {
+	prefixed, err := p.prefixed(collection)
+	if err != nil {
+		return 0, err
+	}
+	return p.ReadVectorStore.CollectionDimension(ctx, prefixed)
+}
+
+func (p *prefixedStore) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	prefixed, err := p.prefixed(collection)
+	if err != nil {
+		return nil, err
+	}
+	return p.ReadVectorStore.Search(ctx, prefixed, vector, topK, filter)
+}
+
+func (p *prefixedStore) HybridSearch(ctx context.Context, collection string, query string, vector []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	h, err := hybridSearcher(p.ReadVectorStore)
+	if err != nil {
+		return nil, err
+	}
+	prefixed, err := p.prefixed(collection)
+	if err != nil {
+		return nil, err
+	}
+	return h.HybridSearch(ctx, prefixed, query, vector, alpha, topK, filter)
+}
+
+// CacheStats returns the stats of the wrapped store's cache, with collections
+// named without the prefix.
+func (p *prefixedStore) CacheStats() (CacheStats, bool) {
+	stats, ok := SearchCacheStats(p.ReadVectorStore)
+	if !ok {
+		return CacheStats{}, false
+	}
+	return withoutCollectionPrefix(stats, p.prefix), true
+}
+
+// prefixedStreamingStore is a prefixedStore for stores which can stream
+// search results.
+type prefixedStreamingStore struct {
+	*prefixedStore
+}
+
+func (p *prefixedStreamingStore) SearchStream(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) (<-chan StreamedResult, error) {
+	prefixed, err := p.prefixed(collection)
+	if err != nil {
+		return nil, err
+	}
+	return p.ReadVectorStore.(SearchStreamer).SearchStream(ctx, prefixed, vector, topK, filter)
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// recordingStore records the collections used by the requests which reach it.
+type recordingStore struct {
+	fakeStreamingStore
+	collections []string
+}
+
+func (r *recordingStore) CollectionExists(ctx context.Context, collection string) (bool, error) {
+	r.collections = append(r.collections, collection)
+	return true, nil
+}
+
+func (r *recordingStore) CollectionDimension(ctx context.Context, collection string) (int, error) {
+	r.collections = append(r.collections, collection)
+	return 0, nil
+}
+
+func (r *recordingStore) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	r.collections = append(r.collections, collection)
+	return r.fakeStreamingStore.Search(ctx, collection, vector, topK, filter)
+}
+
+func (r *recordingStore) SearchStream(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) (<-chan StreamedResult, error) {
+	r.collections = append(r.collections, collection)
+	return r.fakeStreamingStore.SearchStream(ctx, collection, vector, topK, filter)
+}
+
+func TestCollectionPrefix(t *testing.T) {
+	ctx := context.Background()
+	prefix, err := expandCollectionPrefix("${tenant}-", "stack-123")
+	if err != nil {
+		t.Fatalf("expand prefix: %s", err)
+	}
+	backend := &recordingStore{}
+	s := withCollectionPrefix(backend, prefix)
+
+	if _, err := s.CollectionExists(ctx, "dashboards"); err != nil {
+		t.Fatalf("collection exists: %s", err)
+	}
+	if _, err := s.CollectionDimension(ctx, "dashboards"); err != nil {
+		t.Fatalf("collection dimension: %s", err)
+	}
+	if _, err := s.Search(ctx, "dashboards", []float32{1}, 5, nil); err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	streamer, ok := s.(SearchStreamer)
+	if !ok {
+		t.Fatalf("expected the prefixed store to stream searches")
+	}
+	if _, err := streamer.SearchStream(ctx, "dashboards", []float32{1}, 5, nil); err != nil {
+		t.Fatalf("search stream: %s", err)
+	}
+	for _, c := range backend.collections {
+		if c != "stack-123-dashboards" {
+			t.Errorf("expected the backend to be asked for stack-123-dashboards, got %s", c)
+		}
+	}
+	if len(backend.collections) != 4 {
+		t.Errorf("expected 4 requests to reach the backend, got %d", len(backend.collections))
+	}
+}
+
+func TestCollectionPrefixRejectsUnsafeNames(t *testing.T) {
+	ctx := context.Background()
+	backend := &recordingStore{}
+	s := withCollectionPrefix(backend, "stack-123-")
+	for _, collection := range []string{"", "../stack-456-dashboards", "..", ".hidden", "a/b", "docs?x=1", "docs dashboards"} {
+		if _, err := s.CollectionExists(ctx, collection); !errors.Is(err, ErrInvalidCollection) {
+			t.Errorf("collection exists %q: expected ErrInvalidCollection, got %v", collection, err)
+		}
+		if _, err := s.Search(ctx, collection, []float32{1}, 5, nil); !errors.Is(err, ErrInvalidCollection) {
+			t.Errorf("search %q: expected ErrInvalidCollection, got %v", collection, err)
+		}
+		if _, err := s.(SearchStreamer).SearchStream(ctx, collection, []float32{1}, 5, nil); !errors.Is(err, ErrInvalidCollection) {
+			t.Errorf("search stream %q: expected ErrInvalidCollection, got %v", collection, err)
+		}
+	}
+	if len(backend.collections) != 0 {
+		t.Errorf("expected no requests to reach the backend, got %v", backend.collections)
+	}
+	if _, err := s.Search(ctx, "grafana_docs-v1.2", []float32{1}, 5, nil); err != nil {
+		t.Errorf("expected a safe name to be accepted, got %s", err)
+	}
+}
+
+func TestExpandCollectionPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, tenant string
+
+		exp    string
+		expErr bool
+	}{
+		{prefix: "", exp: ""},
+		{prefix: "shared_", tenant: "stack-123", exp: "shared_"},
+		{prefix: "${tenant}_", tenant: "stack-123", exp: "stack-123_"},
+		{prefix: "${tenant}_", expErr: true},
+	} {
+		got, err := expandCollectionPrefix(tc.prefix, tc.tenant)
+		if (err != nil) != tc.expErr {
+			t.Errorf("expandCollectionPrefix(%q, %q): unexpected error %v", tc.prefix, tc.tenant, err)
+		}
+		if got != tc.exp {
+			t.Errorf("expandCollectionPrefix(%q, %q) = %q, expected %q", tc.prefix, tc.tenant, got, tc.exp)
+		}
+	}
+}
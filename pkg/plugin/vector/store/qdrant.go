@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"strconv"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	qdrant "github.com/qdrant/go-client/qdrant"
@@ -20,8 +21,13 @@ type qdrantSettings struct {
 	Address string `json:"address"`
 	// Whether to use a secure connection.
 	Secure bool `json:"secure"`
+
+	userAgent string
 }
 
+// qdrantStore searches Qdrant using its gRPC API, over a single persistent
+// connection. The connection reconnects by itself after failures, and calls
+// wait for it to be ready rather than failing while it does.
 type qdrantStore struct {
 	conn              *grpc.ClientConn
 	md                *metadata.MD
@@ -43,6 +49,9 @@ func newQdrantStore(s qdrantSettings, secrets map[string]string) (ReadVectorStor
 	} else {
 		dialOptions = append(dialOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	}
+	if s.userAgent != "" {
+		dialOptions = append(dialOptions, grpc.WithUserAgent(s.userAgent))
+	}
 	conn, err := grpc.DialContext(context.Background(), s.Address, dialOptions...)
 	if err != nil {
 		return nil, nil, err
@@ -94,6 +103,21 @@ func (q *qdrantStore) CollectionExists(ctx context.Context, collection string) (
 	return true, nil
 }
 
+// CollectionDimension returns the size of the collection's vectors. Collections
+// with several named vectors report 0, since the size depends on the vector.
+func (q *qdrantStore) CollectionDimension(ctx context.Context, collection string) (int, error) {
+	if q.md != nil {
+		ctx = metadata.NewOutgoingContext(ctx, *q.md)
+	}
+	resp, err := q.collectionsClient.Get(ctx, &qdrant.GetCollectionInfoRequest{
+		CollectionName: collection,
+	}, grpc.WaitForReady(true))
+	if err != nil {
+		return 0, fmt.Errorf("get collection: %w", err)
+	}
+	return int(resp.GetResult().GetConfig().GetParams().GetVectorsConfig().GetParams().GetSize()), nil
+}
+
 func (q *qdrantStore) mapFilters(ctx context.Context, filter map[string]interface{}) (*qdrant.Filter, error) {
 	qdrantFilterMap := &qdrant.Filter{}
 
@@ -208,6 +232,7 @@ func (q *qdrantStore) Search(ctx context.Context, collection string, vector []fl
 		}
 		// TODO: handle non-strings, in case they get there
 		results = append(results, SearchResult{
+			ID:      qdrantPointID(v.GetId()),
 			Score:   float64(v.Score),
 			Payload: payload,
 		})
@@ -215,6 +240,17 @@ func (q *qdrantStore) Search(ctx context.Context, collection string, vector []fl
 	return results, nil
 }
 
+// qdrantPointID returns a point ID, which is either a number or a UUID, as a string.
+func qdrantPointID(id *qdrant.PointId) string {
+	switch v := id.GetPointIdOptions().(type) {
+	case *qdrant.PointId_Num:
+		return strconv.FormatUint(v.Num, 10)
+	case *qdrant.PointId_Uuid:
+		return v.Uuid
+	}
+	return ""
+}
+
 func fromQdrantValue(in *qdrant.Value) any {
 	switch v := in.Kind.(type) {
 	case *qdrant.Value_NullValue:
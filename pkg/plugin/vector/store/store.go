@@ -2,6 +2,8 @@ package store
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
@@ -11,6 +13,7 @@ type VectorStoreType string
 const (
 	VectorStoreTypeQdrant           VectorStoreType = "qdrant"
 	VectorStoreTypeGrafanaVectorAPI VectorStoreType = "grafana/vectorapi"
+	VectorStoreTypeVespa            VectorStoreType = "vespa"
 )
 
 type VectorStoreAuthType string
@@ -20,16 +23,40 @@ const (
 )
 
 type SearchResult struct {
+	// ID is the ID of the matching point, if the store provides one.
+	ID string `json:"id,omitempty"`
+	// Payload is the point's metadata, including any nested objects.
 	Payload map[string]any `json:"payload"`
-	Score   float64        `json:"score"`
+	// Score is the similarity score as returned by the store.
+	Score float64 `json:"score"`
+	// Collection is the collection the point is in. It is only set for
+	// results from MultiSearch.
+	Collection string `json:"collection,omitempty"`
 }
 
 type ReadVectorStore interface {
 	CollectionExists(ctx context.Context, collection string) (bool, error)
+	// CollectionDimension returns the dimension of the vectors in collection,
+	// or 0 if the store can't tell.
+	CollectionDimension(ctx context.Context, collection string) (int, error)
 	Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error)
 	Health(ctx context.Context) error
 }
 
+// StreamedResult is a result sent by SearchStream. If Err is set, the search
+// failed part way through, the other fields are empty, and no more results
+// follow.
+type StreamedResult struct {
+	SearchResult
+	Err error
+}
+
+// SearchStreamer is implemented by stores which can stream search results as
+// they are decoded, rather than buffering the whole response.
+type SearchStreamer interface {
+	SearchStream(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) (<-chan StreamedResult, error)
+}
+
 type WriteVectorStore interface {
 	Collections(ctx context.Context) ([]string, error)
 	CreateCollection(ctx context.Context, collection string, size uint64) error
@@ -52,9 +79,46 @@ type Settings struct {
 	GrafanaVectorAPI GrafanaVectorAPISettings `json:"grafanaVectorAPI"`
 
 	Qdrant qdrantSettings `json:"qdrant"`
+
+	Vespa vespaSettings `json:"vespa"`
+
+	Cache VectorCacheSettings `json:"cache"`
+
+	// CollectionPrefix is prepended to the name of every collection used, to
+	// keep tenants sharing a store apart. `${tenant}` is replaced by the tenant.
+	CollectionPrefix string `json:"collectionPrefix"`
+
+	// UserAgent is sent as the User-Agent header of requests to the store.
+	// It is set by the plugin rather than configured directly.
+	UserAgent string `json:"-"`
+	// Tenant is the tenant the store is used by, for CollectionPrefix. It is
+	// set by the plugin rather than configured directly.
+	Tenant string `json:"-"`
 }
 
 func NewReadVectorStore(s Settings, secrets map[string]string) (ReadVectorStore, context.CancelFunc, error) {
+	prefix, err := expandCollectionPrefix(s.CollectionPrefix, s.Tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	vectorStore, cancel, err := newReadVectorStore(s, secrets)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Cache outside the instrumentation, so the metrics reflect the backend.
+	cached, err := withCache(instrument(vectorStore, s.Type), s.Cache)
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("create search cache: %w", err)
+	}
+	// Prefix outermost, so that cache keys and metrics use the full name.
+	return withCollectionPrefix(cached, prefix), cancel, nil
+}
+
+func newReadVectorStore(s Settings, secrets map[string]string) (ReadVectorStore, context.CancelFunc, error) {
+	s.GrafanaVectorAPI.userAgent = s.UserAgent
+	s.Qdrant.userAgent = s.UserAgent
+	s.Vespa.userAgent = s.UserAgent
 	switch s.Type {
 	case VectorStoreTypeGrafanaVectorAPI:
 		log.DefaultLogger.Debug("Creating Grafana Vector API store")
@@ -63,10 +127,22 @@ func NewReadVectorStore(s Settings, secrets map[string]string) (ReadVectorStore,
 	case VectorStoreTypeQdrant:
 		log.DefaultLogger.Debug("Creating Qdrant store")
 		return newQdrantStore(s.Qdrant, secrets)
+	case VectorStoreTypeVespa:
+		log.DefaultLogger.Debug("Creating Vespa store")
+		vectorStore, err := newVespaStore(s.Vespa, secrets)
+		return vectorStore, func() {}, err
 	}
 	return nil, nil, nil
 }
 
+// setUserAgent sets the User-Agent header of req, unless userAgent is empty,
+// in which case Go's default is used.
+func setUserAgent(req *http.Request, userAgent string) {
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+}
+
 func NewVectorStore(s Settings) (VectorStore, error) {
 	// TODO: Implement write vector store.
 	return nil, nil
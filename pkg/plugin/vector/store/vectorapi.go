@@ -3,23 +3,58 @@ package store
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
+// defaultRetryBackoff is the delay before the first retry of a failed request.
+// It doubles on each subsequent retry, up to maxRetryBackoff.
+const (
+	defaultRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = 2 * time.Second
+)
+
+// defaultSignatureHeader is the header request signatures are sent in if no
+// other is configured.
+const defaultSignatureHeader = "X-Signature"
+
 type GrafanaVectorAPISettings struct {
 	URL           string `json:"url"`
 	AuthType      string `json:"authType"`
 	BasicAuthUser string `json:"basicAuthUser"`
+	// MaxRetries is the number of times to retry requests which fail with a
+	// transient error (connection reset/refused, or one of RetryStatusCodes).
+	// If zero, requests are not retried.
+	MaxRetries int `json:"maxRetries"`
+	// RetryStatusCodes are the response status codes which are retried, for
+	// services with their own overload signals, such as 529. Defaults to 502,
+	// 503 and 504.
+	RetryStatusCodes []int `json:"retryStatusCodes"`
+	// SignatureHeader is the header in which request signatures are sent, if
+	// the vectorStoreSigningSecret secret is set. Defaults to X-Signature.
+	SignatureHeader string `json:"signatureHeader"`
+
+	userAgent string
 }
 
 type grafanaVectorAPIAuthSettings struct {
 	BasicAuthUser     string
 	BasicAuthPassword string
+	// SigningSecret, if set, is used to sign request bodies.
+	SigningSecret   string
+	SignatureHeader string
 }
 
 type grafanaVectorAPI struct {
@@ -27,6 +62,11 @@ type grafanaVectorAPI struct {
 	url          string
 	authType     VectorStoreAuthType
 	authSettings grafanaVectorAPIAuthSettings
+	maxRetries   int
+	retryBackoff time.Duration
+	// retryStatusCodes are the status codes which are retried.
+	retryStatusCodes map[int]bool
+	userAgent        string
 }
 
 func (g *grafanaVectorAPI) setAuth(req *http.Request) {
@@ -36,23 +76,147 @@ func (g *grafanaVectorAPI) setAuth(req *http.Request) {
 	}
 }
 
+// sign sets the signature header to the hex-encoded HMAC-SHA256 of body, if a
+// signing secret is configured. Requests without a body sign the empty body.
+func (g *grafanaVectorAPI) sign(req *http.Request, body []byte) {
+	if g.authSettings.SigningSecret == "" {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(g.authSettings.SigningSecret))
+	mac.Write(body)
+	req.Header.Set(g.authSettings.SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// defaultRetryStatusCodes are the status codes retried if none are configured.
+var defaultRetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// isRetryable reports whether a request which failed with err or returned resp
+// is worth retrying.
+func (g *grafanaVectorAPI) isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return g.retryStatusCodes[resp.StatusCode]
+}
+
+// do sends a request to the VectorAPI, retrying transient failures with
+// exponential backoff up to g.maxRetries times. Retries stop early if ctx is
+// done. The caller must close the response body.
+func (g *grafanaVectorAPI) do(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	backoff := g.retryBackoff
+	for attempt := 0; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		setUserAgent(req, g.userAgent)
+		g.setAuth(req)
+		g.sign(req, body)
+		resp, err := g.client.Do(req)
+		if attempt >= g.maxRetries || ctx.Err() != nil || !g.isRetryable(resp, err) {
+			return resp, err
+		}
+		if err == nil {
+			log.DefaultLogger.Debug("Retrying VectorAPI request", "url", url, "status", resp.Status, "attempt", attempt+1)
+			resp.Body.Close()
+		} else {
+			log.DefaultLogger.Debug("Retrying VectorAPI request", "url", url, "err", err, "attempt", attempt+1)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// collectionURL returns the URL of collection, escaped so that the name is
+// always a single path segment. PathEscape leaves dots alone, so `.` and `..`
+// are escaped separately.
+func (g *grafanaVectorAPI) collectionURL(collection string) string {
+	segment := url.PathEscape(collection)
+	if collection == "." || collection == ".." {
+		segment = strings.ReplaceAll(collection, ".", "%2E")
+	}
+	return g.url + "/v1/collections/" + segment
+}
+
 func (g *grafanaVectorAPI) CollectionExists(ctx context.Context, collection string) (bool, error) {
-	req, err := http.NewRequest("GET", g.url+"/v1/collections/"+collection, nil)
+	resp, err := g.do(ctx, http.MethodGet, g.collectionURL(collection), nil)
 	if err != nil {
 		return false, fmt.Errorf("get collection: %w", err)
 	}
-	g.setAuth(req)
-	resp, err := g.client.Do(req)
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.DefaultLogger.Warn("failed to close response body", "err", err)
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("get collection: %s", resp.Status)
+}
+
+// CollectionDimension returns the dimension the vector API reports for the
+// collection.
+func (g *grafanaVectorAPI) CollectionDimension(ctx context.Context, collection string) (int, error) {
+	resp, err := g.do(ctx, http.MethodGet, g.collectionURL(collection), nil)
 	if err != nil {
-		return false, fmt.Errorf("get collection: %w", err)
+		return 0, fmt.Errorf("get collection: %w", err)
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.DefaultLogger.Warn("failed to close response body", "err", err)
+		}
+	}()
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("get collection: %s", resp.Status)
+		return 0, fmt.Errorf("get collection: %s", resp.Status)
 	}
-	return true, nil
+	var body struct {
+		Dimension int `json:"dimension"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("decode collection: %w", err)
+	}
+	return body.Dimension, nil
 }
 
-func (g *grafanaVectorAPI) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+type queryPointPayload struct {
+	ID        string         `json:"id"`
+	Embedding []float32      `json:"embedding"`
+	Metadata  map[string]any `json:"metadata"`
+}
+
+type queryPointResult struct {
+	Payload queryPointPayload `json:"payload"`
+	Score   float64           `json:"score"`
+}
+
+func (r queryPointResult) toSearchResult() SearchResult {
+	return SearchResult{
+		ID:      r.Payload.ID,
+		Payload: r.Payload.Metadata,
+		Score:   r.Score,
+	}
+}
+
+// query sends a query to the collection, returning the response if it was
+// successful. The caller must close the response body.
+func (g *grafanaVectorAPI) query(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) (*http.Response, error) {
 	type queryPointsRequest struct {
 		Query []float32 `json:"query"`
 		TopK  uint64    `json:"top_k"`
@@ -69,15 +233,23 @@ func (g *grafanaVectorAPI) Search(ctx context.Context, collection string, vector
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", g.url+"/v1/collections/"+collection+"/query", bytes.NewReader(reqJSON))
+	resp, err := g.do(ctx, http.MethodPost, g.collectionURL(collection)+"/query", reqJSON)
 	if err != nil {
-		return nil, fmt.Errorf("get collections: %w", err)
+		return nil, fmt.Errorf("post collections: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err := resp.Body.Close(); err != nil {
+			log.DefaultLogger.Warn("failed to close response body", "err", err)
+		}
+		return nil, fmt.Errorf("post collections: %s", resp.Status)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	g.setAuth(req)
-	resp, err := g.client.Do(req)
+	return resp, nil
+}
+
+func (g *grafanaVectorAPI) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	resp, err := g.query(ctx, collection, vector, topK, filter)
 	if err != nil {
-		return nil, fmt.Errorf("post collections: %w", err)
+		return nil, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -88,42 +260,82 @@ func (g *grafanaVectorAPI) Search(ctx context.Context, collection string, vector
 	if err != nil {
 		return nil, fmt.Errorf("read response body: %w", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("post collections: %s", resp.Status)
-	}
-	type queryPointPayload struct {
-		ID        string         `json:"id"`
-		Embedding []float32      `json:"embedding"`
-		Metadata  map[string]any `json:"metadata"`
-	}
-	type queryPointResult struct {
-		Payload queryPointPayload `json:"payload"`
-		Score   float64           `json:"score"`
-	}
 	queryResult := []queryPointResult{}
 	if err := json.Unmarshal(body, &queryResult); err != nil {
 		return nil, fmt.Errorf("decode collections: %w", err)
 	}
 	results := make([]SearchResult, 0, len(queryResult))
 	for _, r := range queryResult {
-		results = append(results, SearchResult{
-			Payload: r.Payload.Metadata,
-			Score:   r.Score,
-		})
+		results = append(results, r.toSearchResult())
 	}
 	return results, nil
 }
 
-func (g *grafanaVectorAPI) Health(ctx context.Context) error {
-	req, err := http.NewRequest("GET", g.url+"/healthz", nil)
+// SearchStream is like Search, but decodes the results incrementally and sends
+// them on the returned channel as they are parsed, so the response size isn't
+// capped and the full result set is never held in memory at once.
+//
+// The channel is closed when all results have been sent or the context is
+// cancelled. If the response can't be decoded part way through, the error is
+// sent as the last result.
+func (g *grafanaVectorAPI) SearchStream(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) (<-chan StreamedResult, error) {
+	resp, err := g.query(ctx, collection, vector, topK, filter)
 	if err != nil {
-		return fmt.Errorf("get health: %w", err)
+		return nil, err
+	}
+	dec := json.NewDecoder(resp.Body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		resp.Body.Close()
+		if err == nil {
+			err = fmt.Errorf("expected JSON array, got %v", tok)
+		}
+		return nil, fmt.Errorf("decode collections: %w", err)
 	}
-	g.setAuth(req)
-	resp, err := g.client.Do(req)
+
+	results := make(chan StreamedResult)
+	go func() {
+		defer close(results)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.DefaultLogger.Warn("failed to close response body", "err", err)
+			}
+		}()
+		send := func(r StreamedResult) bool {
+			select {
+			case results <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for dec.More() {
+			var r queryPointResult
+			if err := dec.Decode(&r); err != nil {
+				send(StreamedResult{Err: fmt.Errorf("decode search result: %w", err)})
+				return
+			}
+			if !send(StreamedResult{SearchResult: r.toSearchResult()}) {
+				return
+			}
+		}
+		// A truncated response ends without closing the array.
+		if _, err := dec.Token(); err != nil {
+			send(StreamedResult{Err: fmt.Errorf("decode search result: %w", err)})
+		}
+	}()
+	return results, nil
+}
+
+func (g *grafanaVectorAPI) Health(ctx context.Context) error {
+	resp, err := g.do(ctx, http.MethodGet, g.url+"/healthz", nil)
 	if err != nil {
 		return fmt.Errorf("get health: %w", err)
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.DefaultLogger.Warn("failed to close response body", "err", err)
+		}
+	}()
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("get health: %s", resp.Status)
 	}
@@ -131,6 +343,18 @@ func (g *grafanaVectorAPI) Health(ctx context.Context) error {
 }
 
 func newGrafanaVectorAPI(s GrafanaVectorAPISettings, secrets map[string]string) (ReadVectorStore, error) {
+	signatureHeader := s.SignatureHeader
+	if signatureHeader == "" {
+		signatureHeader = defaultSignatureHeader
+	}
+	codes := s.RetryStatusCodes
+	if len(codes) == 0 {
+		codes = defaultRetryStatusCodes
+	}
+	retryStatusCodes := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		retryStatusCodes[code] = true
+	}
 	return &grafanaVectorAPI{
 		client:   &http.Client{},
 		url:      s.URL,
@@ -138,6 +362,12 @@ func newGrafanaVectorAPI(s GrafanaVectorAPISettings, secrets map[string]string)
 		authSettings: grafanaVectorAPIAuthSettings{
 			BasicAuthUser:     s.BasicAuthUser,
 			BasicAuthPassword: secrets["vectorStoreBasicAuthPassword"],
+			SigningSecret:     secrets["vectorStoreSigningSecret"],
+			SignatureHeader:   signatureHeader,
 		},
+		maxRetries:       s.MaxRetries,
+		retryBackoff:     defaultRetryBackoff,
+		retryStatusCodes: retryStatusCodes,
+		userAgent:        s.userAgent,
 	}, nil
 }
@@ -0,0 +1,411 @@
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaVectorAPISearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"payload": {"id": "doc-1", "embedding": [0.1], "metadata": {
+			"title": "Dashboards",
+			"source": {"kind": "docs", "tags": ["grafana", "dashboards"]}
+		}}, "score": 0.75}]`))
+	}))
+	defer server.Close()
+
+	s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	results, err := s.Search(context.Background(), "docs", []float32{0.1}, 1, nil)
+	if err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	exp := []SearchResult{{
+		ID: "doc-1",
+		Payload: map[string]any{
+			"title":  "Dashboards",
+			"source": map[string]any{"kind": "docs", "tags": []any{"grafana", "dashboards"}},
+		},
+		Score: 0.75,
+	}}
+	if !reflect.DeepEqual(results, exp) {
+		t.Errorf("expected results %+v, got %+v", exp, results)
+	}
+}
+
+func TestGrafanaVectorAPICollectionDimension(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/collections/docs" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "docs", "dimension": 768}`))
+	}))
+	defer server.Close()
+
+	s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	dimension, err := s.CollectionDimension(context.Background(), "docs")
+	if err != nil {
+		t.Fatalf("collection dimension: %s", err)
+	}
+	if dimension != 768 {
+		t.Errorf("expected dimension 768, got %d", dimension)
+	}
+	if _, err := s.CollectionDimension(context.Background(), "missing"); err == nil {
+		t.Error("expected an error for a missing collection")
+	}
+}
+
+func TestGrafanaVectorAPICollectionEscaping(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	for _, collection := range []string{"../admin/docs", "docs?all=true", ".."} {
+		if _, err := s.Search(context.Background(), collection, []float32{0.1}, 1, nil); err != nil {
+			t.Fatalf("search %s: %s", collection, err)
+		}
+	}
+	exp := []string{
+		"/v1/collections/..%2Fadmin%2Fdocs/query",
+		"/v1/collections/docs%3Fall=true/query",
+		"/v1/collections/%2E%2E/query",
+	}
+	if !reflect.DeepEqual(paths, exp) {
+		t.Errorf("expected paths %v, got %v", exp, paths)
+	}
+}
+
+func TestGrafanaVectorAPISigning(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		header    string
+		secret    string
+		expHeader string
+	}{
+		{name: "default header", secret: "s3cret", expHeader: "X-Signature"},
+		{name: "custom header", header: "X-Vector-Signature", secret: "s3cret", expHeader: "X-Vector-Signature"},
+		{name: "no secret"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				body      []byte
+				signature string
+				header    http.Header
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+				header = r.Header
+				if tc.expHeader != "" {
+					signature = r.Header.Get(tc.expHeader)
+				}
+				_, _ = w.Write([]byte("[]"))
+			}))
+			defer server.Close()
+
+			s, err := newGrafanaVectorAPI(
+				GrafanaVectorAPISettings{URL: server.URL, SignatureHeader: tc.header},
+				map[string]string{"vectorStoreSigningSecret": tc.secret},
+			)
+			if err != nil {
+				t.Fatalf("new store: %s", err)
+			}
+			if _, err := s.Search(context.Background(), "docs", []float32{0.1}, 1, nil); err != nil {
+				t.Fatalf("search: %s", err)
+			}
+
+			if tc.expHeader == "" {
+				if got := header.Get(defaultSignatureHeader); got != "" {
+					t.Errorf("expected no signature without a secret, got %q", got)
+				}
+				return
+			}
+			mac := hmac.New(sha256.New, []byte(tc.secret))
+			mac.Write(body)
+			if exp := hex.EncodeToString(mac.Sum(nil)); signature != exp {
+				t.Errorf("expected %s header %q, got %q", tc.expHeader, exp, signature)
+			}
+		})
+	}
+}
+
+func TestGrafanaVectorAPISearchStream(t *testing.T) {
+	const n = 1000
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/collections/docs/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		results := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			// Pad each result so the full response exceeds the 1 MiB cap used by Search.
+			results = append(results, fmt.Sprintf(`{"payload": {"id": "%d", "metadata": {"title": "doc %d", "padding": "%s"}}, "score": %d}`, i, i, strings.Repeat("x", 2048), i))
+		}
+		_, _ = w.Write([]byte("[" + strings.Join(results, ",") + "]"))
+	}))
+	defer server.Close()
+
+	s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	ch, err := s.(SearchStreamer).SearchStream(context.Background(), "docs", []float32{0.1}, n, nil)
+	if err != nil {
+		t.Fatalf("search stream: %s", err)
+	}
+	count := 0
+	for r := range ch {
+		if r.Err != nil {
+			t.Fatalf("result %d: %s", count, r.Err)
+		}
+		if r.Payload["title"] != fmt.Sprintf("doc %d", count) || r.Score != float64(count) {
+			t.Fatalf("unexpected result %d: %v", count, r)
+		}
+		count++
+	}
+	if count != n {
+		t.Errorf("expected %d results, got %d", n, count)
+	}
+}
+
+func TestGrafanaVectorAPISearchStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	if _, err := s.(SearchStreamer).SearchStream(context.Background(), "docs", []float32{0.1}, 10, nil); err == nil {
+		t.Fatal("expected error for missing collection")
+	}
+}
+
+func TestGrafanaVectorAPISearchStreamMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{name: "malformed result", body: `[{"payload": {"id": "doc-1"}, "score": 0.9}, {"payload": {"id": 2}}]`},
+		{name: "truncated", body: `[{"payload": {"id": "doc-1"}, "score": 0.9}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL}, nil)
+			if err != nil {
+				t.Fatalf("new store: %s", err)
+			}
+			ch, err := instrument(s, "vectorapi").(SearchStreamer).SearchStream(context.Background(), "stream-malformed", []float32{0.1}, 10, nil)
+			if err != nil {
+				t.Fatalf("search stream: %s", err)
+			}
+			var results []StreamedResult
+			for r := range ch {
+				results = append(results, r)
+			}
+			if len(results) != 2 || results[0].ID != "doc-1" || results[0].Err != nil || results[1].Err == nil {
+				t.Fatalf("expected a result followed by an error, got %+v", results)
+			}
+		})
+	}
+}
+
+// newFlakyServer returns a server which fails the first `failures` requests with
+// a 503 before succeeding.
+func newFlakyServer(failures int, body string) (*httptest.Server, *int) {
+	return newFlakyServerWithStatus(failures, http.StatusServiceUnavailable, body)
+}
+
+// newFlakyServerWithStatus is like newFlakyServer, but fails with status.
+func newFlakyServerWithStatus(failures int, status int, body string) (*httptest.Server, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	return server, &calls
+}
+
+func TestGrafanaVectorAPIRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		failures   int
+		maxRetries int
+		call       func(ctx context.Context, s ReadVectorStore) error
+
+		expErr   bool
+		expCalls int
+	}{
+		{
+			name:       "search succeeds after retries",
+			failures:   2,
+			maxRetries: 3,
+			call: func(ctx context.Context, s ReadVectorStore) error {
+				_, err := s.Search(ctx, "docs", []float32{0.1}, 10, nil)
+				return err
+			},
+			expCalls: 3,
+		},
+		{
+			name:       "collection exists succeeds after retries",
+			failures:   1,
+			maxRetries: 1,
+			call: func(ctx context.Context, s ReadVectorStore) error {
+				_, err := s.CollectionExists(ctx, "docs")
+				return err
+			},
+			expCalls: 2,
+		},
+		{
+			name:       "health gives up after max retries",
+			failures:   3,
+			maxRetries: 2,
+			call: func(ctx context.Context, s ReadVectorStore) error {
+				return s.Health(ctx)
+			},
+			expErr:   true,
+			expCalls: 3,
+		},
+		{
+			name:       "no retries by default",
+			failures:   1,
+			maxRetries: 0,
+			call: func(ctx context.Context, s ReadVectorStore) error {
+				return s.Health(ctx)
+			},
+			expErr:   true,
+			expCalls: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, calls := newFlakyServer(tc.failures, "[]")
+			defer server.Close()
+			s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL, MaxRetries: tc.maxRetries}, nil)
+			if err != nil {
+				t.Fatalf("new store: %s", err)
+			}
+			s.(*grafanaVectorAPI).retryBackoff = time.Millisecond
+
+			err = tc.call(context.Background(), s)
+			if tc.expErr && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tc.expErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if *calls != tc.expCalls {
+				t.Errorf("expected %d calls, got %d", tc.expCalls, *calls)
+			}
+		})
+	}
+}
+
+func TestGrafanaVectorAPIRetryStatusCodes(t *testing.T) {
+	// 529 is used by some services to signal that they're overloaded.
+	const statusOverloaded = 529
+	for _, tc := range []struct {
+		name        string
+		status      int
+		statusCodes []int
+
+		expErr   bool
+		expCalls int
+	}{
+		{name: "529 not retried by default", status: statusOverloaded, expErr: true, expCalls: 1},
+		{name: "529 retried if configured", status: statusOverloaded, statusCodes: []int{http.StatusServiceUnavailable, statusOverloaded}, expCalls: 2},
+		{name: "defaults replaced", status: http.StatusServiceUnavailable, statusCodes: []int{statusOverloaded}, expErr: true, expCalls: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, calls := newFlakyServerWithStatus(1, tc.status, "[]")
+			defer server.Close()
+			s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL, MaxRetries: 1, RetryStatusCodes: tc.statusCodes}, nil)
+			if err != nil {
+				t.Fatalf("new store: %s", err)
+			}
+			s.(*grafanaVectorAPI).retryBackoff = time.Millisecond
+
+			err = s.Health(context.Background())
+			if (err != nil) != tc.expErr {
+				t.Errorf("expected error %t, got %v", tc.expErr, err)
+			}
+			if *calls != tc.expCalls {
+				t.Errorf("expected %d calls, got %d", tc.expCalls, *calls)
+			}
+		})
+	}
+}
+
+func TestGrafanaVectorAPIRetriesRespectContext(t *testing.T) {
+	server, calls := newFlakyServer(100, "[]")
+	defer server.Close()
+	s, err := newGrafanaVectorAPI(GrafanaVectorAPISettings{URL: server.URL, MaxRetries: 100}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	s.(*grafanaVectorAPI).retryBackoff = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 75*time.Millisecond)
+	defer cancel()
+	if err := s.Health(ctx); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if *calls > 2 {
+		t.Errorf("expected retries to stop at the context deadline, got %d calls", *calls)
+	}
+}
+
+func TestGrafanaVectorAPIUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	s, _, err := newReadVectorStore(Settings{
+		Type:             VectorStoreTypeGrafanaVectorAPI,
+		GrafanaVectorAPI: GrafanaVectorAPISettings{URL: server.URL},
+		UserAgent:        "grafana-llm-app/1.2.3",
+	}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	if _, err := s.Search(context.Background(), "docs", []float32{0.1}, 1, nil); err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	if userAgent != "grafana-llm-app/1.2.3" {
+		t.Errorf("expected User-Agent %q, got %q", "grafana-llm-app/1.2.3", userAgent)
+	}
+}
@@ -0,0 +1,412 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// vespaSettings contains the settings for a Vespa vector store.
+//
+// Vespa has no notion of collections, so collection names are mapped to Vespa
+// document types. DocType is used when no collection is given.
+type vespaSettings struct {
+	// The URL of the Vespa query/document container, e.g. http://localhost:8080.
+	URL string `json:"url"`
+	// The document namespace, used when checking whether a collection exists.
+	Namespace string `json:"namespace"`
+	// The default document type to search.
+	DocType string `json:"docType"`
+	// The tensor field holding the document embeddings.
+	Field string `json:"field"`
+	// The rank profile to use for searches. It must rank using closeness on Field
+	// against the query tensor `q`. If empty, Vespa's default profile is used.
+	RankProfile string `json:"rankProfile"`
+	// The rank profile to use for hybrid searches, which match documents
+	// either near the query tensor `q` or containing the query's words. It
+	// must combine closeness on Field with keyword scoring such as bm25,
+	// weighting closeness by the input `query(alpha)` and the keyword score
+	// by `1 - query(alpha)`. If empty, hybrid searches fall back to vector
+	// searches.
+	HybridRankProfile string `json:"hybridRankProfile"`
+
+	userAgent string
+}
+
+type vespaStore struct {
+	client      *http.Client
+	url         string
+	namespace   string
+	docType     string
+	field       string
+	rankProfile string
+	token       string
+	userAgent   string
+
+	hybridRankProfile string
+}
+
+func newVespaStore(s vespaSettings, secrets map[string]string) (ReadVectorStore, error) {
+	if s.URL == "" {
+		return nil, fmt.Errorf("vespa URL is required")
+	}
+	if s.Field == "" {
+		return nil, fmt.Errorf("vespa embedding field is required")
+	}
+	if !vespaIdentifier.MatchString(s.Field) {
+		return nil, fmt.Errorf("invalid vespa embedding field: %q", s.Field)
+	}
+	return &vespaStore{
+		client:      &http.Client{},
+		url:         strings.TrimSuffix(s.URL, "/"),
+		namespace:   s.Namespace,
+		docType:     s.DocType,
+		field:       s.Field,
+		rankProfile: s.RankProfile,
+		token:       secrets["vespaToken"],
+		userAgent:   s.userAgent,
+
+		hybridRankProfile: s.HybridRankProfile,
+	}, nil
+}
+
+func (v *vespaStore) setAuth(req *http.Request) {
+	if v.token != "" {
+		req.Header.Set("Authorization", "Bearer "+v.token)
+	}
+}
+
+func (v *vespaStore) documentType(collection string) string {
+	if collection == "" {
+		return v.docType
+	}
+	return collection
+}
+
+// vespaIdentifier matches the document type and field names which can be
+// used in YQL as they are. Anything else is rejected rather than escaped,
+// since YQL has no quoting for identifiers.
+var vespaIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// source returns the document type to search for collection, checking it
+// can be used in YQL.
+func (v *vespaStore) source(collection string) (string, error) {
+	docType := v.documentType(collection)
+	if !vespaIdentifier.MatchString(docType) {
+		return "", fmt.Errorf("invalid vespa document type: %q", docType)
+	}
+	return docType, nil
+}
+
+func (v *vespaStore) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url+"/state/v1/health", nil)
+	if err != nil {
+		return fmt.Errorf("get health: %w", err)
+	}
+	setUserAgent(req, v.userAgent)
+	v.setAuth(req)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("get health: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.DefaultLogger.Warn("failed to close response body", "err", err)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get health: %s", resp.Status)
+	}
+	var body struct {
+		Status struct {
+			Code string `json:"code"`
+		} `json:"status"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&body); err != nil {
+		return fmt.Errorf("decode health: %w", err)
+	}
+	if body.Status.Code != "up" {
+		return fmt.Errorf("vespa status: %s", body.Status.Code)
+	}
+	return nil
+}
+
+// CollectionExists checks whether the document type exists by visiting (at most)
+// a single document of that type using Vespa's document API.
+func (v *vespaStore) CollectionExists(ctx context.Context, collection string) (bool, error) {
+	u := fmt.Sprintf("%s/document/v1/%s/%s/docid?wantedDocumentCount=1",
+		v.url, url.PathEscape(v.namespace), url.PathEscape(v.documentType(collection)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, fmt.Errorf("get collection: %w", err)
+	}
+	setUserAgent(req, v.userAgent)
+	v.setAuth(req)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("get collection: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.DefaultLogger.Warn("failed to close response body", "err", err)
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	// Vespa returns 400 for unknown document types.
+	case http.StatusBadRequest, http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("get collection: %s", resp.Status)
+}
+
+// CollectionDimension returns 0, since the dimension is part of the Vespa
+// schema rather than anything the document API reports.
+func (v *vespaStore) CollectionDimension(ctx context.Context, collection string) (int, error) {
+	return 0, nil
+}
+
+func (v *vespaStore) Search(ctx context.Context, collection string, vector []float32, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	docType, err := v.source(collection)
+	if err != nil {
+		return nil, err
+	}
+	yql := fmt.Sprintf("select * from sources %s where {targetHits: %d}nearestNeighbor(%s, q)", docType, topK, v.field)
+	if len(filter) > 0 {
+		where, err := vespaWhere(filter)
+		if err != nil {
+			return nil, err
+		}
+		yql += " and " + where
+	}
+	reqBody := map[string]interface{}{
+		"yql":            yql,
+		"hits":           topK,
+		"input.query(q)": vector,
+	}
+	if v.rankProfile != "" {
+		reqBody["ranking.profile"] = v.rankProfile
+	}
+	return v.search(ctx, reqBody)
+}
+
+// HybridSearch matches documents either near vector or containing the words
+// of query, ranking them with the hybrid rank profile, which is given alpha
+// as `query(alpha)`. Without a hybrid rank profile it returns
+// ErrHybridSearchUnsupported.
+func (v *vespaStore) HybridSearch(ctx context.Context, collection string, query string, vector []float32, alpha float64, topK uint64, filter map[string]interface{}) ([]SearchResult, error) {
+	if v.hybridRankProfile == "" {
+		return nil, ErrHybridSearchUnsupported
+	}
+	docType, err := v.source(collection)
+	if err != nil {
+		return nil, err
+	}
+	yql := fmt.Sprintf("select * from sources %s where ({targetHits: %d}nearestNeighbor(%s, q) or userQuery())", docType, topK, v.field)
+	if len(filter) > 0 {
+		where, err := vespaWhere(filter)
+		if err != nil {
+			return nil, err
+		}
+		yql += " and " + where
+	}
+	return v.search(ctx, map[string]interface{}{
+		"yql":                yql,
+		"query":              query,
+		"type":               "any",
+		"hits":               topK,
+		"input.query(q)":     vector,
+		"input.query(alpha)": alpha,
+		"ranking.profile":    v.hybridRankProfile,
+	})
+}
+
+// search runs a query, returning its hits as results.
+func (v *vespaStore) search(ctx context.Context, reqBody map[string]interface{}) ([]SearchResult, error) {
+	reqJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url+"/search/", bytes.NewReader(reqJSON))
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setUserAgent(req, v.userAgent)
+	v.setAuth(req)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.DefaultLogger.Warn("failed to close response body", "err", err)
+		}
+	}()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search: %s", resp.Status)
+	}
+	type vespaHit struct {
+		ID        string         `json:"id"`
+		Relevance float64        `json:"relevance"`
+		Fields    map[string]any `json:"fields"`
+	}
+	var searchResult struct {
+		Root struct {
+			Children []vespaHit `json:"children"`
+		} `json:"root"`
+	}
+	if err := json.Unmarshal(body, &searchResult); err != nil {
+		return nil, fmt.Errorf("decode search result: %w", err)
+	}
+	results := make([]SearchResult, 0, len(searchResult.Root.Children))
+	for _, hit := range searchResult.Root.Children {
+		payload := hit.Fields
+		// Don't return the embedding itself, or Vespa's internal fields.
+		delete(payload, v.field)
+		delete(payload, "sddocname")
+		delete(payload, "documentid")
+		results = append(results, SearchResult{
+			ID:      hit.ID,
+			Payload: payload,
+			Score:   hit.Relevance,
+		})
+	}
+	return results, nil
+}
+
+// vespaWhere converts a metadata filter into a YQL where clause. It supports the
+// same operators as the other stores: {"field": {"$eq": value}}, {"field": {"$ne": value}},
+// and {"$and": [...]} / {"$or": [...]} combining nested filters. Field names
+// must be identifiers, and empty filters are rejected, since they have no
+// YQL equivalent.
+func vespaWhere(filter map[string]interface{}) (string, error) {
+	if len(filter) == 0 {
+		return "", fmt.Errorf("empty filter")
+	}
+	clauses := make([]string, 0, len(filter))
+	for _, k := range sortedKeys(filter) {
+		switch v := filter[k].(type) {
+		case map[string]interface{}:
+			if !vespaIdentifier.MatchString(k) {
+				return "", fmt.Errorf("invalid filter field: %q", k)
+			}
+			if len(v) == 0 {
+				return "", fmt.Errorf("no operator for filter field: %s", k)
+			}
+			for _, op := range sortedKeys(v) {
+				clause, err := vespaComparison(k, v[op])
+				if err != nil {
+					return "", err
+				}
+				switch op {
+				case "$eq":
+					clauses = append(clauses, clause)
+				case "$ne":
+					clauses = append(clauses, "!("+clause+")")
+				default:
+					return "", fmt.Errorf("unsupported operator: %s", op)
+				}
+			}
+		case []interface{}:
+			var joiner string
+			switch k {
+			case "$or":
+				joiner = " or "
+			case "$and":
+				joiner = " and "
+			default:
+				return "", fmt.Errorf("unsupported operator: %s", k)
+			}
+			if len(v) == 0 {
+				return "", fmt.Errorf("empty %s", k)
+			}
+			nested := make([]string, 0, len(v))
+			for _, u := range v {
+				m, ok := u.(map[string]interface{})
+				if !ok {
+					return "", fmt.Errorf("unsupported filter struct: %T", u)
+				}
+				clause, err := vespaWhere(m)
+				if err != nil {
+					return "", err
+				}
+				nested = append(nested, clause)
+			}
+			clauses = append(clauses, "("+strings.Join(nested, joiner)+")")
+		default:
+			return "", fmt.Errorf("unsupported filter struct: %T", v)
+		}
+	}
+	return strings.Join(clauses, " and "), nil
+}
+
+// sortedKeys returns the keys of m in order, so that queries generated from
+// it are deterministic.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func vespaComparison(field string, val interface{}) (string, error) {
+	switch val := val.(type) {
+	case string:
+		return fmt.Sprintf("%s contains %s", field, vespaQuote(val)), nil
+	case bool:
+		return fmt.Sprintf("%s = %t", field, val), nil
+	case float64:
+		return fmt.Sprintf("%s = %s", field, strconv.FormatFloat(val, 'f', -1, 64)), nil
+	default:
+		return "", fmt.Errorf("unsupported filter type: %T", val)
+	}
+}
+
+// vespaQuote returns s as a YQL string literal. Only quotes, backslashes and
+// control characters are escaped, using the escapes YQL understands; unlike
+// Go's, it has no `\x` or `\U` escapes.
+func vespaQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVespaWhere(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		filter map[string]interface{}
+		exp    string
+		expErr bool
+	}{
+		{
+			name:   "eq",
+			filter: map[string]interface{}{"source": map[string]interface{}{"$eq": "docs"}},
+			exp:    `source contains "docs"`,
+		},
+		{
+			name:   "ne",
+			filter: map[string]interface{}{"draft": map[string]interface{}{"$ne": true}},
+			exp:    `!(draft = true)`,
+		},
+		{
+			name: "or",
+			filter: map[string]interface{}{"$or": []interface{}{
+				map[string]interface{}{"source": map[string]interface{}{"$eq": "docs"}},
+				map[string]interface{}{"source": map[string]interface{}{"$eq": "blog"}},
+			}},
+			exp: `(source contains "docs" or source contains "blog")`,
+		},
+		{
+			name:   "several operators",
+			filter: map[string]interface{}{"source": map[string]interface{}{"$ne": "blog", "$eq": "docs"}},
+			exp:    `source contains "docs" and !(source contains "blog")`,
+		},
+		{
+			name:   "unsupported operator",
+			filter: map[string]interface{}{"source": map[string]interface{}{"$gt": "docs"}},
+			expErr: true,
+		},
+		{
+			name:   "quoted value",
+			filter: map[string]interface{}{"title": map[string]interface{}{"$eq": "say \"hi\" \\ \x01 \U0001F600"}},
+			exp:    `title contains "say \"hi\" \\ \u0001 😀"`,
+		},
+		{
+			name:   "injected field",
+			filter: map[string]interface{}{"source contains \"docs\" or true": map[string]interface{}{"$eq": "docs"}},
+			expErr: true,
+		},
+		{
+			name:   "empty and",
+			filter: map[string]interface{}{"$and": []interface{}{}},
+			expErr: true,
+		},
+		{
+			name:   "empty or",
+			filter: map[string]interface{}{"$or": []interface{}{}},
+			expErr: true,
+		},
+		{
+			name:   "empty nested filter",
+			filter: map[string]interface{}{"$or": []interface{}{map[string]interface{}{}}},
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := vespaWhere(tc.filter)
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.exp {
+				t.Errorf("expected %s, got %s", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestVespaSearch(t *testing.T) {
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		_, _ = w.Write([]byte(`{"root": {"children": [
+			{"id": "id:docs:doc::1", "relevance": 0.9, "fields": {"title": "Dashboards", "embedding": [0.1], "sddocname": "doc"}}
+		]}}`))
+	}))
+	defer server.Close()
+
+	s, err := newVespaStore(vespaSettings{URL: server.URL, Namespace: "docs", DocType: "doc", Field: "embedding"}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	results, err := s.Search(context.Background(), "", []float32{0.1}, 5, map[string]interface{}{
+		"source": map[string]interface{}{"$eq": "docs"},
+	})
+	if err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	expYQL := `select * from sources doc where {targetHits: 5}nearestNeighbor(embedding, q) and source contains "docs"`
+	if gotBody["yql"] != expYQL {
+		t.Errorf("expected yql %s, got %s", expYQL, gotBody["yql"])
+	}
+	if len(results) != 1 || results[0].Score != 0.9 || results[0].ID != "id:docs:doc::1" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if _, ok := results[0].Payload["embedding"]; ok {
+		t.Errorf("embedding should not be included in payload")
+	}
+	if results[0].Payload["title"] != "Dashboards" {
+		t.Errorf("expected title in payload, got %+v", results[0].Payload)
+	}
+}
+
+func TestVespaInvalidDocumentType(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"root": {}}`))
+	}))
+	defer server.Close()
+
+	s, err := newVespaStore(vespaSettings{URL: server.URL, Namespace: "docs", Field: "embedding", HybridRankProfile: "hybrid"}, nil)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	collection := "doc where true or {targetHits: 5}nearestNeighbor(embedding, q)"
+	if _, err := s.Search(context.Background(), collection, []float32{0.1}, 5, nil); err == nil {
+		t.Error("expected search to reject the collection")
+	}
+	if _, err := s.(HybridSearcher).HybridSearch(context.Background(), collection, "error rate", []float32{0.1}, 0.5, 5, nil); err == nil {
+		t.Error("expected hybrid search to reject the collection")
+	}
+	if requests != 0 {
+		t.Errorf("expected no queries to be sent, got %d", requests)
+	}
+	if _, err := newVespaStore(vespaSettings{URL: server.URL, Field: "embedding, q) or true or nearestNeighbor(embedding"}, nil); err == nil {
+		t.Error("expected an invalid embedding field to be rejected")
+	}
+}
+
+func TestVespaHybridSearch(t *testing.T) {
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody = nil
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		_, _ = w.Write([]byte(`{"root": {"children": [
+			{"id": "id:docs:doc::1", "relevance": 0.7, "fields": {"title": "Dashboards"}}
+		]}}`))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name              string
+		hybridRankProfile string
+
+		expHybrid bool
+		expYQL    string
+	}{
+		{
+			name:              "hybrid rank profile",
+			hybridRankProfile: "hybrid",
+			expHybrid:         true,
+			expYQL:            `select * from sources t_doc where ({targetHits: 5}nearestNeighbor(embedding, q) or userQuery())`,
+		},
+		{
+			name:   "falls back without a hybrid rank profile",
+			expYQL: `select * from sources t_doc where {targetHits: 5}nearestNeighbor(embedding, q)`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newVespaStore(vespaSettings{URL: server.URL, Namespace: "docs", Field: "embedding", HybridRankProfile: tc.hybridRankProfile}, nil)
+			if err != nil {
+				t.Fatalf("new store: %s", err)
+			}
+			// Through the wrappers, as stores are used by the plugin.
+			wrapped := withCollectionPrefix(instrument(s, VectorStoreTypeVespa), "t_")
+			results, hybrid, err := HybridSearch(context.Background(), wrapped, "doc", "error rate", []float32{0.1}, 0.3, 5, nil)
+			if err != nil {
+				t.Fatalf("hybrid search: %s", err)
+			}
+			if hybrid != tc.expHybrid {
+				t.Errorf("expected hybrid to be %t", tc.expHybrid)
+			}
+			if gotBody["yql"] != tc.expYQL {
+				t.Errorf("expected yql %s, got %s", tc.expYQL, gotBody["yql"])
+			}
+			if tc.expHybrid && (gotBody["query"] != "error rate" || gotBody["input.query(alpha)"] != 0.3 || gotBody["ranking.profile"] != "hybrid") {
+				t.Errorf("expected the query, alpha and hybrid rank profile to be sent, got %v", gotBody)
+			}
+			if len(results) != 1 || results[0].Score != 0.7 {
+				t.Fatalf("unexpected results: %+v", results)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// visionModelPrefixes are the prefixes of OpenAI models which accept image inputs.
+var visionModelPrefixes = []string{"gpt-4o", "gpt-4-turbo", "gpt-4-vision"}
+
+// isVisionModel returns true if the model accepts image inputs.
+func isVisionModel(model string) bool {
+	for _, prefix := range visionModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// chatContentPart is a single part of a multi-part message `content` array.
+type chatContentPart struct {
+	Type     string `json:"type"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+// imageURLs returns the model and the URLs of all image_url content parts in a
+// chat completions request body. Message content may be either a plain string
+// or an array of parts; anything other than an array of parts is left for the
+// provider to validate.
+func imageURLs(body []byte) (string, []string, error) {
+	var request struct {
+		Model    string            `json:"model"`
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return "", nil, fmt.Errorf("unmarshal request body: %w", err)
+	}
+	var urls []string
+	for i, raw := range request.Messages {
+		var message struct {
+			Content json.RawMessage `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &message); err != nil {
+			continue
+		}
+		content := bytes.TrimSpace(message.Content)
+		if len(content) == 0 || content[0] != '[' {
+			continue
+		}
+		var parts []chatContentPart
+		if err := json.Unmarshal(content, &parts); err != nil {
+			return "", nil, fmt.Errorf("unmarshal content of message %d: %w", i, err)
+		}
+		for _, part := range parts {
+			if part.Type != "image_url" {
+				continue
+			}
+			if part.ImageURL == nil {
+				return "", nil, fmt.Errorf("message %d: image_url part is missing image_url", i)
+			}
+			urls = append(urls, part.ImageURL.URL)
+		}
+	}
+	return request.Model, urls, nil
+}
+
+// validateImageURL checks that an image URL is either an http(s) URL or a
+// base64-encoded image data URL.
+func validateImageURL(raw string) error {
+	if rest, ok := strings.CutPrefix(raw, "data:"); ok {
+		mediaType, data, ok := strings.Cut(rest, ",")
+		if !ok || !strings.HasPrefix(mediaType, "image/") || !strings.HasSuffix(mediaType, ";base64") {
+			return fmt.Errorf("image data URLs must be base64-encoded images")
+		}
+		if _, err := base64.StdEncoding.DecodeString(data); err != nil {
+			return fmt.Errorf("invalid base64 image data: %w", err)
+		}
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("image URL must be an http(s) or data URL")
+	}
+	return nil
+}
+
+// checkVisionContent validates any image inputs in a chat completions request
+// body, returning an error if they are malformed or if the configured provider
+// can't handle images for the requested model.
+func (a *App) checkVisionContent(body []byte) error {
+	model, urls, err := imageURLs(body)
+	if err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	if a.provider == nil || !a.provider.SupportsVision(model) {
+		return fmt.Errorf("model %q does not support image inputs with the %q provider", model, a.settings.OpenAI.Provider)
+	}
+	for _, u := range urls {
+		if err := validateImageURL(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visionRequestTransformer rejects requests with invalid or unsupported image
+// inputs. It leaves the request body untouched.
+func (a *App) visionRequestTransformer(req *http.Request) error {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err := a.checkVisionContent(body); err != nil {
+		return &TransformError{StatusCode: http.StatusBadRequest, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// A 1x1 transparent PNG.
+const testImageDataURL = "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+
+func visionRequestBody(model, imageURL string) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"model": model,
+		"messages": []interface{}{
+			map[string]interface{}{"role": "system", "content": "You analyse charts."},
+			map[string]interface{}{
+				"role": "user",
+				"content": []interface{}{
+					map[string]interface{}{"type": "text", "text": "What does this chart show?"},
+					map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": imageURL}},
+				},
+			},
+		},
+	})
+	return body
+}
+
+func TestVisionPassthrough(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name     string
+		provider openAIProvider
+		body     []byte
+
+		expStatus int
+	}{
+		{
+			name:      "openai vision model",
+			provider:  openAIProviderOpenAI,
+			body:      visionRequestBody("gpt-4o", testImageDataURL),
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "openai https image",
+			provider:  openAIProviderOpenAI,
+			body:      visionRequestBody("gpt-4o", "https://example.com/chart.png"),
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "azure vision model",
+			provider:  openAIProviderAzure,
+			body:      visionRequestBody("gpt-4o", testImageDataURL),
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "non-vision model",
+			provider:  openAIProviderOpenAI,
+			body:      visionRequestBody("gpt-3.5-turbo", testImageDataURL),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "azure unmapped vision model",
+			provider:  openAIProviderAzure,
+			body:      visionRequestBody("gpt-4-turbo", testImageDataURL),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "invalid base64",
+			provider:  openAIProviderOpenAI,
+			body:      visionRequestBody("gpt-4o", "data:image/png;base64,not base64!"),
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "unsupported scheme",
+			provider:  openAIProviderOpenAI,
+			body:      visionRequestBody("gpt-4o", "file:///etc/passwd"),
+			expStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var upstreamBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamBody, _ = io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			settings := Settings{OpenAI: OpenAISettings{
+				Provider:     tc.provider,
+				URL:          server.URL,
+				AzureMapping: [][]string{{"gpt-4o", "gpt-4o-deployment"}},
+			}}
+			jsonData, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("json marshal: %s", err)
+			}
+			appSettings := backend.AppInstanceSettings{
+				JSONData:                jsonData,
+				DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+			}
+			inst, err := NewApp(ctx, appSettings)
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			var r mockCallResourceResponseSender
+			err = app.CallResource(ctx, &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{AppInstanceSettings: &appSettings},
+				Method:        http.MethodPost,
+				Path:          "/openai/v1/chat/completions",
+				Body:          tc.body,
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if r.response.Status != tc.expStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expStatus, r.response.Status, r.response.Body)
+			}
+			if tc.expStatus != http.StatusOK {
+				if upstreamBody != nil {
+					t.Error("request should not have been proxied")
+				}
+				return
+			}
+
+			// The multi-part content must reach the provider intact.
+			var sent, got map[string]interface{}
+			if err := json.Unmarshal(tc.body, &sent); err != nil {
+				t.Fatalf("unmarshal sent body: %s", err)
+			}
+			if err := json.Unmarshal(upstreamBody, &got); err != nil {
+				t.Fatalf("unmarshal upstream body: %s", err)
+			}
+			if !reflect.DeepEqual(sent["messages"], got["messages"]) {
+				t.Errorf("expected messages %v, got %v", sent["messages"], got["messages"])
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// defaultWarmupInterval is below http.DefaultTransport's 90 second idle
+	// connection timeout, so warmed connections are kept open between probes.
+	defaultWarmupInterval = 60 * time.Second
+	warmupTimeout         = 10 * time.Second
+)
+
+// WarmupSettings configures periodic requests to the provider which keep
+// connections to it open, so the first request after an idle period doesn't
+// pay for connection setup and the TLS handshake.
+type WarmupSettings struct {
+	Enabled bool `json:"enabled"`
+	// IntervalSeconds is the time between probes. Defaults to 60 seconds.
+	IntervalSeconds int `json:"intervalSeconds"`
+}
+
+// warmer calls probe periodically in the background until stopped.
+type warmer struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startWarmer(s WarmupSettings, probe func(ctx context.Context) error) *warmer {
+	interval := time.Duration(s.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultWarmupInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &warmer{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := probe(ctx); err != nil && ctx.Err() == nil {
+				log.DefaultLogger.Debug("Provider warm-up probe failed", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return w
+}
+
+// stop stops the warmer, waiting for any in-flight probe to finish.
+func (w *warmer) stop() {
+	if w == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+// warmupProbe sends a HEAD request to the provider using the default
+// transport, which the proxy and streams also use, leaving an open connection
+// in its pool. The response status doesn't matter, so no credentials are sent
+// and no tokens are used.
+func (a *App) warmupProbe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, a.providerURL(), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", a.settings.userAgent())
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
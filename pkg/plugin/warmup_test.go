@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestWarmup(t *testing.T) {
+	var probes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected HEAD request, got %s", r.Method)
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Error("warm-up probes must not send credentials")
+		}
+		probes.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	settings := Settings{
+		OpenAI: OpenAISettings{Provider: openAIProviderOpenAI, URL: server.URL},
+		Warmup: WarmupSettings{Enabled: true, IntervalSeconds: 1},
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("json marshal: %s", err)
+	}
+	inst, err := NewApp(context.Background(), backend.AppInstanceSettings{
+		JSONData:                jsonData,
+		DecryptedSecureJSONData: map[string]string{openAIKey: "abcd1234"},
+	})
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+
+	// The first probe is sent immediately.
+	deadline := time.Now().Add(5 * time.Second)
+	for probes.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no warm-up probe sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Dispose stops the warmer, after which no more probes are sent.
+	app.Dispose()
+	n := probes.Load()
+	time.Sleep(1500 * time.Millisecond)
+	if probes.Load() != n {
+		t.Errorf("expected no probes after Dispose, got %d more", probes.Load()-n)
+	}
+}
+
+func TestWarmupDisabled(t *testing.T) {
+	app, _ := newTransformTestApp(t, "http://localhost")
+	if app.warmer != nil {
+		t.Error("expected no warmer when warm-up is disabled")
+	}
+	// Stopping a nil warmer is a no-op.
+	app.Dispose()
+}
@@ -7,6 +7,10 @@ interface HealthCheckDetails {
   openAI: OpenAIHealthDetails | boolean;
   vector: VectorHealthDetails | boolean;
   version: string;
+  // The overall health of the configured features. Not set by older plugin versions.
+  status?: 'healthy' | 'degraded' | 'unhealthy' | 'unknown';
+  // A human-readable description of the status.
+  summary?: string;
 }
 
 interface OpenAIHealthDetails {
@@ -21,6 +25,20 @@ interface OpenAIHealthDetails {
   // The health check attempts to call the OpenAI API with each
   // of a few models and records the result of each call here.
   models: Record<string, OpenAIModelHealthDetails>;
+  // Whether the provider rejected the configured credentials for every model.
+  authFailed?: boolean;
+  // The llm-gateway endpoint currently in use, if using the Grafana-managed provider.
+  activeEndpoint?: string;
+  // Whether the provider's host could be reached. If false, models weren't checked.
+  reachable?: boolean;
+  // Moving average latency of successful chat completions requests, in milliseconds.
+  avgLatencyMs?: number;
+  // Whether the health check was cancelled before the models could be checked.
+  unknown?: boolean;
+  // How long results are currently reused for, with adaptive health checks.
+  checkIntervalSeconds?: number;
+  // The health of each load balanced provider, by name, if checkAllProviders is enabled.
+  providers?: Record<string, OpenAIHealthDetails>;
 }
 
 interface OpenAIModelHealthDetails {
@@ -29,6 +47,8 @@ interface OpenAIModelHealthDetails {
   // If set, the error returned when trying to call the OpenAI API.
   // Will be undefined if ok is true.
   error?: string;
+  // Whether the health check was cancelled before this model was checked.
+  unknown?: boolean;
 }
 
 interface VectorHealthDetails {
@@ -39,6 +59,21 @@ interface VectorHealthDetails {
   // If set, the error returned when trying to call the vector service.
   // Will be undefined if ok is true.
   error?: string;
+  // Whether the health check was cancelled before the vector service was checked.
+  unknown?: boolean;
+  // The health of each of the configured health check collections.
+  collections?: Record<string, VectorCollectionHealthDetails>;
+  // How long results are currently reused for, with adaptive health checks.
+  checkIntervalSeconds?: number;
+  // How long the deep check's search took, if it's enabled and succeeded.
+  deepCheckLatencyMs?: number;
+}
+
+interface VectorCollectionHealthDetails {
+  // Whether the collection exists and can be used.
+  ok: boolean;
+  // If set, why the collection can't be used.
+  error?: string;
 }
 
 const isHealthCheckDetails = (obj: unknown): obj is HealthCheckDetails => {
@@ -61,6 +96,18 @@ const getAlertSeverity = (status: string, details: HealthCheckDetails): AlertVar
   if (!isHealthCheckDetails(details)) {
     return 'success';
   }
+  if (details.status !== undefined) {
+    switch (details.status) {
+      case 'healthy':
+        return 'success';
+      case 'degraded':
+        return 'warning';
+      case 'unknown':
+        return 'info';
+      default:
+        return 'error';
+    }
+  }
   if (typeof details.openAI === 'object' && typeof details.vector === 'object') {
     const vectorOk = !details.vector.enabled || details.vector.ok;
     return details.openAI.ok && vectorOk ? 'success' : 'warning';
@@ -99,18 +146,49 @@ function ShowOpenAIHealth({ openAI }: { openAI: OpenAIHealthDetails | boolean })
     const message = openAI ? 'OpenAI health check succeeded!' : 'OpenAI health check failed.';
     return <Alert title={message} severity={severity} />;
   }
-  const message = openAI.ok ? 'OpenAI health check succeeded!' : 'OpenAI health check failed.';
-  const severity = openAI.ok ? 'success' : 'error';
+  const message = openAI.ok
+    ? 'OpenAI health check succeeded!'
+    : openAI.unknown
+      ? 'OpenAI health check did not finish.'
+      : 'OpenAI health check failed.';
+  const severity = openAI.ok ? 'success' : openAI.unknown ? 'info' : 'error';
   return (
     <Alert severity={severity} title={message}>
+      {openAI.configured && openAI.reachable === false && (
+        <div>
+          The plugin could not connect to the provider. Check that outbound network access to the provider is allowed
+          from the Grafana server.
+        </div>
+      )}
+      {openAI.authFailed && (
+        <div>
+          The provider rejected the configured API key. Check that the key is correct and has not expired or been
+          revoked, then save the settings again.
+        </div>
+      )}
+      {openAI.activeEndpoint && <div>Active endpoint: {openAI.activeEndpoint}</div>}
+      {openAI.avgLatencyMs !== undefined && <div>Average latency: {Math.round(openAI.avgLatencyMs)}ms</div>}
+      {openAI.checkIntervalSeconds !== undefined && <div>Checked every {openAI.checkIntervalSeconds}s</div>}
       <b>Models</b>
       <div>
         {Object.entries(openAI.models).map(([model, details], i) => (
           <li key={i}>
-            {model}: {details.ok ? 'OK' : `Error: ${details.error}`}
+            {model}: {details.ok ? 'OK' : details.unknown ? 'Not checked' : `Error: ${details.error}`}
           </li>
         ))}
       </div>
+      {openAI.providers && (
+        <>
+          <b>Load balanced providers</b>
+          <div>
+            {Object.entries(openAI.providers).map(([name, details], i) => (
+              <li key={i}>
+                {name}: {details.ok ? 'OK' : details.unknown ? 'Not checked' : `Error: ${details.error}`}
+              </li>
+            ))}
+          </div>
+        </>
+      )}
     </Alert>
   );
 }
@@ -121,11 +199,31 @@ function ShowVectorHealth({ vector }: { vector: VectorHealthDetails | boolean })
     const message = vector ? 'Vector service health check succeeded!' : 'Vector service health check failed.';
     return <Alert title={message} severity={severity} />;
   }
-  const severity = vector.ok ? 'success' : 'error';
-  const message = vector.ok ? 'Vector service health check succeeded!' : 'Vector service health check failed.';
+  const severity = vector.ok ? 'success' : vector.unknown ? 'info' : 'error';
+  const message = vector.ok
+    ? 'Vector service health check succeeded!'
+    : vector.unknown
+      ? 'Vector service health check did not finish.'
+      : 'Vector service health check failed.';
   return (
     <Alert title={message} severity={severity}>
       {vector.error && <div>Error: {vector.error}</div>}
+      {vector.checkIntervalSeconds !== undefined && <div>Checked every {vector.checkIntervalSeconds}s</div>}
+      {vector.deepCheckLatencyMs !== undefined && (
+        <div>Deep check search latency: {Math.round(vector.deepCheckLatencyMs)}ms</div>
+      )}
+      {vector.collections && (
+        <>
+          <b>Collections</b>
+          <div>
+            {Object.entries(vector.collections).map(([collection, details], i) => (
+              <li key={i}>
+                {collection}: {details.ok ? 'OK' : `Error: ${details.error}`}
+              </li>
+            ))}
+          </div>
+        </>
+      )}
     </Alert>
   );
 }
@@ -7,7 +7,7 @@ import { testIds } from 'components/testIds';
 import { getStyles, Secrets, SecretsSet } from './AppConfig';
 import { AzureModelDeploymentConfig, AzureModelDeployments } from './AzureConfig';
 
-export type OpenAIProvider = 'openai' | 'azure' | 'grafana';
+export type OpenAIProvider = 'openai' | 'azure' | 'cohere' | 'grafana';
 
 export interface OpenAISettings {
   // The URL to reach OpenAI.
@@ -18,8 +18,25 @@ export interface OpenAISettings {
   provider?: OpenAIProvider;
   // A mapping of OpenAI models to Azure deployment names.
   azureModelMapping?: AzureModelDeployments;
+  // The Azure OpenAI API version.
+  azureApiVersion?: string;
 }
 
+const urlLabels: Partial<Record<OpenAIProvider, string>> = {
+  azure: 'Azure OpenAI Language API Endpoint',
+  cohere: 'Cohere API URL',
+};
+
+const urlPlaceholders: Partial<Record<OpenAIProvider, string>> = {
+  azure: 'https://<resource-name>.openai.azure.com',
+  cohere: 'https://api.cohere.com',
+};
+
+const keyLabels: Partial<Record<OpenAIProvider, string>> = {
+  azure: 'Azure OpenAI Key',
+  cohere: 'Cohere API Key',
+};
+
 export function OpenAIConfig({
   settings,
   secrets,
@@ -51,6 +68,7 @@ export function OpenAIConfig({
             [
               { label: 'OpenAI', value: 'openai' },
               { label: 'Azure OpenAI', value: 'azure' },
+              { label: 'Cohere', value: 'cohere' },
             ] as Array<SelectableValue<OpenAIProvider>>
           }
           value={settings.provider ?? 'openai'}
@@ -58,25 +76,20 @@ export function OpenAIConfig({
           width={60}
         />
       </Field>
-      <Field
-        label={settings.provider === 'azure' ? 'Azure OpenAI Language API Endpoint' : 'OpenAI API URL'}
-        className={s.marginTop}
-      >
+      <Field label={urlLabels[settings.provider ?? 'openai'] ?? 'OpenAI API URL'} className={s.marginTop}>
         <Input
           width={60}
           name="url"
           data-testid={testIds.appConfig.openAIUrl}
           value={settings.url}
-          placeholder={
-            settings.provider === 'azure' ? `https://<resource-name>.openai.azure.com` : `https://api.openai.com`
-          }
+          placeholder={urlPlaceholders[settings.provider ?? 'openai'] ?? 'https://api.openai.com'}
           onChange={onChangeField}
         />
       </Field>
 
       <Field
-        label={settings.provider === 'azure' ? 'Azure OpenAI Key' : 'OpenAI API Key'}
-        description={settings.provider === 'azure' ? 'Your Azure OpenAI Key' : 'Your OpenAI API Key'}
+        label={keyLabels[settings.provider ?? 'openai'] ?? 'OpenAI API Key'}
+        description={`Your ${keyLabels[settings.provider ?? 'openai'] ?? 'OpenAI API Key'}`}
       >
         <SecretInput
           width={60}
@@ -84,13 +97,13 @@ export function OpenAIConfig({
           name="openAIKey"
           value={secrets.openAIKey}
           isConfigured={secretsSet.openAIKey ?? false}
-          placeholder={settings.provider === 'azure' ? '' : 'sk-...'}
+          placeholder={settings.provider === 'azure' || settings.provider === 'cohere' ? '' : 'sk-...'}
           onChange={(e) => onChangeSecrets({ ...secrets, openAIKey: e.currentTarget.value })}
           onReset={() => onChangeSecrets({ ...secrets, openAIKey: '' })}
         />
       </Field>
 
-      {settings.provider !== 'azure' && (
+      {settings.provider !== 'azure' && settings.provider !== 'cohere' && (
         <Field label="OpenAI API Organization ID" description="Your OpenAI API Organization ID">
           <Input
             width={60}
@@ -103,6 +116,18 @@ export function OpenAIConfig({
         </Field>
       )}
 
+      {settings.provider === 'azure' && (
+        <Field label="Azure OpenAI API Version" description="The api-version to use for Azure OpenAI requests">
+          <Input
+            width={60}
+            name="azureApiVersion"
+            value={settings.azureApiVersion}
+            placeholder="2024-02-01"
+            onChange={onChangeField}
+          />
+        </Field>
+      )}
+
       {settings.provider === 'azure' && (
         <Field
           label="Azure OpenAI Model Mapping"